@@ -0,0 +1,1463 @@
+package allsrvtesting
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	
+	"github.com/jsteenb2/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+)
+
+// DBInitFn provides an empty DB for each test of the DB suites.
+type DBInitFn func(t *testing.T) allsrv.DB
+
+// TestDB verifies the DB implementation abides by the contract of the
+// allsrv.DB. The capabilities a DB may provide beyond the contract are
+// verified by their own suites (i.e. TestLabelDB, TestAttachmentDB).
+func TestDB(t *testing.T, initFn DBInitFn) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, initFn DBInitFn)
+	}{
+		{
+			name: "CreateFoo",
+			fn:   testDBCreateFoo,
+		},
+		{
+			name: "ReadFoo",
+			fn:   testDBReadFoo,
+		},
+		{
+			name: "UpdateFoo",
+			fn:   testDBUpdateFoo,
+		},
+		{
+			name: "DelFoo",
+			fn:   testDBDeleteFoo,
+		},
+		{
+			name: "ListFoos",
+			fn:   testDBListFoos,
+		},
+		{
+			name: "ConcurrentWriters",
+			fn:   testDBConcurrentWriters,
+		},
+		{
+			name: "ContextCancellation",
+			fn:   testDBContextCancellation,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, initFn)
+		})
+	}
+}
+
+func testDBCreateFoo(t *testing.T, initFn DBInitFn) {
+	t.Helper()
+
+	type (
+		inputs struct {
+			foo allsrv.Foo
+		}
+
+		wantFn func(t *testing.T, db allsrv.DB, insertErr error)
+	)
+
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, db allsrv.DB)
+		inputs  inputs
+		want    wantFn
+	}{
+		{
+			name: "with valid foo should pass",
+			inputs: inputs{
+				foo: allsrv.Foo{
+					ID:        "1",
+					Name:      "name",
+					Note:      "note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				},
+			},
+			want: func(t *testing.T, db allsrv.DB, insertErr error) {
+				require.NoError(t, insertErr)
+
+				got, err := db.ReadFoo(context.TODO(), "1")
+				require.NoError(t, err)
+
+				want := allsrv.Foo{
+					ID:        "1",
+					Name:      "name",
+					Note:      "note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				}
+				assert.Equal(t, want, got)
+			},
+		},
+		{
+			name: "with concurrent valid foo creates should pass",
+			prepare: func(t *testing.T, db allsrv.DB) {
+				newFoo := func(id string) allsrv.Foo {
+					return allsrv.Foo{
+						ID:        id,
+						Name:      "name-" + id,
+						Note:      "note-" + id,
+						CreatedAt: start.Add(time.Minute),
+						UpdatedAt: start.Add(time.Minute),
+					}
+				}
+
+				fixtures := []allsrv.Foo{newFoo("1"), newFoo("2"), newFoo("3"), newFoo("4"), newFoo("5")}
+				doConcurrent(t, fixtures, func(f allsrv.Foo) error {
+					return db.CreateFoo(context.TODO(), f)
+				})
+			},
+			inputs: inputs{
+				foo: allsrv.Foo{
+					ID:        "9000",
+					Name:      "passing",
+					Note:      "note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				},
+			},
+			want: func(t *testing.T, db allsrv.DB, insertErr error) {
+				require.NoError(t, insertErr)
+
+				got, err := db.ReadFoo(context.TODO(), "9000")
+				require.NoError(t, err)
+
+				want := allsrv.Foo{
+					ID:        "9000",
+					Name:      "passing",
+					Note:      "note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				}
+				assert.Equal(t, want, got)
+			},
+		},
+		{
+			name:    "with foo containing name that already exists should fail",
+			prepare: CreateFoos(allsrv.Foo{ID: "1", Name: "collision"}),
+			inputs: inputs{
+				foo: allsrv.Foo{
+					ID:        "2",
+					Name:      "collision",
+					Note:      "some note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				},
+			},
+			want: func(t *testing.T, db allsrv.DB, insertErr error) {
+				require.Error(t, insertErr)
+				assert.True(t, errors.Is(insertErr, allsrv.ErrKindExists))
+			},
+		},
+		{
+			name:    "with foo containing ID that already exists should fail",
+			prepare: CreateFoos(allsrv.Foo{ID: "1", Name: "name-1"}),
+			inputs: inputs{
+				foo: allsrv.Foo{
+					ID:        "1",
+					Name:      "name-2",
+					Note:      "some note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				},
+			},
+			want: func(t *testing.T, db allsrv.DB, insertErr error) {
+				require.Error(t, insertErr)
+				assert.True(t, errors.Is(insertErr, allsrv.ErrKindExists))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := initFn(t)
+			if tt.prepare != nil {
+				tt.prepare(t, db)
+			}
+
+			// action
+			insertErr := db.CreateFoo(context.TODO(), tt.inputs.foo)
+
+			// assert
+			tt.want(t, db, insertErr)
+		})
+	}
+}
+
+func testDBReadFoo(t *testing.T, initFn DBInitFn) {
+	t.Helper()
+
+	type (
+		inputs struct {
+			id string
+		}
+
+		wantFn func(t *testing.T, got allsrv.Foo, readErr error)
+	)
+
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, db allsrv.DB)
+		inputs  inputs
+		want    wantFn
+	}{
+		{
+			name: "with id for existing foo should pass",
+			prepare: CreateFoos(allsrv.Foo{
+				ID:        "1",
+				Name:      "name-1",
+				Note:      "note-1",
+				CreatedAt: start,
+				UpdatedAt: start.Add(time.Hour),
+			}),
+			inputs: inputs{
+				id: "1",
+			},
+			want: func(t *testing.T, got allsrv.Foo, readErr error) {
+				require.NoError(t, readErr)
+
+				want := allsrv.Foo{
+					ID:        "1",
+					Name:      "name-1",
+					Note:      "note-1",
+					CreatedAt: start,
+					UpdatedAt: start.Add(time.Hour),
+				}
+				assert.Equal(t, want, got)
+			},
+		},
+		{
+			name: "with concurrent valid foo update the reading should pass",
+			prepare: func(t *testing.T, db allsrv.DB) {
+				err := db.CreateFoo(context.TODO(), allsrv.Foo{
+					ID:        "1",
+					Name:      "one",
+					Note:      "note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				})
+				require.NoError(t, err)
+
+				newFoo := func(note string) allsrv.Foo {
+					return allsrv.Foo{
+						ID:        "1",
+						Name:      "one",
+						Note:      note,
+						CreatedAt: start,
+						UpdatedAt: start.Add(time.Hour),
+					}
+				}
+
+				// execute these while test is executing read
+				fixtures := []allsrv.Foo{newFoo("a"), newFoo("b"), newFoo("c"), newFoo("d"), newFoo("e")}
+				doConcurrent(t, fixtures, func(f allsrv.Foo) error {
+					return db.UpdateFoo(context.TODO(), f)
+				})
+			},
+			inputs: inputs{
+				id: "1",
+			},
+			want: func(t *testing.T, got allsrv.Foo, readErr error) {
+				require.NoError(t, readErr)
+				assert.Contains(t, []string{"note", "a", "b", "c", "d", "e"}, got.Note)
+			},
+		},
+		{
+			name: "with id for non-existent foo should fail",
+			inputs: inputs{
+				id: "1",
+			},
+			want: func(t *testing.T, _ allsrv.Foo, readErr error) {
+				// this is pretty gross, we're matching against a raw error/text value
+				// any change in the error message means we have to update tests too
+				want := errors.New("foo not found for id: 1")
+				assert.Equal(t, want.Error(), readErr.Error())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := initFn(t)
+			if tt.prepare != nil {
+				tt.prepare(t, db)
+			}
+
+			// action
+			got, err := db.ReadFoo(context.TODO(), tt.inputs.id)
+
+			// assert
+			tt.want(t, got, err)
+		})
+	}
+}
+
+func testDBUpdateFoo(t *testing.T, initFn DBInitFn) {
+	type (
+		inputs struct {
+			foo allsrv.Foo
+		}
+
+		wantFn func(t *testing.T, db allsrv.DB, updateErr error)
+	)
+
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, db allsrv.DB)
+		inputs  inputs
+		want    wantFn
+	}{
+		{
+			name: "with valid update for existing foo should pass",
+			prepare: CreateFoos(allsrv.Foo{
+				ID:        "1",
+				Name:      "name",
+				Note:      "note",
+				CreatedAt: start,
+				UpdatedAt: start,
+			}),
+			inputs: inputs{
+				foo: allsrv.Foo{
+					ID:        "1",
+					Name:      "name",
+					Note:      "some other note",
+					CreatedAt: start,
+					UpdatedAt: start.Add(time.Hour),
+				},
+			},
+			want: func(t *testing.T, db allsrv.DB, updateErr error) {
+				require.NoError(t, updateErr)
+
+				got, err := db.ReadFoo(context.TODO(), "1")
+				require.NoError(t, err)
+
+				want := allsrv.Foo{
+					ID:        "1",
+					Name:      "name",
+					Note:      "some other note",
+					CreatedAt: start,
+					UpdatedAt: start.Add(time.Hour),
+				}
+				assert.Equal(t, want, got)
+			},
+		},
+		{
+			name: "with concurrent valid foo updates should pass",
+			prepare: func(t *testing.T, db allsrv.DB) {
+				require.NoError(t, db.CreateFoo(context.TODO(), allsrv.Foo{
+					ID:        "1",
+					Name:      "one",
+					Note:      "note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				}))
+
+				newFoo := func(note string) allsrv.Foo {
+					return allsrv.Foo{
+						ID:        "1",
+						Name:      "one",
+						Note:      note,
+						UpdatedAt: start.Add(time.Hour),
+					}
+				}
+
+				fixtures := []allsrv.Foo{newFoo("a"), newFoo("b"), newFoo("c"), newFoo("d"), newFoo("e")}
+				doConcurrent(t, fixtures, func(f allsrv.Foo) error {
+					return db.UpdateFoo(context.TODO(), f)
+				})
+			},
+			inputs: inputs{
+				foo: allsrv.Foo{
+					ID:        "1",
+					Name:      "one",
+					Note:      "final",
+					UpdatedAt: start.Add(24 * time.Hour),
+				},
+			},
+			want: func(t *testing.T, db allsrv.DB, updateErr error) {
+				require.NoError(t, updateErr)
+
+				got, err := db.ReadFoo(context.TODO(), "1")
+				require.NoError(t, err)
+
+				assert.Contains(t, []string{"final", "note", "a", "b", "c", "d", "e"}, got.Note)
+			},
+		},
+		{
+			name:    "with update to name of another foo should fail",
+			prepare: CreateFoos(allsrv.Foo{ID: "1", Name: "name-1"}, allsrv.Foo{ID: "2", Name: "name-2"}),
+			inputs: inputs{
+				foo: allsrv.Foo{
+					ID:        "1",
+					Name:      "name-2",
+					CreatedAt: start,
+					UpdatedAt: start.Add(time.Hour),
+				},
+			},
+			want: func(t *testing.T, db allsrv.DB, updateErr error) {
+				require.Error(t, updateErr)
+				assert.True(t, errors.Is(updateErr, allsrv.ErrKindExists))
+
+				got, err := db.ReadFoo(context.TODO(), "1")
+				require.NoError(t, err)
+				assert.Equal(t, "name-1", got.Name)
+			},
+		},
+		{
+			name: "with update for non-existent foo should fail",
+			inputs: inputs{
+				foo: allsrv.Foo{
+					ID:        "1",
+					Name:      "name",
+					Note:      "note",
+					CreatedAt: start,
+					UpdatedAt: start.Add(time.Hour),
+				},
+			},
+			want: func(t *testing.T, db allsrv.DB, updateErr error) {
+				require.Error(t, updateErr)
+				assert.True(t, errors.Is(updateErr, allsrv.ErrKindNotFound))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := initFn(t)
+			if tt.prepare != nil {
+				tt.prepare(t, db)
+			}
+
+			// action
+			err := db.UpdateFoo(context.TODO(), tt.inputs.foo)
+
+			// assert
+			tt.want(t, db, err)
+		})
+	}
+}
+
+func testDBDeleteFoo(t *testing.T, initFn DBInitFn) {
+	t.Helper()
+
+	type (
+		inputs struct {
+			id string
+		}
+
+		wantFn func(t *testing.T, db allsrv.DB, delErr error)
+	)
+
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, db allsrv.DB)
+		inputs  inputs
+		want    wantFn
+	}{
+		{
+			name:    "with id for existing foo should pass",
+			prepare: CreateFoos(allsrv.Foo{ID: "1", Name: "blue"}),
+			inputs: inputs{
+				id: "1",
+			},
+			want: func(t *testing.T, db allsrv.DB, delErr error) {
+				require.NoError(t, delErr)
+
+				_, err := db.ReadFoo(context.TODO(), "1")
+
+				// this is pretty gross, we're matching against a raw error/text value
+				// any change in the error message means we have to update tests too
+				want := errors.New("foo not found for id: 1")
+				assert.Equal(t, want.Error(), err.Error())
+			},
+		},
+		{
+			name: "with concurrent valid foo creates should pass",
+			prepare: func(t *testing.T, db allsrv.DB) {
+				newFoo := func(id string) allsrv.Foo {
+					return allsrv.Foo{
+						ID:        id,
+						Name:      "name-" + id,
+						Note:      "note-" + id,
+						CreatedAt: start,
+						UpdatedAt: start,
+					}
+				}
+
+				fixtures := []allsrv.Foo{newFoo("1"), newFoo("2"), newFoo("3"), newFoo("4"), newFoo("5")}
+				for _, f := range fixtures {
+					require.NoError(t, db.CreateFoo(context.TODO(), f))
+				}
+
+				fixtures = fixtures[1:]
+				doConcurrent(t, fixtures, func(f allsrv.Foo) error {
+					return db.DelFoo(context.TODO(), f.ID)
+				})
+			},
+			inputs: inputs{id: "1"},
+			want: func(t *testing.T, db allsrv.DB, delErr error) {
+				require.NoError(t, delErr)
+			},
+		},
+		{
+			name:   "with id for non-existent foo should fail",
+			inputs: inputs{id: "1"},
+			want: func(t *testing.T, db allsrv.DB, delErr error) {
+				require.Error(t, delErr)
+				assert.True(t, errors.Is(delErr, allsrv.ErrKindNotFound))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			db := initFn(t)
+			if tt.prepare != nil {
+				tt.prepare(t, db)
+			}
+
+			// action
+			delErr := db.DelFoo(context.TODO(), tt.inputs.id)
+
+			// assert
+			tt.want(t, db, delErr)
+		})
+	}
+}
+
+// TestExpiryDB verifies the DB hides the foos that have expired. When the DB
+// implements the allsrv.FooExpirer, the deletion of the expired foos is
+// verified too.
+func TestExpiryDB(t *testing.T, initFn DBInitFn) {
+	t.Helper()
+
+	start := time.Time{}.Add(time.Hour).UTC()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	newFoo := func(id string, expiresAt time.Time) allsrv.Foo {
+		return allsrv.Foo{
+			ID:        id,
+			Name:      "name-" + id,
+			Note:      "note-" + id,
+			CreatedAt: start,
+			UpdatedAt: start,
+			ExpiresAt: expiresAt,
+		}
+	}
+
+	t.Run("expired foo should be hidden", func(t *testing.T) {
+		db := initFn(t)
+		CreateFoos(newFoo("1", now.Add(-time.Minute)))(t, db)
+
+		_, err := db.ReadFoo(context.TODO(), "1")
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+
+		err = db.UpdateFoo(context.TODO(), newFoo("1", time.Time{}))
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+
+		err = db.DelFoo(context.TODO(), "1")
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+	})
+
+	t.Run("foo expiring in the future should be readable", func(t *testing.T) {
+		db := initFn(t)
+		want := newFoo("1", now.Add(time.Hour))
+		CreateFoos(want)(t, db)
+
+		got, err := db.ReadFoo(context.TODO(), "1")
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("expired foo should be replaced by a new foo with the same name", func(t *testing.T) {
+		db := initFn(t)
+		CreateFoos(newFoo("1", now.Add(-time.Minute)))(t, db)
+
+		want := newFoo("2", time.Time{})
+		want.Name = "name-1"
+		require.NoError(t, db.CreateFoo(context.TODO(), want))
+
+		got, err := db.ReadFoo(context.TODO(), "2")
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("deleting expired foos should delete up to the limit", func(t *testing.T) {
+		db := initFn(t)
+		CreateFoos(
+			newFoo("1", now.Add(-time.Hour)),
+			newFoo("2", now.Add(-time.Minute)),
+			newFoo("3", now.Add(-time.Second)),
+			newFoo("4", now.Add(time.Hour)),
+			newFoo("5", time.Time{}),
+		)(t, db)
+
+		expirer, ok := db.(allsrv.FooExpirer)
+		if !ok {
+			t.Skip("db does not implement allsrv.FooExpirer")
+		}
+
+		n, err := expirer.DelExpiredFoos(context.TODO(), now, 2)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		n, err = expirer.DelExpiredFoos(context.TODO(), now, 2)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		for _, id := range []string{"4", "5"} {
+			_, err := db.ReadFoo(context.TODO(), id)
+			assert.NoError(t, err)
+		}
+
+		// past the expiry of foo 4, foo 4 is deleted too
+		n, err = expirer.DelExpiredFoos(context.TODO(), now.Add(2*time.Hour), 2)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+}
+
+func testDBListFoos(t *testing.T, initFn DBInitFn) {
+	t.Helper()
+
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	newFoo := func(id string) allsrv.Foo {
+		return allsrv.Foo{
+			ID:        id,
+			Name:      "name-" + id,
+			Note:      "note-" + id,
+			CreatedAt: start,
+			UpdatedAt: start,
+		}
+	}
+
+	foos := []allsrv.Foo{newFoo("1"), newFoo("2"), newFoo("3")}
+
+	t.Run("with empty db should list no foos", func(t *testing.T) {
+		db := initFn(t)
+
+		got, err := db.ListFoos(context.TODO(), nil)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("with empty selector should list every foo in the order created", func(t *testing.T) {
+		db := initFn(t)
+		CreateFoos(foos...)(t, db)
+
+		got, err := db.ListFoos(context.TODO(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, foos, got)
+	})
+
+	t.Run("deleted foo should not be listed", func(t *testing.T) {
+		db := initFn(t)
+		CreateFoos(foos...)(t, db)
+
+		require.NoError(t, db.DelFoo(context.TODO(), "2"))
+
+		got, err := db.ListFoos(context.TODO(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, []allsrv.Foo{foos[0], foos[2]}, got)
+	})
+}
+
+// TestLabelDB verifies the DB keeps the labels of the foos, and lists the
+// foos whose labels match the label selector.
+func TestLabelDB(t *testing.T, initFn DBInitFn) {
+	t.Helper()
+
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	newFoo := func(id string, labels map[string]string) allsrv.Foo {
+		return allsrv.Foo{
+			ID:        id,
+			Name:      "name-" + id,
+			Note:      "note-" + id,
+			CreatedAt: start,
+			UpdatedAt: start,
+			Labels:    labels,
+		}
+	}
+
+	foos := []allsrv.Foo{
+		newFoo("1", map[string]string{"env": "prod", "team": "infra", "tier": "a"}),
+		newFoo("2", map[string]string{"env": "prod", "team": "web", "tier": "b"}),
+		newFoo("3", map[string]string{"env": "dev", "tier": "c"}),
+		newFoo("4", nil),
+	}
+
+	tests := []struct {
+		name    string
+		sel     string
+		wantIDs []string
+	}{
+		{
+			name:    "with empty selector should list every foo",
+			wantIDs: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:    "with equality selector",
+			sel:     "env=prod",
+			wantIDs: []string{"1", "2"},
+		},
+		{
+			name:    "with inequality selector should include foos missing the label",
+			sel:     "team!=infra",
+			wantIDs: []string{"2", "3", "4"},
+		},
+		{
+			name:    "with set selectors",
+			sel:     "tier in (a,c),env notin (dev)",
+			wantIDs: []string{"1"},
+		},
+		{
+			name:    "with existence selectors",
+			sel:     "tier,!team",
+			wantIDs: []string{"3"},
+		},
+		{
+			name: "with selector matching no foos",
+			sel:  "env=staging",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := initFn(t)
+			CreateFoos(foos...)(t, db)
+
+			sel, err := allsrv.ParseLabelSelector(tt.sel)
+			require.NoError(t, err)
+
+			got, err := db.ListFoos(context.TODO(), sel)
+			require.NoError(t, err)
+
+			var gotIDs []string
+			for _, f := range got {
+				gotIDs = append(gotIDs, f.ID)
+			}
+			assert.Equal(t, tt.wantIDs, gotIDs)
+		})
+	}
+
+	t.Run("listed foos should include their labels", func(t *testing.T) {
+		db := initFn(t)
+		CreateFoos(foos...)(t, db)
+
+		got, err := db.ListFoos(context.TODO(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, foos, got)
+	})
+
+	t.Run("updated labels should replace the existing labels", func(t *testing.T) {
+		db := initFn(t)
+		CreateFoos(foos[0])(t, db)
+
+		want := newFoo("1", map[string]string{"env": "dev"})
+		require.NoError(t, db.UpdateFoo(context.TODO(), want))
+
+		got, err := db.ReadFoo(context.TODO(), "1")
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+
+		listed, err := db.ListFoos(context.TODO(), allsrv.LabelSelector{{Key: "team", Op: allsrv.LabelOpExists}})
+		require.NoError(t, err)
+		assert.Empty(t, listed)
+	})
+
+	t.Run("deleted foo should not be listed by its labels", func(t *testing.T) {
+		db := initFn(t)
+		CreateFoos(foos...)(t, db)
+
+		require.NoError(t, db.DelFoo(context.TODO(), "1"))
+
+		got, err := db.ListFoos(context.TODO(), allsrv.LabelSelector{{Key: "team", Op: allsrv.LabelOpExists}})
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Equal(t, "2", got[0].ID)
+	})
+}
+
+// TestAttachmentDB verifies the DB implements the allsrv.AttachmentDB, and
+// abides by its contract.
+func TestAttachmentDB(t *testing.T, initFn DBInitFn) {
+	t.Helper()
+
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	newAttachment := func(id, fooID, name string) allsrv.Attachment {
+		return allsrv.Attachment{
+			ID:          id,
+			FooID:       fooID,
+			Name:        name,
+			ContentType: "text/plain",
+			Size:        3,
+			Digest:      "sha256:" + id,
+			CreatedAt:   start,
+		}
+	}
+
+	initAttachmentDB := func(t *testing.T) (allsrv.DB, allsrv.AttachmentDB) {
+		db := initFn(t)
+		CreateFoos(
+			allsrv.Foo{ID: "1", Name: "name-1", CreatedAt: start, UpdatedAt: start},
+			allsrv.Foo{ID: "2", Name: "name-2", CreatedAt: start, UpdatedAt: start},
+		)(t, db)
+
+		attDB, ok := db.(allsrv.AttachmentDB)
+		require.True(t, ok, "db does not implement allsrv.AttachmentDB")
+		return db, attDB
+	}
+
+	t.Run("created attachments should be readable and listed in order", func(t *testing.T) {
+		_, db := initAttachmentDB(t)
+
+		first, second := newAttachment("a", "1", "first.txt"), newAttachment("b", "1", "second.txt")
+		second.CreatedAt = start.Add(time.Minute)
+		require.NoError(t, db.CreateAttachment(context.TODO(), first))
+		require.NoError(t, db.CreateAttachment(context.TODO(), second))
+		require.NoError(t, db.CreateAttachment(context.TODO(), newAttachment("c", "2", "first.txt")))
+
+		got, err := db.ReadAttachment(context.TODO(), "1", "a")
+		require.NoError(t, err)
+		assert.Equal(t, first, got)
+
+		list, err := db.ListAttachments(context.TODO(), "1")
+		require.NoError(t, err)
+		assert.Equal(t, []allsrv.Attachment{first, second}, list)
+	})
+
+	t.Run("attachment with name of existing attachment of foo should fail", func(t *testing.T) {
+		_, db := initAttachmentDB(t)
+		require.NoError(t, db.CreateAttachment(context.TODO(), newAttachment("a", "1", "dupe.txt")))
+
+		err := db.CreateAttachment(context.TODO(), newAttachment("b", "1", "dupe.txt"))
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindExists), errors.Fields(err))
+	})
+
+	t.Run("attachment of non-existent foo should fail", func(t *testing.T) {
+		_, db := initAttachmentDB(t)
+
+		err := db.CreateAttachment(context.TODO(), newAttachment("a", "9000", "first.txt"))
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+
+		_, err = db.ListAttachments(context.TODO(), "9000")
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+	})
+
+	t.Run("attachment of another foo should not be found", func(t *testing.T) {
+		_, db := initAttachmentDB(t)
+		require.NoError(t, db.CreateAttachment(context.TODO(), newAttachment("a", "1", "first.txt")))
+
+		_, err := db.ReadAttachment(context.TODO(), "2", "a")
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+
+		err = db.DelAttachment(context.TODO(), "2", "a")
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+	})
+
+	t.Run("deleted attachment should no longer reference its digest", func(t *testing.T) {
+		_, db := initAttachmentDB(t)
+		shared := newAttachment("b", "2", "first.txt")
+		shared.Digest = "sha256:a"
+		require.NoError(t, db.CreateAttachment(context.TODO(), newAttachment("a", "1", "first.txt")))
+		require.NoError(t, db.CreateAttachment(context.TODO(), shared))
+
+		require.NoError(t, db.DelAttachment(context.TODO(), "1", "a"))
+		_, err := db.ReadAttachment(context.TODO(), "1", "a")
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+
+		referenced, err := db.DigestReferenced(context.TODO(), "sha256:a")
+		require.NoError(t, err)
+		assert.True(t, referenced)
+
+		require.NoError(t, db.DelAttachment(context.TODO(), "2", "b"))
+		referenced, err = db.DigestReferenced(context.TODO(), "sha256:a")
+		require.NoError(t, err)
+		assert.False(t, referenced)
+	})
+
+	t.Run("expired digests should be those of the attachments of expired foos", func(t *testing.T) {
+		fooDB, db := initAttachmentDB(t)
+		shared := newAttachment("c", "2", "first.txt")
+		shared.Digest = "sha256:a"
+		require.NoError(t, db.CreateAttachment(context.TODO(), newAttachment("a", "1", "first.txt")))
+		require.NoError(t, db.CreateAttachment(context.TODO(), newAttachment("b", "1", "second.txt")))
+		require.NoError(t, db.CreateAttachment(context.TODO(), shared))
+
+		digests, err := db.ExpiredDigests(context.TODO(), time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, digests)
+
+		expiresAt := time.Now().Add(time.Minute).UTC()
+		require.NoError(t, fooDB.UpdateFoo(context.TODO(), allsrv.Foo{
+			ID:        "1",
+			Name:      "name-1",
+			CreatedAt: start,
+			UpdatedAt: start,
+			ExpiresAt: expiresAt,
+		}))
+
+		digests, err = db.ExpiredDigests(context.TODO(), expiresAt.Add(time.Hour))
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"sha256:a", "sha256:b"}, digests)
+	})
+
+	t.Run("attachments should be deleted with their foo", func(t *testing.T) {
+		fooDB, db := initAttachmentDB(t)
+		require.NoError(t, db.CreateAttachment(context.TODO(), newAttachment("a", "1", "first.txt")))
+
+		require.NoError(t, fooDB.DelFoo(context.TODO(), "1"))
+
+		referenced, err := db.DigestReferenced(context.TODO(), "sha256:a")
+		require.NoError(t, err)
+		assert.False(t, referenced)
+	})
+}
+
+// TestRelationshipDB verifies the DB implements the allsrv.RelationshipDB,
+// and abides by its contract.
+func TestRelationshipDB(t *testing.T, initFn DBInitFn) {
+	t.Helper()
+
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	initRelationshipDB := func(t *testing.T) (allsrv.DB, allsrv.RelationshipDB) {
+		db := initFn(t)
+		CreateFoos(
+			allsrv.Foo{ID: "1", Name: "name-1", CreatedAt: start, UpdatedAt: start},
+			allsrv.Foo{ID: "2", Name: "name-2", CreatedAt: start, UpdatedAt: start},
+			allsrv.Foo{ID: "3", Name: "name-3", CreatedAt: start, UpdatedAt: start},
+		)(t, db)
+
+		relDB, ok := db.(allsrv.RelationshipDB)
+		require.True(t, ok, "db does not implement allsrv.RelationshipDB")
+		return db, relDB
+	}
+
+	t.Run("related foos should be read in the order they were related", func(t *testing.T) {
+		_, db := initRelationshipDB(t)
+
+		require.NoError(t, db.SetRelated(context.TODO(), "1", "depends_on", []string{"3", "2"}))
+		require.NoError(t, db.SetRelated(context.TODO(), "2", "depends_on", []string{"3"}))
+
+		related, err := db.ReadRelated(context.TODO(), "1", "depends_on")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"3", "2"}, related)
+
+		relatedTo, err := db.ReadRelatedTo(context.TODO(), "3", "depends_on")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1", "2"}, relatedTo)
+
+		related, err = db.ReadRelated(context.TODO(), "1", "parent")
+		require.NoError(t, err)
+		assert.Empty(t, related)
+	})
+
+	t.Run("set related foos should replace the related foos", func(t *testing.T) {
+		_, db := initRelationshipDB(t)
+		require.NoError(t, db.SetRelated(context.TODO(), "1", "depends_on", []string{"2", "3"}))
+
+		require.NoError(t, db.SetRelated(context.TODO(), "1", "depends_on", []string{"3"}))
+
+		related, err := db.ReadRelated(context.TODO(), "1", "depends_on")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"3"}, related)
+
+		require.NoError(t, db.SetRelated(context.TODO(), "1", "depends_on", nil))
+
+		related, err = db.ReadRelated(context.TODO(), "1", "depends_on")
+		require.NoError(t, err)
+		assert.Empty(t, related)
+	})
+
+	t.Run("relating non-existent foos should fail", func(t *testing.T) {
+		_, db := initRelationshipDB(t)
+		require.NoError(t, db.SetRelated(context.TODO(), "1", "depends_on", []string{"2"}))
+
+		err := db.SetRelated(context.TODO(), "1", "depends_on", []string{"3", "9000"})
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound), errors.Fields(err))
+
+		related, err := db.ReadRelated(context.TODO(), "1", "depends_on")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2"}, related)
+
+		err = db.SetRelated(context.TODO(), "9000", "depends_on", []string{"1"})
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+
+		_, err = db.ReadRelated(context.TODO(), "9000", "depends_on")
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+
+		_, err = db.ReadRelatedTo(context.TODO(), "9000", "depends_on")
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+	})
+
+	t.Run("deleting a foo related to by another foo should fail", func(t *testing.T) {
+		fooDB, db := initRelationshipDB(t)
+		require.NoError(t, db.SetRelated(context.TODO(), "2", "parent", []string{"1"}))
+
+		err := fooDB.DelFoo(context.TODO(), "1")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindExists), errors.Fields(err))
+		assert.Contains(t, err.Error(), "foo 1 is related to by the parent of foo 2")
+
+		_, err = fooDB.ReadFoo(context.TODO(), "1")
+		require.NoError(t, err)
+	})
+
+	t.Run("deleted foo should delete its relationships", func(t *testing.T) {
+		fooDB, db := initRelationshipDB(t)
+		require.NoError(t, db.SetRelated(context.TODO(), "2", "parent", []string{"1"}))
+
+		require.NoError(t, fooDB.DelFoo(context.TODO(), "2"))
+		require.NoError(t, fooDB.DelFoo(context.TODO(), "1"))
+
+		err := fooDB.DelFoo(context.TODO(), "9000")
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+	})
+
+	t.Run("expired foos should not be related", func(t *testing.T) {
+		fooDB, db := initRelationshipDB(t)
+		require.NoError(t, db.SetRelated(context.TODO(), "1", "depends_on", []string{"2", "3"}))
+		require.NoError(t, db.SetRelated(context.TODO(), "2", "depends_on", []string{"1"}))
+
+		require.NoError(t, fooDB.UpdateFoo(context.TODO(), allsrv.Foo{
+			ID:        "2",
+			Name:      "name-2",
+			CreatedAt: start,
+			UpdatedAt: start,
+			ExpiresAt: time.Now().Add(time.Millisecond),
+		}))
+		time.Sleep(5 * time.Millisecond)
+
+		related, err := db.ReadRelated(context.TODO(), "1", "depends_on")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"3"}, related)
+
+		err = db.SetRelated(context.TODO(), "3", "depends_on", []string{"2"})
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+
+		// the expired foo relating to the foo does not hold onto it
+		require.NoError(t, fooDB.DelFoo(context.TODO(), "1"))
+
+		expirer, ok := fooDB.(allsrv.FooExpirer)
+		if !ok {
+			return // the expired foo is only deleted by a FooExpirer
+		}
+		n, err := expirer.DelExpiredFoos(context.TODO(), time.Now(), 10)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		relatedTo, err := db.ReadRelatedTo(context.TODO(), "3", "depends_on")
+		require.NoError(t, err)
+		assert.Empty(t, relatedTo)
+	})
+}
+
+func doConcurrent(t *testing.T, foos []allsrv.Foo, doFn func(f allsrv.Foo) error) {
+	t.Helper()
+
+	// execute while rest of test completes
+	errs := make(chan []error, 1)
+	go func() {
+		errs <- concurrently(len(foos), func(i int) error {
+			return doFn(foos[i])
+		})
+	}()
+	t.Cleanup(func() {
+		t.Helper()
+
+		for _, err := range <-errs {
+			assert.NoError(t, err)
+		}
+	})
+}
+
+// TestMetadataDB verifies the DB keeps the metadata of the foos, and lists
+// the foos whose metadata matches the metadata filter.
+func TestMetadataDB(t *testing.T, initFn DBInitFn) {
+	t.Helper()
+
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	newFoo := func(id string, metadata map[string]any) allsrv.Foo {
+		return allsrv.Foo{
+			ID:        id,
+			Name:      "name-" + id,
+			Note:      "note-" + id,
+			CreatedAt: start,
+			UpdatedAt: start,
+			Metadata:  metadata,
+		}
+	}
+
+	foos := []allsrv.Foo{
+		newFoo("1", map[string]any{
+			"region":   "us",
+			"replicas": 3.0,
+			"public":   true,
+			"owner":    map[string]any{"team": "infra"},
+		}),
+		newFoo("2", map[string]any{
+			"region":   "eu",
+			"replicas": 1.5,
+			"public":   false,
+			"owner":    map[string]any{"team": "web"},
+			"tags":     []any{"a", "b"},
+		}),
+		newFoo("3", map[string]any{"region": "3", "owner": "infra"}),
+		newFoo("4", nil),
+	}
+
+	tests := []struct {
+		name    string
+		filter  allsrv.MetadataFilter
+		wantIDs []string
+	}{
+		{
+			name:    "with empty filter should list every foo",
+			wantIDs: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:    "with string value",
+			filter:  allsrv.MetadataFilter{{Path: []string{"region"}, Value: "us"}},
+			wantIDs: []string{"1"},
+		},
+		{
+			name:    "with nested path",
+			filter:  allsrv.MetadataFilter{{Path: []string{"owner", "team"}, Value: "infra"}},
+			wantIDs: []string{"1"},
+		},
+		{
+			name:    "with number value",
+			filter:  allsrv.MetadataFilter{{Path: []string{"replicas"}, Value: "1.5"}},
+			wantIDs: []string{"2"},
+		},
+		{
+			name:    "with integer number value",
+			filter:  allsrv.MetadataFilter{{Path: []string{"replicas"}, Value: "3"}},
+			wantIDs: []string{"1"},
+		},
+		{
+			name:    "with boolean value",
+			filter:  allsrv.MetadataFilter{{Path: []string{"public"}, Value: "false"}},
+			wantIDs: []string{"2"},
+		},
+		{
+			name:    "with string value of number text should only match strings",
+			filter:  allsrv.MetadataFilter{{Path: []string{"region"}, Value: "3"}},
+			wantIDs: []string{"3"},
+		},
+		{
+			name: "with many requirements should match every requirement",
+			filter: allsrv.MetadataFilter{
+				{Path: []string{"public"}, Value: "true"},
+				{Path: []string{"owner", "team"}, Value: "infra"},
+			},
+			wantIDs: []string{"1"},
+		},
+		{
+			name:   "with path of array should match no foos",
+			filter: allsrv.MetadataFilter{{Path: []string{"tags"}, Value: "a"}},
+		},
+		{
+			name:    "with path of object should only match foos with a value at the path",
+			filter:  allsrv.MetadataFilter{{Path: []string{"owner"}, Value: "infra"}},
+			wantIDs: []string{"3"},
+		},
+		{
+			name:   "with missing path should match no foos",
+			filter: allsrv.MetadataFilter{{Path: []string{"region", "zone"}, Value: "us"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := initFn(t)
+			CreateFoos(foos...)(t, db)
+
+			got, err := db.ListFoos(context.TODO(), nil, allsrv.WithListMetadata(tt.filter))
+			require.NoError(t, err)
+
+			var gotIDs []string
+			for _, f := range got {
+				gotIDs = append(gotIDs, f.ID)
+			}
+			assert.Equal(t, tt.wantIDs, gotIDs)
+		})
+	}
+
+	t.Run("foos should be read and listed with their metadata", func(t *testing.T) {
+		db := initFn(t)
+		CreateFoos(foos...)(t, db)
+
+		got, err := db.ReadFoo(context.TODO(), "2")
+		require.NoError(t, err)
+		assert.Equal(t, foos[1], got)
+
+		listed, err := db.ListFoos(context.TODO(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, foos, listed)
+	})
+
+	t.Run("updated metadata should replace the existing metadata", func(t *testing.T) {
+		db := initFn(t)
+		CreateFoos(foos[0])(t, db)
+
+		want := newFoo("1", map[string]any{"region": "eu"})
+		require.NoError(t, db.UpdateFoo(context.TODO(), want))
+
+		got, err := db.ReadFoo(context.TODO(), "1")
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+
+		want = newFoo("1", nil)
+		require.NoError(t, db.UpdateFoo(context.TODO(), want))
+
+		got, err = db.ReadFoo(context.TODO(), "1")
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+}
+
+func testDBConcurrentWriters(t *testing.T, initFn DBInitFn) {
+	t.Helper()
+
+	const writers = 10
+
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	newFoo := func(id, name string) allsrv.Foo {
+		return allsrv.Foo{ID: id, Name: name, CreatedAt: start, UpdatedAt: start}
+	}
+
+	t.Run("concurrent creates of the same name should create one foo", func(t *testing.T) {
+		db := initFn(t)
+
+		errs := concurrently(writers, func(i int) error {
+			return db.CreateFoo(context.TODO(), newFoo(strconv.Itoa(i), "collision"))
+		})
+
+		var created []string
+		for i, err := range errs {
+			if err == nil {
+				created = append(created, strconv.Itoa(i))
+				continue
+			}
+			assert.True(t, errors.Is(err, allsrv.ErrKindExists), "unexpected error: %v", err)
+		}
+		require.Len(t, created, 1)
+
+		foos, err := db.ListFoos(context.TODO(), nil)
+		require.NoError(t, err)
+		require.Len(t, foos, 1)
+		assert.Equal(t, newFoo(created[0], "collision"), foos[0])
+	})
+
+	t.Run("concurrent updates to the same name should update one foo", func(t *testing.T) {
+		db := initFn(t)
+		for i := range writers {
+			require.NoError(t, db.CreateFoo(context.TODO(), newFoo(strconv.Itoa(i), "name-"+strconv.Itoa(i))))
+		}
+
+		errs := concurrently(writers, func(i int) error {
+			return db.UpdateFoo(context.TODO(), newFoo(strconv.Itoa(i), "collision"))
+		})
+
+		var updated int
+		for _, err := range errs {
+			if err == nil {
+				updated++
+				continue
+			}
+			assert.True(t, errors.Is(err, allsrv.ErrKindExists), "unexpected error: %v", err)
+		}
+		assert.Equal(t, 1, updated)
+
+		foos, err := db.ListFoos(context.TODO(), nil)
+		require.NoError(t, err)
+		require.Len(t, foos, writers)
+
+		var collisions int
+		for _, f := range foos {
+			if f.Name == "collision" {
+				collisions++
+			}
+		}
+		assert.Equal(t, 1, collisions)
+	})
+
+	t.Run("concurrent deletes of the same foo should delete it once", func(t *testing.T) {
+		db := initFn(t)
+		CreateFoos(newFoo("1", "name-1"), newFoo("2", "name-2"))(t, db)
+
+		errs := concurrently(writers, func(int) error {
+			return db.DelFoo(context.TODO(), "1")
+		})
+
+		var deleted int
+		for _, err := range errs {
+			if err == nil {
+				deleted++
+				continue
+			}
+			assert.True(t, errors.Is(err, allsrv.ErrKindNotFound), "unexpected error: %v", err)
+		}
+		assert.Equal(t, 1, deleted)
+
+		foos, err := db.ListFoos(context.TODO(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, []allsrv.Foo{newFoo("2", "name-2")}, foos)
+	})
+
+	t.Run("concurrent writes of distinct foos should all be kept", func(t *testing.T) {
+		db := initFn(t)
+		for i := range writers {
+			require.NoError(t, db.CreateFoo(context.TODO(), newFoo("existing-"+strconv.Itoa(i), "existing-"+strconv.Itoa(i))))
+		}
+
+		errs := concurrently(writers, func(i int) error {
+			id := strconv.Itoa(i)
+			if err := db.CreateFoo(context.TODO(), newFoo("new-"+id, "new-"+id)); err != nil {
+				return err
+			}
+			upd := newFoo("existing-"+id, "updated-"+id)
+			upd.Note = "note-" + id
+			return db.UpdateFoo(context.TODO(), upd)
+		})
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+
+		for i := range writers {
+			id := strconv.Itoa(i)
+
+			created, err := db.ReadFoo(context.TODO(), "new-"+id)
+			require.NoError(t, err)
+			assert.Equal(t, newFoo("new-"+id, "new-"+id), created)
+
+			updated, err := db.ReadFoo(context.TODO(), "existing-"+id)
+			require.NoError(t, err)
+			assert.Equal(t, "updated-"+id, updated.Name)
+			assert.Equal(t, "note-"+id, updated.Note)
+		}
+	})
+}
+
+func testDBContextCancellation(t *testing.T, initFn DBInitFn) {
+	t.Helper()
+
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	existing := allsrv.Foo{ID: "1", Name: "name-1", Note: "note-1", CreatedAt: start, UpdatedAt: start}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		opFn func(db allsrv.DB) error
+	}{
+		{
+			name: "CreateFoo",
+			opFn: func(db allsrv.DB) error {
+				return db.CreateFoo(canceled, allsrv.Foo{ID: "2", Name: "name-2", CreatedAt: start, UpdatedAt: start})
+			},
+		},
+		{
+			name: "ReadFoo",
+			opFn: func(db allsrv.DB) error {
+				_, err := db.ReadFoo(canceled, "1")
+				return err
+			},
+		},
+		{
+			name: "UpdateFoo",
+			opFn: func(db allsrv.DB) error {
+				upd := existing
+				upd.Note = "canceled"
+				return db.UpdateFoo(canceled, upd)
+			},
+		},
+		{
+			name: "DelFoo",
+			opFn: func(db allsrv.DB) error {
+				return db.DelFoo(canceled, "1")
+			},
+		},
+		{
+			name: "ListFoos",
+			opFn: func(db allsrv.DB) error {
+				_, err := db.ListFoos(canceled, nil)
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+" with canceled context should fail without changes", func(t *testing.T) {
+			db := initFn(t)
+			CreateFoos(existing)(t, db)
+
+			err := tt.opFn(db)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+
+			foos, err := db.ListFoos(context.TODO(), nil)
+			require.NoError(t, err)
+			assert.Equal(t, []allsrv.Foo{existing}, foos)
+		})
+	}
+}
+
+// concurrently calls the fn n times concurrently, and provides the error of
+// each call once every call has returned.
+func concurrently(n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i)
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
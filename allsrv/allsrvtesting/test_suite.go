@@ -2,6 +2,7 @@ package allsrvtesting
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,7 +13,10 @@ import (
 	"github.com/jsteenb2/mess/allsrv"
 )
 
-var start = time.Time{}.Add(time.Hour).UTC()
+var (
+	start     = time.Time{}.Add(time.Hour).UTC()
+	expiresAt = time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)
+)
 
 type (
 	SVCInitFn func(t *testing.T, opts SVCTestOpts) SVCDeps
@@ -36,6 +40,7 @@ func TestSVC(t *testing.T, initFn SVCInitFn) {
 		{name: "Read", testFn: testSVCRead},
 		{name: "Update", testFn: testSVCUpdate},
 		{name: "Delete", testFn: testSVCDel},
+		{name: "List", testFn: testSVCList},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -53,6 +58,18 @@ func testSVCCreate(t *testing.T, initFn SVCInitFn) {
 		wantFn func(t *testing.T, newFoo allsrv.Foo, insertErr error)
 	)
 
+	schemaRules := allsrv.DefaultFooRules()
+	schema, err := allsrv.ParseMetadataSchema([]byte(`{
+		"type": "object",
+		"properties": {
+			"region": {"enum": ["us", "eu"]},
+			"replicas": {"type": "integer", "minimum": 1}
+		},
+		"required": ["region"]
+	}`))
+	require.NoError(t, err)
+	schemaRules.Metadata = schema
+
 	tests := []struct {
 		name  string
 		opts  SVCTestOpts
@@ -77,6 +94,22 @@ func testSVCCreate(t *testing.T, initFn SVCInitFn) {
 				})
 			},
 		},
+		{
+			name: "with foo name of any printable characters should pass",
+			input: inputs{
+				foo: allsrv.Foo{
+					Name: "first/foo: *not* the last!",
+					Note: "first note",
+				},
+			},
+			want: wantFoo(allsrv.Foo{
+				ID:        "1",
+				Name:      "first/foo: *not* the last!",
+				Note:      "first note",
+				CreatedAt: start,
+				UpdatedAt: start,
+			}),
+		},
 		{
 			name: "with valid foo missing note should pass",
 			input: inputs{
@@ -124,6 +157,171 @@ func testSVCCreate(t *testing.T, initFn SVCInitFn) {
 				assert.True(t, errors.Is(insertErr, allsrv.ErrKindInvalid))
 			},
 		},
+		{
+			name: "with foo violating multiple rules should fail",
+			input: inputs{
+				foo: allsrv.Foo{
+					Name: "invalid\x00name",
+					Note: strings.Repeat("a", 4097),
+				},
+			},
+			want: func(t *testing.T, _ allsrv.Foo, insertErr error) {
+				require.Error(t, insertErr)
+				assert.True(t, errors.Is(insertErr, allsrv.ErrKindInvalid))
+				assert.Contains(t, insertErr.Error(), "name must only contain")
+				assert.Contains(t, insertErr.Error(), "note must be at most 4096 bytes")
+			},
+		},
+		{
+			name: "with foo expiring in the future should pass",
+			input: inputs{
+				foo: allsrv.Foo{
+					Name:      "first_foo",
+					ExpiresAt: expiresAt,
+				},
+			},
+			want: func(t *testing.T, newFoo allsrv.Foo, insertErr error) {
+				wantFoo(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start,
+					UpdatedAt: start,
+					ExpiresAt: expiresAt,
+				})(t, newFoo, insertErr)
+			},
+		},
+		{
+			name: "with foo expiring in the past should fail",
+			input: inputs{
+				foo: allsrv.Foo{
+					Name:      "first_foo",
+					ExpiresAt: start.Add(-time.Minute),
+				},
+			},
+			want: func(t *testing.T, _ allsrv.Foo, insertErr error) {
+				require.Error(t, insertErr)
+				assert.True(t, errors.Is(insertErr, allsrv.ErrKindInvalid))
+				assert.Contains(t, insertErr.Error(), "expires_at must be in the future")
+			},
+		},
+		{
+			name: "with labeled foo should pass",
+			input: inputs{
+				foo: allsrv.Foo{
+					Name:   "first_foo",
+					Labels: map[string]string{"env": "prod", "team": "infra"},
+				},
+			},
+			want: func(t *testing.T, newFoo allsrv.Foo, insertErr error) {
+				wantFoo(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start,
+					UpdatedAt: start,
+					Labels:    map[string]string{"env": "prod", "team": "infra"},
+				})(t, newFoo, insertErr)
+			},
+		},
+		{
+			name: "with foo with invalid labels should fail",
+			input: inputs{
+				foo: allsrv.Foo{
+					Name:   "first_foo",
+					Labels: map[string]string{"bad key": "prod", "env": "not valid!"},
+				},
+			},
+			want: func(t *testing.T, _ allsrv.Foo, insertErr error) {
+				require.Error(t, insertErr)
+				assert.True(t, errors.Is(insertErr, allsrv.ErrKindInvalid))
+				assert.Contains(t, insertErr.Error(), `label key "bad key" must only contain`)
+				assert.Contains(t, insertErr.Error(), `label "env" value must only contain`)
+			},
+		},
+		{
+			name: "with foo with metadata should pass",
+			input: inputs{
+				foo: allsrv.Foo{
+					Name:     "first_foo",
+					Metadata: map[string]any{"region": "us", "replicas": 3, "owner": map[string]any{"team": "infra"}},
+				},
+			},
+			want: func(t *testing.T, newFoo allsrv.Foo, insertErr error) {
+				wantFoo(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start,
+					UpdatedAt: start,
+					Metadata:  map[string]any{"region": "us", "replicas": 3.0, "owner": map[string]any{"team": "infra"}},
+				})(t, newFoo, insertErr)
+			},
+		},
+		{
+			name: "with foo with metadata meeting the metadata schema should pass",
+			opts: SVCTestOpts{
+				SVCOpts: append(DefaultSVCOpts(start), allsrv.WithSVCFooRules(schemaRules)),
+			},
+			input: inputs{
+				foo: allsrv.Foo{
+					Name:     "first_foo",
+					Metadata: map[string]any{"region": "eu", "replicas": 2},
+				},
+			},
+			want: func(t *testing.T, newFoo allsrv.Foo, insertErr error) {
+				wantFoo(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start,
+					UpdatedAt: start,
+					Metadata:  map[string]any{"region": "eu", "replicas": 2.0},
+				})(t, newFoo, insertErr)
+			},
+		},
+		{
+			name: "with foo with metadata violating the metadata schema should fail",
+			opts: SVCTestOpts{
+				SVCOpts: append(DefaultSVCOpts(start), allsrv.WithSVCFooRules(schemaRules)),
+			},
+			input: inputs{
+				foo: allsrv.Foo{
+					Name:     "first_foo",
+					Metadata: map[string]any{"region": "ap", "replicas": 1.5},
+				},
+			},
+			want: func(t *testing.T, _ allsrv.Foo, insertErr error) {
+				require.Error(t, insertErr)
+				assert.True(t, errors.Is(insertErr, allsrv.ErrKindInvalid))
+				assert.Contains(t, insertErr.Error(), `metadata/region must be one of: "us", "eu"`)
+				assert.Contains(t, insertErr.Error(), "metadata/replicas must be of type integer")
+			},
+		},
+		{
+			name: "with foo missing metadata required by the metadata schema should fail",
+			opts: SVCTestOpts{
+				SVCOpts: append(DefaultSVCOpts(start), allsrv.WithSVCFooRules(schemaRules)),
+			},
+			input: inputs{
+				foo: allsrv.Foo{Name: "first_foo"},
+			},
+			want: func(t *testing.T, _ allsrv.Foo, insertErr error) {
+				require.Error(t, insertErr)
+				assert.True(t, errors.Is(insertErr, allsrv.ErrKindInvalid))
+				assert.Contains(t, insertErr.Error(), "metadata/region is required")
+			},
+		},
+		{
+			name: "with foo with metadata exceeding the max size should fail",
+			input: inputs{
+				foo: allsrv.Foo{
+					Name:     "first_foo",
+					Metadata: map[string]any{"blob": strings.Repeat("a", 16<<10)},
+				},
+			},
+			want: func(t *testing.T, _ allsrv.Foo, insertErr error) {
+				require.Error(t, insertErr)
+				assert.True(t, errors.Is(insertErr, allsrv.ErrKindInvalid))
+				assert.Contains(t, insertErr.Error(), "metadata must be at most 16384 bytes")
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -359,6 +557,188 @@ func testSVCUpdate(t *testing.T, initFn SVCInitFn) {
 				assert.True(t, errors.Is(updErr, allsrv.ErrKindExists))
 			},
 		},
+		{
+			name: "with update to an invalid name should fail",
+			opts: SVCTestOpts{
+				PrepDB: CreateFoos(allsrv.Foo{ID: "1", Name: "start-foo"}),
+			},
+			input: inputs{
+				upd: allsrv.FooUpd{
+					ID:   "1",
+					Name: Ptr(strings.Repeat("a", 129)),
+				},
+			},
+			want: func(t *testing.T, updatedFoo allsrv.Foo, updErr error) {
+				require.Error(t, updErr)
+				assert.True(t, errors.Is(updErr, allsrv.ErrKindInvalid))
+				assert.Contains(t, updErr.Error(), "name must be at most 128 characters")
+			},
+		},
+		{
+			name: "with expires_at update of existing foo should pass",
+			opts: SVCTestOpts{
+				PrepDB: CreateFoos(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start.Add(-time.Minute),
+					UpdatedAt: start.Add(-time.Minute),
+				}),
+			},
+			input: inputs{
+				upd: allsrv.FooUpd{
+					ID:        "1",
+					ExpiresAt: Ptr(expiresAt),
+				},
+			},
+			want: func(t *testing.T, updatedFoo allsrv.Foo, updErr error) {
+				wantFoo(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start.Add(-time.Minute),
+					UpdatedAt: start,
+					ExpiresAt: expiresAt,
+				})(t, updatedFoo, updErr)
+			},
+		},
+		{
+			name: "with zero expires_at update of expiring foo should remove the expiry",
+			opts: SVCTestOpts{
+				PrepDB: CreateFoos(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start.Add(-time.Minute),
+					UpdatedAt: start.Add(-time.Minute),
+					ExpiresAt: expiresAt,
+				}),
+			},
+			input: inputs{
+				upd: allsrv.FooUpd{
+					ID:        "1",
+					ExpiresAt: Ptr(time.Time{}),
+				},
+			},
+			want: func(t *testing.T, updatedFoo allsrv.Foo, updErr error) {
+				wantFoo(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start.Add(-time.Minute),
+					UpdatedAt: start,
+				})(t, updatedFoo, updErr)
+			},
+		},
+		{
+			name: "with expires_at in the past should fail",
+			opts: SVCTestOpts{
+				PrepDB: CreateFoos(allsrv.Foo{ID: "1", Name: "start-foo"}),
+			},
+			input: inputs{
+				upd: allsrv.FooUpd{
+					ID:        "1",
+					ExpiresAt: Ptr(start.Add(-time.Minute)),
+				},
+			},
+			want: func(t *testing.T, updatedFoo allsrv.Foo, updErr error) {
+				require.Error(t, updErr)
+				assert.True(t, errors.Is(updErr, allsrv.ErrKindInvalid))
+				assert.Contains(t, updErr.Error(), "expires_at must be in the future")
+			},
+		},
+		{
+			name: "with labels update of labeled foo should replace the labels",
+			opts: SVCTestOpts{
+				PrepDB: CreateFoos(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start.Add(-time.Minute),
+					UpdatedAt: start.Add(-time.Minute),
+					Labels:    map[string]string{"env": "prod", "team": "infra"},
+				}),
+			},
+			input: inputs{
+				upd: allsrv.FooUpd{
+					ID:     "1",
+					Labels: Ptr(map[string]string{"env": "dev"}),
+				},
+			},
+			want: func(t *testing.T, updatedFoo allsrv.Foo, updErr error) {
+				wantFoo(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start.Add(-time.Minute),
+					UpdatedAt: start,
+					Labels:    map[string]string{"env": "dev"},
+				})(t, updatedFoo, updErr)
+			},
+		},
+		{
+			name: "with metadata update of foo with metadata should replace the metadata",
+			opts: SVCTestOpts{
+				PrepDB: CreateFoos(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start.Add(-time.Minute),
+					UpdatedAt: start.Add(-time.Minute),
+					Metadata:  map[string]any{"region": "us", "owner": map[string]any{"team": "infra"}},
+				}),
+			},
+			input: inputs{
+				upd: allsrv.FooUpd{
+					ID:       "1",
+					Metadata: Ptr(map[string]any{"region": "eu"}),
+				},
+			},
+			want: func(t *testing.T, updatedFoo allsrv.Foo, updErr error) {
+				wantFoo(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start.Add(-time.Minute),
+					UpdatedAt: start,
+					Metadata:  map[string]any{"region": "eu"},
+				})(t, updatedFoo, updErr)
+			},
+		},
+		{
+			name: "with empty metadata update of foo with metadata should remove the metadata",
+			opts: SVCTestOpts{
+				PrepDB: CreateFoos(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start.Add(-time.Minute),
+					UpdatedAt: start.Add(-time.Minute),
+					Metadata:  map[string]any{"region": "us"},
+				}),
+			},
+			input: inputs{
+				upd: allsrv.FooUpd{
+					ID:       "1",
+					Metadata: Ptr(map[string]any{}),
+				},
+			},
+			want: func(t *testing.T, updatedFoo allsrv.Foo, updErr error) {
+				wantFoo(allsrv.Foo{
+					ID:        "1",
+					Name:      "first_foo",
+					CreatedAt: start.Add(-time.Minute),
+					UpdatedAt: start,
+				})(t, updatedFoo, updErr)
+			},
+		},
+		{
+			name: "with expired foo should fail",
+			opts: SVCTestOpts{
+				PrepDB: CreateFoos(allsrv.Foo{ID: "1", Name: "start-foo", ExpiresAt: start}),
+			},
+			input: inputs{
+				upd: allsrv.FooUpd{
+					ID:   "1",
+					Note: Ptr("new note"),
+				},
+			},
+			want: func(t *testing.T, updatedFoo allsrv.Foo, updErr error) {
+				require.Error(t, updErr)
+				assert.True(t, errors.Is(updErr, allsrv.ErrKindNotFound))
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -441,6 +821,125 @@ func testSVCDel(t *testing.T, initFn SVCInitFn) {
 	}
 }
 
+func testSVCList(t *testing.T, initFn SVCInitFn) {
+	type (
+		inputs struct {
+			sel    allsrv.LabelSelector
+			filter allsrv.MetadataFilter
+		}
+
+		wantFn func(t *testing.T, foos []allsrv.Foo, listErr error)
+	)
+
+	prep := CreateFoos(
+		allsrv.Foo{ID: "1", Name: "first_foo", CreatedAt: start, UpdatedAt: start, Labels: map[string]string{"env": "prod", "team": "infra"}},
+		allsrv.Foo{ID: "2", Name: "second_foo", CreatedAt: start, UpdatedAt: start, Labels: map[string]string{"env": "dev"}},
+		allsrv.Foo{ID: "3", Name: "third_foo", CreatedAt: start, UpdatedAt: start, Metadata: map[string]any{"region": "us", "owner": map[string]any{"team": "infra"}}},
+	)
+
+	tests := []struct {
+		name  string
+		opts  SVCTestOpts
+		input inputs
+		want  wantFn
+	}{
+		{
+			name:  "with empty selector should list every foo",
+			opts:  SVCTestOpts{PrepDB: prep},
+			input: inputs{},
+			want: func(t *testing.T, foos []allsrv.Foo, listErr error) {
+				require.NoError(t, listErr)
+				assert.Equal(t, []string{"1", "2", "3"}, fooIDs(foos))
+			},
+		},
+		{
+			name: "with selector should list the matching foos",
+			opts: SVCTestOpts{PrepDB: prep},
+			input: inputs{
+				sel: allsrv.LabelSelector{
+					{Key: "env", Op: allsrv.LabelOpIn, Values: []string{"prod", "dev"}},
+					{Key: "team", Op: allsrv.LabelOpNotEq, Values: []string{"infra"}},
+				},
+			},
+			want: func(t *testing.T, foos []allsrv.Foo, listErr error) {
+				require.NoError(t, listErr)
+				require.Len(t, foos, 1)
+				assert.Equal(t, allsrv.Foo{
+					ID:        "2",
+					Name:      "second_foo",
+					CreatedAt: start,
+					UpdatedAt: start,
+					Labels:    map[string]string{"env": "dev"},
+				}, foos[0])
+			},
+		},
+		{
+			name: "with selector matching no foos should list none",
+			opts: SVCTestOpts{PrepDB: prep},
+			input: inputs{
+				sel: allsrv.LabelSelector{{Key: "env", Op: allsrv.LabelOpEq, Values: []string{"staging"}}},
+			},
+			want: func(t *testing.T, foos []allsrv.Foo, listErr error) {
+				require.NoError(t, listErr)
+				assert.Empty(t, foos)
+			},
+		},
+		{
+			name: "with metadata filter should list the matching foos",
+			opts: SVCTestOpts{PrepDB: prep},
+			input: inputs{
+				filter: allsrv.MetadataFilter{
+					{Path: []string{"region"}, Value: "us"},
+					{Path: []string{"owner", "team"}, Value: "infra"},
+				},
+			},
+			want: func(t *testing.T, foos []allsrv.Foo, listErr error) {
+				require.NoError(t, listErr)
+				require.Len(t, foos, 1)
+				assert.Equal(t, allsrv.Foo{
+					ID:        "3",
+					Name:      "third_foo",
+					CreatedAt: start,
+					UpdatedAt: start,
+					Metadata:  map[string]any{"region": "us", "owner": map[string]any{"team": "infra"}},
+				}, foos[0])
+			},
+		},
+		{
+			name: "with metadata filter and selector should list the foos matching both",
+			opts: SVCTestOpts{PrepDB: prep},
+			input: inputs{
+				sel:    allsrv.LabelSelector{{Key: "env", Op: allsrv.LabelOpExists}},
+				filter: allsrv.MetadataFilter{{Path: []string{"region"}, Value: "us"}},
+			},
+			want: func(t *testing.T, foos []allsrv.Foo, listErr error) {
+				require.NoError(t, listErr)
+				assert.Empty(t, foos)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			deps := initFn(t, withTestOptions(tt.opts))
+
+			// action
+			got, err := deps.SVC.ListFoos(context.TODO(), tt.input.sel, allsrv.WithListMetadata(tt.input.filter))
+
+			// assert
+			tt.want(t, got, err)
+		})
+	}
+}
+
+func fooIDs(foos []allsrv.Foo) []string {
+	var ids []string
+	for _, f := range foos {
+		ids = append(ids, f.ID)
+	}
+	return ids
+}
+
 // withTestOptions provides some sane default values for tests.
 func withTestOptions(opts SVCTestOpts) SVCTestOpts {
 	if opts.PrepDB == nil {
@@ -0,0 +1,464 @@
+package allsrv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jsteenb2/errors"
+)
+
+// The ops of a bench.
+const (
+	BenchOpCreate = "create"
+	BenchOpRead   = "read"
+	BenchOpUpdate = "update"
+	BenchOpDelete = "delete"
+	BenchOpList   = "list"
+)
+
+var benchOps = []string{BenchOpCreate, BenchOpRead, BenchOpUpdate, BenchOpDelete, BenchOpList}
+
+// BenchMix is the relative weight of each op of a bench. The ops of a foo are
+// drawn from the foos created by the bench, a read, update or delete is made
+// as a create when no foo is available. A read or update may race the delete
+// of its foo, failing as not found.
+type BenchMix map[string]int
+
+// ParseBenchMix parses the comma separated weights of the ops of a bench
+// (i.e. create=1,read=4,update=2,delete=1,list=1). An op that is left out
+// is not made.
+func ParseBenchMix(s string) (BenchMix, error) {
+	mix := make(BenchMix)
+	for _, weight := range strings.Split(s, ",") {
+		op, w, ok := strings.Cut(strings.TrimSpace(weight), "=")
+		if !ok || !slices.Contains(benchOps, op) {
+			return nil, InvalidErr("invalid bench mix weight provided: " + weight + "; must be op=weight of the ops: " + strings.Join(benchOps, ", "))
+		}
+		n, err := strconv.Atoi(w)
+		if err != nil || n < 0 {
+			return nil, InvalidErr("invalid bench mix weight provided: " + weight + "; weight must be a non negative integer")
+		}
+		if _, ok := mix[op]; ok {
+			return nil, InvalidErr("bench mix weight of " + op + " must be provided once")
+		}
+		mix[op] = n
+	}
+	if mix.total() == 0 {
+		return nil, InvalidErr("bench mix must have a weight greater than 0")
+	}
+	return mix, nil
+}
+
+func (m BenchMix) String() string {
+	var weights []string
+	for _, op := range benchOps {
+		if w, ok := m[op]; ok {
+			weights = append(weights, op+"="+strconv.Itoa(w))
+		}
+	}
+	return strings.Join(weights, ",")
+}
+
+func (m BenchMix) total() int {
+	var total int
+	for _, w := range m {
+		total += w
+	}
+	return total
+}
+
+// pick picks the op with the roll, a number within [0, total).
+func (m BenchMix) pick(roll int) string {
+	for _, op := range benchOps {
+		if roll < m[op] {
+			return op
+		}
+		roll -= m[op]
+	}
+	return BenchOpCreate
+}
+
+// BenchConfig is the config of a bench. The bench runs until its duration
+// elapses or its number of ops is made, whichever comes first.
+type BenchConfig struct {
+	Mix BenchMix
+	// Concurrency is the number of workers making the ops.
+	Concurrency int
+	// Rate is the target ops per second across the workers, 0 leaves the ops
+	// unlimited.
+	Rate     float64
+	Duration time.Duration
+	// Ops is the number of ops to make, 0 leaves the ops unlimited.
+	Ops int
+	// Prefill is the number of foos created ahead of the bench, so the reads,
+	// updates, and deletes have foos to make the op with.
+	Prefill int
+	// Seed seeds the ops drawn by the workers.
+	Seed int64
+}
+
+// BenchReport is the result of a bench. The target describes what the bench
+// was run against (i.e. the addr of the server), and is left to the caller.
+type BenchReport struct {
+	Target      string          `json:"target,omitempty"`
+	Mix         BenchMix        `json:"mix"`
+	Concurrency int             `json:"concurrency"`
+	Rate        float64         `json:"rate,omitempty"`
+	Duration    time.Duration   `json:"-"`
+	Ops         int             `json:"ops"`
+	Errs        int             `json:"errs"`
+	Throughput  float64         `json:"throughput"` // ops per second
+	ErrKinds    map[string]int  `json:"err_kinds,omitempty"`
+	Latency     BenchLatency    `json:"latency"`
+	OpStats     []BenchOpReport `json:"op_stats"`
+}
+
+// BenchOpReport is the result of an op of a bench.
+type BenchOpReport struct {
+	Op         string         `json:"op"`
+	Ops        int            `json:"ops"`
+	Errs       int            `json:"errs"`
+	Throughput float64        `json:"throughput"` // ops per second
+	ErrKinds   map[string]int `json:"err_kinds,omitempty"`
+	Latency    BenchLatency   `json:"latency"`
+}
+
+// BenchLatency are the latency percentiles of the ops of a bench. The
+// latencies are provided in fractional ms by the JSON encoding.
+type BenchLatency struct {
+	Min  time.Duration
+	Mean time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+func (l BenchLatency) MarshalJSON() ([]byte, error) {
+	ms := func(d time.Duration) float64 {
+		return float64(d.Microseconds()) / 1000
+	}
+	return json.Marshal(struct {
+		Min  float64 `json:"min_ms"`
+		Mean float64 `json:"mean_ms"`
+		P50  float64 `json:"p50_ms"`
+		P90  float64 `json:"p90_ms"`
+		P99  float64 `json:"p99_ms"`
+		Max  float64 `json:"max_ms"`
+	}{ms(l.Min), ms(l.Mean), ms(l.P50), ms(l.P90), ms(l.P99), ms(l.Max)})
+}
+
+func (r BenchReport) MarshalJSON() ([]byte, error) {
+	type report BenchReport // drops the MarshalJSON of the report
+	return json.Marshal(struct {
+		report
+		DurationMS int64 `json:"duration_ms"`
+	}{report(r), r.Duration.Milliseconds()})
+}
+
+// WriteText writes the report as text, with the latencies and errors of each
+// op of the bench.
+func (r BenchReport) WriteText(w io.Writer) error {
+	if r.Target != "" {
+		fmt.Fprintf(w, "target: %s\n", r.Target)
+	}
+	fmt.Fprintf(w, "mix: %s concurrency: %d", r.Mix, r.Concurrency)
+	if r.Rate > 0 {
+		fmt.Fprintf(w, " rate: %g/s", r.Rate)
+	}
+	fmt.Fprintf(w, "\n%d ops in %s, %.1f ops/s, %d errs\n\n", r.Ops, r.Duration.Round(time.Millisecond), r.Throughput, r.Errs)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\tops\tops/s\terrs\tmin\tmean\tp50\tp90\tp99\tmax\t")
+	row := func(op string, ops int, throughput float64, errs int, l BenchLatency) {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			op, ops, throughput, errs,
+			fmtBenchLatency(l.Min), fmtBenchLatency(l.Mean), fmtBenchLatency(l.P50),
+			fmtBenchLatency(l.P90), fmtBenchLatency(l.P99), fmtBenchLatency(l.Max),
+		)
+	}
+	for _, s := range r.OpStats {
+		row(s.Op, s.Ops, s.Throughput, s.Errs, s.Latency)
+	}
+	row("total", r.Ops, r.Throughput, r.Errs, r.Latency)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(r.ErrKinds) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nerrors:")
+	for _, s := range r.OpStats {
+		for _, kind := range sortedKeys(s.ErrKinds) {
+			fmt.Fprintf(w, "  %s %s: %d\n", s.Op, kind, s.ErrKinds[kind])
+		}
+	}
+	return nil
+}
+
+func fmtBenchLatency(d time.Duration) string {
+	return d.Round(time.Microsecond).String()
+}
+
+// RunBench drives the mix of the config through the service, and reports the
+// throughput, errors, and latencies of the ops. The foos created ahead of the
+// bench are not part of the report.
+func RunBench(ctx context.Context, svc SVC, cfg BenchConfig) (BenchReport, error) {
+	if cfg.Mix.total() <= 0 {
+		return BenchReport{}, InvalidErr("bench mix must have a weight greater than 0")
+	}
+	if cfg.Concurrency <= 0 {
+		return BenchReport{}, InvalidErr("bench concurrency must be greater than 0")
+	}
+	if cfg.Rate < 0 {
+		return BenchReport{}, InvalidErr("bench rate must not be negative")
+	}
+	if cfg.Duration <= 0 && cfg.Ops <= 0 {
+		return BenchReport{}, InvalidErr("one of bench duration or ops must be greater than 0")
+	}
+
+	b := &bench{
+		svc:   svc,
+		run:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		stats: make(map[string]*benchOpStats),
+	}
+	for _, op := range benchOps {
+		b.stats[op] = &benchOpStats{errKinds: make(map[string]int)}
+	}
+	for range cfg.Prefill {
+		if _, err := b.create(ctx); err != nil {
+			return BenchReport{}, errors.Wrap(err, "failed to prefill bench foos")
+		}
+	}
+
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	ops := make(chan struct{})
+	go func() {
+		defer close(ops)
+
+		var tick <-chan time.Time
+		if interval := time.Duration(float64(time.Second) / cfg.Rate); cfg.Rate > 0 && interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for issued := 0; cfg.Ops <= 0 || issued < cfg.Ops; issued++ {
+			if tick != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-tick:
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ops <- struct{}{}:
+			}
+		}
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range cfg.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rnd := rand.New(rand.NewSource(cfg.Seed + int64(i)))
+			for range ops {
+				b.do(ctx, cfg.Mix.pick(rnd.Intn(cfg.Mix.total())), rnd)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return b.report(cfg, time.Since(start)), nil
+}
+
+type bench struct {
+	svc  SVC
+	run  string
+	next atomic.Int64
+
+	mu    sync.Mutex
+	ids   []string
+	stats map[string]*benchOpStats
+}
+
+type benchOpStats struct {
+	durations []time.Duration
+	errs      int
+	errKinds  map[string]int
+}
+
+// do makes the op, recording its latency and error. The latency of an op that
+// fails as the bench completes is not recorded.
+func (b *bench) do(ctx context.Context, op string, rnd *rand.Rand) {
+	id, ok := "", false
+	switch op {
+	case BenchOpRead, BenchOpUpdate:
+		id, ok = b.pickID(rnd, false)
+	case BenchOpDelete:
+		id, ok = b.pickID(rnd, true)
+	}
+	if !ok && op != BenchOpList {
+		op = BenchOpCreate
+	}
+
+	start := time.Now()
+	var err error
+	switch op {
+	case BenchOpCreate:
+		_, err = b.create(ctx)
+	case BenchOpRead:
+		_, err = b.svc.ReadFoo(ctx, id)
+	case BenchOpUpdate:
+		note := "bench update " + strconv.FormatInt(b.next.Add(1), 10)
+		_, err = b.svc.UpdateFoo(ctx, FooUpd{ID: id, Note: &note})
+	case BenchOpDelete:
+		err = b.svc.DelFoo(ctx, id)
+	case BenchOpList:
+		_, err = b.svc.ListFoos(ctx, nil)
+	}
+	took := time.Since(start)
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats[op]
+	stats.durations = append(stats.durations, took)
+	if err != nil {
+		stats.errs++
+		stats.errKinds[benchErrKind(err)]++
+	}
+}
+
+func (b *bench) create(ctx context.Context) (Foo, error) {
+	f, err := b.svc.CreateFoo(ctx, Foo{
+		Name: "bench-" + b.run + "-" + strconv.FormatInt(b.next.Add(1), 10),
+		Note: "bench",
+	})
+	if err == nil {
+		b.addID(f.ID)
+	}
+	return f, err
+}
+
+func (b *bench) addID(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ids = append(b.ids, id)
+}
+
+// pickID picks a foo created by the bench, removing it from the foos of the
+// bench when taken, so it is not deleted twice.
+func (b *bench) pickID(rnd *rand.Rand, take bool) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.ids) == 0 {
+		return "", false
+	}
+	i := rnd.Intn(len(b.ids))
+	id := b.ids[i]
+	if take {
+		b.ids[i] = b.ids[len(b.ids)-1]
+		b.ids = b.ids[:len(b.ids)-1]
+	}
+	return id, true
+}
+
+func (b *bench) report(cfg BenchConfig, took time.Duration) BenchReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := BenchReport{
+		Mix:         cfg.Mix,
+		Concurrency: cfg.Concurrency,
+		Rate:        cfg.Rate,
+		Duration:    took,
+		ErrKinds:    make(map[string]int),
+	}
+	var all []time.Duration
+	for _, op := range benchOps {
+		stats := b.stats[op]
+		if len(stats.durations) == 0 {
+			continue
+		}
+		out.OpStats = append(out.OpStats, BenchOpReport{
+			Op:         op,
+			Ops:        len(stats.durations),
+			Errs:       stats.errs,
+			Throughput: float64(len(stats.durations)) / took.Seconds(),
+			ErrKinds:   stats.errKinds,
+			Latency:    benchLatency(stats.durations),
+		})
+		out.Ops += len(stats.durations)
+		out.Errs += stats.errs
+		for kind, n := range stats.errKinds {
+			out.ErrKinds[kind] += n
+		}
+		all = append(all, stats.durations...)
+	}
+	out.Throughput = float64(out.Ops) / took.Seconds()
+	out.Latency = benchLatency(all)
+	return out
+}
+
+// benchLatency provides the percentiles of the durations by their nearest rank.
+func benchLatency(durations []time.Duration) BenchLatency {
+	if len(durations) == 0 {
+		return BenchLatency{}
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	percentile := func(p float64) time.Duration {
+		rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+		return sorted[min(max(rank, 0), len(sorted)-1)]
+	}
+	return BenchLatency{
+		Min:  sorted[0],
+		Mean: sum / time.Duration(len(sorted)),
+		P50:  percentile(0.5),
+		P90:  percentile(0.9),
+		P99:  percentile(0.99),
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+// benchErrKind provides the kind of the error of an op.
+func benchErrKind(err error) string {
+	for _, kind := range errKinds {
+		if errors.Is(err, kind) {
+			return string(kind)
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "deadline exceeded"
+	}
+	return "unknown"
+}
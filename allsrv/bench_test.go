@@ -0,0 +1,184 @@
+package allsrv_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jsteenb2/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+)
+
+func TestParseBenchMix(t *testing.T) {
+	t.Run("valid mix should be parsed", func(t *testing.T) {
+		mix, err := allsrv.ParseBenchMix("create=1, read=4,update=2,delete=0,list=1")
+		require.NoError(t, err)
+		assert.Equal(t, allsrv.BenchMix{"create": 1, "read": 4, "update": 2, "delete": 0, "list": 1}, mix)
+		assert.Equal(t, "create=1,read=4,update=2,delete=0,list=1", mix.String())
+	})
+
+	tests := []struct {
+		name    string
+		input   string
+		wantMsg string
+	}{
+		{
+			name:    "unknown op",
+			input:   "create=1,upsert=2",
+			wantMsg: "invalid bench mix weight provided: upsert=2; must be op=weight of the ops: create, read, update, delete, list",
+		},
+		{
+			name:    "missing weight",
+			input:   "create",
+			wantMsg: "invalid bench mix weight provided: create; must be op=weight of the ops: create, read, update, delete, list",
+		},
+		{
+			name:    "negative weight",
+			input:   "read=-1",
+			wantMsg: "invalid bench mix weight provided: read=-1; weight must be a non negative integer",
+		},
+		{
+			name:    "duplicate op",
+			input:   "read=1,read=2",
+			wantMsg: "bench mix weight of read must be provided once",
+		},
+		{
+			name:    "zero weights",
+			input:   "read=0,list=0",
+			wantMsg: "bench mix must have a weight greater than 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := allsrv.ParseBenchMix(tt.input)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, allsrv.ErrKindInvalid))
+			assert.Equal(t, tt.wantMsg, err.Error())
+		})
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	t.Run("ops of the mix should be reported", func(t *testing.T) {
+		db := new(allsrv.InmemDB)
+		report, err := allsrv.RunBench(context.TODO(), allsrv.NewService(db), allsrv.BenchConfig{
+			Mix:         allsrv.BenchMix{"create": 1, "read": 4, "list": 1},
+			Concurrency: 4,
+			Ops:         300,
+			Prefill:     10,
+			Seed:        1,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 300, report.Ops)
+		assert.Zero(t, report.Errs)
+		assert.Empty(t, report.ErrKinds)
+		assert.Positive(t, report.Throughput)
+
+		var ops []string
+		var total int
+		for _, s := range report.OpStats {
+			ops = append(ops, s.Op)
+			total += s.Ops
+			assert.LessOrEqual(t, s.Latency.Min, s.Latency.P50)
+			assert.LessOrEqual(t, s.Latency.P50, s.Latency.P90)
+			assert.LessOrEqual(t, s.Latency.P90, s.Latency.P99)
+			assert.LessOrEqual(t, s.Latency.P99, s.Latency.Max)
+		}
+		assert.Equal(t, []string{"create", "read", "list"}, ops)
+		assert.Equal(t, 300, total)
+
+		foos, err := db.ListFoos(context.TODO(), nil)
+		require.NoError(t, err)
+		assert.Len(t, foos, 10+report.OpStats[0].Ops)
+	})
+
+	t.Run("error kinds of the ops should be reported", func(t *testing.T) {
+		chaos := allsrv.NewChaos(1)
+		require.NoError(t, chaos.SetRules(allsrv.ChaosRule{Op: "read", Fault: allsrv.ChaosFaultError, Probability: 1, ErrKind: allsrv.ErrKindUnavailable}))
+		svc := allsrv.ChaosSVC(chaos)(allsrv.NewService(new(allsrv.InmemDB)))
+
+		report, err := allsrv.RunBench(context.TODO(), svc, allsrv.BenchConfig{
+			Mix:         allsrv.BenchMix{"read": 1, "update": 1},
+			Concurrency: 2,
+			Ops:         50,
+			Prefill:     5,
+			Seed:        1,
+		})
+		require.NoError(t, err)
+
+		require.Len(t, report.OpStats, 2)
+		reads := report.OpStats[0]
+		assert.Equal(t, "read", reads.Op)
+		assert.Equal(t, reads.Ops, reads.Errs)
+		assert.Equal(t, map[string]int{"unavailable": reads.Ops}, reads.ErrKinds)
+		assert.Equal(t, map[string]int{"unavailable": reads.Ops}, report.ErrKinds)
+		assert.Zero(t, report.OpStats[1].Errs)
+
+		var text bytes.Buffer
+		require.NoError(t, report.WriteText(&text))
+		assert.Contains(t, text.String(), "read unavailable: ")
+	})
+
+	t.Run("ops should be limited to the rate", func(t *testing.T) {
+		report, err := allsrv.RunBench(context.TODO(), allsrv.NewService(new(allsrv.InmemDB)), allsrv.BenchConfig{
+			Mix:         allsrv.BenchMix{"create": 1},
+			Concurrency: 4,
+			Rate:        200,
+			Ops:         10,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 10, report.Ops)
+		assert.GreaterOrEqual(t, report.Duration, 45*time.Millisecond)
+	})
+
+	t.Run("bench should end once its duration elapses", func(t *testing.T) {
+		report, err := allsrv.RunBench(context.TODO(), allsrv.NewService(new(allsrv.InmemDB)), allsrv.BenchConfig{
+			Mix:         allsrv.BenchMix{"create": 1, "read": 1},
+			Concurrency: 2,
+			Rate:        100,
+			Duration:    50 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		assert.Positive(t, report.Ops)
+		assert.Less(t, report.Duration, time.Second)
+	})
+
+	t.Run("report should be encoded as JSON", func(t *testing.T) {
+		report, err := allsrv.RunBench(context.TODO(), allsrv.NewService(new(allsrv.InmemDB)), allsrv.BenchConfig{
+			Mix:         allsrv.BenchMix{"create": 1},
+			Concurrency: 1,
+			Ops:         5,
+		})
+		require.NoError(t, err)
+		report.Target = "in-process inmem db"
+
+		b, err := json.Marshal(report)
+		require.NoError(t, err)
+
+		var got map[string]any
+		require.NoError(t, json.Unmarshal(b, &got))
+		assert.Equal(t, "in-process inmem db", got["target"])
+		assert.Equal(t, map[string]any{"create": float64(1)}, got["mix"])
+		assert.Equal(t, float64(5), got["ops"])
+		assert.Contains(t, got, "duration_ms")
+		assert.Contains(t, got["latency"], "p99_ms")
+	})
+
+	t.Run("invalid config should fail", func(t *testing.T) {
+		_, err := allsrv.RunBench(context.TODO(), allsrv.NewService(new(allsrv.InmemDB)), allsrv.BenchConfig{
+			Mix:         allsrv.BenchMix{"create": 1},
+			Concurrency: 1,
+		})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindInvalid))
+	})
+}
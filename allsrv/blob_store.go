@@ -0,0 +1,164 @@
+package allsrv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsteenb2/errors"
+)
+
+const blobDigestAlgo = "sha256:"
+
+// Blob is the content of an attachment, addressed by the SHA-256 digest of the
+// content (i.e. sha256:<hex>).
+type Blob struct {
+	Digest string
+	Size   int64
+}
+
+// BlobStore stores the content of attachments by its digest. Content that is
+// already stored is not stored again, so identical attachments share a blob.
+type BlobStore interface {
+	PutBlob(ctx context.Context, r io.Reader) (Blob, error)
+	OpenBlob(ctx context.Context, digest string) (io.ReadCloser, error)
+	DelBlob(ctx context.Context, digest string) error
+	BlobDigests(ctx context.Context) ([]string, error)
+}
+
+// FSBlobStore is a BlobStore on the local filesystem. Each blob is a file in the
+// dir, named by its digest.
+type FSBlobStore struct {
+	dir string
+}
+
+// NewFSBlobStore creates a blob store in the dir, creating the dir when it does
+// not exist.
+func NewFSBlobStore(dir string) (*FSBlobStore, error) {
+	s := FSBlobStore{dir: dir}
+	for _, d := range []string{s.blobsDir(), s.tmpDir()} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, errors.Wrap(err, "failed to create blob store dir")
+		}
+	}
+	return &s, nil
+}
+
+// PutBlob streams the content of r into the store. The content is written to a
+// temp file while its digest is computed, and is moved into place once the
+// content is complete. When r fails, nothing is stored.
+func (s *FSBlobStore) PutBlob(ctx context.Context, r io.Reader) (Blob, error) {
+	tmp, err := os.CreateTemp(s.tmpDir(), "blob-*")
+	if err != nil {
+		return Blob{}, errors.Wrap(err, "failed to create blob temp file")
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Blob{}, errors.Wrap(err, "failed to write blob")
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	blob := Blob{Digest: blobDigestAlgo + sum, Size: size}
+
+	path := s.blobPath(sum)
+	if _, err := os.Stat(path); err == nil {
+		return blob, nil // deduplicated
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return Blob{}, errors.Wrap(err, "failed to create blob dir")
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return Blob{}, errors.Wrap(err, "failed to move blob into place")
+	}
+	return blob, nil
+}
+
+// OpenBlob opens the content of the blob for reading.
+func (s *FSBlobStore) OpenBlob(_ context.Context, digest string) (io.ReadCloser, error) {
+	sum, err := parseBlobDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(s.blobPath(sum))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, NotFoundErr("blob not found for digest: "+digest, "digest", digest)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open blob")
+	}
+	return f, nil
+}
+
+// DelBlob deletes the blob. Deleting a blob that does not exist is not an
+// error.
+func (s *FSBlobStore) DelBlob(_ context.Context, digest string) error {
+	sum, err := parseBlobDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(s.blobPath(sum))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errors.Wrap(err, "failed to delete blob")
+	}
+	return nil
+}
+
+// BlobDigests provides the digests of every blob of the store.
+func (s *FSBlobStore) BlobDigests(ctx context.Context) ([]string, error) {
+	var digests []string
+	err := filepath.WalkDir(s.blobsDir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if _, err := parseBlobDigest(blobDigestAlgo + d.Name()); err == nil {
+			digests = append(digests, blobDigestAlgo+d.Name())
+		}
+		return nil
+	})
+	return digests, errors.Wrap(err, "failed to list blobs")
+}
+
+func (s *FSBlobStore) blobsDir() string { return filepath.Join(s.dir, "sha256") }
+
+func (s *FSBlobStore) tmpDir() string { return filepath.Join(s.dir, "tmp") }
+
+// blobPath provides the path of the blob, which is sharded by the first byte
+// of its digest so no single dir holds every blob.
+func (s *FSBlobStore) blobPath(sum string) string {
+	return filepath.Join(s.blobsDir(), sum[:2], sum)
+}
+
+// parseBlobDigest provides the hex encoded sum of the digest, which must be a
+// SHA-256 digest.
+func parseBlobDigest(digest string) (string, error) {
+	sum, ok := strings.CutPrefix(digest, blobDigestAlgo)
+	if b, err := hex.DecodeString(sum); !ok || err != nil || len(b) != sha256.Size || strings.ToLower(sum) != sum {
+		return "", InvalidErr("invalid blob digest: "+digest, "digest", digest)
+	}
+	return sum, nil
+}
@@ -0,0 +1,100 @@
+package allsrv_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/jsteenb2/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+)
+
+func TestFSBlobStore(t *testing.T) {
+	digestOf := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	t.Run("put blob should be addressed by the digest of its content", func(t *testing.T) {
+		store, err := allsrv.NewFSBlobStore(t.TempDir())
+		require.NoError(t, err)
+
+		blob, err := store.PutBlob(context.TODO(), strings.NewReader("some content"))
+		require.NoError(t, err)
+		assert.Equal(t, allsrv.Blob{Digest: digestOf("some content"), Size: 12}, blob)
+
+		rc, err := store.OpenBlob(context.TODO(), blob.Digest)
+		require.NoError(t, err)
+		defer rc.Close()
+
+		got, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		assert.Equal(t, "some content", string(got))
+	})
+
+	t.Run("identical content should be stored once", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := allsrv.NewFSBlobStore(dir)
+		require.NoError(t, err)
+
+		first, err := store.PutBlob(context.TODO(), strings.NewReader("dupe"))
+		require.NoError(t, err)
+		second, err := store.PutBlob(context.TODO(), strings.NewReader("dupe"))
+		require.NoError(t, err)
+		assert.Equal(t, first, second)
+
+		digests, err := store.BlobDigests(context.TODO())
+		require.NoError(t, err)
+		assert.Equal(t, []string{first.Digest}, digests)
+
+		tmps, err := os.ReadDir(filepath.Join(dir, "tmp"))
+		require.NoError(t, err)
+		assert.Empty(t, tmps)
+	})
+
+	t.Run("failed read should not store the blob", func(t *testing.T) {
+		store, err := allsrv.NewFSBlobStore(t.TempDir())
+		require.NoError(t, err)
+
+		r := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("read failed")))
+		_, err = store.PutBlob(context.TODO(), r)
+		require.Error(t, err)
+
+		digests, err := store.BlobDigests(context.TODO())
+		require.NoError(t, err)
+		assert.Empty(t, digests)
+	})
+
+	t.Run("deleted blob should not be found", func(t *testing.T) {
+		store, err := allsrv.NewFSBlobStore(t.TempDir())
+		require.NoError(t, err)
+
+		blob, err := store.PutBlob(context.TODO(), strings.NewReader("content"))
+		require.NoError(t, err)
+
+		require.NoError(t, store.DelBlob(context.TODO(), blob.Digest))
+		require.NoError(t, store.DelBlob(context.TODO(), blob.Digest))
+
+		_, err = store.OpenBlob(context.TODO(), blob.Digest)
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+	})
+
+	t.Run("invalid digest should fail", func(t *testing.T) {
+		store, err := allsrv.NewFSBlobStore(t.TempDir())
+		require.NoError(t, err)
+
+		for _, digest := range []string{"", "sha256:../../etc/passwd", "md5:" + strings.Repeat("a", 32)} {
+			_, err := store.OpenBlob(context.TODO(), digest)
+			assert.Truef(t, errors.Is(err, allsrv.ErrKindInvalid), "digest=%q", digest)
+		}
+	})
+}
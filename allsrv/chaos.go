@@ -0,0 +1,278 @@
+package allsrv
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jsteenb2/errors"
+)
+
+// ChaosFault is the fault a ChaosRule injects into an operation.
+type ChaosFault string
+
+const (
+	// ChaosFaultLatency delays the operation by the latency of the rule.
+	ChaosFaultLatency ChaosFault = "latency"
+	// ChaosFaultError fails the operation with an error of the kind of the rule.
+	ChaosFaultError ChaosFault = "error"
+	// ChaosFaultHang blocks the operation until its context is done.
+	ChaosFaultHang ChaosFault = "hang"
+)
+
+// The layers a ChaosRule injects faults into.
+const (
+	ChaosLayerDB  = "db"
+	ChaosLayerSVC = "svc"
+)
+
+var (
+	chaosLayers = []string{ChaosLayerDB, ChaosLayerSVC}
+	chaosOps    = []string{"create", "read", "update", "delete", "list"}
+)
+
+// ChaosRule injects a fault into the foo operations of a layer with the
+// probability of the rule.
+type ChaosRule struct {
+	Layer       string // one of db or svc, empty for both layers
+	Op          string // one of create, read, update, delete or list, empty for every op
+	Fault       ChaosFault
+	Probability float64       // within [0, 1]
+	Latency     time.Duration // of a latency fault
+	ErrKind     errors.Kind   // of an error fault
+}
+
+func (r ChaosRule) matches(layer, op string) bool {
+	return (r.Layer == "" || r.Layer == layer) && (r.Op == "" || r.Op == op)
+}
+
+// validate validates the rule, identifying the offending fields of the rule
+// by the attribute prefix.
+func (r ChaosRule) validate(attr string) error {
+	var errs []error
+	if r.Layer != "" && !slices.Contains(chaosLayers, r.Layer) {
+		errs = append(errs, InvalidErr(attr+"/layer must be one of: db, svc", "attribute", attr+"/layer"))
+	}
+	if r.Op != "" && !slices.Contains(chaosOps, r.Op) {
+		errs = append(errs, InvalidErr(attr+"/op must be one of: create, read, update, delete, list", "attribute", attr+"/op"))
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		errs = append(errs, InvalidErr(attr+"/probability must be within 0 and 1", "attribute", attr+"/probability"))
+	}
+	switch r.Fault {
+	case ChaosFaultLatency:
+		if r.Latency <= 0 {
+			errs = append(errs, InvalidErr(attr+"/latency must be greater than 0 for a latency fault", "attribute", attr+"/latency"))
+		}
+	case ChaosFaultError:
+		if !slices.Contains(errKinds, r.ErrKind) {
+			errs = append(errs, InvalidErr(attr+"/error_kind must be one of: exists, invalid, not found, unauthorized, internal, unavailable", "attribute", attr+"/error_kind"))
+		}
+	case ChaosFaultHang:
+	default:
+		errs = append(errs, InvalidErr(attr+"/fault must be one of: latency, error, hang", "attribute", attr+"/fault"))
+	}
+	return joinViolations(errs...)
+}
+
+// Chaos injects the faults of its rules into the DB and SVC decorated by
+// ChaosDB and ChaosSVC. The rules may be replaced at any time. The faults
+// are rolled from the seed of the chaos, so the same sequence of operations
+// sees the same faults for a given seed.
+type Chaos struct {
+	mu    sync.Mutex
+	seed  int64
+	rand  *rand.Rand
+	rules []ChaosRule
+}
+
+// NewChaos creates a chaos without any rules, rolling its faults from the seed.
+func NewChaos(seed int64) *Chaos {
+	return &Chaos{
+		seed: seed,
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Rules provides the rules of the chaos.
+func (c *Chaos) Rules() []ChaosRule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.rules)
+}
+
+// SetRules replaces the rules of the chaos. The rules are left untouched when
+// any of the rules is invalid.
+func (c *Chaos) SetRules(rules ...ChaosRule) error {
+	var errs []error
+	for i, r := range rules {
+		errs = append(errs, r.validate("rules/"+strconv.Itoa(i)))
+	}
+	if err := joinViolations(errs...); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = slices.Clone(rules)
+	return nil
+}
+
+// Seed provides the seed the faults are rolled from.
+func (c *Chaos) Seed() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seed
+}
+
+// Reseed restarts the rolls of the faults from the seed.
+func (c *Chaos) Reseed(seed int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seed, c.rand = seed, rand.New(rand.NewSource(seed))
+}
+
+// inject injects the faults rolled for the op of the layer. An injected error,
+// or the error of a context done during a latency or hang, is returned.
+func (c *Chaos) inject(ctx context.Context, layer, op string) error {
+	for _, r := range c.roll(layer, op) {
+		switch r.Fault {
+		case ChaosFaultLatency:
+			t := time.NewTimer(r.Latency)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return errors.Wrap(ctx.Err())
+			case <-t.C:
+			}
+		case ChaosFaultError:
+			msg := "chaos injected " + string(r.ErrKind) + " error into " + layer + " " + op
+			return errors.New(msg, r.ErrKind, errors.KVs("chaos_layer", layer, "chaos_op", op))
+		case ChaosFaultHang:
+			<-ctx.Done()
+			return errors.Wrap(ctx.Err())
+		}
+	}
+	return nil
+}
+
+// roll provides the rules whose fault is injected into the op of the layer.
+// Every rule of the op is rolled, in order, so the rolls of the op do not
+// depend on the faults of its prior rules.
+func (c *Chaos) roll(layer, op string) []ChaosRule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var out []ChaosRule
+	for _, r := range c.rules {
+		if r.matches(layer, op) && c.rand.Float64() < r.Probability {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// ChaosDB injects the faults of the chaos into the database, ahead of the
+// operations of the database.
+func ChaosDB(c *Chaos) func(DB) DB {
+	return func(next DB) DB {
+		return &chaosDB{
+			chaos: c,
+			next:  next,
+		}
+	}
+}
+
+type chaosDB struct {
+	chaos *Chaos
+	next  DB
+}
+
+func (d *chaosDB) CreateFoo(ctx context.Context, f Foo) error {
+	if err := d.chaos.inject(ctx, ChaosLayerDB, "create"); err != nil {
+		return err
+	}
+	return d.next.CreateFoo(ctx, f)
+}
+
+func (d *chaosDB) ReadFoo(ctx context.Context, id string, opts ...ReadOptFn) (Foo, error) {
+	if err := d.chaos.inject(ctx, ChaosLayerDB, "read"); err != nil {
+		return Foo{}, err
+	}
+	return d.next.ReadFoo(ctx, id, opts...)
+}
+
+func (d *chaosDB) UpdateFoo(ctx context.Context, f Foo) error {
+	if err := d.chaos.inject(ctx, ChaosLayerDB, "update"); err != nil {
+		return err
+	}
+	return d.next.UpdateFoo(ctx, f)
+}
+
+func (d *chaosDB) DelFoo(ctx context.Context, id string) error {
+	if err := d.chaos.inject(ctx, ChaosLayerDB, "delete"); err != nil {
+		return err
+	}
+	return d.next.DelFoo(ctx, id)
+}
+
+func (d *chaosDB) ListFoos(ctx context.Context, sel LabelSelector, opts ...ListOptFn) ([]Foo, error) {
+	if err := d.chaos.inject(ctx, ChaosLayerDB, "list"); err != nil {
+		return nil, err
+	}
+	return d.next.ListFoos(ctx, sel, opts...)
+}
+
+// ChaosSVC injects the faults of the chaos into the service, ahead of the
+// operations of the service.
+func ChaosSVC(c *Chaos) func(SVC) SVC {
+	return func(next SVC) SVC {
+		return &chaosSVC{
+			chaos: c,
+			next:  next,
+		}
+	}
+}
+
+type chaosSVC struct {
+	chaos *Chaos
+	next  SVC
+}
+
+func (s *chaosSVC) CreateFoo(ctx context.Context, f Foo) (Foo, error) {
+	if err := s.chaos.inject(ctx, ChaosLayerSVC, "create"); err != nil {
+		return Foo{}, err
+	}
+	return s.next.CreateFoo(ctx, f)
+}
+
+func (s *chaosSVC) ReadFoo(ctx context.Context, id string, opts ...ReadOptFn) (Foo, error) {
+	if err := s.chaos.inject(ctx, ChaosLayerSVC, "read"); err != nil {
+		return Foo{}, err
+	}
+	return s.next.ReadFoo(ctx, id, opts...)
+}
+
+func (s *chaosSVC) UpdateFoo(ctx context.Context, f FooUpd) (Foo, error) {
+	if err := s.chaos.inject(ctx, ChaosLayerSVC, "update"); err != nil {
+		return Foo{}, err
+	}
+	return s.next.UpdateFoo(ctx, f)
+}
+
+func (s *chaosSVC) DelFoo(ctx context.Context, id string) error {
+	if err := s.chaos.inject(ctx, ChaosLayerSVC, "delete"); err != nil {
+		return err
+	}
+	return s.next.DelFoo(ctx, id)
+}
+
+func (s *chaosSVC) ListFoos(ctx context.Context, sel LabelSelector, opts ...ListOptFn) ([]Foo, error) {
+	if err := s.chaos.inject(ctx, ChaosLayerSVC, "list"); err != nil {
+		return nil, err
+	}
+	return s.next.ListFoos(ctx, sel, opts...)
+}
@@ -0,0 +1,286 @@
+package allsrv_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsteenb2/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
+)
+
+func TestChaos(t *testing.T) {
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	newDB := func(t *testing.T) *allsrv.InmemDB {
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(allsrv.Foo{ID: "1", Name: "name-1", CreatedAt: start, UpdatedAt: start})(t, db)
+		return db
+	}
+
+	// injected rolls the faults of the chaos for n reads of the db.
+	injected := func(t *testing.T, db allsrv.DB, n int) []bool {
+		t.Helper()
+
+		out := make([]bool, 0, n)
+		for range n {
+			_, err := db.ReadFoo(context.TODO(), "1")
+			out = append(out, err != nil)
+		}
+		return out
+	}
+
+	t.Run("chaos db without rules should abide by the DB contract", func(t *testing.T) {
+		testDBSuites(t, func(t *testing.T) allsrv.DB {
+			return allsrv.ChaosDB(allsrv.NewChaos(1))(new(allsrv.InmemDB))
+		})
+	})
+
+	t.Run("faults should be deterministic for a seed", func(t *testing.T) {
+		rule := allsrv.ChaosRule{Layer: allsrv.ChaosLayerDB, Op: "read", Fault: allsrv.ChaosFaultError, Probability: 0.5, ErrKind: allsrv.ErrKindUnavailable}
+
+		newChaos := func(t *testing.T, seed int64) *allsrv.Chaos {
+			c := allsrv.NewChaos(seed)
+			require.NoError(t, c.SetRules(rule))
+			return c
+		}
+
+		chaos := newChaos(t, 42)
+		db := allsrv.ChaosDB(chaos)(newDB(t))
+		want := injected(t, db, 50)
+		assert.Contains(t, want, true)
+		assert.Contains(t, want, false)
+
+		assert.Equal(t, want, injected(t, allsrv.ChaosDB(newChaos(t, 42))(newDB(t)), 50))
+		assert.NotEqual(t, want, injected(t, allsrv.ChaosDB(newChaos(t, 7))(newDB(t)), 50))
+
+		chaos.Reseed(42)
+		assert.Equal(t, want, injected(t, db, 50))
+	})
+
+	t.Run("error fault should fail with the error kind of the rule", func(t *testing.T) {
+		db := newDB(t)
+		chaos := allsrv.NewChaos(1)
+		require.NoError(t, chaos.SetRules(allsrv.ChaosRule{
+			Layer:       allsrv.ChaosLayerSVC,
+			Op:          "create",
+			Fault:       allsrv.ChaosFaultError,
+			Probability: 1,
+			ErrKind:     allsrv.ErrKindUnavailable,
+		}))
+		svc := allsrv.ChaosSVC(chaos)(allsrv.NewService(allsrv.ChaosDB(chaos)(db)))
+
+		_, err := svc.CreateFoo(context.TODO(), allsrv.Foo{Name: "name-2"})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindUnavailable))
+
+		_, err = svc.ReadFoo(context.TODO(), "1")
+		require.NoError(t, err)
+
+		foos, err := db.ListFoos(context.TODO(), nil)
+		require.NoError(t, err)
+		assert.Len(t, foos, 1)
+	})
+
+	t.Run("latency fault should delay the op", func(t *testing.T) {
+		chaos := allsrv.NewChaos(1)
+		require.NoError(t, chaos.SetRules(allsrv.ChaosRule{Op: "read", Fault: allsrv.ChaosFaultLatency, Probability: 1, Latency: 20 * time.Millisecond}))
+		db := allsrv.ChaosDB(chaos)(newDB(t))
+
+		begin := time.Now()
+		f, err := db.ReadFoo(context.TODO(), "1")
+		require.NoError(t, err)
+		assert.Equal(t, "1", f.ID)
+		assert.GreaterOrEqual(t, time.Since(begin), 20*time.Millisecond)
+
+		require.NoError(t, chaos.SetRules(allsrv.ChaosRule{Op: "read", Fault: allsrv.ChaosFaultLatency, Probability: 1, Latency: time.Hour}))
+		ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err = db.ReadFoo(ctx, "1")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("hang fault should block until the context is done", func(t *testing.T) {
+		db := newDB(t)
+		chaos := allsrv.NewChaos(1)
+		require.NoError(t, chaos.SetRules(allsrv.ChaosRule{Layer: allsrv.ChaosLayerDB, Fault: allsrv.ChaosFaultHang, Probability: 1}))
+
+		ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+		defer cancel()
+
+		err := allsrv.ChaosDB(chaos)(db).CreateFoo(ctx, allsrv.Foo{ID: "2", Name: "name-2", CreatedAt: start, UpdatedAt: start})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+		_, err = db.ReadFoo(context.TODO(), "2")
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+	})
+
+	t.Run("invalid rules should not replace the rules", func(t *testing.T) {
+		valid := allsrv.ChaosRule{Fault: allsrv.ChaosFaultHang, Probability: 0.1}
+		chaos := allsrv.NewChaos(1)
+		require.NoError(t, chaos.SetRules(valid))
+
+		err := chaos.SetRules(
+			valid,
+			allsrv.ChaosRule{Layer: "cache", Op: "upsert", Fault: allsrv.ChaosFaultLatency, Probability: 1.5},
+			allsrv.ChaosRule{Fault: allsrv.ChaosFaultError, Probability: 1, ErrKind: "boom"},
+			allsrv.ChaosRule{Fault: "explode", Probability: 1},
+		)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindInvalid))
+
+		var attrs []string
+		for _, violation := range errors.Disjoin(err) {
+			attr, _ := errors.V(violation, "attribute").(string)
+			attrs = append(attrs, attr)
+		}
+		assert.Equal(t, []string{
+			"rules/1/layer",
+			"rules/1/op",
+			"rules/1/probability",
+			"rules/1/latency",
+			"rules/2/error_kind",
+			"rules/3/fault",
+		}, attrs)
+
+		assert.Equal(t, []allsrv.ChaosRule{valid}, chaos.Rules())
+	})
+}
+
+func TestServerV2Chaos(t *testing.T) {
+	type chaosResp struct {
+		Data struct {
+			Type  string            `json:"type"`
+			Attrs allsrv.ChaosAttrs `json:"attributes"`
+		} `json:"data"`
+		Errs []struct {
+			Status int    `json:"status"`
+			Msg    string `json:"message"`
+			Source struct {
+				Pointer string `json:"pointer"`
+			} `json:"source"`
+		} `json:"errors"`
+	}
+
+	newServer := func(t *testing.T) (*allsrv.ServerV2, *allsrv.Chaos) {
+		chaos := allsrv.NewChaos(42)
+		svc := allsrv.ChaosSVC(chaos)(allsrv.NewService(allsrv.ChaosDB(chaos)(new(allsrv.InmemDB))))
+		return allsrv.NewServerV2(svc, allsrv.WithBasicAuthV2("dodgers@stink.com", "PaSsWoRd"), allsrv.WithChaos(chaos)), chaos
+	}
+
+	do := func(t *testing.T, svr *allsrv.ServerV2, method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, req)
+		return rec
+	}
+
+	decode := func(t *testing.T, rec *httptest.ResponseRecorder) chaosResp {
+		t.Helper()
+
+		var resp chaosResp
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		return resp
+	}
+
+	t.Run("rules should be replaced by the admin route", func(t *testing.T) {
+		svr, chaos := newServer(t)
+
+		rec := do(t, svr, "GET", "/v1/admin/chaos", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		resp := decode(t, rec)
+		assert.Equal(t, "chaos", resp.Data.Type)
+		require.NotNil(t, resp.Data.Attrs.Seed)
+		assert.Equal(t, int64(42), *resp.Data.Attrs.Seed)
+		assert.Empty(t, resp.Data.Attrs.Rules)
+
+		rec = do(t, svr, "PUT", "/v1/admin/chaos", `{"data":{"type":"chaos","attributes":{"seed":7,"rules":[
+			{"layer":"db","op":"create","fault":"error","probability":1,"error_kind":"unavailable"},
+			{"layer":"svc","fault":"latency","probability":0.25,"latency":"250ms"}
+		]}}}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+		resp = decode(t, rec)
+		require.NotNil(t, resp.Data.Attrs.Seed)
+		assert.Equal(t, int64(7), *resp.Data.Attrs.Seed)
+		assert.Equal(t, []allsrv.ChaosRuleAttrs{
+			{Layer: "db", Op: "create", Fault: "error", Probability: 1, ErrKind: "unavailable"},
+			{Layer: "svc", Fault: "latency", Probability: 0.25, Latency: "250ms"},
+		}, resp.Data.Attrs.Rules)
+		assert.Equal(t, []allsrv.ChaosRule{
+			{Layer: allsrv.ChaosLayerDB, Op: "create", Fault: allsrv.ChaosFaultError, Probability: 1, ErrKind: allsrv.ErrKindUnavailable},
+			{Layer: allsrv.ChaosLayerSVC, Fault: allsrv.ChaosFaultLatency, Probability: 0.25, Latency: 250 * time.Millisecond},
+		}, chaos.Rules())
+
+		rec = do(t, svr, "POST", "/v1/foos", `{"data":{"type":"foo","attributes":{"name":"first_foo","note":"some note"}}}`)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+		rec = do(t, svr, "PUT", "/v1/admin/chaos", `{"data":{"type":"chaos","attributes":{"rules":[]}}}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, int64(7), chaos.Seed())
+		assert.Empty(t, chaos.Rules())
+
+		rec = do(t, svr, "POST", "/v1/foos", `{"data":{"type":"foo","attributes":{"name":"first_foo","note":"some note"}}}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("invalid rules should be rejected by the admin route", func(t *testing.T) {
+		svr, chaos := newServer(t)
+
+		rec := do(t, svr, "PUT", "/v1/admin/chaos", `{"data":{"type":"chaos","attributes":{"seed":7,"rules":[
+			{"fault":"latency","probability":1,"latency":"soon"}
+		]}}}`)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+
+		resp := decode(t, rec)
+		require.Len(t, resp.Errs, 1)
+		assert.Equal(t, "/data/attributes/rules/0/latency", resp.Errs[0].Source.Pointer)
+
+		rec = do(t, svr, "PUT", "/v1/admin/chaos", `{"data":{"type":"chaos","attributes":{"seed":7,"rules":[
+			{"fault":"error","probability":2,"error_kind":"unavailable"}
+		]}}}`)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+
+		resp = decode(t, rec)
+		require.Len(t, resp.Errs, 1)
+		assert.Equal(t, "/data/attributes/rules/0/probability", resp.Errs[0].Source.Pointer)
+		assert.Equal(t, "rules/0/probability must be within 0 and 1", resp.Errs[0].Msg)
+
+		assert.Equal(t, int64(42), chaos.Seed())
+		assert.Empty(t, chaos.Rules())
+	})
+
+	t.Run("admin route should require auth", func(t *testing.T) {
+		svr, _ := newServer(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/admin/chaos", nil))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("admin routes should not be registered without auth", func(t *testing.T) {
+		chaos := allsrv.NewChaos(42)
+		svr := allsrv.NewServerV2(allsrv.NewService(new(allsrv.InmemDB)), allsrv.WithChaos(chaos))
+
+		rec := do(t, svr, "PUT", "/v1/admin/chaos", `{"data":{"type":"chaos","attributes":{"seed":7,"rules":[]}}}`)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, int64(42), chaos.Seed())
+		assert.NotContains(t, svr.Routes(), "PUT /v1/admin/chaos")
+	})
+}
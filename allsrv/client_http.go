@@ -1,94 +1,292 @@
 package allsrv
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/hashicorp/go-metrics"
 	"github.com/jsteenb2/errors"
 
 	"github.com/jsteenb2/allsrvc"
 )
 
+// ClientHTTP is the http client of the foo service. The requests are made
+// directly, rather than with the allsrvc SDK, as the SDK neither provides
+// the foo attributes beyond the name and note, nor lists foos, nor encodes
+// with codecs other than json.
 type ClientHTTP struct {
-	c *allsrvc.ClientHTTP
+	hc           *http.Client
+	codec        Codec
+	addr, origin string
+	user, pass   string
 }
 
 var _ SVC = (*ClientHTTP)(nil)
 
-func NewClientHTTP(addr, origin string, c *http.Client, opts ...func(*allsrvc.ClientHTTP)) *ClientHTTP {
-	return &ClientHTTP{
-		c: allsrvc.NewClientHTTP(addr, origin, c, opts...),
+// ClientOptFn is a functional option for the ClientHTTP.
+type ClientOptFn func(*clientOpts)
+
+type clientOpts struct {
+	codec Codec
+
+	user, pass string
+
+	retry   retryPolicy
+	breaker breakerPolicy
+	met     *metrics.Metrics
+
+	cacheEntries int
+}
+
+// WithClientCodec sets the codec for the wire encoding of the requests and
+// responses. Defaults to json.
+func WithClientCodec(codec Codec) ClientOptFn {
+	return func(o *clientOpts) {
+		o.codec = codec
 	}
 }
 
-func (c *ClientHTTP) CreateFoo(ctx context.Context, f Foo) (Foo, error) {
-	resp, err := c.c.CreateFoo(ctx, allsrvc.FooCreateAttrs{
-		Name: f.Name,
-		Note: f.Note,
-	})
-	if err != nil {
-		return Foo{}, InternalErr(err.Error())
+// WithClientBasicAuth sets the basic auth credentials of the client.
+func WithClientBasicAuth(user, pass string) ClientOptFn {
+	return func(o *clientOpts) {
+		o.user, o.pass = user, pass
 	}
-	newFoo, err := takeRespFoo(resp)
-	return newFoo, errors.Wrap(err)
 }
 
-func (c *ClientHTTP) ReadFoo(ctx context.Context, id string) (Foo, error) {
-	resp, err := c.c.ReadFoo(ctx, id)
-	if err != nil {
-		if errors.Is(err, allsrvc.ErrIDRequired) {
-			return Foo{}, errIDRequired
-		}
+// NewClientHTTP creates a new http client for the foo service. Idempotent requests
+// are retried, and requests fail fast when the server is unhealthy. See
+// WithClientRetry and WithClientCircuitBreaker for the defaults.
+func NewClientHTTP(addr, origin string, c *http.Client, opts ...ClientOptFn) *ClientHTTP {
+	opt := clientOpts{
+		retry: retryPolicy{
+			maxAttempts: 3,
+			baseDelay:   100 * time.Millisecond,
+			maxDelay:    2 * time.Second,
+		},
+		breaker: breakerPolicy{
+			failThreshold: 5,
+			cooldown:      10 * time.Second,
+		},
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	hc := new(http.Client)
+	if c != nil {
+		*hc = *c
+	}
+	transport := cmp.Or(hc.Transport, http.DefaultTransport)
+	transport = &errRespTransport{next: transport}
+	transport = newCacheTransport(opt.cacheEntries, opt.met, transport)
+	if opt.met != nil {
+		transport = &metricsTransport{met: opt.met, next: transport}
 	}
+	transport = newBreakerTransport(opt.breaker, opt.met, transport)
+	hc.Transport = newRetryTransport(opt.retry, opt.met, transport)
 
-	newFoo, err := takeRespFoo(resp)
-	return newFoo, errors.Wrap(err)
+	return &ClientHTTP{
+		hc:     hc,
+		codec:  cmp.Or(opt.codec, JSONCodec(MediaTypeJSON)),
+		addr:   addr,
+		origin: origin,
+		user:   opt.user,
+		pass:   opt.pass,
+	}
+}
+
+func (c *ClientHTTP) CreateFoo(ctx context.Context, f Foo) (Foo, error) {
+	reqBody := allsrvc.ReqBody[FooCreateAttrs]{Data: allsrvc.Data[FooCreateAttrs]{
+		Type: resourceTypeFoo,
+		Attrs: FooCreateAttrs{
+			FooCreateAttrs: allsrvc.FooCreateAttrs{
+				Name: f.Name,
+				Note: f.Note,
+			},
+			ExpiresAt: toOptTimestamp(f.ExpiresAt),
+			Labels:    f.Labels,
+			Metadata:  f.Metadata,
+		},
+	}}
+	return c.doFoo(ctx, http.MethodPost, "/v1/foos", reqBody)
+}
+
+func (c *ClientHTTP) ReadFoo(ctx context.Context, id string, opts ...ReadOptFn) (Foo, error) {
+	if id == "" {
+		return Foo{}, errIDRequired
+	}
+	path := "/v1/foos/" + url.PathEscape(id)
+	if fields := newReadOpts(opts).Fields; len(fields) > 0 {
+		path += "?" + url.Values{fieldsParam(resourceTypeFoo): {strings.Join(fields, ",")}}.Encode()
+	}
+	return c.doFoo(ctx, http.MethodGet, path, nil)
 }
 
 func (c *ClientHTTP) UpdateFoo(ctx context.Context, f FooUpd) (Foo, error) {
-	resp, err := c.c.UpdateFoo(ctx, f.ID, allsrvc.FooUpdAttrs{
-		Name: f.Name,
-		Note: f.Note,
-	})
-	if err != nil {
-		return Foo{}, InternalErr(err.Error())
+	attrs := FooUpdAttrs{
+		FooUpdAttrs: allsrvc.FooUpdAttrs{
+			Name: f.Name,
+			Note: f.Note,
+		},
+		Labels:   f.Labels,
+		Metadata: f.Metadata,
+	}
+	if f.ExpiresAt != nil {
+		expiresAt := toOptTimestamp(*f.ExpiresAt)
+		attrs.ExpiresAt = &expiresAt
 	}
-	newFoo, err := takeRespFoo(resp)
-	return newFoo, errors.Wrap(err)
+	reqBody := allsrvc.ReqBody[FooUpdAttrs]{Data: allsrvc.Data[FooUpdAttrs]{
+		Type:  resourceTypeFoo,
+		ID:    f.ID,
+		Attrs: attrs,
+	}}
+	return c.doFoo(ctx, http.MethodPatch, "/v1/foos/"+url.PathEscape(f.ID), reqBody)
 }
 
 func (c *ClientHTTP) DelFoo(ctx context.Context, id string) error {
-	resp, err := c.c.DelFoo(ctx, id)
-	if err != nil {
-		if errors.Is(err, allsrvc.ErrIDRequired) {
-			return errIDRequired
-		}
+	if id == "" {
+		return errIDRequired
 	}
 
-	return errors.Wrap(convertSDKErrors(resp.Errs))
+	var respBody allsrvc.RespBody[any]
+	if err := c.do(ctx, http.MethodDelete, c.addr+"/v1/foos/"+url.PathEscape(id), nil, &respBody); err != nil {
+		return err
+	}
+	return errors.Wrap(convertSDKErrors(respBody.Errs))
 }
 
-func DataToFoo(data allsrvc.Data[allsrvc.ResourceFooAttrs]) Foo {
-	return Foo{
-		ID:        data.ID,
-		Name:      data.Attrs.Name,
-		Note:      data.Attrs.Note,
-		CreatedAt: toTime(data.Attrs.CreatedAt),
-		UpdatedAt: toTime(data.Attrs.UpdatedAt),
+func (c *ClientHTTP) ListFoos(ctx context.Context, sel LabelSelector, opts ...ListOptFn) ([]Foo, error) {
+	u, err := url.Parse(c.addr + "/v1/foos")
+	if err != nil {
+		return nil, errors.Wrap(err, ErrKindInvalid)
+	}
+	q := make(url.Values)
+	if len(sel) > 0 {
+		q.Set(labelsParam, sel.String())
+	}
+	for _, req := range newListOpts(opts).Metadata {
+		q.Set(metadataParam(req), req.Value)
 	}
+	u.RawQuery = q.Encode()
+
+	var respBody allsrvc.RespBody[[]allsrvc.Data[FooAttrs]]
+	if err := c.do(ctx, http.MethodGet, u.String(), nil, &respBody); err != nil {
+		return nil, err
+	}
+	if err := convertSDKErrors(respBody.Errs); err != nil {
+		return nil, errors.Wrap(err)
+	}
+	if respBody.Data == nil {
+		return nil, nil
+	}
+
+	return toSlc(respBody.Data.Attrs, FooDataToFoo), nil
 }
 
-func takeRespFoo(respBody allsrvc.RespBody[allsrvc.ResourceFooAttrs]) (Foo, error) {
+// doFoo requests the foo resource at the path, with the foo attributes the
+// allsrvc SDK does not provide.
+func (c *ClientHTTP) doFoo(ctx context.Context, method, path string, reqBody any) (Foo, error) {
+	var respBody allsrvc.RespBody[FooAttrs]
+	if err := c.do(ctx, method, c.addr+path, reqBody, &respBody); err != nil {
+		return Foo{}, err
+	}
 	if err := convertSDKErrors(respBody.Errs); err != nil {
 		return Foo{}, errors.Wrap(err)
 	}
-
 	if respBody.Data == nil {
 		return Foo{}, nil
 	}
 
-	return DataToFoo(*respBody.Data), nil
+	return FooDataToFoo(*respBody.Data), nil
+}
+
+// do sends the request with the origin and credentials of the client, and
+// decodes the response body into respBody. The bodies are encoded with the
+// codec of the client. Error responses the server did not encode with the
+// codec, such as those of a proxy, are decoded as json.
+func (c *ClientHTTP) do(ctx context.Context, method, addr string, reqBody, respBody any) error {
+	var body io.Reader
+	if reqBody != nil {
+		var buf bytes.Buffer
+		if err := c.codec.Encode(&buf, reqBody); err != nil {
+			return errors.Wrap(err, ErrKindInvalid)
+		}
+		body = &buf
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, addr, body)
+	if err != nil {
+		return errors.Wrap(err, ErrKindInvalid)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", c.codec.MediaType())
+	}
+	req.Header.Set("Accept", c.codec.MediaType())
+	req.Header.Set("Origin", c.origin)
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return transportErr(err)
+	}
+	defer resp.Body.Close()
+
+	codec := c.codec
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != codec.MediaType() {
+		codec = JSONCodec(mediaType)
+	}
+	if err := codec.Decode(resp.Body, respBody); err != nil {
+		return errors.Wrap(err, ErrKindInternal)
+	}
+	return nil
+}
+
+// transportErr converts the errors that occur before a response is received
+// to an error of the matching kind. Transport errors, including those of the
+// circuit breaker, are unavailable errors.
+func transportErr(err error) error {
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, ErrKindUnavailable):
+		return errors.Wrap(err)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded), errors.As(err, &urlErr):
+		return errors.Wrap(err, ErrKindUnavailable)
+	default:
+		return errors.Wrap(err, ErrKindInternal)
+	}
+}
+
+func DataToFoo(data allsrvc.Data[allsrvc.ResourceFooAttrs]) Foo {
+	return FooDataToFoo(allsrvc.Data[FooAttrs]{
+		Type:  data.Type,
+		ID:    data.ID,
+		Attrs: FooAttrs{ResourceFooAttrs: data.Attrs},
+	})
+}
+
+// FooDataToFoo converts the API representation of the foo, including the
+// attributes the allsrvc SDK does not provide, to the foo. It is the
+// inverse of FooToData.
+func FooDataToFoo(data allsrvc.Data[FooAttrs]) Foo {
+	return Foo{
+		ID:        data.ID,
+		Name:      data.Attrs.Name,
+		Note:      data.Attrs.Note,
+		CreatedAt: toTime(data.Attrs.CreatedAt),
+		UpdatedAt: toTime(data.Attrs.UpdatedAt),
+		ExpiresAt: toTime(data.Attrs.ExpiresAt),
+		Labels:    data.Attrs.Labels,
+		Metadata:  data.Attrs.Metadata,
+	}
 }
 
 func convertSDKErrors(errs []allsrvc.RespErr) error {
@@ -114,6 +312,8 @@ func toErr(respErr allsrvc.RespErr) error {
 		errFn = NotFoundErr
 	case errCodeUnAuthed:
 		errFn = unauthedErr
+	case errCodeUnavailable:
+		errFn = UnavailableErr
 	}
 	var fields []any
 	if respErr.Source != nil {
@@ -128,7 +328,7 @@ func toTime(in string) time.Time {
 }
 
 func toSlc[In, Out any](in []In, to func(In) Out) []Out {
-	out := make([]Out, len(in))
+	out := make([]Out, 0, len(in))
 	for _, v := range in {
 		out = append(out, to(v))
 	}
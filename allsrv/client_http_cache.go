@@ -0,0 +1,150 @@
+package allsrv
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/hashicorp/go-metrics"
+)
+
+// WithClientCache sets a local cache of read responses for the client, holding
+// up to maxEntries responses. A cached read is revalidated with the server
+// via a conditional request, and the cached response is provided when the
+// server reports it unmodified. Writes to a cached resource evict it.
+func WithClientCache(maxEntries int) ClientOptFn {
+	return func(o *clientOpts) {
+		o.cacheEntries = maxEntries
+	}
+}
+
+type cachedResp struct {
+	path   string
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (c cachedResp) toResp(r *http.Request) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(c.status) + " " + http.StatusText(c.status),
+		StatusCode:    c.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        c.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(c.body)),
+		ContentLength: int64(len(c.body)),
+		Request:       r,
+	}
+}
+
+// cacheTransport caches the read responses that provide validators, and
+// revalidates them with conditional requests.
+type cacheTransport struct {
+	maxEntries int
+	met        *metrics.Metrics
+	next       http.RoundTripper
+
+	mu      sync.Mutex
+	entries map[string]cachedResp
+	order   []string // insertion order of the keys, for eviction
+}
+
+func newCacheTransport(maxEntries int, met *metrics.Metrics, next http.RoundTripper) http.RoundTripper {
+	if maxEntries <= 0 {
+		return next
+	}
+	return &cacheTransport{
+		maxEntries: maxEntries,
+		met:        met,
+		next:       next,
+		entries:    make(map[string]cachedResp),
+	}
+}
+
+func (t *cacheTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet {
+		resp, err := t.next.RoundTrip(r)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			t.evictPath(r.URL.Path)
+		}
+		return resp, err
+	}
+
+	key := r.URL.String()
+	cached, ok := t.get(key)
+	if ok {
+		r = r.Clone(r.Context())
+		if etag := cached.header.Get("ETag"); etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := cached.header.Get("Last-Modified"); lastModified != "" {
+			r.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		incrClientCounter(t.met, "cache_hits", r)
+		return cached.toResp(r), nil
+	}
+	if resp.StatusCode != http.StatusOK || (resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "") {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	entry := cachedResp{path: r.URL.Path, status: resp.StatusCode, header: resp.Header.Clone(), body: body}
+	t.put(key, entry)
+
+	return entry.toResp(r), nil
+}
+
+func (t *cacheTransport) get(key string) (cachedResp, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.entries[key]
+	return c, ok
+}
+
+func (t *cacheTransport) put(key string, c cachedResp) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.entries[key]; !ok {
+		t.order = append(t.order, key)
+	}
+	t.entries[key] = c
+
+	for len(t.order) > t.maxEntries {
+		delete(t.entries, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+func (t *cacheTransport) evictPath(path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	order := t.order[:0]
+	for _, key := range t.order {
+		if c, ok := t.entries[key]; ok && c.path == path {
+			delete(t.entries, key)
+			continue
+		}
+		order = append(order, key)
+	}
+	t.order = order
+}
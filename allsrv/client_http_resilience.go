@@ -0,0 +1,382 @@
+package allsrv
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/jsteenb2/errors"
+
+	"github.com/jsteenb2/allsrvc"
+)
+
+// WithClientRetry sets the retry policy for idempotent requests. A request is
+// attempted at most maxAttempts times, where each retry waits a jittered
+// exponential backoff starting from baseDelay and capped at maxDelay. A
+// Retry-After provided by the server is honored in place of the backoff.
+// A maxAttempts of 1 disables retries.
+func WithClientRetry(maxAttempts int, baseDelay, maxDelay time.Duration) ClientOptFn {
+	return func(o *clientOpts) {
+		o.retry = retryPolicy{
+			maxAttempts: maxAttempts,
+			baseDelay:   baseDelay,
+			maxDelay:    maxDelay,
+		}
+	}
+}
+
+// WithClientCircuitBreaker sets the circuit breaker of the client. After
+// failThreshold consecutive failures, the circuit opens and requests fail fast
+// with an unavailable error until the cooldown elapses. A single trial request
+// is then permitted, closing the circuit on success. A failThreshold of 0
+// disables the circuit breaker.
+func WithClientCircuitBreaker(failThreshold int, cooldown time.Duration) ClientOptFn {
+	return func(o *clientOpts) {
+		o.breaker = breakerPolicy{
+			failThreshold: failThreshold,
+			cooldown:      cooldown,
+		}
+	}
+}
+
+// WithClientMetrics sets the metrics the client reports requests, retries, and
+// circuit breaker rejections to.
+func WithClientMetrics(met *metrics.Metrics) ClientOptFn {
+	return func(o *clientOpts) {
+		o.met = met
+	}
+}
+
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+type breakerPolicy struct {
+	failThreshold int
+	cooldown      time.Duration
+}
+
+var errCircuitOpen = UnavailableErr("circuit breaker is open; failing fast")
+
+// retryTransport retries idempotent requests that fail with a transport
+// error or a retryable status.
+type retryTransport struct {
+	policy retryPolicy
+	met    *metrics.Metrics
+	next   http.RoundTripper
+
+	randFn  func() float64
+	sleepFn func(r *http.Request, d time.Duration) error
+}
+
+func (t *retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !isIdempotent(r.Method) {
+		return t.next.RoundTrip(r)
+	}
+
+	for attempt := 1; ; attempt++ {
+		req := r
+		if attempt > 1 {
+			var err error
+			if req, err = rewind(r); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.policy.maxAttempts || r.Context().Err() != nil || !retryable(resp, err) || err == errCircuitOpen {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt, resp)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		incrClientCounter(t.met, "retries", r)
+
+		if err := t.sleepFn(r, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *retryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			return min(d, t.policy.maxDelay)
+		}
+	}
+
+	ceil := t.policy.baseDelay << (attempt - 1)
+	if ceil <= 0 || ceil > t.policy.maxDelay {
+		ceil = t.policy.maxDelay
+	}
+	return time.Duration(t.randFn() * float64(ceil)) // full jitter
+}
+
+func sleepCtx(r *http.Request, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-r.Context().Done():
+		return r.Context().Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfter parses the Retry-After header, provided in either delay
+// seconds or as an http date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+func rewind(r *http.Request) (*http.Request, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return r, nil
+	}
+	if r.GetBody == nil {
+		return nil, InternalErr("unable to retry request without a rewindable body")
+	}
+
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	req := r.Clone(r.Context())
+	req.Body = body
+	return req, nil
+}
+
+// breakerTransport fails requests fast when the server is unhealthy.
+type breakerTransport struct {
+	policy breakerPolicy
+	met    *metrics.Metrics
+	next   http.RoundTripper
+	nowFn  func() time.Time
+
+	mu        sync.Mutex
+	fails     int
+	openUntil time.Time
+	trialing  bool
+}
+
+func (t *breakerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !t.allow() {
+		incrClientCounter(t.met, "circuit_open", r)
+		return nil, errCircuitOpen
+	}
+
+	resp, err := t.next.RoundTrip(r)
+	if r.Context().Err() != nil {
+		// the caller gave up on the request, which says nothing of the server's health
+		t.endTrial()
+		return resp, err
+	}
+	t.record(err != nil || resp.StatusCode >= http.StatusInternalServerError)
+	return resp, err
+}
+
+func (t *breakerTransport) allow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.fails < t.policy.failThreshold {
+		return true
+	}
+	if t.trialing || t.nowFn().Before(t.openUntil) {
+		return false
+	}
+	t.trialing = true // half open, permit a single trial request
+	return true
+}
+
+func (t *breakerTransport) endTrial() {
+	t.mu.Lock()
+	t.trialing = false
+	t.mu.Unlock()
+}
+
+func (t *breakerTransport) record(failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.trialing = false
+	if !failed {
+		t.fails = 0
+		return
+	}
+	t.fails++
+	if t.fails >= t.policy.failThreshold {
+		t.openUntil = t.nowFn().Add(t.policy.cooldown)
+	}
+}
+
+// metricsTransport reports the requests made by the client.
+type metricsTransport struct {
+	met  *metrics.Metrics
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	name := []string{metricsPrefix, "client"}
+	labels := clientLabels(r)
+
+	t.met.IncrCounterWithLabels(append(name, "reqs"), 1, labels)
+
+	resp, err := t.next.RoundTrip(r)
+
+	status := "transport_error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	labels = append(labels, metrics.Label{Name: "status", Value: status})
+	if err != nil || resp.StatusCode > 299 {
+		t.met.IncrCounterWithLabels(append(name, "errs"), 1, labels)
+	}
+	t.met.MeasureSinceWithLabels(append(name, "dur"), start, labels)
+
+	return resp, err
+}
+
+func incrClientCounter(met *metrics.Metrics, metric string, r *http.Request) {
+	if met == nil {
+		return
+	}
+	met.IncrCounterWithLabels([]string{metricsPrefix, "client", metric}, 1, clientLabels(r))
+}
+
+func clientLabels(r *http.Request) []metrics.Label {
+	return []metrics.Label{
+		{
+			Name:  "method",
+			Value: r.Method,
+		},
+		{
+			Name:  "url_path",
+			Value: r.URL.Path,
+		},
+	}
+}
+
+func newRetryTransport(policy retryPolicy, met *metrics.Metrics, next http.RoundTripper) http.RoundTripper {
+	if policy.maxAttempts <= 1 {
+		return next
+	}
+	return &retryTransport{
+		policy:  policy,
+		met:     met,
+		next:    next,
+		randFn:  rand.Float64,
+		sleepFn: sleepCtx,
+	}
+}
+
+func newBreakerTransport(policy breakerPolicy, met *metrics.Metrics, next http.RoundTripper) http.RoundTripper {
+	if policy.failThreshold <= 0 {
+		return next
+	}
+	return &breakerTransport{
+		policy: policy,
+		met:    met,
+		next:   next,
+		nowFn:  time.Now,
+	}
+}
+
+// errRespTransport provides an error response body for error responses that
+// lack one, such as those from a proxy in front of the server, so that the
+// kind of the error is surfaced from the status of the response.
+type errRespTransport struct {
+	next http.RoundTripper
+}
+
+func (t *errRespTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(r)
+	if err != nil || resp.StatusCode < http.StatusBadRequest {
+		return resp, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if accept := r.Header.Get("Accept"); mediaType == MediaTypeJSON || mediaType == MediaTypeJSONAPI || (accept != "" && mediaType == accept) {
+		return resp, nil
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	var buf bytes.Buffer
+	err = json.NewEncoder(&buf).Encode(allsrvc.RespBody[any]{
+		Errs: []allsrvc.RespErr{{
+			Status: resp.StatusCode,
+			Code:   errCode(statusErrKind(resp.StatusCode)),
+			Msg:    "received error response: " + resp.Status,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body, resp.ContentLength = io.NopCloser(&buf), int64(buf.Len())
+	resp.Header.Set("Content-Type", MediaTypeJSON)
+	resp.Header.Del("Content-Length")
+
+	return resp, nil
+}
+
+// statusErrKind is the inverse of errStatus.
+func statusErrKind(status int) errors.Kind {
+	switch status {
+	case http.StatusConflict:
+		return ErrKindExists
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ErrKindInvalid
+	case http.StatusNotFound:
+		return ErrKindNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrKindUnAuthed
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ErrKindUnavailable
+	default:
+		return ErrKindInternal
+	}
+}
@@ -0,0 +1,216 @@
+package allsrv_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/jsteenb2/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
+)
+
+func TestClientHTTP(t *testing.T) {
+	start := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
+	newSVR := func(t *testing.T) http.Handler {
+		t.Helper()
+
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(allsrv.Foo{
+			ID:        "1",
+			Name:      "first-foo",
+			Note:      "some note",
+			CreatedAt: start,
+			UpdatedAt: start,
+		})(t, db)
+
+		return allsrv.NewServerV2(allsrv.NewService(db))
+	}
+
+	// unhealthy fails the first n requests with the status, before handing
+	// off to the next handler.
+	unhealthy := func(n int64, status int, next http.Handler) (http.Handler, *atomic.Int64) {
+		var calls atomic.Int64
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) <= n {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}), &calls
+	}
+
+	newClient := func(t *testing.T, h http.Handler, opts ...allsrv.ClientOptFn) *allsrv.ClientHTTP {
+		t.Helper()
+
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+
+		return allsrv.NewClientHTTP(srv.URL, "allsrv_test", &http.Client{Timeout: time.Second}, opts...)
+	}
+
+	t.Run("retries", func(t *testing.T) {
+		t.Run("when reading from an unavailable server should retry until success", func(t *testing.T) {
+			h, calls := unhealthy(2, http.StatusServiceUnavailable, newSVR(t))
+			client := newClient(t, h, allsrv.WithClientRetry(3, time.Millisecond, 10*time.Millisecond))
+
+			got, err := client.ReadFoo(context.TODO(), "1")
+			require.NoError(t, err)
+
+			assert.Equal(t, "first-foo", got.Name)
+			assert.Equal(t, int64(3), calls.Load())
+		})
+
+		t.Run("when the retries are exhausted should fail with unavailable error", func(t *testing.T) {
+			h, calls := unhealthy(5, http.StatusServiceUnavailable, newSVR(t))
+			client := newClient(t, h, allsrv.WithClientRetry(3, time.Millisecond, 10*time.Millisecond))
+
+			err := client.DelFoo(context.TODO(), "1")
+			require.Error(t, err)
+
+			assert.True(t, errors.Is(err, allsrv.ErrKindUnavailable), "got: %s", err)
+			assert.Equal(t, int64(3), calls.Load())
+		})
+
+		t.Run("when creating should not retry", func(t *testing.T) {
+			h, calls := unhealthy(1, http.StatusServiceUnavailable, newSVR(t))
+			client := newClient(t, h, allsrv.WithClientRetry(3, time.Millisecond, 10*time.Millisecond))
+
+			_, err := client.CreateFoo(context.TODO(), allsrv.Foo{Name: "second-foo"})
+			require.Error(t, err)
+
+			assert.True(t, errors.Is(err, allsrv.ErrKindUnavailable), "got: %s", err)
+			assert.Equal(t, int64(1), calls.Load())
+		})
+
+		t.Run("when the error is not retryable should not retry", func(t *testing.T) {
+			h, calls := unhealthy(0, 0, newSVR(t))
+			client := newClient(t, h, allsrv.WithClientRetry(3, time.Millisecond, 10*time.Millisecond))
+
+			_, err := client.ReadFoo(context.TODO(), "9000")
+			require.Error(t, err)
+
+			assert.True(t, errors.Is(err, allsrv.ErrKindNotFound), "got: %s", err)
+			assert.Equal(t, int64(1), calls.Load())
+		})
+	})
+
+	t.Run("circuit breaker", func(t *testing.T) {
+		t.Run("when the server is unhealthy should fail fast until the cooldown elapses", func(t *testing.T) {
+			h, calls := unhealthy(2, http.StatusBadGateway, newSVR(t))
+			client := newClient(t, h,
+				allsrv.WithClientRetry(1, 0, 0),
+				allsrv.WithClientCircuitBreaker(2, 50*time.Millisecond),
+			)
+
+			for i := 0; i < 2; i++ {
+				_, err := client.ReadFoo(context.TODO(), "1")
+				require.Error(t, err)
+			}
+
+			_, err := client.ReadFoo(context.TODO(), "1")
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, allsrv.ErrKindUnavailable), "got: %s", err)
+			assert.Contains(t, err.Error(), "circuit breaker is open")
+			assert.Equal(t, int64(2), calls.Load())
+
+			time.Sleep(60 * time.Millisecond)
+
+			got, err := client.ReadFoo(context.TODO(), "1")
+			require.NoError(t, err)
+			assert.Equal(t, "first-foo", got.Name)
+			assert.Equal(t, int64(3), calls.Load())
+		})
+	})
+
+	t.Run("transport errors", func(t *testing.T) {
+		srv := httptest.NewServer(newSVR(t))
+		srv.Close()
+
+		client := allsrv.NewClientHTTP(srv.URL, "allsrv_test", &http.Client{Timeout: time.Second}, allsrv.WithClientRetry(1, 0, 0))
+
+		t.Run("when reading should fail with unavailable error", func(t *testing.T) {
+			_, err := client.ReadFoo(context.TODO(), "1")
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, allsrv.ErrKindUnavailable), "got: %s", err)
+		})
+
+		t.Run("when deleting should fail with unavailable error", func(t *testing.T) {
+			err := client.DelFoo(context.TODO(), "1")
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, allsrv.ErrKindUnavailable), "got: %s", err)
+		})
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		t.Run("when reading an unmodified foo should revalidate the cached foo", func(t *testing.T) {
+			var statuses []int
+			svr := newSVR(t)
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				rec := httptest.NewRecorder()
+				svr.ServeHTTP(rec, r)
+				statuses = append(statuses, rec.Code)
+
+				for k, v := range rec.Header() {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(rec.Code)
+				w.Write(rec.Body.Bytes())
+			})
+			client := newClient(t, h, allsrv.WithClientCache(10))
+
+			first, err := client.ReadFoo(context.TODO(), "1")
+			require.NoError(t, err)
+
+			second, err := client.ReadFoo(context.TODO(), "1")
+			require.NoError(t, err)
+
+			assert.Equal(t, first, second)
+			assert.Equal(t, []int{http.StatusOK, http.StatusNotModified}, statuses)
+
+			updated, err := client.UpdateFoo(context.TODO(), allsrv.FooUpd{ID: "1", Note: allsrvtesting.Ptr("new note")})
+			require.NoError(t, err)
+
+			third, err := client.ReadFoo(context.TODO(), "1")
+			require.NoError(t, err)
+
+			assert.Equal(t, updated, third)
+			assert.Equal(t, []int{http.StatusOK, http.StatusNotModified, http.StatusOK, http.StatusOK}, statuses)
+		})
+	})
+
+	t.Run("should report client metrics", func(t *testing.T) {
+		sink := metrics.NewInmemSink(time.Minute, time.Minute)
+		met, err := metrics.New(&metrics.Config{FilterDefault: true, TimerGranularity: time.Millisecond}, sink)
+		require.NoError(t, err)
+
+		h, _ := unhealthy(1, http.StatusServiceUnavailable, newSVR(t))
+		client := newClient(t, h,
+			allsrv.WithClientRetry(3, time.Millisecond, 10*time.Millisecond),
+			allsrv.WithClientMetrics(met),
+		)
+
+		_, err = client.ReadFoo(context.TODO(), "1")
+		require.NoError(t, err)
+
+		counters := make(map[string]int)
+		for _, interval := range sink.Data() {
+			for k, v := range interval.Counters {
+				name, _, _ := strings.Cut(k, ";")
+				counters[name] += v.Count
+			}
+		}
+		assert.Equal(t, 2, counters["mess.client.reqs"])
+		assert.Equal(t, 1, counters["mess.client.errs"])
+		assert.Equal(t, 1, counters["mess.client.retries"])
+	})
+}
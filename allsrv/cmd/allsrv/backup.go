@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jsteenb2/mess/allsrv"
+)
+
+func cmdBackup() *cobra.Command {
+	var (
+		to     string
+		dir    string
+		retain int
+	)
+	cmd := cobra.Command{
+		Use:   "backup",
+		Short: "make an online backup of the sqlite db of ALLSRV_SQLITE_DSN",
+		Long: `make an online backup of the sqlite db of ALLSRV_SQLITE_DSN.
+
+The backup is written to the file provided with --to, or otherwise into the
+backup dir, where only the newest backups are retained. The db remains
+available to a running server during the backup.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openSQLiteNoMigrate()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			var backup allsrv.SQLiteBackup
+			switch {
+			case to != "":
+				backup, err = allsrv.BackupSQLite(cmd.Context(), db, to)
+			case dir != "":
+				backup, err = allsrv.NewSQLiteBackups(db, dir, retain).Backup(cmd.Context())
+			default:
+				return errors.New("one of --to or --dir (env: ALLSRV_BACKUP_DIR) must be provided")
+			}
+			if err != nil {
+				return err
+			}
+
+			return writeBackup(cmd, backup)
+		},
+	}
+	cmd.Flags().StringVar(&to, "to", "", "file to write the backup to")
+	cmd.Flags().StringVar(&dir, "dir", os.Getenv("ALLSRV_BACKUP_DIR"), "dir to write the backup to, retaining the newest backups (env: ALLSRV_BACKUP_DIR)")
+	cmd.Flags().IntVar(&retain, "retain", backupRetain(), "number of backups retained in the dir, 0 retains every backup (env: ALLSRV_BACKUP_RETAIN)")
+
+	return &cmd
+}
+
+func cmdRestore() *cobra.Command {
+	var (
+		from         string
+		validateOnly bool
+	)
+	cmd := cobra.Command{
+		Use:   "restore",
+		Short: "restore a backup into the sqlite db of ALLSRV_SQLITE_DSN",
+		Long: `restore a backup into the sqlite db of ALLSRV_SQLITE_DSN, replacing its contents.
+
+The backup is validated before it is restored. A backup with a schema version
+newer than the migrations of this allsrv is rejected, while a backup with an
+older schema version is migrated after the restore. Stop the servers of the db
+before restoring it.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if from == "" {
+				return errors.New("the backup to restore must be provided with --from")
+			}
+
+			if validateOnly {
+				backup, err := allsrv.ValidateSQLiteBackup(cmd.Context(), from)
+				if err != nil {
+					return err
+				}
+				return writeBackup(cmd, backup)
+			}
+
+			db, err := openSQLiteNoMigrate()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			backup, err := allsrv.RestoreSQLite(cmd.Context(), db, from)
+			if err != nil {
+				return err
+			}
+			if err := migrateSQLite(db); err != nil {
+				return err
+			}
+
+			return writeBackup(cmd, backup)
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "file of the backup to restore")
+	cmd.Flags().BoolVar(&validateOnly, "validate-only", false, "validate the backup without restoring it")
+
+	return &cmd
+}
+
+// openSQLiteNoMigrate opens the sqlite db of ALLSRV_SQLITE_DSN as is, so
+// the backup and restore do not alter the schema of the db.
+func openSQLiteNoMigrate() (*sql.DB, error) {
+	dsn := os.Getenv("ALLSRV_SQLITE_DSN")
+	if dsn == "" {
+		return nil, errors.New("the sqlite db must be provided with the ALLSRV_SQLITE_DSN env var")
+	}
+	return sql.Open(sqliteDriver, dsn)
+}
+
+func writeBackup(cmd *cobra.Command, backup allsrv.SQLiteBackup) error {
+	return json.NewEncoder(cmd.OutOrStdout()).Encode(map[string]any{
+		"name":           backup.Name,
+		"path":           backup.Path,
+		"schema_version": backup.SchemaVersion,
+		"size":           backup.Size,
+		"created_at":     backup.CreatedAt,
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jsteenb2/mess/allsrv"
+)
+
+func cmdBench() *cobra.Command {
+	var (
+		addr, user, pass string
+		mix              string
+		cfg              allsrv.BenchConfig
+		migrateUp        bool
+		output           string
+	)
+	cmd := cobra.Command{
+		Use:   "bench",
+		Short: "drive a mix of foo traffic and report the throughput, errors and latencies",
+		Long: `drive a mix of foo traffic and report the throughput, errors and latencies.
+
+The traffic is sent to the server at --addr through the http client, without
+retries or a circuit breaker. Without --addr, the traffic is sent to an
+in-process service of the sqlite db of ALLSRV_SQLITE_DSN, or of an in-memory
+db when the dsn is not set.
+
+The bench runs for the --duration, or until --ops are made. The foos created by
+the bench are left in the db.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "text" && output != "json" {
+				return errors.New("invalid output provided: " + output + "; must be one of: text, json")
+			}
+			var err error
+			if cfg.Mix, err = allsrv.ParseBenchMix(mix); err != nil {
+				return err
+			}
+
+			svc, target, closeFn, err := benchSVC(addr, user, pass, cfg.Concurrency, migrateUp)
+			if err != nil {
+				return err
+			}
+			defer closeFn()
+
+			report, err := allsrv.RunBench(cmd.Context(), svc, cfg)
+			if err != nil {
+				return err
+			}
+			report.Target = target
+
+			if output == "json" {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(report)
+			}
+			return report.WriteText(cmd.OutOrStdout())
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", "", "addr of the server to bench (i.e. http://localhost:8091), defaults to an in-process service")
+	cmd.Flags().StringVar(&user, "user", "admin", "user for basic auth of the server")
+	cmd.Flags().StringVar(&pass, "password", "pass", "password for basic auth of the server")
+	cmd.Flags().StringVar(&mix, "mix", "create=2,read=5,update=2,delete=1", "weights of the ops as op=weight, of the ops: create, read, update, delete, list")
+	cmd.Flags().IntVarP(&cfg.Concurrency, "concurrency", "c", 8, "number of workers making the ops")
+	cmd.Flags().Float64Var(&cfg.Rate, "rate", 0, "target ops per second across the workers, 0 makes the ops as fast as the workers can")
+	cmd.Flags().DurationVarP(&cfg.Duration, "duration", "d", 10*time.Second, "duration of the bench, 0 runs until the ops are made")
+	cmd.Flags().IntVarP(&cfg.Ops, "ops", "n", 0, "number of ops to make, 0 makes ops until the duration elapses")
+	cmd.Flags().IntVar(&cfg.Prefill, "prefill", 100, "number of foos created ahead of the bench")
+	cmd.Flags().Int64Var(&cfg.Seed, "seed", time.Now().UnixNano(), "seed of the ops drawn by the workers, defaults to a seed from the current time")
+	cmd.Flags().BoolVar(&migrateUp, "migrate", os.Getenv("ALLSRV_MIGRATE_ON_START") == "true", "apply the migrations of the in-process sqlite db (env: ALLSRV_MIGRATE_ON_START)")
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "output format, one of: text, json")
+
+	return &cmd
+}
+
+// benchSVC provides the service the bench drives, and the target it describes.
+func benchSVC(addr, user, pass string, concurrency int, migrateUp bool) (allsrv.SVC, string, func(), error) {
+	if addr != "" {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConnsPerHost = concurrency
+
+		client := allsrv.NewClientHTTP(addr, "allsrv-bench", &http.Client{Transport: transport, Timeout: 5 * time.Second},
+			allsrv.WithClientBasicAuth(user, pass),
+			allsrv.WithClientRetry(1, 0, 0),
+			allsrv.WithClientCircuitBreaker(0, 0),
+		)
+		return client, addr, transport.CloseIdleConnections, nil
+	}
+
+	dsn := os.Getenv("ALLSRV_SQLITE_DSN")
+	if dsn == "" {
+		return allsrv.NewService(new(allsrv.InmemDB)), "in-process inmem db", func() {}, nil
+	}
+
+	dbx, err := openSQLite(dsn, migrateUp)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return allsrv.NewService(allsrv.NewSQLiteDB(dbx)), "in-process sqlite db " + dsn, func() { dbx.Close() }, nil
+}
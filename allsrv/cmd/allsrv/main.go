@@ -2,38 +2,88 @@ package main
 
 import (
 	"cmp"
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
-	migsqlite "github.com/golang-migrate/migrate/v4/database/sqlite3"
-	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/hashicorp/go-metrics"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/spf13/cobra"
 
 	"github.com/jsteenb2/mess/allsrv"
-	"github.com/jsteenb2/mess/allsrv/migrations"
 )
 
 func main() {
+	cmd := newCmd()
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCmd() *cobra.Command {
+	var migrateOnStart bool
+	cmd := cobra.Command{
+		Use:   "allsrv",
+		Short: "serves the foo svc",
+		Long: `serves the foo svc.
+
+The schema of the sqlite db is not migrated at startup unless --migrate is
+provided. Without it, the server refuses to serve a db whose schema is behind
+the migrations or dirty; apply the migrations with allsrv migrate up.`,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			serve(migrateOnStart)
+		},
+	}
+	cmd.Flags().BoolVar(&migrateOnStart, "migrate", os.Getenv("ALLSRV_MIGRATE_ON_START") == "true", "apply the migrations of the sqlite db at startup (env: ALLSRV_MIGRATE_ON_START)")
+
+	cmd.AddCommand(
+		cmdBackup(),
+		cmdRestore(),
+		cmdMigrate(),
+		cmdBench(),
+		cmdReplay(),
+	)
+	return &cmd
+}
+
+func serve(migrateOnStart bool) {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{AddSource: true}))
 
-	var db allsrv.DB = new(allsrv.InmemDB)
+	var (
+		db     allsrv.DB = new(allsrv.InmemDB)
+		sqlDB  *sql.DB
+		v2Opts []allsrv.SvrOptFn
+	)
 	if dsn := os.Getenv("ALLSRV_SQLITE_DSN"); dsn != "" {
-		var err error
-		db, err = newSQLiteDB(dsn)
+		dbx, err := openSQLite(dsn, migrateOnStart)
 		if err != nil {
 			logger.Error("failed to open sqlite db", "err", err.Error())
 			os.Exit(1)
 		}
+		db, sqlDB = allsrv.NewSQLiteDB(dbx), dbx.DB
 		logger.Info("sqlite database opened", "dsn", dsn)
+
+		if dir := os.Getenv("ALLSRV_BACKUP_DIR"); dir != "" {
+			backups := allsrv.NewSQLiteBackups(dbx.DB, dir, backupRetain())
+			v2Opts = append(v2Opts, allsrv.WithSQLiteBackups(backups))
+
+			if interval, err := time.ParseDuration(os.Getenv("ALLSRV_BACKUP_INTERVAL")); err == nil && interval > 0 {
+				logger.Info("scheduling sqlite backups", "dir", dir, "interval", interval.String())
+				go backups.Schedule(context.Background(), interval, logger)
+			}
+		}
 	}
 
 	mux := http.NewServeMux()
@@ -45,25 +95,143 @@ func main() {
 	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 
-	selectedSVR := strings.TrimSpace(strings.ToLower(os.Getenv("ALLSRV_SERVER")))
-	if selectedSVR != "v2" {
-		logger.Info("registering v1 server")
-		allsrv.NewServer(db, allsrv.WithBasicAuth("admin", "pass"), allsrv.WithMux(mux))
+	mux.Handle("GET /readyz", allsrv.ReadyzHandler(sqlDB))
+
+	rules := allsrv.DefaultFooRules()
+	if path := os.Getenv("ALLSRV_METADATA_SCHEMA"); path != "" {
+		schema, err := readMetadataSchema(path)
+		if err != nil {
+			logger.Error("failed to read metadata schema", "path", path, "err", err.Error())
+			os.Exit(1)
+		}
+		rules.Metadata = schema
+		logger.Info("metadata schema enabled", "path", path)
 	}
-	if selectedSVR != "v1" {
-		logger.Info("registering v2 server")
 
-		var svc allsrv.SVC = allsrv.NewService(db)
+	met, err := metrics.New(metrics.DefaultConfig("allsrv"), metrics.NewInmemSink(5*time.Second, time.Minute))
+	if err != nil {
+		logger.Error("failed to create metrics", "err", err.Error())
+		os.Exit(1)
+	}
+
+	svcDB := db
+	var chaos *allsrv.Chaos
+	if os.Getenv("ALLSRV_CHAOS") == "true" {
+		chaos = allsrv.NewChaos(chaosSeed())
+		svcDB = allsrv.ChaosDB(chaos)(svcDB)
+		v2Opts = append(v2Opts, allsrv.WithChaos(chaos))
+		logger.Warn("chaos enabled, faults are injected per the rules of the admin chaos route", "seed", chaos.Seed())
+	}
+
+	var (
+		v1Opts   []allsrv.SvrOptFn
+		legacyDB = svcDB
+		mirror   *allsrv.InmemDB
+	)
+	if os.Getenv("ALLSRV_SHADOW") == "true" {
+		// the shadow writes the legacy requests to a mirror of the db, so the
+		// legacy requests are compared against the v2 svc without writing to
+		// the db twice. Every other write is mirrored as is.
+		mirror = new(allsrv.InmemDB)
+		n, err := allsrv.MirrorFoos(context.Background(), db, mirror)
+		if err != nil {
+			logger.Error("failed to mirror foos for the shadow", "err", err.Error())
+			os.Exit(1)
+		}
+		svcDB = allsrv.MirrorDB(mirror)(svcDB)
+
+		shadow := allsrv.NewShadow(mirror, logger, met, allsrv.WithShadowSVCOpts(allsrv.WithSVCFooRules(rules)))
+		go shadow.Run(context.Background())
+		v1Opts = append(v1Opts, allsrv.WithShadow(shadow))
+		logger.Info("shadowing legacy requests against the v2 svc", "mirrored_foos", n)
+	}
+
+	var attachments *allsrv.Attachments
+	if dir := os.Getenv("ALLSRV_ATTACHMENTS_DIR"); dir != "" {
+		blobs, err := allsrv.NewFSBlobStore(dir)
+		if err != nil {
+			logger.Error("failed to open attachments blob store", "err", err.Error())
+			os.Exit(1)
+		}
+		attDB, ok := db.(allsrv.AttachmentDB)
+		if !ok {
+			logger.Error("attachments are not supported by the db", "db", fmt.Sprintf("%T", db))
+			os.Exit(1)
+		}
+		attachments = allsrv.NewAttachments(attDB, blobs, allsrv.WithAttachmentsMaxSize(attachmentsMaxSize()))
+		v2Opts = append(v2Opts, allsrv.WithAttachments(attachments))
+		logger.Info("attachments enabled", "dir", dir, "max_size", attachments.MaxSize())
+
+		// blobs left behind by a prior run are pruned at startup
+		if n, err := attachments.PruneBlobs(context.Background()); err != nil {
+			logger.Error("failed to prune attachment blobs", "err", err.Error())
+		} else if n > 0 {
+			logger.Info("pruned attachment blobs", "pruned", n)
+		}
+	}
+
+	relDB, ok := db.(allsrv.RelationshipDB)
+	if !ok {
+		logger.Error("relationships are not supported by the db", "db", fmt.Sprintf("%T", db))
+		os.Exit(1)
+	}
+	v2Opts = append(v2Opts, allsrv.WithRelationships(allsrv.NewRelationships(relDB)))
+
+	newSVC := func(db allsrv.DB) allsrv.SVC {
+		var svc allsrv.SVC = allsrv.NewService(db, allsrv.WithSVCFooRules(rules))
+		if attachments != nil {
+			svc = allsrv.SVCAttachmentsCascade(attachments)(svc)
+		}
+		if chaos != nil { // put chaos ahead of the logging and metrics so the injected faults are observed
+			svc = allsrv.ChaosSVC(chaos)(svc)
+		}
 		svc = allsrv.SVCLogging(logger)(svc)
+		return allsrv.ObserveSVC(met)(svc)
+	}
+	svc, legacySVC := newSVC(svcDB), newSVC(legacyDB)
+
+	if expirer, ok := db.(allsrv.FooExpirer); ok {
+		if attachments != nil {
+			expirer = allsrv.ExpirerAttachmentsCascade(attachments)(expirer)
+		}
+		if mirror != nil {
+			expirer = allsrv.MirrorExpirer(mirror)(expirer)
+		}
+		if interval := reapInterval(); interval > 0 {
+			logger.Info("reaping expired foos", "interval", interval.String())
+			go allsrv.NewFooReaper(expirer, reapBatch(), met).Run(context.Background(), interval, logger)
+		}
+	}
 
-		met, err := metrics.New(metrics.DefaultConfig("allsrv"), metrics.NewInmemSink(5*time.Second, time.Minute))
+	if path := os.Getenv("ALLSRV_TRAFFIC_RECORD_FILE"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
 		if err != nil {
-			logger.Error("failed to create metrics", "err", err.Error())
+			logger.Error("failed to open traffic recording", "path", path, "err", err.Error())
 			os.Exit(1)
 		}
-		svc = allsrv.ObserveSVC(met)(svc)
+		defer f.Close()
+
+		traffic := allsrv.NewTrafficRecorder(f)
+		v1Opts = append(v1Opts, allsrv.WithTrafficRecorder(traffic))
+		v2Opts = append(v2Opts, allsrv.WithTrafficRecorder(traffic))
+		logger.Info("recording traffic", "path", path)
+	}
+	selectedSVR := strings.TrimSpace(strings.ToLower(os.Getenv("ALLSRV_SERVER")))
+	if selectedSVR != "v2" {
+		logger.Info("registering v1 server")
+		allsrv.NewServer(legacySVC, append([]allsrv.SvrOptFn{
+			allsrv.WithBasicAuth("admin", "pass"),
+			allsrv.WithMux(mux),
+		}, v1Opts...)...)
+	}
+	if selectedSVR != "v1" {
+		logger.Info("registering v2 server")
 
-		allsrv.NewServerV2(svc, allsrv.WithBasicAuthV2("admin", "pass"), allsrv.WithMux(mux))
+		allsrv.NewServerV2(svc, append([]allsrv.SvrOptFn{
+			allsrv.WithBasicAuthV2("admin", "pass"),
+			allsrv.WithAccessLog(logger, accessLogSampleRate()),
+			allsrv.WithMux(mux),
+		}, v2Opts...)...)
 	}
 
 	addr := "localhost:" + strings.TrimPrefix(cmp.Or(os.Getenv("ALLSRV_PORT"), "8091"), ":")
@@ -74,36 +242,119 @@ func main() {
 	}
 }
 
-func newSQLiteDB(dsn string) (allsrv.DB, error) {
-	const driver = "sqlite3"
+// accessLogSampleRate is the sample rate of successful requests logged by the
+// access log. Defaults to logging 10% of successful requests.
+func accessLogSampleRate() float64 {
+	rate, err := strconv.ParseFloat(os.Getenv("ALLSRV_ACCESS_LOG_SAMPLE_RATE"), 64)
+	if err != nil {
+		return 0.1
+	}
+	return rate
+}
 
-	db, err := sql.Open(driver, dsn)
+// backupRetain is the number of sqlite backups retained in the backup dir.
+// Defaults to retaining 7 backups.
+func backupRetain() int {
+	retain, err := strconv.Atoi(os.Getenv("ALLSRV_BACKUP_RETAIN"))
 	if err != nil {
-		return nil, err
+		return 7
 	}
+	return retain
+}
 
-	const dbName = "testdb"
-	drvr, err := migsqlite.WithInstance(db, &migsqlite.Config{DatabaseName: dbName})
+// reapInterval is the interval expired foos are reaped at. Defaults to reaping
+// every minute, an interval of zero disables the reaper.
+func reapInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("ALLSRV_REAP_INTERVAL"))
 	if err != nil {
-		return nil, err
+		return time.Minute
+	}
+	return interval
+}
+
+// reapBatch is the number of expired foos deleted per batch of the reaper.
+// Defaults to 500 foos.
+func reapBatch() int {
+	batch, err := strconv.Atoi(os.Getenv("ALLSRV_REAP_BATCH"))
+	if err != nil || batch <= 0 {
+		return 500
 	}
+	return batch
+}
 
-	iodrvr, err := iofs.New(migrations.SQLite, "sqlite")
+// chaosSeed is the seed the faults of the chaos are rolled from. Defaults to
+// a seed from the current time.
+func chaosSeed() int64 {
+	seed, err := strconv.ParseInt(os.Getenv("ALLSRV_CHAOS_SEED"), 10, 64)
+	if err != nil {
+		return time.Now().UnixNano()
+	}
+	return seed
+}
+
+// attachmentsMaxSize is the size limit of an attachment in bytes. Defaults to
+// allsrv.DefaultAttachmentMaxSize.
+func attachmentsMaxSize() int64 {
+	maxSize, err := strconv.ParseInt(os.Getenv("ALLSRV_ATTACHMENTS_MAX_SIZE"), 10, 64)
+	if err != nil || maxSize <= 0 {
+		return allsrv.DefaultAttachmentMaxSize
+	}
+	return maxSize
+}
+
+// readMetadataSchema reads the JSON Schema file that validates the metadata
+// of foos.
+func readMetadataSchema(path string) (*allsrv.MetadataSchema, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	return allsrv.ParseMetadataSchema(b)
+}
 
-	m, err := migrate.NewWithInstance("iofs", iodrvr, dbName, drvr)
+// openSQLite opens the sqlite db. When migrateUp is true, the db is migrated
+// to the latest schema version, otherwise the schema is checked to be up to date.
+func openSQLite(dsn string, migrateUp bool) (*sqlx.DB, error) {
+	db, err := sql.Open(sqliteDriver, dsn)
 	if err != nil {
 		return nil, err
 	}
-	err = m.Up()
+
+	if migrateUp {
+		err = migrateSQLite(db)
+	} else {
+		err = checkSQLiteSchema(db)
+	}
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	dbx := sqlx.NewDb(db, driver)
+	dbx := sqlx.NewDb(db, sqliteDriver)
 	dbx.SetMaxIdleConns(1)
 
-	return allsrv.NewSQLiteDB(dbx), nil
+	return dbx, nil
+}
+
+const sqliteDriver = "sqlite3"
+
+func migrateSQLite(db *sql.DB) error {
+	m, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	err = m.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+func checkSQLiteSchema(db *sql.DB) error {
+	schema, err := allsrv.SQLiteSchemaStatus(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	return schema.OK()
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	migsqlite "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/spf13/cobra"
+
+	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/migrations"
+)
+
+func cmdMigrate() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "migrate",
+		Short: "manage the schema migrations of the sqlite db of ALLSRV_SQLITE_DSN",
+		Long: `manage the schema migrations of the sqlite db of ALLSRV_SQLITE_DSN.
+
+Every command writes the status of the schema once it completes.`,
+	}
+	cmd.AddCommand(
+		cmdMigrateUp(),
+		cmdMigrateDown(),
+		cmdMigrateGoto(),
+		cmdMigrateStatus(),
+		cmdMigrateForce(),
+	)
+	return &cmd
+}
+
+func cmdMigrateUp() *cobra.Command {
+	return &cobra.Command{
+		Use:   "up [$N]",
+		Short: "apply all the pending migrations, or the next N migrations",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrator(cmd, func(m *migrate.Migrate) error {
+				if len(args) == 0 {
+					return m.Up()
+				}
+				n, err := parseSteps(args[0])
+				if err != nil {
+					return err
+				}
+				return m.Steps(n)
+			})
+		},
+	}
+}
+
+func cmdMigrateDown() *cobra.Command {
+	var all bool
+	cmd := cobra.Command{
+		Use:   "down [$N]",
+		Short: "revert the last N migrations, or all of the migrations with --all",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if all == (len(args) == 1) {
+				return errors.New("one of the number of migrations to revert or --all must be provided")
+			}
+			return withMigrator(cmd, func(m *migrate.Migrate) error {
+				if all {
+					return m.Down()
+				}
+				n, err := parseSteps(args[0])
+				if err != nil {
+					return err
+				}
+				return m.Steps(-n)
+			})
+		},
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "revert all of the migrations, dropping the schema")
+	return &cmd
+}
+
+func cmdMigrateGoto() *cobra.Command {
+	return &cobra.Command{
+		Use:   "goto $VERSION",
+		Short: "migrate up or down to the version",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				return errors.New("invalid version provided: " + args[0])
+			}
+			return withMigrator(cmd, func(m *migrate.Migrate) error {
+				return m.Migrate(uint(version))
+			})
+		},
+	}
+}
+
+func cmdMigrateStatus() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "write the status of the schema",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openSQLiteNoMigrate()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			return writeSchema(cmd, db)
+		},
+	}
+}
+
+func cmdMigrateForce() *cobra.Command {
+	return &cobra.Command{
+		Use:   "force $VERSION",
+		Short: "set the version of the schema and clear the dirty state, without running any migrations",
+		Long: `set the version of the schema and clear the dirty state, without running any migrations.
+
+Use after manually fixing the schema of a failed migration. A version of -1
+sets the schema to no version.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := strconv.Atoi(args[0])
+			if err != nil || version < database.NilVersion {
+				return errors.New("invalid version provided: " + args[0])
+			}
+			return withMigrator(cmd, func(m *migrate.Migrate) error {
+				return m.Force(version)
+			})
+		},
+	}
+}
+
+// withMigrator runs the fn with the migrator of the sqlite db of
+// ALLSRV_SQLITE_DSN, and writes the status of the schema once it completes.
+func withMigrator(cmd *cobra.Command, fn func(m *migrate.Migrate) error) error {
+	db, err := openSQLiteNoMigrate()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(m); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return writeSchema(cmd, db)
+}
+
+func newMigrator(db *sql.DB) (*migrate.Migrate, error) {
+	const dbName = "testdb"
+	drvr, err := migsqlite.WithInstance(db, &migsqlite.Config{DatabaseName: dbName})
+	if err != nil {
+		return nil, err
+	}
+
+	iodrvr, err := iofs.New(migrations.SQLite, "sqlite")
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.NewWithInstance("iofs", iodrvr, dbName, drvr)
+}
+
+func writeSchema(cmd *cobra.Command, db *sql.DB) error {
+	schema, err := allsrv.SQLiteSchemaStatus(cmd.Context(), db)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(cmd.OutOrStdout()).Encode(schema)
+}
+
+func parseSteps(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, errors.New("invalid number of migrations provided: " + s)
+	}
+	return n, nil
+}
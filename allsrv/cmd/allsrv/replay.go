@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jsteenb2/mess/allsrv"
+)
+
+func cmdReplay() *cobra.Command {
+	var (
+		file, addr, user, pass string
+		ignoreKeys             []string
+		output                 string
+	)
+	cmd := cobra.Command{
+		Use:   "replay",
+		Short: "replay a traffic recording against a server and report the diffs of its responses",
+		Long: `replay a traffic recording against a server and report the diffs of its responses.
+
+The recording is the JSON Lines written by a server with ALLSRV_TRAFFIC_RECORD_FILE.
+The exchanges are replayed in order, and the status, content type and body of
+each response are compared to the recorded response. The ids, ETags and times
+provided by the server are learned from its responses, so the recording may be
+replayed against an empty server. The trace_id and took_ms fields, and any
+timestamps, of the bodies are not compared.
+
+The command fails when any of the exchanges diverged.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if file == "" || addr == "" {
+				return errors.New("the recording and the server must be provided with --file and --addr")
+			}
+			if output != "text" && output != "json" {
+				return errors.New("invalid output provided: " + output + "; must be one of: text, json")
+			}
+
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			replayer := allsrv.NewTrafficReplayer(addr, &http.Client{Timeout: 5 * time.Second},
+				allsrv.WithReplayBasicAuth(user, pass),
+				allsrv.WithReplayIgnoreKeys(ignoreKeys...),
+			)
+			report, err := replayer.Replay(cmd.Context(), f)
+			if err != nil {
+				return err
+			}
+
+			if output == "json" {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				err = enc.Encode(report)
+			} else {
+				err = report.WriteText(cmd.OutOrStdout())
+			}
+			if err != nil {
+				return err
+			}
+			if n := len(report.Diverged); n > 0 {
+				return errors.New(strconv.Itoa(n) + " of " + strconv.Itoa(report.Exchanges) + " exchanges diverged")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "file of the traffic recording")
+	cmd.Flags().StringVar(&addr, "addr", "", "addr of the server to replay the traffic against (i.e. http://localhost:8091)")
+	cmd.Flags().StringVar(&user, "user", "admin", "user for basic auth of the server")
+	cmd.Flags().StringVar(&pass, "password", "pass", "password for basic auth of the server")
+	cmd.Flags().StringSliceVar(&ignoreKeys, "ignore", nil, "additional keys of the body fields to not compare (i.e. note,name)")
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "output format, one of: text, json")
+
+	return &cmd
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jsteenb2/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/jsteenb2/mess/allsrv"
+)
+
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
+const (
+	conflictSkip   = "skip"
+	conflictUpdate = "update"
+	conflictFail   = "fail"
+)
+
+// fooRecord is the record of a foo in an import or export. The labels and
+// metadata of a csv record are JSON objects.
+type fooRecord struct {
+	ID        string            `json:"id,omitempty"`
+	Name      string            `json:"name"`
+	Note      string            `json:"note"`
+	CreatedAt string            `json:"created_at,omitempty"`
+	UpdatedAt string            `json:"updated_at,omitempty"`
+	ExpiresAt string            `json:"expires_at,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Metadata  map[string]any    `json:"metadata,omitempty"`
+}
+
+var csvHeader = []string{"id", "name", "note", "created_at", "updated_at", "expires_at", "labels", "metadata"}
+
+func toFooRecord(f allsrv.Foo) fooRecord {
+	rec := fooRecord{
+		ID:        f.ID,
+		Name:      f.Name,
+		Note:      f.Note,
+		CreatedAt: f.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: f.UpdatedAt.Format(time.RFC3339),
+		Labels:    f.Labels,
+		Metadata:  f.Metadata,
+	}
+	if !f.ExpiresAt.IsZero() {
+		rec.ExpiresAt = f.ExpiresAt.Format(time.RFC3339)
+	}
+	return rec
+}
+
+// toFoo provides the foo of the record. The id and timestamps of the record
+// are left to the target svc.
+func (rec fooRecord) toFoo() (allsrv.Foo, error) {
+	expiresAt, err := parseExpiresAt(rec.ExpiresAt)
+	if err != nil {
+		return allsrv.Foo{}, errors.Wrap(err, "invalid expires_at of the record", allsrv.ErrKindInvalid)
+	}
+	return allsrv.Foo{
+		Name:      rec.Name,
+		Note:      rec.Note,
+		ExpiresAt: expiresAt,
+		Labels:    rec.Labels,
+		Metadata:  rec.Metadata,
+	}, nil
+}
+
+// csvRow provides the csv row of the record, in the order of the csvHeader.
+func (rec fooRecord) csvRow() ([]string, error) {
+	var labels, metadata string
+	if len(rec.Labels) > 0 {
+		b, err := json.Marshal(rec.Labels)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		labels = string(b)
+	}
+	if len(rec.Metadata) > 0 {
+		b, err := json.Marshal(rec.Metadata)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		metadata = string(b)
+	}
+	return []string{rec.ID, rec.Name, rec.Note, rec.CreatedAt, rec.UpdatedAt, rec.ExpiresAt, labels, metadata}, nil
+}
+
+type bulkFlags struct {
+	file        string
+	format      string
+	concurrency int
+}
+
+func (b *bulkFlags) register(cmd *cobra.Command, fileUsage string) {
+	cmd.Flags().StringVarP(&b.file, "file", "f", "-", fileUsage)
+	cmd.Flags().StringVar(&b.format, "format", formatJSONL, "format of the records, one of: csv, jsonl")
+	cmd.Flags().IntVar(&b.concurrency, "concurrency", 4, "max number of concurrent requests to the foo svc")
+}
+
+func (b *bulkFlags) validate() error {
+	if b.format != formatCSV && b.format != formatJSONL {
+		return errors.New("invalid format provided: " + b.format + "; valid formats are: csv, jsonl")
+	}
+	if b.concurrency < 1 {
+		return errors.New("concurrency must be at least 1")
+	}
+	return nil
+}
+
+func (c *cli) cmdImport() *cobra.Command {
+	var (
+		flags      bulkFlags
+		dryRun     bool
+		onConflict string
+	)
+	cmd := cobra.Command{
+		Use:   "import",
+		Short: "creates the foos of the records; the summary of the import is written to stdout",
+		Long: `creates the foos of the records; the summary of the import is written to stdout.
+
+When a foo already exists, the conflict strategy determines the outcome of the record:
+	skip:   the record is skipped
+	update: the existing foo of the same name is updated, the labels, metadata
+	        and expires_at of the foo are updated only when the record has them
+	fail:   the record fails
+
+The ids of the records are not imported, so records exported from one
+environment may be imported into another.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := flags.validate(); err != nil {
+				return err
+			}
+			switch onConflict {
+			case conflictSkip, conflictUpdate, conflictFail:
+			default:
+				return errors.New("invalid on-conflict provided: " + onConflict + "; valid strategies are: skip, update, fail")
+			}
+
+			r, closeFn, err := openIn(cmd, flags.file)
+			if err != nil {
+				return err
+			}
+			defer closeFn()
+
+			records, err := readRecords(r, flags.format)
+			if err != nil {
+				return err
+			}
+
+			imp := &importer{
+				svc:        c.newClient(),
+				dryRun:     dryRun,
+				onConflict: onConflict,
+				progress:   newProgress(cmd.ErrOrStderr(), "imported", len(records)),
+			}
+			sum := imp.run(cmd.Context(), records, flags.concurrency)
+
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(sum); err != nil {
+				return errors.Wrap(err)
+			}
+			if sum.Failed > 0 {
+				return errors.New(fmt.Sprintf("%d of %d records failed to import", sum.Failed, sum.Total))
+			}
+			return nil
+		},
+	}
+	c.registerCommonFlags(&cmd)
+	flags.register(&cmd, "file to read records from, - for stdin")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "validate the records locally without importing them")
+	cmd.Flags().StringVar(&onConflict, "on-conflict", conflictFail, "strategy when a foo already exists, one of: skip, update, fail")
+
+	return &cmd
+}
+
+func (c *cli) cmdExport() *cobra.Command {
+	var (
+		flags   bulkFlags
+		idsFile string
+	)
+	cmd := cobra.Command{
+		Use:   "export [$FOO_ID...]",
+		Short: "writes the foos of the provided ids as records",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := flags.validate(); err != nil {
+				return err
+			}
+
+			ids := args
+			if idsFile != "" {
+				fileIDs, err := readIDs(cmd, idsFile)
+				if err != nil {
+					return err
+				}
+				ids = append(ids, fileIDs...)
+			}
+			if len(ids) == 0 {
+				return errors.New("no foo ids provided; provide the ids as args or with --ids-file")
+			}
+
+			w, closeFn, err := openOut(cmd, flags.file)
+			if err != nil {
+				return err
+			}
+			defer closeFn()
+
+			foos, sum := exportFoos(cmd.Context(), c.newClient(), ids, flags.concurrency, newProgress(cmd.ErrOrStderr(), "exported", len(ids)))
+			if err := writeRecords(w, flags.format, foos); err != nil {
+				return err
+			}
+
+			if sum.Failed > 0 {
+				enc := json.NewEncoder(cmd.ErrOrStderr())
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(sum); err != nil {
+					return errors.Wrap(err)
+				}
+				return errors.New(fmt.Sprintf("%d of %d foos failed to export", sum.Failed, sum.Total))
+			}
+			return nil
+		},
+	}
+	c.registerCommonFlags(&cmd)
+	flags.register(&cmd, "file to write records to, - for stdout")
+	cmd.Flags().StringVar(&idsFile, "ids-file", "", "file of foo ids to export, one per line, - for stdin")
+
+	return &cmd
+}
+
+// bulkSummary is the machine-readable summary of an import or export.
+type bulkSummary struct {
+	Total    int           `json:"total"`
+	Created  int           `json:"created,omitempty"`
+	Updated  int           `json:"updated,omitempty"`
+	Skipped  int           `json:"skipped,omitempty"`
+	Valid    int           `json:"valid,omitempty"`
+	Exported int           `json:"exported,omitempty"`
+	Failed   int           `json:"failed"`
+	DryRun   bool          `json:"dry_run,omitempty"`
+	Failures []bulkFailure `json:"failures"`
+}
+
+// bulkFailure identifies the failed record by its position in the records
+// (1-indexed, excluding the csv header) or the foo id.
+type bulkFailure struct {
+	Line  int    `json:"line,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Kind  string `json:"kind"`
+	Error string `json:"error"`
+}
+
+type importer struct {
+	svc        allsrv.SVC
+	dryRun     bool
+	onConflict string
+	progress   *progress
+
+	mu  sync.Mutex
+	ids map[string]string // foo name => id of the existing foos of the target
+}
+
+type importOutcome int
+
+const (
+	outcomeCreated importOutcome = iota
+	outcomeUpdated
+	outcomeSkipped
+	outcomeValid
+)
+
+func (imp *importer) run(ctx context.Context, records []fooRecord, concurrency int) bulkSummary {
+	sum := bulkSummary{Total: len(records), DryRun: imp.dryRun, Failures: []bulkFailure{}}
+
+	var mu sync.Mutex
+	forEach(len(records), concurrency, func(i int) {
+		rec := records[i]
+		outcome, err := imp.importRecord(ctx, rec)
+
+		mu.Lock()
+		defer mu.Unlock()
+		imp.progress.incr()
+		if err != nil {
+			sum.Failed++
+			sum.Failures = append(sum.Failures, bulkFailure{
+				Line:  i + 1,
+				ID:    rec.ID,
+				Name:  rec.Name,
+				Kind:  errKind(err),
+				Error: err.Error(),
+			})
+			return
+		}
+		switch outcome {
+		case outcomeCreated:
+			sum.Created++
+		case outcomeUpdated:
+			sum.Updated++
+		case outcomeSkipped:
+			sum.Skipped++
+		case outcomeValid:
+			sum.Valid++
+		}
+	})
+	sortFailures(sum.Failures)
+
+	return sum
+}
+
+func (imp *importer) importRecord(ctx context.Context, rec fooRecord) (importOutcome, error) {
+	f, err := rec.toFoo()
+	if err != nil {
+		return 0, err
+	}
+	if imp.dryRun {
+		return outcomeValid, f.OK()
+	}
+
+	_, err = imp.svc.CreateFoo(ctx, f)
+	if !errors.Is(err, allsrv.ErrKindExists) {
+		return outcomeCreated, err
+	}
+
+	switch imp.onConflict {
+	case conflictSkip:
+		return outcomeSkipped, nil
+	case conflictUpdate:
+		// the id of the record is of the environment the record was exported
+		// from, so the existing foo is resolved by its name in the target
+		id, err := imp.existingID(ctx, rec.Name)
+		if err != nil {
+			return 0, err
+		}
+		upd := allsrv.FooUpd{ID: id, Name: &f.Name, Note: &f.Note}
+		if rec.ExpiresAt != "" {
+			upd.ExpiresAt = &f.ExpiresAt
+		}
+		if rec.Labels != nil {
+			upd.Labels = &f.Labels
+		}
+		if rec.Metadata != nil {
+			upd.Metadata = &f.Metadata
+		}
+		_, err = imp.svc.UpdateFoo(ctx, upd)
+		return outcomeUpdated, err
+	default:
+		return 0, err
+	}
+}
+
+// existingID resolves the id of the existing foo of the name. The foos of the
+// target are listed on the first conflict, and listed again when the name is
+// of a foo created since.
+func (imp *importer) existingID(ctx context.Context, name string) (string, error) {
+	imp.mu.Lock()
+	defer imp.mu.Unlock()
+
+	if id, ok := imp.ids[name]; ok {
+		return id, nil
+	}
+
+	foos, err := imp.svc.ListFoos(ctx, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list the existing foos")
+	}
+	imp.ids = make(map[string]string, len(foos))
+	for _, f := range foos {
+		imp.ids[f.Name] = f.ID
+	}
+
+	id, ok := imp.ids[name]
+	if !ok {
+		return "", errors.New("existing foo "+name+" not found", allsrv.ErrKindNotFound)
+	}
+	return id, nil
+}
+
+func exportFoos(ctx context.Context, svc allsrv.SVC, ids []string, concurrency int, prog *progress) ([]allsrv.Foo, bulkSummary) {
+	sum := bulkSummary{Total: len(ids), Failures: []bulkFailure{}}
+	foos := make([]allsrv.Foo, len(ids))
+	found := make([]bool, len(ids))
+
+	var mu sync.Mutex
+	forEach(len(ids), concurrency, func(i int) {
+		f, err := svc.ReadFoo(ctx, ids[i])
+
+		mu.Lock()
+		defer mu.Unlock()
+		prog.incr()
+		if err != nil {
+			sum.Failed++
+			sum.Failures = append(sum.Failures, bulkFailure{
+				ID:    ids[i],
+				Kind:  errKind(err),
+				Error: err.Error(),
+			})
+			return
+		}
+		sum.Exported++
+		foos[i], found[i] = f, true
+	})
+	sortFailures(sum.Failures)
+
+	out := make([]allsrv.Foo, 0, sum.Exported)
+	for i, f := range foos {
+		if found[i] {
+			out = append(out, f)
+		}
+	}
+	return out, sum
+}
+
+// forEach calls fn for every index, with at most concurrency calls in flight.
+func forEach(n, concurrency int, fn func(i int)) {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func sortFailures(failures []bulkFailure) {
+	slices.SortFunc(failures, func(a, b bulkFailure) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+}
+
+func errKind(err error) string {
+	for _, kind := range []errors.Kind{
+		allsrv.ErrKindExists,
+		allsrv.ErrKindInvalid,
+		allsrv.ErrKindNotFound,
+		allsrv.ErrKindUnAuthed,
+		allsrv.ErrKindUnavailable,
+	} {
+		if errors.Is(err, kind) {
+			return string(kind)
+		}
+	}
+	return string(allsrv.ErrKindInternal)
+}
+
+// progress reports the progress of a bulk operation, at every tenth of
+// the total.
+type progress struct {
+	w      io.Writer
+	verb   string
+	total  int
+	done   int
+	report int
+}
+
+func newProgress(w io.Writer, verb string, total int) *progress {
+	return &progress{w: w, verb: verb, total: total, report: max(total/10, 1)}
+}
+
+func (p *progress) incr() {
+	p.done++
+	if p.done%p.report == 0 || p.done == p.total {
+		fmt.Fprintf(p.w, "%s %d/%d\n", p.verb, p.done, p.total)
+	}
+}
+
+func readRecords(r io.Reader, format string) ([]fooRecord, error) {
+	if format == formatCSV {
+		return readCSV(r)
+	}
+
+	var (
+		out  []fooRecord
+		line int
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var rec fooRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid jsonl record on line %d", line))
+		}
+		out = append(out, rec)
+	}
+	return out, errors.Wrap(scanner.Err())
+}
+
+func readCSV(r io.Reader) ([]fooRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read csv header")
+	}
+	cols := make(map[string]int)
+	for i, h := range header {
+		cols[strings.TrimSpace(strings.ToLower(h))] = i
+	}
+	if _, ok := cols["name"]; !ok {
+		return nil, errors.New("csv header must contain the name column")
+	}
+
+	var out []fooRecord
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid csv record on line %d", line))
+		}
+		col := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+		rec := fooRecord{
+			ID:        col("id"),
+			Name:      col("name"),
+			Note:      col("note"),
+			CreatedAt: col("created_at"),
+			UpdatedAt: col("updated_at"),
+			ExpiresAt: col("expires_at"),
+		}
+		if raw := col("labels"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &rec.Labels); err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("invalid csv labels on line %d, must be a JSON object", line))
+			}
+		}
+		if raw := col("metadata"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &rec.Metadata); err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("invalid csv metadata on line %d, must be a JSON object", line))
+			}
+		}
+		out = append(out, rec)
+	}
+}
+
+func writeRecords(w io.Writer, format string, foos []allsrv.Foo) error {
+	if format == formatCSV {
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return errors.Wrap(err)
+		}
+		for _, f := range foos {
+			row, err := toFooRecord(f).csvRow()
+			if err != nil {
+				return err
+			}
+			if err := cw.Write(row); err != nil {
+				return errors.Wrap(err)
+			}
+		}
+		cw.Flush()
+		return errors.Wrap(cw.Error())
+	}
+
+	enc := json.NewEncoder(w)
+	for _, f := range foos {
+		if err := enc.Encode(toFooRecord(f)); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func readIDs(cmd *cobra.Command, file string) ([]string, error) {
+	r, closeFn, err := openIn(cmd, file)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	var ids []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, errors.Wrap(scanner.Err())
+}
+
+func openIn(cmd *cobra.Command, file string) (io.Reader, func(), error) {
+	if file == "-" {
+		return cmd.InOrStdin(), func() {}, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+	return f, func() { f.Close() }, nil
+}
+
+func openOut(cmd *cobra.Command, file string) (io.Writer, func(), error) {
+	if file == "-" {
+		return cmd.OutOrStdout(), func() {}, nil
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+	return f, func() { f.Close() }, nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jsteenb2/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// env vars overriding the config of the current context
+const (
+	envConfig   = "ALLSRVC_CONFIG"
+	envContext  = "ALLSRVC_CONTEXT"
+	envAddr     = "ALLSRVC_ADDR"
+	envUser     = "ALLSRVC_USER"
+	envPassword = "ALLSRVC_PASSWORD"
+	envOutput   = "ALLSRVC_OUTPUT"
+)
+
+const defaultAddr = "http://localhost:8091"
+
+// config is the allsrvc config file, holding the named contexts for
+// connecting to an allsrv server.
+type config struct {
+	CurrentContext string                   `yaml:"current-context,omitempty"`
+	Contexts       map[string]configContext `yaml:"contexts,omitempty"`
+}
+
+// configContext is a named connection profile. The password is never stored
+// in the config, rather a reference to the env var or file holding it.
+type configContext struct {
+	Addr         string `yaml:"addr,omitempty"`
+	User         string `yaml:"user,omitempty"`
+	PasswordEnv  string `yaml:"password-env,omitempty"`
+	PasswordFile string `yaml:"password-file,omitempty"`
+	Output       string `yaml:"output,omitempty"`
+}
+
+func (c configContext) password() (string, error) {
+	switch {
+	case c.PasswordEnv != "":
+		return os.Getenv(c.PasswordEnv), nil
+	case c.PasswordFile != "":
+		b, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read password file")
+		}
+		return strings.TrimSpace(string(b)), nil
+	default:
+		return "", nil
+	}
+}
+
+// configPath provides the path of the config file, which defaults to the
+// allsrvc/config.yaml file in the user's config dir (i.e. $XDG_CONFIG_HOME).
+func configPath() (string, error) {
+	if p := os.Getenv(envConfig); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find user config dir; provide the config path with the "+envConfig+" env var")
+	}
+	return filepath.Join(dir, name, "config.yaml"), nil
+}
+
+func readConfig(path string) (config, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config{}, nil
+	}
+	if err != nil {
+		return config{}, errors.Wrap(err, "failed to read config")
+	}
+
+	var cfg config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return config{}, errors.Wrap(err, "invalid config file "+path)
+	}
+	return cfg, nil
+}
+
+func writeConfig(path string, cfg config) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return errors.Wrap(err, "failed to create config dir")
+	}
+
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	return errors.Wrap(os.WriteFile(path, b, 0o600), "failed to write config")
+}
+
+// resolveConn resolves the connection settings of the command. Flags take
+// precedence over env vars, which take precedence over the current context.
+func (c *cli) resolveConn(cmd *cobra.Command) error {
+	if c.conn != nil && !cmd.Flags().Changed("context") {
+		// within the shell, the connection of the shell takes the place of
+		// the config and env vars
+		inherit := func(v *string, flag, fromShell string) {
+			if !cmd.Flags().Changed(flag) {
+				*v = fromShell
+			}
+		}
+		inherit(&c.addr, "addr", c.conn.addr)
+		inherit(&c.user, "user", c.conn.user)
+		inherit(&c.pass, "password", c.conn.pass)
+		inherit(&c.output, "output", c.conn.output)
+		return nil
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	ctxName := c.context
+	if !cmd.Flags().Changed("context") {
+		ctxName = cmp.Or(os.Getenv(envContext), cfg.CurrentContext)
+	}
+	var profile configContext
+	if ctxName != "" {
+		p, ok := cfg.Contexts[ctxName]
+		if !ok {
+			return errors.New("context " + ctxName + " does not exist in config " + path)
+		}
+		profile = p
+	}
+
+	pass, err := profile.password()
+	if err != nil {
+		return err
+	}
+
+	resolve := func(v *string, flag, env, fromCtx, def string) {
+		if cmd.Flags().Changed(flag) {
+			return
+		}
+		*v = cmp.Or(os.Getenv(env), fromCtx, def)
+	}
+	resolve(&c.addr, "addr", envAddr, profile.Addr, defaultAddr)
+	resolve(&c.user, "user", envUser, profile.User, "")
+	resolve(&c.pass, "password", envPassword, pass, "")
+	resolve(&c.output, "output", envOutput, profile.Output, outputJSON)
+
+	return nil
+}
+
+func (c *cli) cmdConfig() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "config",
+		Short: "manage the contexts for connecting to allsrv servers",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// the config commands do not connect to a server
+			return nil
+		},
+	}
+	cmd.AddCommand(
+		c.cmdConfigGetContexts(),
+		c.cmdConfigUseContext(),
+		c.cmdConfigSetContext(),
+	)
+	return &cmd
+}
+
+func (c *cli) cmdConfigGetContexts() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get-contexts",
+		Short: "list the contexts of the config",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := configPath()
+			if err != nil {
+				return err
+			}
+			cfg, err := readConfig(path)
+			if err != nil {
+				return err
+			}
+
+			names := make([]string, 0, len(cfg.Contexts))
+			for ctxName := range cfg.Contexts {
+				names = append(names, ctxName)
+			}
+			slices.Sort(names)
+
+			tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "CURRENT\tNAME\tADDR\tUSER\tOUTPUT")
+			for _, ctxName := range names {
+				var current string
+				if ctxName == cfg.CurrentContext {
+					current = "*"
+				}
+				p := cfg.Contexts[ctxName]
+				fmt.Fprintln(tw, strings.Join([]string{current, ctxName, p.Addr, p.User, p.Output}, "\t"))
+			}
+			return errors.Wrap(tw.Flush())
+		},
+	}
+}
+
+func (c *cli) cmdConfigUseContext() *cobra.Command {
+	return &cobra.Command{
+		Use:   "use-context $CONTEXT",
+		Short: "set the current context",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := configPath()
+			if err != nil {
+				return err
+			}
+			cfg, err := readConfig(path)
+			if err != nil {
+				return err
+			}
+
+			if _, ok := cfg.Contexts[args[0]]; !ok {
+				return errors.New("context " + args[0] + " does not exist in config " + path)
+			}
+			cfg.CurrentContext = args[0]
+
+			if err := writeConfig(path, cfg); err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), "switched to context "+args[0])
+			return errors.Wrap(err)
+		},
+	}
+}
+
+func (c *cli) cmdConfigSetContext() *cobra.Command {
+	var profile configContext
+	cmd := cobra.Command{
+		Use:   "set-context $CONTEXT",
+		Short: "create or update a context; only the provided settings are updated",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if profile.PasswordEnv != "" && profile.PasswordFile != "" {
+				return errors.New("only one of password-env or password-file may be provided")
+			}
+			if profile.Output != "" {
+				if _, err := newPrinter(profile.Output, nil); err != nil {
+					return err
+				}
+			}
+
+			path, err := configPath()
+			if err != nil {
+				return err
+			}
+			cfg, err := readConfig(path)
+			if err != nil {
+				return err
+			}
+			if cfg.Contexts == nil {
+				cfg.Contexts = make(map[string]configContext)
+			}
+
+			existing := cfg.Contexts[args[0]]
+			set := func(v *string, flag, newVal string) {
+				if cmd.Flags().Changed(flag) {
+					*v = newVal
+				}
+			}
+			set(&existing.Addr, "addr", profile.Addr)
+			set(&existing.User, "user", profile.User)
+			set(&existing.Output, "output", profile.Output)
+			if cmd.Flags().Changed("password-env") || cmd.Flags().Changed("password-file") {
+				existing.PasswordEnv, existing.PasswordFile = profile.PasswordEnv, profile.PasswordFile
+			}
+			cfg.Contexts[args[0]] = existing
+			if cfg.CurrentContext == "" {
+				cfg.CurrentContext = args[0]
+			}
+
+			if err := writeConfig(path, cfg); err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), "context "+args[0]+" set in "+path)
+			return errors.Wrap(err)
+		},
+	}
+	cmd.Flags().StringVar(&profile.Addr, "addr", "", "addr for foo svc")
+	cmd.Flags().StringVar(&profile.User, "user", "", "user for basic auth")
+	cmd.Flags().StringVar(&profile.PasswordEnv, "password-env", "", "env var holding the password for basic auth")
+	cmd.Flags().StringVar(&profile.PasswordFile, "password-file", "", "file holding the password for basic auth")
+	cmd.Flags().StringVar(&profile.Output, "output", "", "default output format of the context")
+	return &cmd
+}
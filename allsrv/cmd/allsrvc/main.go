@@ -2,15 +2,15 @@ package main
 
 import (
 	"encoding/json"
-	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jsteenb2/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/jsteenb2/allsrvc"
 	"github.com/jsteenb2/mess/allsrv"
 )
 
@@ -30,27 +30,62 @@ const name = "allsrvc"
 
 type cli struct {
 	// base flags
-	addr string
-	pass string
-	user string
+	addr    string
+	pass    string
+	user    string
+	context string
+	output  string
+	fields  []string
 
 	// foo flags
-	id   string
-	name string
-	note string
+	id        string
+	name      string
+	note      string
+	expiresAt string
+	labels    []string
+	selector  string
+	metadata  string
+	mdFilters []string
+
+	printer *printer
+
+	// shell state, shared by the commands run within the shell
+	conn       *shellConn
+	httpClient *http.Client
+	seen       func(ids ...string)
 }
 
 func (c *cli) cmd() *cobra.Command {
 	cmd := cobra.Command{
 		Use:          name,
 		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.resolveConn(cmd); err != nil {
+				return err
+			}
+			p, err := newPrinter(c.output, c.fields)
+			if err != nil {
+				return err
+			}
+			p.seen = c.seen
+			c.printer = p
+			return nil
+		},
 	}
+	cmd.PersistentFlags().StringVar(&c.context, "context", "", "context of the config to use, defaults to the current context (env: "+envContext+")")
+	cmd.PersistentFlags().StringVarP(&c.output, "output", "o", "", "output format, one of: json, yaml, table, go-template=$TEMPLATE (i.e. go-template={{.attributes.name}}), defaults to json (env: "+envOutput+")")
+	cmd.PersistentFlags().StringSliceVar(&c.fields, "fields", nil, "foo attributes to output (i.e. name,updated_at), defaults to all")
 
 	cmd.AddCommand(
 		c.cmdCreateFoo(),
 		c.cmdReadFoo(),
 		c.cmdUpdateFoo(),
 		c.cmdRmFoo(),
+		c.cmdListFoos(),
+		c.cmdImport(),
+		c.cmdExport(),
+		c.cmdConfig(),
+		c.cmdShell(),
 	)
 
 	return &cmd
@@ -63,22 +98,41 @@ func (c *cli) cmdCreateFoo() *cobra.Command {
 		Short:   "creates a new foo",
 		Args:    cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			expiresAt, err := parseExpiresAt(c.expiresAt)
+			if err != nil {
+				return err
+			}
+			labels, err := applyLabels(nil, c.labels)
+			if err != nil {
+				return err
+			}
+			metadata, err := parseMetadata(c.metadata)
+			if err != nil {
+				return err
+			}
+
 			client := c.newClient()
 
 			f, err := client.CreateFoo(cmd.Context(), allsrv.Foo{
-				Name: c.name,
-				Note: c.note,
+				Name:      c.name,
+				Note:      c.note,
+				ExpiresAt: expiresAt,
+				Labels:    labels,
+				Metadata:  metadata,
 			})
 			if err != nil {
 				return err
 			}
 
-			return json.NewEncoder(cmd.OutOrStderr()).Encode(f)
+			return c.printer.printFoos(cmd.OutOrStdout(), f)
 		},
 	}
 	c.registerCommonFlags(&cmd)
 	cmd.Flags().StringVar(&c.name, "name", "", "name of the new foo")
 	cmd.Flags().StringVar(&c.note, "note", "", "optional foo note")
+	cmd.Flags().StringVar(&c.expiresAt, "expires-at", "", "optional RFC3339 timestamp the foo expires at")
+	cmd.Flags().StringArrayVar(&c.labels, "label", nil, "optional foo label as key=value, may be repeated")
+	cmd.Flags().StringVar(&c.metadata, "metadata", "", `optional foo metadata as a JSON object (i.e. {"region":"us"})`)
 
 	return &cmd
 }
@@ -91,12 +145,12 @@ func (c *cli) cmdReadFoo() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client := c.newClient()
 
-			f, err := client.ReadFoo(cmd.Context(), args[0])
+			f, err := client.ReadFoo(cmd.Context(), args[0], allsrv.WithReadFields(c.fields...))
 			if err != nil {
 				return err
 			}
 
-			return errors.Wrap(writeFoo(cmd.OutOrStdout(), f))
+			return c.printer.printFoos(cmd.OutOrStdout(), f)
 		},
 	}
 	c.registerCommonFlags(&cmd)
@@ -120,19 +174,147 @@ func (c *cli) cmdUpdateFoo() *cobra.Command {
 			if c.note != "" {
 				upd.Note = &c.note
 			}
+			if cmd.Flags().Changed("expires-at") {
+				expiresAt, err := parseExpiresAt(c.expiresAt)
+				if err != nil {
+					return err
+				}
+				upd.ExpiresAt = &expiresAt
+			}
+			if len(c.labels) > 0 {
+				// the labels are merged into all the existing labels, so the
+				// foo is read regardless of the fields selected
+				existing, err := client.ReadFoo(cmd.Context(), c.id)
+				if err != nil {
+					return err
+				}
+				labels, err := applyLabels(existing.Labels, c.labels)
+				if err != nil {
+					return err
+				}
+				upd.Labels = &labels
+			}
+			if cmd.Flags().Changed("metadata") {
+				metadata, err := parseMetadata(c.metadata)
+				if err != nil {
+					return err
+				}
+				upd.Metadata = &metadata
+			}
 
 			f, err := client.UpdateFoo(cmd.Context(), upd)
 			if err != nil {
 				return err
 			}
 
-			return errors.Wrap(writeFoo(cmd.OutOrStdout(), f))
+			return c.printer.printFoos(cmd.OutOrStdout(), f)
 		},
 	}
 	c.registerCommonFlags(&cmd)
 	cmd.Flags().StringVar(&c.id, "id", "", "id of the foo resource")
 	cmd.Flags().StringVar(&c.name, "name", "", "optional foo name")
 	cmd.Flags().StringVar(&c.note, "note", "", "optional foo note")
+	cmd.Flags().StringVar(&c.expiresAt, "expires-at", "", "optional RFC3339 timestamp the foo expires at, an empty timestamp removes the expiry")
+	cmd.Flags().StringArrayVar(&c.labels, "label", nil, "optional foo label to set as key=value, or to remove as key-, may be repeated")
+	cmd.Flags().StringVar(&c.metadata, "metadata", "", "optional foo metadata as a JSON object, replaces all the metadata, an empty object removes the metadata")
+
+	return &cmd
+}
+
+// parseExpiresAt parses the RFC3339 timestamp of the expires-at flag. An
+// empty timestamp is the zero time, for a foo that does not expire.
+func parseExpiresAt(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "invalid --expires-at timestamp, must be RFC3339")
+	}
+	return t, nil
+}
+
+// applyLabels applies the labels of the label flags to the existing labels.
+// A key=value label sets the label, and a key- label removes the label.
+func applyLabels(existing map[string]string, flags []string) (map[string]string, error) {
+	out := make(map[string]string, len(existing)+len(flags))
+	for k, v := range existing {
+		out[k] = v
+	}
+	for _, flag := range flags {
+		if k, v, ok := strings.Cut(flag, "="); ok {
+			out[k] = v
+			continue
+		}
+		k, ok := strings.CutSuffix(flag, "-")
+		if !ok {
+			return nil, errors.New("invalid --label " + strconv.Quote(flag) + ", must be key=value or key-")
+		}
+		delete(out, k)
+	}
+	return out, nil
+}
+
+// parseMetadata parses the JSON object of the metadata flag. An empty flag is
+// no metadata.
+func parseMetadata(v string) (map[string]any, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var out map[string]any
+	if err := json.Unmarshal([]byte(v), &out); err != nil {
+		return nil, errors.Wrap(err, "invalid --metadata, must be a JSON object")
+	}
+	return out, nil
+}
+
+// parseMetadataFilter parses the path=value requirements of the metadata
+// flags of the list.
+func parseMetadataFilter(flags []string) (allsrv.MetadataFilter, error) {
+	var filter allsrv.MetadataFilter
+	for _, flag := range flags {
+		rawPath, v, ok := strings.Cut(flag, "=")
+		if !ok {
+			return nil, errors.New("invalid --metadata " + strconv.Quote(flag) + ", must be path=value")
+		}
+		path, err := allsrv.ParseMetadataPath(rawPath)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, allsrv.MetadataReq{Path: path, Value: v})
+	}
+	return filter, nil
+}
+
+func (c *cli) cmdListFoos() *cobra.Command {
+	cmd := cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "list the foos, optionally selected by their labels and metadata",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sel, err := allsrv.ParseLabelSelector(c.selector)
+			if err != nil {
+				return err
+			}
+			filter, err := parseMetadataFilter(c.mdFilters)
+			if err != nil {
+				return err
+			}
+
+			client := c.newClient()
+
+			foos, err := client.ListFoos(cmd.Context(), sel, allsrv.WithListMetadata(filter))
+			if err != nil {
+				return err
+			}
+
+			return c.printer.printFoos(cmd.OutOrStdout(), foos...)
+		},
+	}
+	c.registerCommonFlags(&cmd)
+	cmd.Flags().StringVarP(&c.selector, "selector", "l", "", "label selector of the foos to list (i.e. env=prod,team!=infra,tier in (a,b))")
+	cmd.Flags().StringArrayVar(&c.mdFilters, "metadata", nil, "metadata the foos to list must have as path=value (i.e. owner.team=infra), may be repeated")
 
 	return &cmd
 }
@@ -152,21 +334,20 @@ func (c *cli) cmdRmFoo() *cobra.Command {
 }
 
 func (c *cli) newClient() *allsrv.ClientHTTP {
+	httpClient := c.httpClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 5 * time.Second}
+	}
 	return allsrv.NewClientHTTP(
 		c.addr,
 		name,
-		&http.Client{Timeout: 5 * time.Second},
-		allsrvc.WithBasicAuth(c.user, c.pass),
+		httpClient,
+		allsrv.WithClientBasicAuth(c.user, c.pass),
 	)
 }
 
 func (c *cli) registerCommonFlags(cmd *cobra.Command) {
-	cmd.Flags().StringVar(&c.addr, "addr", "http://localhost:8091", "addr for foo svc")
-	cmd.Flags().StringVar(&c.user, "user", "admin", "user for basic auth")
-	cmd.Flags().StringVar(&c.pass, "password", "pass", "password for basic auth")
-}
-
-func writeFoo(w io.Writer, f allsrv.Foo) error {
-	err := json.NewEncoder(w).Encode(allsrv.FooToData(f))
-	return errors.Wrap(err)
+	cmd.Flags().StringVar(&c.addr, "addr", "", "addr for foo svc, defaults to "+defaultAddr+" (env: "+envAddr+")")
+	cmd.Flags().StringVar(&c.user, "user", "", "user for basic auth (env: "+envUser+")")
+	cmd.Flags().StringVar(&c.pass, "password", "", "password for basic auth, prefer the "+envPassword+" env var or a context's password reference")
 }
@@ -4,15 +4,26 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jsteenb2/allsrvc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
 	"github.com/jsteenb2/mess/allsrv"
 	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
 )
 
 func TestCliSVC(t *testing.T) {
+	t.Setenv("ALLSRVC_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+
 	allsrvtesting.TestSVC(t, func(t *testing.T, opts allsrvtesting.SVCTestOpts) allsrvtesting.SVCDeps {
 		svc := allsrvtesting.NewInmemSVC(t, opts)
 		srv := httptest.NewServer(allsrv.NewServerV2(svc))
@@ -24,15 +35,578 @@ func TestCliSVC(t *testing.T) {
 	})
 }
 
+func TestCliOutput(t *testing.T) {
+	t.Setenv("ALLSRVC_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	start := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
+
+	db := new(allsrv.InmemDB)
+	allsrvtesting.CreateFoos(allsrv.Foo{
+		ID:        "1",
+		Name:      "first-foo",
+		Note:      "some note",
+		CreatedAt: start,
+		UpdatedAt: start,
+	})(t, db)
+
+	srv := httptest.NewServer(allsrv.NewServerV2(allsrv.NewService(db)))
+	t.Cleanup(srv.Close)
+
+	cli := &cmdCLI{addr: srv.URL}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "json with fields",
+			args: []string{"1", "--fields", "name"},
+			want: `{"attributes":{"name":"first-foo"},"id":"1","type":"foo"}` + "\n",
+		},
+		{
+			name: "yaml",
+			args: []string{"1", "-o", "yaml", "--fields", "name,note"},
+			want: "attributes:\n    name: first-foo\n    note: some note\nid: \"1\"\ntype: foo\n",
+		},
+		{
+			name: "table",
+			args: []string{"1", "-o", "table", "--fields", "name,note"},
+			want: "ID  NAME       NOTE\n1   first-foo  some note\n",
+		},
+		{
+			name: "go-template",
+			args: []string{"1", "-o", "go-template={{.id}}: {{.attributes.name}}"},
+			want: "1: first-foo\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := cli.execute(context.TODO(), "read", tt.args...)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, string(b))
+		})
+	}
+
+	t.Run("add should write to stdout", func(t *testing.T) {
+		b, err := cli.execute(context.TODO(), "add", "--name", "second-foo", "-o", "go-template={{.attributes.name}}")
+		require.NoError(t, err)
+
+		assert.Equal(t, "second-foo\n", string(b))
+	})
+
+	t.Run("update with fields should keep the fields not selected", func(t *testing.T) {
+		require.NoError(t, db.UpdateFoo(context.TODO(), allsrv.Foo{
+			ID:        "1",
+			Name:      "first-foo",
+			Note:      "some note",
+			CreatedAt: start,
+			UpdatedAt: start,
+			Labels:    map[string]string{"env": "prod"},
+		}))
+
+		b, err := cli.execute(context.TODO(), "update", "--id", "1", "--name", "renamed-foo", "--label", "team=core", "--fields", "name")
+		require.NoError(t, err)
+		assert.Equal(t, `{"attributes":{"name":"renamed-foo"},"id":"1","type":"foo"}`+"\n", string(b))
+
+		f, err := db.ReadFoo(context.TODO(), "1")
+		require.NoError(t, err)
+		assert.Equal(t, "some note", f.Note)
+		assert.Equal(t, map[string]string{"env": "prod", "team": "core"}, f.Labels)
+	})
+
+	t.Run("with invalid output should fail", func(t *testing.T) {
+		_, err := cli.execute(context.TODO(), "read", "1", "-o", "xml")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid output provided: xml")
+	})
+}
+
+func TestCliConfig(t *testing.T) {
+	start := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
+
+	db := new(allsrv.InmemDB)
+	allsrvtesting.CreateFoos(allsrv.Foo{
+		ID:        "1",
+		Name:      "first-foo",
+		Note:      "some note",
+		CreatedAt: start,
+		UpdatedAt: start,
+	})(t, db)
+
+	srv := httptest.NewServer(allsrv.NewServerV2(allsrv.NewService(db), allsrv.WithBasicAuthV2("admin", "secret")))
+	t.Cleanup(srv.Close)
+
+	cfgPath := filepath.Join(t.TempDir(), "allsrvc", "config.yaml")
+	t.Setenv("ALLSRVC_CONFIG", cfgPath)
+	t.Setenv("ALLSRVC_TEST_PASSWORD", "secret")
+
+	_, err := run(context.TODO(), "config", "set-context", "local",
+		"--addr", srv.URL,
+		"--user", "admin",
+		"--password-env", "ALLSRVC_TEST_PASSWORD",
+		"--output", "go-template={{.attributes.name}}",
+	)
+	require.NoError(t, err)
+
+	_, err = run(context.TODO(), "config", "set-context", "prod", "--addr", "http://prod.example.com", "--user", "admin")
+	require.NoError(t, err)
+
+	t.Run("should not store the password in the config", func(t *testing.T) {
+		b, err := os.ReadFile(cfgPath)
+		require.NoError(t, err)
+		assert.NotContains(t, string(b), "secret")
+
+		info, err := os.Stat(cfgPath)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+
+	t.Run("get-contexts should list the contexts", func(t *testing.T) {
+		b, err := run(context.TODO(), "config", "get-contexts")
+		require.NoError(t, err)
+
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		require.Len(t, lines, 3)
+		assert.Equal(t, []string{"*", "local", srv.URL, "admin", "go-template={{.attributes.name}}"}, strings.Fields(lines[1]))
+		assert.Equal(t, []string{"prod", "http://prod.example.com", "admin"}, strings.Fields(lines[2]))
+	})
+
+	t.Run("should connect with the current context", func(t *testing.T) {
+		b, err := run(context.TODO(), "read", "1")
+		require.NoError(t, err)
+		assert.Equal(t, "first-foo\n", string(b))
+	})
+
+	t.Run("flags should override the current context", func(t *testing.T) {
+		b, err := run(context.TODO(), "read", "1", "-o", "go-template={{.attributes.note}}")
+		require.NoError(t, err)
+		assert.Equal(t, "some note\n", string(b))
+
+		_, err = run(context.TODO(), "read", "1", "--password", "wrong")
+		require.Error(t, err)
+	})
+
+	t.Run("env vars should override the current context", func(t *testing.T) {
+		t.Setenv("ALLSRVC_OUTPUT", "go-template={{.id}}")
+
+		b, err := run(context.TODO(), "read", "1")
+		require.NoError(t, err)
+		assert.Equal(t, "1\n", string(b))
+	})
+
+	t.Run("use-context should switch the current context", func(t *testing.T) {
+		_, err := run(context.TODO(), "config", "use-context", "prod")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_, err := run(context.TODO(), "config", "use-context", "local")
+			require.NoError(t, err)
+		})
+
+		b, err := run(context.TODO(), "read", "1", "--context", "local")
+		require.NoError(t, err)
+		assert.Equal(t, "first-foo\n", string(b))
+	})
+
+	t.Run("use-context with non-existent context should fail", func(t *testing.T) {
+		_, err := run(context.TODO(), "config", "use-context", "staging")
+		require.Error(t, err)
+	})
+}
+
+func TestCliBulk(t *testing.T) {
+	t.Setenv("ALLSRVC_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	start := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
+
+	newCLI := func(t *testing.T) (*cmdCLI, *allsrv.InmemDB) {
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(allsrv.Foo{
+			ID:        "1",
+			Name:      "first-foo",
+			Note:      "some note",
+			CreatedAt: start,
+			UpdatedAt: start,
+		})(t, db)
+
+		srv := httptest.NewServer(allsrv.NewServerV2(allsrv.NewService(db)))
+		t.Cleanup(srv.Close)
+
+		return &cmdCLI{addr: srv.URL}, db
+	}
+
+	writeFile := func(t *testing.T, name, content string) string {
+		p := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+		return p
+	}
+
+	type summary struct {
+		Total    int `json:"total"`
+		Created  int `json:"created"`
+		Updated  int `json:"updated"`
+		Skipped  int `json:"skipped"`
+		Valid    int `json:"valid"`
+		Failed   int `json:"failed"`
+		Failures []struct {
+			Line int    `json:"line"`
+			Kind string `json:"kind"`
+		} `json:"failures"`
+	}
+	decodeSummary := func(t *testing.T, b []byte) summary {
+		var sum summary
+		require.NoError(t, json.Unmarshal(b, &sum))
+		return sum
+	}
+
+	t.Run("import csv should create the foos", func(t *testing.T) {
+		cli, _ := newCLI(t)
+		file := writeFile(t, "foos.csv", "name,note\nsecond-foo,second note\nthird-foo,\n")
+
+		b, err := cli.execute(context.TODO(), "import", "--file", file, "--format", "csv")
+		require.NoError(t, err)
+
+		sum := decodeSummary(t, b)
+		assert.Equal(t, 2, sum.Total)
+		assert.Equal(t, 2, sum.Created)
+		assert.Empty(t, sum.Failures)
+
+		_, err = cli.CreateFoo(context.TODO(), allsrv.Foo{Name: "second-foo"})
+		require.Error(t, err)
+	})
+
+	t.Run("import with existing foos and on-conflict", func(t *testing.T) {
+		records := `{"id":"1","name":"first-foo","note":"updated note"}` + "\n" + `{"name":"second-foo"}` + "\n"
+
+		t.Run("fail should report the failure", func(t *testing.T) {
+			cli, _ := newCLI(t)
+			file := writeFile(t, "foos.jsonl", records)
+
+			b, err := cli.execute(context.TODO(), "import", "--file", file)
+			require.Error(t, err)
+
+			sum := decodeSummary(t, b)
+			assert.Equal(t, 1, sum.Created)
+			assert.Equal(t, 1, sum.Failed)
+			require.Len(t, sum.Failures, 1)
+			assert.Equal(t, 1, sum.Failures[0].Line)
+			assert.Equal(t, "exists", sum.Failures[0].Kind)
+		})
+
+		t.Run("skip should skip the record", func(t *testing.T) {
+			cli, db := newCLI(t)
+			file := writeFile(t, "foos.jsonl", records)
+
+			b, err := cli.execute(context.TODO(), "import", "--file", file, "--on-conflict", "skip")
+			require.NoError(t, err)
+
+			sum := decodeSummary(t, b)
+			assert.Equal(t, 1, sum.Created)
+			assert.Equal(t, 1, sum.Skipped)
+
+			f, err := db.ReadFoo(context.TODO(), "1")
+			require.NoError(t, err)
+			assert.Equal(t, "some note", f.Note)
+		})
+
+		t.Run("update should update the existing foo", func(t *testing.T) {
+			cli, db := newCLI(t)
+			file := writeFile(t, "foos.jsonl", records)
+
+			b, err := cli.execute(context.TODO(), "import", "--file", file, "--on-conflict", "update", "--concurrency", "1")
+			require.NoError(t, err)
+
+			sum := decodeSummary(t, b)
+			assert.Equal(t, 1, sum.Created)
+			assert.Equal(t, 1, sum.Updated)
+
+			f, err := db.ReadFoo(context.TODO(), "1")
+			require.NoError(t, err)
+			assert.Equal(t, "updated note", f.Note)
+		})
+	})
+
+	t.Run("import with dry-run should validate without creating the foos", func(t *testing.T) {
+		cli, _ := newCLI(t)
+		file := writeFile(t, "foos.jsonl", `{"name":"second-foo"}`+"\n"+`{"name":""}`+"\n")
+
+		b, err := cli.execute(context.TODO(), "import", "--file", file, "--dry-run")
+		require.Error(t, err)
+
+		sum := decodeSummary(t, b)
+		assert.Equal(t, 1, sum.Valid)
+		require.Len(t, sum.Failures, 1)
+		assert.Equal(t, 2, sum.Failures[0].Line)
+		assert.Equal(t, "invalid", sum.Failures[0].Kind)
+
+		_, err = cli.CreateFoo(context.TODO(), allsrv.Foo{Name: "second-foo"})
+		require.NoError(t, err)
+	})
+
+	t.Run("export should write the records of the foos", func(t *testing.T) {
+		cli, _ := newCLI(t)
+
+		b, err := cli.execute(context.TODO(), "export", "1", "--format", "csv")
+		require.NoError(t, err)
+		assert.Equal(t, "id,name,note,created_at,updated_at,expires_at,labels,metadata\n1,first-foo,some note,2024-07-20T00:00:00Z,2024-07-20T00:00:00Z,,,\n", string(b))
+
+		b, err = cli.execute(context.TODO(), "export", "--ids-file", writeFile(t, "ids", "1\n"))
+		require.NoError(t, err)
+		assert.Equal(t, `{"id":"1","name":"first-foo","note":"some note","created_at":"2024-07-20T00:00:00Z","updated_at":"2024-07-20T00:00:00Z"}`+"\n", string(b))
+	})
+
+	t.Run("export with missing foos should fail", func(t *testing.T) {
+		cli, _ := newCLI(t)
+
+		b, err := cli.execute(context.TODO(), "export", "1", "9000")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "1 of 2 foos failed to export")
+		assert.Contains(t, string(b), `"id":"1"`)
+	})
+
+	t.Run("exported records should import", func(t *testing.T) {
+		src, _ := newCLI(t)
+		b, err := src.execute(context.TODO(), "export", "1")
+		require.NoError(t, err)
+
+		srv := httptest.NewServer(allsrv.NewServerV2(allsrv.NewService(new(allsrv.InmemDB))))
+		t.Cleanup(srv.Close)
+
+		dst := &cmdCLI{addr: srv.URL}
+		b, err = dst.execute(context.TODO(), "import", "--file", writeFile(t, "foos.jsonl", string(b)))
+		require.NoError(t, err)
+		assert.Equal(t, 1, decodeSummary(t, b).Created)
+	})
+
+	t.Run("exported records should import into another environment", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+		src, srcDB := newCLI(t)
+		require.NoError(t, srcDB.UpdateFoo(context.TODO(), allsrv.Foo{
+			ID:        "1",
+			Name:      "first-foo",
+			Note:      "exported note",
+			CreatedAt: start,
+			UpdatedAt: start,
+			ExpiresAt: expiresAt,
+			Labels:    map[string]string{"env": "prod"},
+			Metadata:  map[string]any{"region": "us"},
+		}))
+
+		for _, format := range []string{"jsonl", "csv"} {
+			t.Run(format, func(t *testing.T) {
+				b, err := src.execute(context.TODO(), "export", "1", "--format", format)
+				require.NoError(t, err)
+
+				// the foo of the same name has an id of its own in the target
+				dstDB := new(allsrv.InmemDB)
+				allsrvtesting.CreateFoos(
+					allsrv.Foo{ID: "1", Name: "other-foo", CreatedAt: start, UpdatedAt: start},
+					allsrv.Foo{ID: "2", Name: "first-foo", Note: "stale note", CreatedAt: start, UpdatedAt: start},
+				)(t, dstDB)
+				srv := httptest.NewServer(allsrv.NewServerV2(allsrv.NewService(dstDB)))
+				t.Cleanup(srv.Close)
+
+				dst := &cmdCLI{addr: srv.URL}
+				b, err = dst.execute(context.TODO(), "import", "--file", writeFile(t, "foos."+format, string(b)), "--format", format, "--on-conflict", "update")
+				require.NoError(t, err)
+				assert.Equal(t, 1, decodeSummary(t, b).Updated)
+
+				f, err := dstDB.ReadFoo(context.TODO(), "2")
+				require.NoError(t, err)
+				assert.Equal(t, "exported note", f.Note)
+				assert.Equal(t, expiresAt, f.ExpiresAt.UTC())
+				assert.Equal(t, map[string]string{"env": "prod"}, f.Labels)
+				assert.Equal(t, map[string]any{"region": "us"}, f.Metadata)
+
+				other, err := dstDB.ReadFoo(context.TODO(), "1")
+				require.NoError(t, err)
+				assert.Equal(t, "other-foo", other.Name)
+				assert.Empty(t, other.Note)
+			})
+		}
+	})
+}
+
+func TestCliShell(t *testing.T) {
+	start := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
+
+	db := new(allsrv.InmemDB)
+	allsrvtesting.CreateFoos(allsrv.Foo{
+		ID:        "1",
+		Name:      "first-foo",
+		Note:      "some note",
+		CreatedAt: start,
+		UpdatedAt: start,
+	})(t, db)
+
+	srv := httptest.NewServer(allsrv.NewServerV2(allsrv.NewService(db), allsrv.WithBasicAuthV2("admin", "secret")))
+	t.Cleanup(srv.Close)
+
+	cfgDir := t.TempDir()
+	t.Setenv("ALLSRVC_CONFIG", filepath.Join(cfgDir, "config.yaml"))
+
+	shell := func(t *testing.T, lines ...string) string {
+		t.Helper()
+
+		stdin := strings.NewReader(strings.Join(lines, "\n") + "\n")
+		b, err := runIn(context.TODO(), stdin, "shell", "--addr", srv.URL, "--user", "admin", "--password", "secret", "-o", "go-template={{.attributes.name}}")
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	t.Run("should keep the connection and profile across commands", func(t *testing.T) {
+		out := shell(t,
+			"read 1",
+			"read 1 -o go-template={{.attributes.note}}",
+			`update --id 1 --note "new note"`,
+		)
+		assert.Equal(t, "first-foo\nsome note\nfirst-foo\n", out)
+	})
+
+	t.Run("should continue after a failed command", func(t *testing.T) {
+		out := shell(t, "read 9000", "read 1")
+		assert.Equal(t, "first-foo\n", out)
+	})
+
+	t.Run("should pipe the output of a command into the next", func(t *testing.T) {
+		out := shell(t, "export 1 | import --dry-run -o json")
+
+		var sum struct {
+			Total int `json:"total"`
+			Valid int `json:"valid"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(out), &sum))
+		assert.Equal(t, 1, sum.Total)
+		assert.Equal(t, 1, sum.Valid)
+	})
+
+	t.Run("should keep the history across sessions without passwords", func(t *testing.T) {
+		shell(t, "read 1 --password secret")
+
+		out := shell(t, "history")
+		assert.Contains(t, out, "read 1 --password ***\n")
+		assert.NotContains(t, out, "secret")
+
+		b, err := os.ReadFile(filepath.Join(cfgDir, "history"))
+		require.NoError(t, err)
+		assert.NotContains(t, string(b), "secret")
+	})
+
+	t.Run("should exit early", func(t *testing.T) {
+		out := shell(t, "exit", "read 1")
+		assert.Empty(t, out)
+	})
+
+	t.Run("interrupt should cancel the running command and not the shell", func(t *testing.T) {
+		received, cancelled := make(chan struct{}), make(chan struct{})
+		blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(received)
+			<-r.Context().Done()
+			close(cancelled)
+		}))
+		t.Cleanup(blocking.Close)
+
+		done := make(chan error, 1)
+		go func() {
+			stdin := strings.NewReader("read 1\nexit\n")
+			_, err := runIn(context.TODO(), stdin, "shell", "--addr", blocking.URL)
+			done <- err
+		}()
+
+		<-received
+		p, err := os.FindProcess(os.Getpid())
+		require.NoError(t, err)
+		require.NoError(t, p.Signal(os.Interrupt))
+
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("the command was not cancelled")
+		}
+		require.NoError(t, <-done)
+	})
+}
+
+func TestShellComplete(t *testing.T) {
+	sh := new(shell)
+	sh.see("1", "2", "10")
+	sh.see("1")
+
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{line: "", want: []string{"add", "config", "exit", "export", "history", "import", "ls", "read", "rm", "update"}},
+		{line: "re", want: []string{"read"}},
+		{line: "config ", want: []string{"get-contexts", "set-context", "use-context"}},
+		{line: "read ", want: []string{"1", "10", "2"}},
+		{line: "read 1", want: []string{"1", "10"}},
+		{line: "export 1 | imp", want: []string{"import"}},
+		{line: "import --dry", want: []string{"--dry-run"}},
+		{line: "rm --pass", want: []string{"--password"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			assert.Equal(t, tt.want, sh.complete(tt.line))
+		})
+	}
+}
+
+func TestShellLineEditor(t *testing.T) {
+	sh := &shell{history: []string{"read 1", "rm 2"}}
+	sh.see("42")
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "completes the command and id", input: "re\t4\t\r", want: "read 42 "},
+		{name: "browses the history", input: "\x1b[A\x1b[A\x1b[B\r", want: "rm 2"},
+		{name: "erases with backspace", input: "readx\x7f 1\r", want: "read 1"},
+		{name: "clears the line with ctrl-c", input: "read\x03", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newLineEditor(strings.NewReader(tt.input), io.Discard, sh)
+
+			line, err := e.readLine()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, line)
+		})
+	}
+
+	t.Run("ctrl-d on an empty line ends the input", func(t *testing.T) {
+		_, err := newLineEditor(strings.NewReader("\x04"), io.Discard, sh).readLine()
+		assert.Equal(t, io.EOF, err)
+	})
+}
+
 type cmdCLI struct {
 	addr string
 }
 
 func (c *cmdCLI) CreateFoo(ctx context.Context, f allsrv.Foo) (allsrv.Foo, error) {
-	return c.expectFoo(ctx, "add", "--name", f.Name, "--note", f.Note)
+	args := []string{"--name", f.Name, "--note", f.Note}
+	if !f.ExpiresAt.IsZero() {
+		args = append(args, "--expires-at", f.ExpiresAt.Format(time.RFC3339))
+	}
+	for k, v := range f.Labels {
+		args = append(args, "--label", k+"="+v)
+	}
+	if len(f.Metadata) > 0 {
+		b, err := json.Marshal(f.Metadata)
+		if err != nil {
+			return allsrv.Foo{}, err
+		}
+		args = append(args, "--metadata", string(b))
+	}
+	return c.expectFoo(ctx, "add", args...)
 }
 
-func (c *cmdCLI) ReadFoo(ctx context.Context, id string) (allsrv.Foo, error) {
+func (c *cmdCLI) ReadFoo(ctx context.Context, id string, _ ...allsrv.ReadOptFn) (allsrv.Foo, error) {
 	return c.expectFoo(ctx, "read", id)
 }
 
@@ -44,6 +618,34 @@ func (c *cmdCLI) UpdateFoo(ctx context.Context, f allsrv.FooUpd) (allsrv.Foo, er
 	if f.Note != nil {
 		args = append(args, "--note", *f.Note)
 	}
+	if f.Labels != nil {
+		// the labels of the cli are applied to the existing labels, so
+		// every existing label is removed before the new labels are set
+		existing, err := c.ReadFoo(ctx, f.ID)
+		if err != nil {
+			return allsrv.Foo{}, err
+		}
+		for k := range existing.Labels {
+			args = append(args, "--label", k+"-")
+		}
+		for k, v := range *f.Labels {
+			args = append(args, "--label", k+"="+v)
+		}
+	}
+	if f.Metadata != nil {
+		b, err := json.Marshal(*f.Metadata)
+		if err != nil {
+			return allsrv.Foo{}, err
+		}
+		args = append(args, "--metadata", string(b))
+	}
+	if f.ExpiresAt != nil {
+		var expiresAt string
+		if !f.ExpiresAt.IsZero() {
+			expiresAt = f.ExpiresAt.Format(time.RFC3339)
+		}
+		args = append(args, "--expires-at", expiresAt)
+	}
 	return c.expectFoo(ctx, "update", args...)
 }
 
@@ -52,29 +654,65 @@ func (c *cmdCLI) DelFoo(ctx context.Context, id string) error {
 	return err
 }
 
+func (c *cmdCLI) ListFoos(ctx context.Context, sel allsrv.LabelSelector, opts ...allsrv.ListOptFn) ([]allsrv.Foo, error) {
+	var o allsrv.ListOpts
+	for _, fn := range opts {
+		fn(&o)
+	}
+
+	args := []string{"--selector", sel.String()}
+	for _, req := range o.Metadata {
+		args = append(args, "--metadata", strings.Join(req.Path, ".")+"="+req.Value)
+	}
+	b, err := c.execute(ctx, "ls", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []allsrv.Foo
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for dec.More() {
+		var data allsrvc.Data[allsrv.FooAttrs]
+		if err := dec.Decode(&data); err != nil {
+			return nil, err
+		}
+		out = append(out, allsrv.FooDataToFoo(data))
+	}
+	return out, nil
+}
+
 func (c *cmdCLI) expectFoo(ctx context.Context, op string, args ...string) (allsrv.Foo, error) {
 	b, err := c.execute(ctx, op, args...)
 	if err != nil {
 		return allsrv.Foo{}, err
 	}
 
-	var out allsrvc.Data[allsrvc.ResourceFooAttrs]
+	var out allsrvc.Data[allsrv.FooAttrs]
 	if err := json.Unmarshal(b, &out); err != nil {
 		return allsrv.Foo{}, err
 	}
 
-	return allsrv.DataToFoo(out), nil
+	return allsrv.FooDataToFoo(out), nil
 }
 
 func (c *cmdCLI) execute(ctx context.Context, op string, args ...string) ([]byte, error) {
+	return run(ctx, append([]string{op, "--addr", c.addr}, args...)...)
+}
+
+func run(ctx context.Context, args ...string) ([]byte, error) {
+	return runIn(ctx, strings.NewReader(""), args...)
+}
+
+func runIn(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
 	cmd := newCmd()
 
-	var buf bytes.Buffer
-	cmd.SetOut(&buf)
-	cmd.SetErr(&buf)
+	var stdout, stderr bytes.Buffer
+	cmd.SetIn(stdin)
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
 
-	cmd.SetArgs(append([]string{op, "--addr", c.addr}, args...))
+	cmd.SetArgs(args)
 
 	err := cmd.ExecuteContext(ctx)
-	return buf.Bytes(), err
+	return stdout.Bytes(), err
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/jsteenb2/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jsteenb2/mess/allsrv"
+)
+
+const (
+	outputJSON       = "json"
+	outputYAML       = "yaml"
+	outputTable      = "table"
+	outputGoTemplate = "go-template"
+)
+
+// printer writes foos in the output format. Only the selected fields of
+// the foo attributes are written, when fields are provided.
+type printer struct {
+	format string
+	tmpl   *template.Template
+	fields []string
+
+	// seen is called with the ids of the foos printed, when set.
+	seen func(ids ...string)
+}
+
+// newPrinter parses the output format. The go-template format is provided
+// with the template (i.e. go-template={{.attributes.name}}).
+func newPrinter(output string, fields []string) (*printer, error) {
+	format, tmpl, _ := strings.Cut(output, "=")
+
+	p := printer{format: format, fields: fields}
+	switch format {
+	case outputJSON, outputYAML, outputTable:
+		if tmpl != "" {
+			return nil, errors.New("a template is only valid for the " + outputGoTemplate + " output")
+		}
+	case outputGoTemplate:
+		if tmpl == "" {
+			return nil, errors.New("the " + outputGoTemplate + " output requires a template (i.e. " + outputGoTemplate + "={{.id}})")
+		}
+		t, err := template.New(name).Option("missingkey=zero").Parse(tmpl)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid go-template provided")
+		}
+		p.tmpl = t
+	default:
+		return nil, errors.New("invalid output provided: " + output + "; valid outputs are: " + strings.Join([]string{outputJSON, outputYAML, outputTable, outputGoTemplate + "=$TEMPLATE"}, ", "))
+	}
+
+	return &p, nil
+}
+
+func (p *printer) printFoos(w io.Writer, foos ...allsrv.Foo) error {
+	if p.seen != nil {
+		ids := make([]string, 0, len(foos))
+		for _, f := range foos {
+			ids = append(ids, f.ID)
+		}
+		p.seen(ids...)
+	}
+
+	docs := make([]map[string]any, 0, len(foos))
+	for _, f := range foos {
+		doc, err := toDoc(allsrv.FooToSparseData(f, p.fields...))
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		docs = append(docs, doc)
+	}
+
+	switch p.format {
+	case outputYAML:
+		enc := yaml.NewEncoder(w)
+		for _, doc := range docs {
+			if err := enc.Encode(doc); err != nil {
+				return errors.Wrap(err)
+			}
+		}
+		return errors.Wrap(enc.Close())
+	case outputTable:
+		return p.printTable(w, docs)
+	case outputGoTemplate:
+		for _, doc := range docs {
+			if err := p.tmpl.Execute(w, doc); err != nil {
+				return errors.Wrap(err)
+			}
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return errors.Wrap(err)
+			}
+		}
+		return nil
+	default:
+		enc := json.NewEncoder(w)
+		for _, doc := range docs {
+			if err := enc.Encode(doc); err != nil {
+				return errors.Wrap(err)
+			}
+		}
+		return nil
+	}
+}
+
+func (p *printer) printTable(w io.Writer, docs []map[string]any) error {
+	cols := p.fields
+	if len(cols) == 0 {
+		cols = []string{"name", "note", "created_at", "updated_at"}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	header := append([]string{"ID"}, cols...)
+	for i := range header {
+		header[i] = strings.ToUpper(header[i])
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for _, doc := range docs {
+		attrs, _ := doc["attributes"].(map[string]any)
+		row := []string{fmt.Sprint(doc["id"])}
+		for _, col := range cols {
+			row = append(row, fmt.Sprint(attrs[col]))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return errors.Wrap(tw.Flush())
+}
+
+// toDoc converts the value to its json document, so that every output
+// format shares the field names of the API.
+func toDoc(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]any
+	return doc, json.Unmarshal(b, &doc)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jsteenb2/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+)
+
+const (
+	shellPrompt      = name + "> "
+	shellHistoryMax  = 500
+	shellRecentIDMax = 50
+)
+
+// shellConn is the connection of the shell, resolved once when the shell
+// starts and shared by every command run within it.
+type shellConn struct {
+	addr   string
+	user   string
+	pass   string
+	output string
+}
+
+func (c *cli) cmdShell() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "shell",
+		Short: "start an interactive shell, keeping the connection and profile across commands",
+		Long: `start an interactive shell, keeping the connection and profile across commands.
+
+Commands are provided without the allsrvc prefix, and may be piped into one
+another, where the output of a command is the input of the next:
+
+	export 1 2 3 | import --dry-run
+
+The tab key completes commands, flags and the ids of recently seen foos. The
+history of the shell is kept across sessions. Use exit or ctrl-d to quit.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := configPath()
+			if err != nil {
+				return err
+			}
+
+			sh := &shell{
+				conn: &shellConn{
+					addr:   c.addr,
+					user:   c.user,
+					pass:   c.pass,
+					output: c.output,
+				},
+				httpClient:  &http.Client{Timeout: 5 * time.Second},
+				historyPath: filepath.Join(filepath.Dir(path), "history"),
+				out:         cmd.OutOrStdout(),
+				errOut:      cmd.ErrOrStderr(),
+			}
+			sh.loadHistory()
+
+			return sh.run(cmd.Context(), cmd.InOrStdin())
+		},
+	}
+	c.registerCommonFlags(&cmd)
+	return &cmd
+}
+
+type shell struct {
+	conn        *shellConn
+	httpClient  *http.Client
+	historyPath string
+	out, errOut io.Writer
+
+	history []string
+
+	mu        sync.Mutex
+	recentIDs []string // most recent first
+}
+
+func (s *shell) run(ctx context.Context, in io.Reader) error {
+	readLine := s.lineReader(in)
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		readLine = s.rawLineReader(f)
+	}
+
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		s.addHistory(line)
+
+		switch line {
+		case "exit", "quit":
+			return nil
+		case "history":
+			for _, h := range s.history {
+				fmt.Fprintln(s.out, h)
+			}
+			continue
+		}
+
+		// an interrupt cancels the command being run, rather than the shell
+		cmdCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		err = s.exec(cmdCtx, line)
+		stop()
+		if err != nil {
+			// the error is reported by the command, the shell carries on
+			continue
+		}
+	}
+}
+
+// rawLineReader reads lines of a terminal with the line editor. The terminal
+// is in raw mode only while a line is read, so the commands run with the
+// terminal as is, where ctrl-c interrupts the command.
+func (s *shell) rawLineReader(f *os.File) func() (string, error) {
+	editor := newLineEditor(f, s.errOut, s)
+	return func() (string, error) {
+		fd := int(f.Fd())
+		old, err := term.MakeRaw(fd)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to set the terminal to raw mode")
+		}
+		defer term.Restore(fd, old)
+		return editor.readLine()
+	}
+}
+
+// lineReader reads lines of non-interactive input, as when a script is
+// provided on stdin.
+func (s *shell) lineReader(in io.Reader) func() (string, error) {
+	scanner := bufio.NewScanner(in)
+	return func() (string, error) {
+		fmt.Fprint(s.errOut, shellPrompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(s.errOut)
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return scanner.Text(), nil
+	}
+}
+
+// exec runs the pipeline of commands of the line. The output of each command
+// is provided as the input of the next, and the output of the last command
+// is written to the shell's output.
+func (s *shell) exec(ctx context.Context, line string) error {
+	pipeline, err := splitPipeline(line)
+	if err != nil {
+		fmt.Fprintln(s.errOut, "Error:", err)
+		return err
+	}
+
+	var in io.Reader = strings.NewReader("")
+	for i, args := range pipeline {
+		if len(args) == 0 {
+			err := errors.New("empty command in pipeline")
+			fmt.Fprintln(s.errOut, "Error:", err)
+			return err
+		}
+		if args[0] == "shell" {
+			err := errors.New("the shell may not be started within the shell")
+			fmt.Fprintln(s.errOut, "Error:", err)
+			return err
+		}
+
+		out := s.out
+		var buf bytes.Buffer
+		if i < len(pipeline)-1 {
+			out = &buf
+		}
+
+		if err := s.execCmd(ctx, args, in, out); err != nil {
+			return err
+		}
+		in = &buf
+	}
+	return nil
+}
+
+func (s *shell) execCmd(ctx context.Context, args []string, in io.Reader, out io.Writer) error {
+	sub := &cli{
+		conn:       s.conn,
+		httpClient: s.httpClient,
+		seen:       s.see,
+	}
+	cmd := sub.cmd()
+	cmd.SetArgs(args)
+	cmd.SetIn(in)
+	cmd.SetOut(out)
+	cmd.SetErr(s.errOut)
+	return cmd.ExecuteContext(ctx)
+}
+
+func (s *shell) see(ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		s.recentIDs = slices.DeleteFunc(s.recentIDs, func(existing string) bool { return existing == id })
+		s.recentIDs = slices.Insert(s.recentIDs, 0, id)
+	}
+	if len(s.recentIDs) > shellRecentIDMax {
+		s.recentIDs = s.recentIDs[:shellRecentIDMax]
+	}
+}
+
+// complete provides the candidates for the last word of the line. The first
+// word completes to the commands, words starting with a dash complete to the
+// flags of the command, and any other word completes to the ids of recently
+// seen foos.
+func (s *shell) complete(line string) []string {
+	if i := strings.LastIndex(line, "|"); i >= 0 {
+		line = line[i+1:]
+	}
+	words := strings.Fields(line)
+	if len(words) == 0 || strings.HasSuffix(line, " ") {
+		words = append(words, "")
+	}
+	prefix := words[len(words)-1]
+
+	root := (&cli{}).cmd()
+	cmd, consumed := root, 0
+	for _, w := range words[:len(words)-1] {
+		sub, _, err := cmd.Find([]string{w})
+		if err != nil || sub == cmd {
+			break
+		}
+		cmd, consumed = sub, consumed+1
+	}
+
+	var candidates []string
+	switch {
+	case strings.HasPrefix(prefix, "-"):
+		addFlag := func(f *pflag.Flag) {
+			candidates = append(candidates, "--"+f.Name)
+		}
+		cmd.Flags().VisitAll(addFlag)
+		cmd.InheritedFlags().VisitAll(addFlag)
+		slices.Sort(candidates)
+	case consumed == len(words)-1 && cmd.HasAvailableSubCommands():
+		if cmd == root {
+			candidates = append(candidates, "exit", "history")
+		}
+		for _, sub := range cmd.Commands() {
+			if sub.IsAvailableCommand() && sub.Name() != "shell" {
+				candidates = append(candidates, sub.Name())
+			}
+		}
+		slices.Sort(candidates)
+	default:
+		s.mu.Lock()
+		candidates = slices.Clone(s.recentIDs)
+		s.mu.Unlock()
+	}
+
+	out := candidates[:0]
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			out = append(out, c)
+		}
+	}
+	return slices.Compact(out)
+}
+
+func (s *shell) loadHistory() {
+	b, err := os.ReadFile(s.historyPath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			s.history = append(s.history, line)
+		}
+	}
+	if len(s.history) > shellHistoryMax {
+		s.history = s.history[len(s.history)-shellHistoryMax:]
+	}
+}
+
+// addHistory adds the line to the history, persisting it for later sessions.
+// The history is best effort, a failure to persist it does not stop the shell.
+// Passwords provided with the --password flag are not kept in the history.
+func (s *shell) addHistory(line string) {
+	line = redactPassword(line)
+	if n := len(s.history); n > 0 && s.history[n-1] == line {
+		return
+	}
+	s.history = append(s.history, line)
+
+	if len(s.history) > shellHistoryMax {
+		s.history = s.history[len(s.history)-shellHistoryMax:]
+		if err := os.MkdirAll(filepath.Dir(s.historyPath), 0o700); err == nil {
+			os.WriteFile(s.historyPath, []byte(strings.Join(s.history, "\n")+"\n"), 0o600)
+		}
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.historyPath), 0o700); err != nil {
+		return
+	}
+	f, err := os.OpenFile(s.historyPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+var passwordFlagRe = regexp.MustCompile(`(--password[= ]+)("[^"]*"|'[^']*'|\S+)`)
+
+func redactPassword(line string) string {
+	return passwordFlagRe.ReplaceAllString(line, "${1}***")
+}
+
+// splitPipeline splits the line into the args of each command of the
+// pipeline. Single and double quotes group words, and a backslash escapes
+// the following character.
+func splitPipeline(line string) ([][]string, error) {
+	var (
+		pipeline [][]string
+		args     []string
+		word     strings.Builder
+		inWord   bool
+		quote    rune
+		escaped  bool
+	)
+	endWord := func() {
+		if inWord {
+			args = append(args, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+				continue
+			}
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == '|':
+			endWord()
+			pipeline = append(pipeline, args)
+			args = nil
+		case r == ' ' || r == '\t':
+			endWord()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in command")
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash in command")
+	}
+	endWord()
+
+	return append(pipeline, args), nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyBackspace = 8
+	keyTab       = 9
+	keyLF        = 10
+	keyCR        = 13
+	keyCtrlU     = 21
+	keyEsc       = 27
+	keyDel       = 127
+)
+
+// lineEditor reads lines from a terminal in raw mode. It supports the
+// completion of the last word with tab, and browsing the history with the
+// up and down arrows. The cursor is always at the end of the line.
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+	sh  *shell
+}
+
+func newLineEditor(in io.Reader, out io.Writer, sh *shell) *lineEditor {
+	return &lineEditor{in: bufio.NewReader(in), out: out, sh: sh}
+}
+
+func (e *lineEditor) readLine() (string, error) {
+	var (
+		buf     []rune
+		histIdx = len(e.sh.history)
+	)
+	e.redraw(buf)
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case keyCR, keyLF:
+			fmt.Fprint(e.out, "\r\n")
+			return string(buf), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", nil
+		case keyCtrlD:
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+		case keyBackspace, keyDel:
+			if len(buf) > 0 {
+				buf = buf[:len(buf)-1]
+			}
+		case keyCtrlU:
+			buf = buf[:0]
+		case keyTab:
+			buf = e.complete(buf)
+		case keyEsc:
+			if next, _, err := e.in.ReadRune(); err != nil || next != '[' {
+				continue
+			}
+			arrow, _, err := e.in.ReadRune()
+			if err != nil {
+				return "", err
+			}
+			switch {
+			case arrow == 'A' && histIdx > 0:
+				histIdx--
+				buf = []rune(e.sh.history[histIdx])
+			case arrow == 'B' && histIdx < len(e.sh.history)-1:
+				histIdx++
+				buf = []rune(e.sh.history[histIdx])
+			case arrow == 'B':
+				histIdx, buf = len(e.sh.history), buf[:0]
+			}
+		default:
+			if r >= ' ' {
+				buf = append(buf, r)
+			}
+		}
+		e.redraw(buf)
+	}
+}
+
+// complete extends the last word of the line to the longest common prefix
+// of the candidates. When the candidates do not extend the word, they are
+// listed below the line.
+func (e *lineEditor) complete(buf []rune) []rune {
+	line := string(buf)
+	candidates := e.sh.complete(line)
+	if len(candidates) == 0 {
+		return buf
+	}
+
+	word := line[strings.LastIndexAny(line, " |")+1:]
+	common := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, common) {
+			common = common[:len(common)-1]
+		}
+	}
+
+	switch {
+	case len(candidates) == 1:
+		return []rune(line + strings.TrimPrefix(common, word) + " ")
+	case len(common) > len(word):
+		return []rune(line + strings.TrimPrefix(common, word))
+	default:
+		fmt.Fprint(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+		return buf
+	}
+}
+
+func (e *lineEditor) redraw(buf []rune) {
+	fmt.Fprint(e.out, "\r\033[K"+shellPrompt+string(buf))
+}
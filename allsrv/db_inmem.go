@@ -2,46 +2,84 @@ package allsrv
 
 import (
 	"context"
+	"maps"
 	"sync"
+	"time"
+
+	"github.com/jsteenb2/errors"
 )
 
-// InmemDB is an in-memory store.
+// InmemDB is an in-memory store. Expired foos are hidden from reads, and are
+// replaced by a foo created in their place. The foo operations of a done
+// context fail without making any changes.
 type InmemDB struct {
-	mu sync.Mutex
-	m  []Foo // 12)
+	mu          sync.Mutex
+	m           []Foo // 12)
+	attachments []Attachment
+	links       []fooLink
+}
+
+// fooLink relates the foo to the related foo by the named relationship.
+type fooLink struct {
+	fooID     string
+	name      string
+	relatedID string
 }
 
-func (db *InmemDB) CreateFoo(_ context.Context, f Foo) error {
+func (db *InmemDB) CreateFoo(ctx context.Context, f Foo) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err)
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	db.delExpired(time.Now(), func(existing Foo) bool {
+		return f.Name == existing.Name || f.ID == existing.ID
+	})
 	for _, existing := range db.m {
 		if f.Name == existing.Name || f.ID == existing.ID {
 			return ExistsErr("foo "+f.Name+" exists", "name", f.Name, "existing_foo_id", existing.ID) // 8)
 		}
 	}
 
+	f.Labels, f.Metadata = maps.Clone(f.Labels), cloneMetadata(f.Metadata)
 	db.m = append(db.m, f)
 
 	return nil
 }
 
-func (db *InmemDB) ReadFoo(_ context.Context, id string) (Foo, error) {
+// ReadFoo reads the whole of the foo, regardless of the fields requested.
+func (db *InmemDB) ReadFoo(ctx context.Context, id string, _ ...ReadOptFn) (Foo, error) {
+	if err := ctx.Err(); err != nil {
+		return Foo{}, errors.Wrap(err)
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	now := time.Now()
 	for _, f := range db.m {
-		if id == f.ID {
+		if id == f.ID && !f.Expired(now) {
+			f.Labels, f.Metadata = maps.Clone(f.Labels), cloneMetadata(f.Metadata)
 			return f, nil
 		}
 	}
 	return Foo{}, NotFoundErr("foo not found for id: "+id, "id", id) // 8)
 }
 
-func (db *InmemDB) UpdateFoo(_ context.Context, f Foo) error {
+func (db *InmemDB) UpdateFoo(ctx context.Context, f Foo) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err)
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	now := time.Now()
+	db.delExpired(now, func(existing Foo) bool {
+		return existing.Name == f.Name && existing.ID != f.ID
+	})
 	for _, existing := range db.m {
 		if existing.Name == f.Name && existing.ID != f.ID {
 			return ExistsErr("foo "+f.Name+" exists", "name", f.Name, "existing_foo_id", existing.ID) // 8)
@@ -49,7 +87,8 @@ func (db *InmemDB) UpdateFoo(_ context.Context, f Foo) error {
 	}
 
 	for i, existing := range db.m {
-		if f.ID == existing.ID {
+		if f.ID == existing.ID && !existing.Expired(now) {
+			f.Labels, f.Metadata = maps.Clone(f.Labels), cloneMetadata(f.Metadata)
 			db.m[i] = f
 			return nil
 		}
@@ -57,15 +96,283 @@ func (db *InmemDB) UpdateFoo(_ context.Context, f Foo) error {
 	return NotFoundErr("foo not found for id: "+f.ID, "id", f.ID) // 8)
 }
 
-func (db *InmemDB) DelFoo(_ context.Context, id string) error {
+func (db *InmemDB) DelFoo(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err)
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	now := time.Now()
 	for i, f := range db.m {
-		if id == f.ID {
+		if id == f.ID && !f.Expired(now) {
+			for _, l := range db.links {
+				if l.relatedID == id && l.fooID != id && db.liveFoo(l.fooID) == nil {
+					return errRelatedTo(id, l.fooID, l.name)
+				}
+			}
+
 			db.m = append(db.m[:i], db.m[i+1:]...)
+			db.delAttachments(func(a Attachment) bool { return a.FooID == id })
+			db.delLinks(func(l fooLink) bool { return l.fooID == id || l.relatedID == id })
 			return nil // 13)
 		}
 	}
 	return NotFoundErr("foo not found for id: "+id, "id", id) // 8)
 }
+
+// ListFoos lists the foos whose labels match the selector, and whose metadata
+// matches the metadata filter of the options, in the order they were created.
+func (db *InmemDB) ListFoos(ctx context.Context, sel LabelSelector, opts ...ListOptFn) ([]Foo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now()
+	filter := newListOpts(opts).Metadata
+	var out []Foo
+	for _, f := range db.m {
+		if !f.Expired(now) && sel.Matches(f.Labels) && filter.Matches(f.Metadata) {
+			f.Labels, f.Metadata = maps.Clone(f.Labels), cloneMetadata(f.Metadata)
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// DelExpiredFoos deletes up to limit foos that have expired by now.
+func (db *InmemDB) DelExpiredFoos(_ context.Context, now time.Time, limit int) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var n int
+	db.delExpired(now, func(Foo) bool {
+		n++
+		return n <= limit
+	})
+	return min(n, limit), nil
+}
+
+// delExpired deletes the expired foos that match, along with their attachments
+// and relationships.
+func (db *InmemDB) delExpired(now time.Time, match func(Foo) bool) {
+	deleted := make(map[string]bool)
+	live := db.m[:0]
+	for _, f := range db.m {
+		if f.Expired(now) && match(f) {
+			deleted[f.ID] = true
+			continue
+		}
+		live = append(live, f)
+	}
+	clear(db.m[len(live):])
+	db.m = live
+
+	if len(deleted) > 0 {
+		db.delAttachments(func(a Attachment) bool { return deleted[a.FooID] })
+		db.delLinks(func(l fooLink) bool { return deleted[l.fooID] || deleted[l.relatedID] })
+	}
+}
+
+// CreateAttachment creates the attachment of a foo. The name of the attachment
+// must be unique among the attachments of the foo.
+func (db *InmemDB) CreateAttachment(_ context.Context, a Attachment) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.liveFoo(a.FooID); err != nil {
+		return err
+	}
+	for _, existing := range db.attachments {
+		if existing.ID == a.ID || (existing.FooID == a.FooID && existing.Name == a.Name) {
+			return ExistsErr("attachment "+a.Name+" exists", "name", a.Name, "existing_attachment_id", existing.ID)
+		}
+	}
+
+	db.attachments = append(db.attachments, a)
+
+	return nil
+}
+
+func (db *InmemDB) ReadAttachment(_ context.Context, fooID, id string) (Attachment, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.liveFoo(fooID); err != nil {
+		return Attachment{}, err
+	}
+	for _, a := range db.attachments {
+		if a.FooID == fooID && a.ID == id {
+			return a, nil
+		}
+	}
+	return Attachment{}, NotFoundErr("attachment not found for id: "+id, "id", id)
+}
+
+// ListAttachments lists the attachments of the foo, in the order they were
+// created.
+func (db *InmemDB) ListAttachments(_ context.Context, fooID string) ([]Attachment, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.liveFoo(fooID); err != nil {
+		return nil, err
+	}
+	var out []Attachment
+	for _, a := range db.attachments {
+		if a.FooID == fooID {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+func (db *InmemDB) DelAttachment(_ context.Context, fooID, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.liveFoo(fooID); err != nil {
+		return err
+	}
+	for i, a := range db.attachments {
+		if a.FooID == fooID && a.ID == id {
+			db.attachments = append(db.attachments[:i], db.attachments[i+1:]...)
+			return nil
+		}
+	}
+	return NotFoundErr("attachment not found for id: "+id, "id", id)
+}
+
+// DigestReferenced reports whether an attachment references the blob of the
+// digest.
+func (db *InmemDB) DigestReferenced(_ context.Context, digest string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, a := range db.attachments {
+		if a.Digest == digest {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ExpiredDigests provides the digests of the attachments of the foos that have
+// expired by now.
+func (db *InmemDB) ExpiredDigests(_ context.Context, now time.Time) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	expired := make(map[string]bool)
+	for _, f := range db.m {
+		if f.Expired(now) {
+			expired[f.ID] = true
+		}
+	}
+
+	var out []string
+	seen := make(map[string]bool)
+	for _, a := range db.attachments {
+		if expired[a.FooID] && !seen[a.Digest] {
+			seen[a.Digest] = true
+			out = append(out, a.Digest)
+		}
+	}
+	return out, nil
+}
+
+// liveFoo validates the foo exists and has not expired.
+func (db *InmemDB) liveFoo(id string) error {
+	now := time.Now()
+	for _, f := range db.m {
+		if f.ID == id && !f.Expired(now) {
+			return nil
+		}
+	}
+	return NotFoundErr("foo not found for id: "+id, "id", id)
+}
+
+func (db *InmemDB) delAttachments(match func(Attachment) bool) {
+	kept := db.attachments[:0]
+	for _, a := range db.attachments {
+		if !match(a) {
+			kept = append(kept, a)
+		}
+	}
+	clear(db.attachments[len(kept):])
+	db.attachments = kept
+}
+
+// ReadRelated provides the ids of the live foos the foo relates to by the
+// relationship, in the order they were related.
+func (db *InmemDB) ReadRelated(_ context.Context, fooID, name string) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.liveFoo(fooID); err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, l := range db.links {
+		if l.fooID == fooID && l.name == name && db.liveFoo(l.relatedID) == nil {
+			out = append(out, l.relatedID)
+		}
+	}
+	return out, nil
+}
+
+// ReadRelatedTo provides the ids of the live foos that relate to the foo by
+// the relationship, in the order they were related.
+func (db *InmemDB) ReadRelatedTo(_ context.Context, fooID, name string) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.liveFoo(fooID); err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, l := range db.links {
+		if l.relatedID == fooID && l.name == name && db.liveFoo(l.fooID) == nil {
+			out = append(out, l.fooID)
+		}
+	}
+	return out, nil
+}
+
+// SetRelated replaces the foos the foo relates to by the relationship. Every
+// related foo must be live.
+func (db *InmemDB) SetRelated(_ context.Context, fooID, name string, relatedIDs []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.liveFoo(fooID); err != nil {
+		return err
+	}
+	for _, id := range relatedIDs {
+		if err := db.liveFoo(id); err != nil {
+			return err
+		}
+	}
+
+	db.delLinks(func(l fooLink) bool { return l.fooID == fooID && l.name == name })
+	for _, id := range relatedIDs {
+		db.links = append(db.links, fooLink{fooID: fooID, name: name, relatedID: id})
+	}
+
+	return nil
+}
+
+func (db *InmemDB) delLinks(match func(fooLink) bool) {
+	kept := db.links[:0]
+	for _, l := range db.links {
+		if !match(l) {
+			kept = append(kept, l)
+		}
+	}
+	clear(db.links[len(kept):])
+	db.links = kept
+}
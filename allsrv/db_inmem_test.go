@@ -4,10 +4,41 @@ import (
 	"testing"
 
 	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
 )
 
 func TestInmemDB(t *testing.T) {
-	testDB(t, func(t *testing.T) allsrv.DB {
+	testDBSuites(t, func(t *testing.T) allsrv.DB {
 		return new(allsrv.InmemDB)
 	})
 }
+
+// testDBSuites verifies the DB abides by the DB contract, and provides the
+// capabilities of the DB suites. The attachments and relationships are only
+// verified for the DBs that implement them.
+func testDBSuites(t *testing.T, initFn allsrvtesting.DBInitFn) {
+	t.Helper()
+
+	db := initFn(t)
+
+	allsrvtesting.TestDB(t, initFn)
+	t.Run("Expiry", func(t *testing.T) {
+		allsrvtesting.TestExpiryDB(t, initFn)
+	})
+	t.Run("Labels", func(t *testing.T) {
+		allsrvtesting.TestLabelDB(t, initFn)
+	})
+	t.Run("Metadata", func(t *testing.T) {
+		allsrvtesting.TestMetadataDB(t, initFn)
+	})
+	if _, ok := db.(allsrv.AttachmentDB); ok {
+		t.Run("Attachments", func(t *testing.T) {
+			allsrvtesting.TestAttachmentDB(t, initFn)
+		})
+	}
+	if _, ok := db.(allsrv.RelationshipDB); ok {
+		t.Run("Relationships", func(t *testing.T) {
+			allsrvtesting.TestRelationshipDB(t, initFn)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package allsrv
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/jsteenb2/errors"
+)
+
+// FooExpirer is a db that deletes expired foos. Both the InmemDB and the
+// sqlite db are expirers.
+type FooExpirer interface {
+	DelExpiredFoos(ctx context.Context, now time.Time, limit int) (int, error)
+}
+
+// FooReaper deletes the expired foos of the db in batches, so the db is not
+// locked for the whole of the reaping.
+type FooReaper struct {
+	db    FooExpirer
+	batch int
+	met   *metrics.Metrics
+	nowFn func() time.Time
+}
+
+// NewFooReaper creates a reaper of the expired foos of the db. The metrics
+// are optional.
+func NewFooReaper(db FooExpirer, batch int, met *metrics.Metrics) *FooReaper {
+	return &FooReaper{
+		db:    db,
+		batch: max(batch, 1),
+		met:   met,
+		nowFn: time.Now,
+	}
+}
+
+// Reap deletes the foos that have expired, a batch at a time, until no expired
+// foos remain. The number of foos reaped is returned.
+func (r *FooReaper) Reap(ctx context.Context) (int, error) {
+	now := r.nowFn()
+
+	var total int
+	for {
+		n, err := r.db.DelExpiredFoos(ctx, now, r.batch)
+		total += n
+		r.record(n, err)
+		if err != nil {
+			return total, errors.Wrap(err, "failed to reap expired foos")
+		}
+		if n < r.batch {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, errors.Wrap(err)
+		}
+	}
+}
+
+// Run reaps the expired foos every interval, until the ctx is done. A failed
+// reaping is logged and retried at the next interval.
+func (r *FooReaper) Run(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := r.Reap(ctx)
+		if err != nil {
+			logger.Error("reaping expired foos failed", "err", err.Error(), "reaped", n)
+			continue
+		}
+		if n > 0 {
+			logger.Info("reaped expired foos", "reaped", n)
+		}
+	}
+}
+
+func (r *FooReaper) record(n int, err error) {
+	if r.met == nil {
+		return
+	}
+	name := []string{metricsPrefix, "foos", "reaper"}
+	r.met.IncrCounter(append(name, "batches"), 1)
+	r.met.IncrCounter(append(name, "reaped"), float32(n))
+	if err != nil {
+		r.met.IncrCounter(append(name, "errs"), 1)
+	}
+}
@@ -0,0 +1,73 @@
+package allsrv_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
+)
+
+func TestFooReaper(t *testing.T) {
+	start := time.Time{}.Add(time.Hour).UTC()
+	expired := time.Now().Add(-time.Minute)
+
+	newFoos := func(prefix string, n int, expiresAt time.Time) []allsrv.Foo {
+		var out []allsrv.Foo
+		for i := range n {
+			id := fmt.Sprintf("%s-%d", prefix, i)
+			out = append(out, allsrv.Foo{
+				ID:        id,
+				Name:      "name-" + id,
+				CreatedAt: start,
+				UpdatedAt: start,
+				ExpiresAt: expiresAt,
+			})
+		}
+		return out
+	}
+
+	t.Run("should reap every expired foo in batches", func(t *testing.T) {
+		db := new(allsrv.InmemDB)
+		live := newFoos("live", 2, time.Time{})
+		allsrvtesting.CreateFoos(append(newFoos("expired", 5, expired), live...)...)(t, db)
+
+		sink := metrics.NewInmemSink(time.Minute, time.Minute)
+		cfg := metrics.DefaultConfig("")
+		cfg.EnableRuntimeMetrics = false
+		met, err := metrics.New(cfg, sink)
+		require.NoError(t, err)
+
+		n, err := allsrv.NewFooReaper(db, 2, met).Reap(context.TODO())
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+
+		counters := sink.Data()[0].Counters
+		assert.Equal(t, float64(5), counters["mess.foos.reaper.reaped"].Sum)
+		assert.Equal(t, 3, counters["mess.foos.reaper.batches"].Count)
+
+		for _, f := range live {
+			_, err := db.ReadFoo(context.TODO(), f.ID)
+			assert.NoError(t, err)
+		}
+
+		n, err = allsrv.NewFooReaper(db, 2, nil).Reap(context.TODO())
+		require.NoError(t, err)
+		assert.Zero(t, n)
+	})
+
+	t.Run("with sqlite db should reap every expired foo", func(t *testing.T) {
+		db := newSQLiteDB(t)
+		allsrvtesting.CreateFoos(append(newFoos("expired", 3, expired), newFoos("live", 1, time.Time{})...)...)(t, db)
+
+		n, err := allsrv.NewFooReaper(db.(allsrv.FooExpirer), 2, nil).Reap(context.TODO())
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+	})
+}
@@ -3,6 +3,7 @@ package allsrv
 import (
 	"context"
 	"database/sql"
+	"slices"
 	"sync"
 	"time"
 
@@ -28,23 +29,45 @@ type sqlDB struct {
 }
 
 func (s *sqlDB) CreateFoo(ctx context.Context, f Foo) error {
-	sb := s.sq.
-		Insert("foos").
-		Columns("id", "name", "note", "created_at", "updated_at").
-		Values(f.ID, f.Name, f.Note, f.CreatedAt, f.UpdatedAt)
+	return s.tx(ctx, func(exec execFn) error {
+		// an expired foo is replaced by a foo created in its place
+		expired := s.sq.
+			Delete("foos").
+			Where(sq.Or{sq.Eq{"id": f.ID}, sq.Eq{"name": f.Name}}).
+			Where(fooExpired(time.Now()))
+		if _, err := exec(expired); err != nil {
+			return err
+		}
 
-	_, err := s.exec(ctx, sb)
-	return errors.Wrap(err)
+		sb := s.sq.
+			Insert("foos").
+			Columns("id", "name", "note", "created_at", "updated_at", "expires_at", "metadata").
+			Values(f.ID, f.Name, f.Note, f.CreatedAt, f.UpdatedAt, nullTime(f.ExpiresAt), sqlMetadata(f.Metadata))
+		if _, err := exec(sb); err != nil {
+			return err
+		}
+
+		return s.insertLabels(exec, f.ID, f.Labels)
+	})
 }
 
-func (s *sqlDB) ReadFoo(ctx context.Context, id string) (Foo, error) {
+func (s *sqlDB) ReadFoo(ctx context.Context, id string, opts ...ReadOptFn) (Foo, error) {
+	fields := newReadOpts(opts).Fields
+	query, args, err := s.sq.
+		Select(fooColumns(fields)...).
+		From("foos").
+		Where(sq.Eq{"id": id}).
+		Where(fooLive(time.Now())).
+		ToSql()
+	if err != nil {
+		return Foo{}, errors.Wrap(err)
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	const query = `SELECT * FROM foos WHERE id=?`
-
 	var ent entFoo
-	err := s.db.GetContext(ctx, &ent, s.db.Rebind(query), id)
+	err = s.db.GetContext(ctx, &ent, query, args...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Foo{}, NotFoundErr("foo not found for id: " + id)
@@ -52,43 +75,250 @@ func (s *sqlDB) ReadFoo(ctx context.Context, id string) (Foo, error) {
 		return Foo{}, errors.Wrap(err, errSQLiteFields(err))
 	}
 
-	out := Foo{
-		ID:        ent.ID,
-		Name:      ent.Name,
-		Note:      ent.Note,
-		CreatedAt: ent.CreatedAt,
-		UpdatedAt: ent.UpdatedAt,
+	out := ent.toFoo()
+	if len(fields) == 0 || slices.Contains(fields, "labels") {
+		labels, err := s.selectLabels(ctx, sq.Eq{"foo_id": id})
+		if err != nil {
+			return Foo{}, errors.Wrap(err)
+		}
+		out.Labels = labels[id]
 	}
 
 	return out, nil
 }
 
 func (s *sqlDB) UpdateFoo(ctx context.Context, f Foo) error {
-	sb := s.sq.
-		Update("foos").
-		Set("name", f.Name).
-		Set("note", f.Note).
-		Set("updated_at", f.UpdatedAt).
-		Where(sq.Eq{"id": f.ID})
-	
-	return errors.Wrap(s.update(ctx, sb))
+	now := time.Now()
+	return s.tx(ctx, func(exec execFn) error {
+		expired := s.sq.
+			Delete("foos").
+			Where(sq.Eq{"name": f.Name}).
+			Where(sq.NotEq{"id": f.ID}).
+			Where(fooExpired(now))
+		if _, err := exec(expired); err != nil {
+			return err
+		}
+
+		sb := s.sq.
+			Update("foos").
+			Set("name", f.Name).
+			Set("note", f.Note).
+			Set("updated_at", f.UpdatedAt).
+			Set("expires_at", nullTime(f.ExpiresAt)).
+			Set("metadata", sqlMetadata(f.Metadata)).
+			Where(sq.Eq{"id": f.ID}).
+			Where(fooLive(now))
+		res, err := exec(sb)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return NotFoundErr("foo not found")
+		}
+
+		if _, err := exec(s.sq.Delete("foo_labels").Where(sq.Eq{"foo_id": f.ID})); err != nil {
+			return err
+		}
+		return s.insertLabels(exec, f.ID, f.Labels)
+	})
 }
 
+// DelFoo deletes the live foo, unless another live foo relates to it.
 func (s *sqlDB) DelFoo(ctx context.Context, id string) error {
-	err := s.update(ctx, s.sq.Delete("foos").Where(sq.Eq{"id": id}))
+	now := time.Now()
+	relatedToQuery, relatedToArgs, err := s.relatedToQuery(id, now).ToSql()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	err = s.update(ctx, s.sq.
+		Delete("foos").
+		Where(sq.Eq{"id": id}).
+		Where(fooLive(now)).
+		Where("NOT EXISTS ("+relatedToQuery+")", relatedToArgs...),
+	)
+	if errors.Is(err, ErrKindNotFound) {
+		if relErr := s.relatedToErr(ctx, id, now); relErr != nil {
+			return relErr
+		}
+	}
 	return errors.Wrap(err)
 }
 
-func (s *sqlDB) exec(ctx context.Context, sqlizer sq.Sqlizer) (sql.Result, error) {
-	query, args, err := sqlizer.ToSql()
+// ListFoos lists the foos whose labels match the selector, and whose metadata
+// matches the metadata filter of the options, in the order they were created.
+func (s *sqlDB) ListFoos(ctx context.Context, sel LabelSelector, opts ...ListOptFn) ([]Foo, error) {
+	conds := sq.And{fooLive(time.Now())}
+	for _, req := range sel {
+		cond, err := s.labelReqCond(req)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		conds = append(conds, cond)
+	}
+	for _, req := range newListOpts(opts).Metadata {
+		conds = append(conds, metadataReqCond(req))
+	}
+
+	query, args, err := s.sq.
+		Select("*").
+		From("foos").
+		Where(conds).
+		OrderBy("created_at", "id").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	idsQuery, idsArgs, err := s.sq.Select("id").From("foos").Where(conds).ToSql()
 	if err != nil {
 		return nil, errors.Wrap(err)
 	}
 
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ents []entFoo
+	if err := s.db.SelectContext(ctx, &ents, query, args...); err != nil {
+		return nil, errors.Wrap(err, errSQLiteFields(err))
+	}
+	labels, err := s.selectLabels(ctx, sq.Expr("foo_id IN ("+idsQuery+")", idsArgs...))
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	out := make([]Foo, 0, len(ents))
+	for _, ent := range ents {
+		f := ent.toFoo()
+		f.Labels = labels[f.ID]
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// labelReqCond provides the condition on the foos that meet the requirement.
+// Foos without the label meet the != and notin requirements, so those are
+// the foos that are not among the foos with the label of another value.
+func (s *sqlDB) labelReqCond(req LabelReq) (sq.Sqlizer, error) {
+	sub := s.sq.
+		Select("foo_id").
+		From("foo_labels").
+		Where(sq.Eq{"key": req.Key})
+	if len(req.Values) > 0 {
+		sub = sub.Where(sq.Eq{"value": req.Values})
+	}
+	query, args, err := sub.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.Op {
+	case LabelOpNotEq, LabelOpNotIn, LabelOpNotExists:
+		return sq.Expr("id NOT IN ("+query+")", args...), nil
+	default:
+		return sq.Expr("id IN ("+query+")", args...), nil
+	}
+}
+
+// selectLabels selects the labels of the foos that match the condition,
+// keyed by the id of the foo. The caller must hold the read lock.
+func (s *sqlDB) selectLabels(ctx context.Context, cond sq.Sqlizer) (map[string]map[string]string, error) {
+	query, args, err := s.sq.
+		Select("foo_id", "key", "value").
+		From("foo_labels").
+		Where(cond).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var ents []entFooLabel
+	if err := s.db.SelectContext(ctx, &ents, query, args...); err != nil {
+		return nil, errors.Wrap(err, errSQLiteFields(err))
+	}
+
+	out := make(map[string]map[string]string)
+	for _, ent := range ents {
+		if out[ent.FooID] == nil {
+			out[ent.FooID] = make(map[string]string)
+		}
+		out[ent.FooID][ent.Key] = ent.Value
+	}
+	return out, nil
+}
+
+func (s *sqlDB) insertLabels(exec execFn, fooID string, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	sb := s.sq.Insert("foo_labels").Columns("foo_id", "key", "value")
+	for _, k := range labelKeys(labels) {
+		sb = sb.Values(fooID, k, labels[k])
+	}
+	_, err := exec(sb)
+	return err
+}
+
+// DelExpiredFoos deletes up to limit foos that have expired by now.
+func (s *sqlDB) DelExpiredFoos(ctx context.Context, now time.Time, limit int) (int, error) {
+	ids := s.sq.
+		Select("id").
+		From("foos").
+		Where(fooExpired(now)).
+		Limit(uint64(limit))
+	idsQuery, args, err := ids.ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	res, err := s.exec(ctx, s.sq.Delete("foos").Where("id IN ("+idsQuery+")", args...))
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), errors.Wrap(err)
+}
+
+// execFn executes the statement of the sqlizer.
+type execFn func(sqlizer sq.Sqlizer) (sql.Result, error)
+
+func (s *sqlDB) exec(ctx context.Context, sqlizer sq.Sqlizer) (sql.Result, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	res, err := s.db.ExecContext(ctx, query, args...)
+	return execSQL(ctx, s.db, sqlizer)
+}
+
+// tx executes the statements of fn in a transaction, which is committed when
+// fn succeeds and rolled back otherwise.
+func (s *sqlDB) tx(ctx context.Context, fn func(exec execFn) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, errSQLiteFields(err))
+	}
+
+	err = fn(func(sqlizer sq.Sqlizer) (sql.Result, error) {
+		return execSQL(ctx, tx, sqlizer)
+	})
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err)
+	}
+	err = tx.Commit()
+	return errors.Wrap(err, errSQLiteFields(err))
+}
+
+func execSQL(ctx context.Context, db sqlx.ExecerContext, sqlizer sq.Sqlizer) (sql.Result, error) {
+	query, args, err := sqlizer.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	res, err := db.ExecContext(ctx, query, args...)
 	if sqErr := new(sqlite3.Error); errors.As(err, sqErr) {
 		switch sqErr.Code {
 		case sqlite3.ErrConstraint:
@@ -112,12 +342,67 @@ func (s *sqlDB) update(ctx context.Context, sqlizer sq.Sqlizer) error {
 	return nil
 }
 
+// fooColumns provides the columns for the requested foo attribute fields. The
+// id is always selected. When no fields are provided, all columns are selected.
+func fooColumns(fields []string) []string {
+	if len(fields) == 0 {
+		return []string{"*"}
+	}
+
+	cols := []string{"id"}
+	for _, field := range fields {
+		switch field {
+		case "name", "note", "created_at", "updated_at", "expires_at", "metadata":
+			cols = append(cols, field)
+		}
+	}
+	return cols
+}
+
 type entFoo struct {
-	ID        string    `db:"id"`
-	Name      string    `db:"name"`
-	Note      string    `db:"note"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	ID        string       `db:"id"`
+	Name      string       `db:"name"`
+	Note      string       `db:"note"`
+	CreatedAt time.Time    `db:"created_at"`
+	UpdatedAt time.Time    `db:"updated_at"`
+	ExpiresAt sql.NullTime `db:"expires_at"`
+	Metadata  sqlMetadata  `db:"metadata"`
+}
+
+// fooLive matches the foos that have not expired by now. The timestamps are
+// compared as text by sqlite, so they are always written in UTC.
+func fooLive(now time.Time) sq.Sqlizer {
+	return sq.Or{sq.Eq{"expires_at": nil}, sq.Gt{"expires_at": now.UTC()}}
+}
+
+// fooExpired matches the foos that have expired by now.
+func fooExpired(now time.Time) sq.Sqlizer {
+	return sq.LtOrEq{"expires_at": now.UTC()}
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func (ent entFoo) toFoo() Foo {
+	return Foo{
+		ID:        ent.ID,
+		Name:      ent.Name,
+		Note:      ent.Note,
+		CreatedAt: ent.CreatedAt,
+		UpdatedAt: ent.UpdatedAt,
+		ExpiresAt: ent.ExpiresAt.Time,
+		Metadata:  ent.Metadata,
+	}
+}
+
+type entFooLabel struct {
+	FooID string `db:"foo_id"`
+	Key   string `db:"key"`
+	Value string `db:"value"`
 }
 
 func errSQLiteFields(err error) []errors.KV {
@@ -0,0 +1,209 @@
+package allsrv
+
+import (
+	"context"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jsteenb2/errors"
+)
+
+// CreateAttachment creates the attachment of a foo. The attachment is only
+// inserted when its foo is live, so the foo is not found otherwise.
+func (s *sqlDB) CreateAttachment(ctx context.Context, a Attachment) error {
+	liveQuery, liveArgs, err := s.liveFooQuery(a.FooID)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	values := s.sq.
+		Select().
+		Column("?", a.ID).
+		Column("?", a.FooID).
+		Column("?", a.Name).
+		Column("?", a.ContentType).
+		Column("?", a.Size).
+		Column("?", a.Digest).
+		Column("?", a.CreatedAt.UTC()).
+		Where("EXISTS ("+liveQuery+")", liveArgs...)
+	sb := s.sq.
+		Insert("foo_attachments").
+		Columns("id", "foo_id", "name", "content_type", "size", "digest", "created_at").
+		Select(values)
+
+	res, err := s.exec(ctx, sb)
+	if errors.Is(err, ErrKindExists) {
+		return ExistsErr("attachment "+a.Name+" exists", "name", a.Name)
+	}
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return NotFoundErr("foo not found for id: "+a.FooID, "id", a.FooID)
+	}
+	return nil
+}
+
+func (s *sqlDB) ReadAttachment(ctx context.Context, fooID, id string) (Attachment, error) {
+	ents, err := s.selectAttachments(ctx, fooID, sq.Eq{"id": id})
+	if err != nil {
+		return Attachment{}, errors.Wrap(err)
+	}
+	if len(ents) == 0 {
+		return Attachment{}, NotFoundErr("attachment not found for id: "+id, "id", id)
+	}
+	return ents[0].toAttachment(), nil
+}
+
+// ListAttachments lists the attachments of the foo, in the order they were
+// created.
+func (s *sqlDB) ListAttachments(ctx context.Context, fooID string) ([]Attachment, error) {
+	ents, err := s.selectAttachments(ctx, fooID, nil)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	out := make([]Attachment, 0, len(ents))
+	for _, ent := range ents {
+		out = append(out, ent.toAttachment())
+	}
+	return out, nil
+}
+
+func (s *sqlDB) DelAttachment(ctx context.Context, fooID, id string) error {
+	liveQuery, liveArgs, err := s.liveFooQuery(fooID)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	res, err := s.exec(ctx, s.sq.
+		Delete("foo_attachments").
+		Where(sq.Eq{"id": id, "foo_id": fooID}).
+		Where("EXISTS ("+liveQuery+")", liveArgs...),
+	)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return NotFoundErr("attachment not found for id: "+id, "id", id)
+	}
+	return nil
+}
+
+// DigestReferenced reports whether an attachment references the blob of the
+// digest.
+func (s *sqlDB) DigestReferenced(ctx context.Context, digest string) (bool, error) {
+	query, args, err := s.sq.
+		Select("COUNT(*) > 0").
+		From("foo_attachments").
+		Where(sq.Eq{"digest": digest}).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var referenced bool
+	err = s.db.GetContext(ctx, &referenced, query, args...)
+	return referenced, errors.Wrap(err, errSQLiteFields(err))
+}
+
+// ExpiredDigests provides the digests of the attachments of the foos that have
+// expired by now.
+func (s *sqlDB) ExpiredDigests(ctx context.Context, now time.Time) ([]string, error) {
+	expiredQuery, expiredArgs, err := s.sq.
+		Select("id").
+		From("foos").
+		Where(fooExpired(now)).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	query, args, err := s.sq.
+		Select("DISTINCT digest").
+		From("foo_attachments").
+		Where("foo_id IN ("+expiredQuery+")", expiredArgs...).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var digests []string
+	err = s.db.SelectContext(ctx, &digests, query, args...)
+	return digests, errors.Wrap(err, errSQLiteFields(err))
+}
+
+// selectAttachments selects the attachments of the live foo that match the
+// condition. The foo is not found when it is not live.
+func (s *sqlDB) selectAttachments(ctx context.Context, fooID string, cond sq.Sqlizer) ([]entAttachment, error) {
+	liveQuery, liveArgs, err := s.liveFooQuery(fooID)
+	if err != nil {
+		return nil, err
+	}
+	sb := s.sq.
+		Select("*").
+		From("foo_attachments").
+		Where(sq.Eq{"foo_id": fooID}).
+		OrderBy("created_at", "id")
+	if cond != nil {
+		sb = sb.Where(cond)
+	}
+	query, args, err := sb.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var live bool
+	if err := s.db.GetContext(ctx, &live, "SELECT EXISTS ("+liveQuery+")", liveArgs...); err != nil {
+		return nil, errors.Wrap(err, errSQLiteFields(err))
+	}
+	if !live {
+		return nil, NotFoundErr("foo not found for id: "+fooID, "id", fooID)
+	}
+
+	var ents []entAttachment
+	if err := s.db.SelectContext(ctx, &ents, query, args...); err != nil {
+		return nil, errors.Wrap(err, errSQLiteFields(err))
+	}
+	return ents, nil
+}
+
+// liveFooQuery provides the query of the foo when it is live.
+func (s *sqlDB) liveFooQuery(fooID string) (string, []any, error) {
+	return s.sq.
+		Select("1").
+		From("foos").
+		Where(sq.Eq{"id": fooID}).
+		Where(fooLive(time.Now())).
+		ToSql()
+}
+
+type entAttachment struct {
+	ID          string    `db:"id"`
+	FooID       string    `db:"foo_id"`
+	Name        string    `db:"name"`
+	ContentType string    `db:"content_type"`
+	Size        int64     `db:"size"`
+	Digest      string    `db:"digest"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+func (ent entAttachment) toAttachment() Attachment {
+	return Attachment{
+		ID:          ent.ID,
+		FooID:       ent.FooID,
+		Name:        ent.Name,
+		ContentType: ent.ContentType,
+		Size:        ent.Size,
+		Digest:      ent.Digest,
+		CreatedAt:   ent.CreatedAt,
+	}
+}
@@ -0,0 +1,331 @@
+package allsrv
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jsteenb2/errors"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	sqliteBackupPrefix     = "allsrv-"
+	sqliteBackupExt        = ".db"
+	sqliteBackupTimeFormat = "20060102T150405.000Z"
+
+	// sqliteBackupStepPages is the number of pages copied per step of the
+	// backup, so the source db is not locked for the whole of the backup.
+	sqliteBackupStepPages = 256
+)
+
+// SQLiteBackup is a backup of the sqlite db.
+type SQLiteBackup struct {
+	Name          string
+	Path          string
+	SchemaVersion uint
+	Size          int64
+	CreatedAt     time.Time
+}
+
+// BackupSQLite makes an online backup of the sqlite db to the file at path,
+// using the sqlite backup API. The db remains available for reads and
+// writes during the backup.
+func BackupSQLite(ctx context.Context, db *sql.DB, path string) (SQLiteBackup, error) {
+	tmpPath := path + ".tmp"
+	defer os.Remove(tmpPath)
+
+	if err := copySQLite(ctx, "file:"+tmpPath, db, true); err != nil {
+		return SQLiteBackup{}, errors.Wrap(err, "failed to backup sqlite db")
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return SQLiteBackup{}, errors.Wrap(err, "failed to move sqlite backup into place")
+	}
+
+	return statSQLiteBackup(ctx, path)
+}
+
+// RestoreSQLite restores the backup at path into the sqlite db, replacing
+// its contents. The backup is validated before the restore, and is rejected
+// when its schema version is dirty or newer than the latest migration of
+// migrations.SQLite. A backup with an older schema version is restored as is,
+// and is brought up to date by the migrations.
+func RestoreSQLite(ctx context.Context, db *sql.DB, path string) (SQLiteBackup, error) {
+	backup, err := ValidateSQLiteBackup(ctx, path)
+	if err != nil {
+		return SQLiteBackup{}, err
+	}
+
+	if err := copySQLite(ctx, "file:"+path+"?mode=ro", db, false); err != nil {
+		return SQLiteBackup{}, errors.Wrap(err, "failed to restore sqlite db")
+	}
+	return backup, nil
+}
+
+// ValidateSQLiteBackup validates the integrity and schema version of the
+// backup at path.
+func ValidateSQLiteBackup(ctx context.Context, path string) (SQLiteBackup, error) {
+	backup, err := statSQLiteBackup(ctx, path)
+	if err != nil {
+		return SQLiteBackup{}, err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return SQLiteBackup{}, errors.Wrap(err)
+	}
+	defer db.Close()
+
+	var integrity string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&integrity); err != nil {
+		return SQLiteBackup{}, InvalidErr("sqlite backup is not a valid sqlite db: "+err.Error(), "path", path)
+	}
+	if integrity != "ok" {
+		return SQLiteBackup{}, InvalidErr("sqlite backup failed integrity check: "+integrity, "path", path)
+	}
+
+	version, dirty, err := sqliteSchemaVersion(ctx, db)
+	if err != nil {
+		return SQLiteBackup{}, InvalidErr("sqlite backup is missing the schema version: "+err.Error(), "path", path)
+	}
+	if dirty {
+		return SQLiteBackup{}, InvalidErr(fmt.Sprintf("sqlite backup has a dirty schema version %d", version), "path", path)
+	}
+
+	latest, err := LatestSQLiteSchemaVersion()
+	if err != nil {
+		return SQLiteBackup{}, err
+	}
+	if version > latest {
+		return SQLiteBackup{}, InvalidErr(
+			fmt.Sprintf("sqlite backup schema version %d is newer than the latest supported version %d", version, latest),
+			"path", path,
+		)
+	}
+
+	return backup, nil
+}
+
+func statSQLiteBackup(ctx context.Context, path string) (SQLiteBackup, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return SQLiteBackup{}, NotFoundErr("sqlite backup not found: "+path, "path", path)
+		}
+		return SQLiteBackup{}, errors.Wrap(err)
+	}
+
+	backup := SQLiteBackup{
+		Name:      filepath.Base(path),
+		Path:      path,
+		Size:      info.Size(),
+		CreatedAt: info.ModTime().UTC(),
+	}
+	if t, ok := parseSQLiteBackupName(backup.Name); ok {
+		backup.CreatedAt = t
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return SQLiteBackup{}, errors.Wrap(err)
+	}
+	defer db.Close()
+	if version, _, err := sqliteSchemaVersion(ctx, db); err == nil {
+		backup.SchemaVersion = version
+	}
+
+	return backup, nil
+}
+
+// copySQLite copies the db between the file of the dsn and the db with the
+// sqlite backup API. When toFile is true, the db is copied into the file,
+// otherwise the file is copied into the db.
+func copySQLite(ctx context.Context, dsn string, db *sql.DB, toFile bool) error {
+	fileDB, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return err
+	}
+	defer fileDB.Close()
+
+	fileConn, err := fileDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer fileConn.Close()
+
+	dbConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	return fileConn.Raw(func(fileDriverConn any) error {
+		return dbConn.Raw(func(dbDriverConn any) error {
+			fileSQLite, ok := fileDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup file is not a sqlite db")
+			}
+			dbSQLite, ok := dbDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("db is not a sqlite db")
+			}
+
+			dst, src := fileSQLite, dbSQLite
+			if !toFile {
+				dst, src = dbSQLite, fileSQLite
+			}
+
+			bk, err := dst.Backup("main", src, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				done, err := bk.Step(sqliteBackupStepPages)
+				if err != nil {
+					bk.Close()
+					return err
+				}
+				if done {
+					return bk.Finish()
+				}
+				if err := ctx.Err(); err != nil {
+					bk.Close()
+					return err
+				}
+			}
+		})
+	})
+}
+
+// SQLiteBackups manages the backups of the sqlite db kept in a dir. Only the
+// newest backups are retained.
+type SQLiteBackups struct {
+	db     *sql.DB
+	dir    string
+	retain int
+	nowFn  func() time.Time
+
+	// mu serializes the backups, so a scheduled backup and an on-demand
+	// backup do not race for the same retention.
+	mu sync.Mutex
+}
+
+// NewSQLiteBackups creates a manager of the sqlite db backups in the dir. A
+// retain of zero or less retains every backup.
+func NewSQLiteBackups(db *sql.DB, dir string, retain int) *SQLiteBackups {
+	return &SQLiteBackups{
+		db:     db,
+		dir:    dir,
+		retain: retain,
+		nowFn:  time.Now,
+	}
+}
+
+// Backup makes a backup in the dir, and removes the backups beyond
+// the retention.
+func (b *SQLiteBackups) Backup(ctx context.Context) (SQLiteBackup, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := os.MkdirAll(b.dir, 0o700); err != nil {
+		return SQLiteBackup{}, errors.Wrap(err, "failed to create backup dir")
+	}
+
+	name := sqliteBackupPrefix + b.nowFn().UTC().Format(sqliteBackupTimeFormat) + sqliteBackupExt
+	backup, err := BackupSQLite(ctx, b.db, filepath.Join(b.dir, name))
+	if err != nil {
+		return SQLiteBackup{}, err
+	}
+
+	return backup, b.prune(ctx)
+}
+
+// List provides the backups of the dir, newest first.
+func (b *SQLiteBackups) List(ctx context.Context) ([]SQLiteBackup, error) {
+	entries, err := os.ReadDir(b.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	var out []SQLiteBackup
+	for _, e := range entries {
+		if _, ok := parseSQLiteBackupName(e.Name()); !ok || e.IsDir() {
+			continue
+		}
+		backup, err := statSQLiteBackup(ctx, filepath.Join(b.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, backup)
+	}
+	slices.SortFunc(out, func(a, b SQLiteBackup) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return out, nil
+}
+
+// Schedule makes a backup every interval, until the ctx is done. A failed
+// backup is logged and retried at the next interval.
+func (b *SQLiteBackups) Schedule(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		backup, err := b.Backup(ctx)
+		if err != nil {
+			logger.Error("scheduled sqlite backup failed", "err", err.Error(), "dir", b.dir)
+			continue
+		}
+		logger.Info("scheduled sqlite backup complete", "name", backup.Name, "size", backup.Size)
+	}
+}
+
+func (b *SQLiteBackups) prune(ctx context.Context) error {
+	if b.retain <= 0 {
+		return nil
+	}
+
+	backups, err := b.List(ctx)
+	if err != nil || len(backups) <= b.retain {
+		return err
+	}
+
+	var errs []error
+	for _, backup := range backups[b.retain:] {
+		if err := os.Remove(backup.Path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Wrap(errors.Join(errs), "failed to remove backups beyond the retention")
+}
+
+func parseSQLiteBackupName(name string) (time.Time, bool) {
+	ts, ok := strings.CutPrefix(name, sqliteBackupPrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	ts, ok = strings.CutSuffix(ts, sqliteBackupExt)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(sqliteBackupTimeFormat, ts)
+	return t, err == nil
+}
@@ -0,0 +1,213 @@
+package allsrv_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
+)
+
+func TestSQLiteBackup(t *testing.T) {
+	start := time.Time{}.Add(time.Hour).UTC()
+	foo := allsrv.Foo{
+		ID:        "1",
+		Name:      "name-1",
+		Note:      "note-1",
+		CreatedAt: start,
+		UpdatedAt: start,
+	}
+
+	newSrcDB := func(t *testing.T) *sqlx.DB {
+		t.Helper()
+
+		dbx := newSQLiteInmem(t)
+		t.Cleanup(func() { dbx.Close() })
+		allsrvtesting.CreateFoos(foo)(t, allsrv.NewSQLiteDB(dbx))
+		return dbx
+	}
+
+	t.Run("backup should restore into an empty db", func(t *testing.T) {
+		src := newSrcDB(t)
+		path := filepath.Join(t.TempDir(), "backup.db")
+
+		backup, err := allsrv.BackupSQLite(context.TODO(), src.DB, path)
+		require.NoError(t, err)
+
+		latest, err := allsrv.LatestSQLiteSchemaVersion()
+		require.NoError(t, err)
+		assert.Equal(t, latest, backup.SchemaVersion)
+		assert.Equal(t, "backup.db", backup.Name)
+		assert.NotZero(t, backup.Size)
+
+		dst, err := sql.Open("sqlite3", ":memory:")
+		require.NoError(t, err)
+		dst.SetMaxIdleConns(1)
+		t.Cleanup(func() { dst.Close() })
+
+		_, err = allsrv.RestoreSQLite(context.TODO(), dst, path)
+		require.NoError(t, err)
+
+		got, err := allsrv.NewSQLiteDB(sqlx.NewDb(dst, "sqlite3")).ReadFoo(context.TODO(), "1")
+		require.NoError(t, err)
+		assert.Equal(t, foo, got)
+	})
+
+	t.Run("restore should reject invalid backups", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			prepare func(t *testing.T, db *sql.DB)
+			wantErr string
+		}{
+			{
+				name: "with a newer schema version",
+				prepare: func(t *testing.T, db *sql.DB) {
+					_, err := db.Exec("UPDATE schema_migrations SET version = version + 1")
+					require.NoError(t, err)
+				},
+				wantErr: "is newer than the latest supported version",
+			},
+			{
+				name: "with a dirty schema version",
+				prepare: func(t *testing.T, db *sql.DB) {
+					_, err := db.Exec("UPDATE schema_migrations SET dirty = true")
+					require.NoError(t, err)
+				},
+				wantErr: "dirty schema version",
+			},
+			{
+				name: "without a schema version",
+				prepare: func(t *testing.T, db *sql.DB) {
+					_, err := db.Exec("DROP TABLE schema_migrations")
+					require.NoError(t, err)
+				},
+				wantErr: "missing the schema version",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				src := newSrcDB(t)
+				tt.prepare(t, src.DB)
+
+				path := filepath.Join(t.TempDir(), "backup.db")
+				_, err := allsrv.BackupSQLite(context.TODO(), src.DB, path)
+				require.NoError(t, err)
+
+				dst := newSQLiteInmem(t)
+				t.Cleanup(func() { dst.Close() })
+
+				_, err = allsrv.RestoreSQLite(context.TODO(), dst.DB, path)
+				require.Error(t, err)
+				assert.ErrorIs(t, err, allsrv.ErrKindInvalid)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			})
+		}
+
+		t.Run("that is not a sqlite db", func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "backup.db")
+			require.NoError(t, os.WriteFile(path, []byte("not a db"), 0o600))
+
+			_, err := allsrv.ValidateSQLiteBackup(context.TODO(), path)
+			assert.ErrorIs(t, err, allsrv.ErrKindInvalid)
+		})
+
+		t.Run("that does not exist", func(t *testing.T) {
+			_, err := allsrv.ValidateSQLiteBackup(context.TODO(), filepath.Join(t.TempDir(), "missing.db"))
+			assert.ErrorIs(t, err, allsrv.ErrKindNotFound)
+		})
+	})
+
+	t.Run("backups should retain the newest backups", func(t *testing.T) {
+		src := newSrcDB(t)
+		dir := t.TempDir()
+		backups := allsrv.NewSQLiteBackups(src.DB, dir, 2)
+
+		var names []string
+		for range 3 {
+			backup, err := backups.Backup(context.TODO())
+			require.NoError(t, err)
+			names = append(names, backup.Name)
+			time.Sleep(2 * time.Millisecond) // backups are named by the ms
+		}
+
+		got, err := backups.List(context.TODO())
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		assert.Equal(t, names[2], got[0].Name)
+		assert.Equal(t, names[1], got[1].Name)
+	})
+
+	t.Run("backups should be served by the admin routes", func(t *testing.T) {
+		src := newSrcDB(t)
+		svr := allsrv.NewServerV2(
+			allsrv.NewService(allsrv.NewSQLiteDB(src)),
+			allsrv.WithBasicAuthV2("dodgers@stink.com", "PaSsWoRd"),
+			allsrv.WithSQLiteBackups(allsrv.NewSQLiteBackups(src.DB, t.TempDir(), 5)),
+		)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/admin/backups", nil))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		req := httptest.NewRequest("POST", "/v1/admin/backups", nil)
+		req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		var created struct {
+			Data struct {
+				Type  string             `json:"type"`
+				ID    string             `json:"id"`
+				Attrs allsrv.BackupAttrs `json:"attributes"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+		assert.Equal(t, "backup", created.Data.Type)
+		assert.Equal(t, created.Data.ID, created.Data.Attrs.Name)
+		assert.NotZero(t, created.Data.Attrs.SchemaVersion)
+
+		req = httptest.NewRequest("GET", "/v1/admin/backups", nil)
+		req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var listed struct {
+			Data struct {
+				Attrs []allsrv.BackupAttrs `json:"attributes"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&listed))
+		require.Len(t, listed.Data.Attrs, 1)
+		assert.Equal(t, created.Data.Attrs, listed.Data.Attrs[0])
+	})
+	t.Run("backup admin routes should not be registered without auth", func(t *testing.T) {
+		src := newSrcDB(t)
+		dir := t.TempDir()
+		svr := allsrv.NewServerV2(
+			allsrv.NewService(allsrv.NewSQLiteDB(src)),
+			allsrv.WithSQLiteBackups(allsrv.NewSQLiteBackups(src.DB, dir, 5)),
+		)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/admin/backups", nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
@@ -0,0 +1,58 @@
+package allsrv
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jsteenb2/errors"
+)
+
+// metadataReqCond provides the condition on the foos whose metadata meets the
+// requirement. A string is compared by its value, and a number or boolean by
+// its JSON text, matching MetadataReq.Matches.
+func metadataReqCond(req MetadataReq) sq.Sqlizer {
+	path := metadataJSONPath(req.Path)
+	return sq.Or{
+		sq.Expr("(json_type(metadata, ?) = 'text' AND metadata ->> ? = ?)", path, path, req.Value),
+		sq.Expr("(json_type(metadata, ?) IN ('integer', 'real', 'true', 'false') AND metadata -> ? = ?)", path, path, req.Value),
+	}
+}
+
+// metadataJSONPath provides the sqlite JSON path of the metadata path. The keys
+// of a valid path do not need escaping within the quotes.
+func metadataJSONPath(path []string) string {
+	return `$."` + strings.Join(path, `"."`) + `"`
+}
+
+// sqlMetadata is the metadata of a foo, stored as a JSON object. Empty
+// metadata is stored as NULL.
+type sqlMetadata map[string]any
+
+func (m sqlMetadata) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	b, err := marshalMetadata(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (m *sqlMetadata) Scan(src any) error {
+	var b []byte
+	switch src := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case string:
+		b = []byte(src)
+	case []byte:
+		b = src
+	default:
+		return errors.New("metadata must be stored as JSON text")
+	}
+	return json.Unmarshal(b, (*map[string]any)(m))
+}
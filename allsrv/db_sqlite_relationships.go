@@ -0,0 +1,161 @@
+package allsrv
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jsteenb2/errors"
+)
+
+// ReadRelated provides the ids of the live foos the foo relates to by the
+// relationship, in the order they were related.
+func (s *sqlDB) ReadRelated(ctx context.Context, fooID, name string) ([]string, error) {
+	sb := s.sq.
+		Select("r.related_id").
+		From("foo_relationships r").
+		Join("foos f ON f.id = r.related_id").
+		Where(sq.Eq{"r.foo_id": fooID, "r.name": name})
+	ids, err := s.selectRelated(ctx, fooID, sb)
+	return ids, errors.Wrap(err)
+}
+
+// ReadRelatedTo provides the ids of the live foos that relate to the foo by
+// the relationship, in the order they were related.
+func (s *sqlDB) ReadRelatedTo(ctx context.Context, fooID, name string) ([]string, error) {
+	sb := s.sq.
+		Select("r.foo_id").
+		From("foo_relationships r").
+		Join("foos f ON f.id = r.foo_id").
+		Where(sq.Eq{"r.related_id": fooID, "r.name": name})
+	ids, err := s.selectRelated(ctx, fooID, sb)
+	return ids, errors.Wrap(err)
+}
+
+// SetRelated replaces the foos the foo relates to by the relationship. Every
+// related foo must be live.
+func (s *sqlDB) SetRelated(ctx context.Context, fooID, name string, relatedIDs []string) error {
+	if err := s.fooIsLive(ctx, fooID); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return s.tx(ctx, func(exec execFn) error {
+		del := s.sq.
+			Delete("foo_relationships").
+			Where(sq.Eq{"foo_id": fooID, "name": name})
+		if _, err := exec(del); err != nil {
+			return err
+		}
+
+		for _, id := range relatedIDs {
+			liveQuery, liveArgs, err := s.liveFooQuery(id)
+			if err != nil {
+				return err
+			}
+			values := s.sq.
+				Select().
+				Column("?", fooID).
+				Column("?", name).
+				Column("?", id).
+				Where("EXISTS ("+liveQuery+")", liveArgs...)
+			sb := s.sq.
+				Insert("foo_relationships").
+				Columns("foo_id", "name", "related_id").
+				Select(values)
+
+			res, err := exec(sb)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err == nil && n == 0 {
+				return NotFoundErr("foo not found for id: "+id, "id", id)
+			}
+		}
+		return nil
+	})
+}
+
+// relatedToQuery selects the relationships of the live foos, other than the
+// foo itself, that relate to the foo.
+func (s *sqlDB) relatedToQuery(fooID string, now time.Time) sq.SelectBuilder {
+	return s.sq.
+		Select("r.foo_id", "r.name").
+		From("foo_relationships r").
+		Join("foos f ON f.id = r.foo_id").
+		Where(sq.Eq{"r.related_id": fooID}).
+		Where(sq.NotEq{"r.foo_id": fooID}).
+		Where(fooLive(now))
+}
+
+// relatedToErr provides the error of deleting a foo that a live foo relates
+// to. When no live foo relates to the foo, there is no error.
+func (s *sqlDB) relatedToErr(ctx context.Context, fooID string, now time.Time) error {
+	query, args, err := s.relatedToQuery(fooID, now).Limit(1).ToSql()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ent entFooRelationship
+	err = s.db.GetContext(ctx, &ent, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, errSQLiteFields(err))
+	}
+	return errRelatedTo(fooID, ent.FooID, ent.Name)
+}
+
+// selectRelated selects the ids of the related live foos, joined as f, of
+// the live foo. The foo is not found when it is not live.
+func (s *sqlDB) selectRelated(ctx context.Context, fooID string, sb sq.SelectBuilder) ([]string, error) {
+	query, args, err := sb.
+		Where(fooLive(time.Now())).
+		OrderBy("r.rowid").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.fooIsLive(ctx, fooID); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []string
+	if err := s.db.SelectContext(ctx, &ids, query, args...); err != nil {
+		return nil, errors.Wrap(err, errSQLiteFields(err))
+	}
+	return ids, nil
+}
+
+// fooIsLive validates the foo exists and has not expired.
+func (s *sqlDB) fooIsLive(ctx context.Context, fooID string) error {
+	liveQuery, liveArgs, err := s.liveFooQuery(fooID)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var live bool
+	if err := s.db.GetContext(ctx, &live, "SELECT EXISTS ("+liveQuery+")", liveArgs...); err != nil {
+		return errors.Wrap(err, errSQLiteFields(err))
+	}
+	if !live {
+		return NotFoundErr("foo not found for id: "+fooID, "id", fooID)
+	}
+	return nil
+}
+
+type entFooRelationship struct {
+	FooID string `db:"foo_id"`
+	Name  string `db:"name"`
+}
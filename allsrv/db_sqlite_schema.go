@@ -0,0 +1,128 @@
+package allsrv
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jsteenb2/errors"
+
+	"github.com/jsteenb2/mess/allsrv/migrations"
+)
+
+// SQLiteSchema is the status of the schema of the sqlite db, relative to the
+// migrations of migrations.SQLite. A version of zero is a db without any of
+// the migrations applied.
+type SQLiteSchema struct {
+	Version uint `json:"version"`
+	Dirty   bool `json:"dirty"`
+	Latest  uint `json:"latest"`
+}
+
+// OK validates the schema is ready to serve, which requires the latest
+// migration to be applied cleanly. A schema newer than the latest migration
+// is served, as the migrations are expected to be backwards compatible.
+func (s SQLiteSchema) OK() error {
+	if s.Dirty {
+		return UnavailableErr(fmt.Sprintf("sqlite schema version %d is dirty; fix the schema and force the version with allsrv migrate force", s.Version))
+	}
+	if s.Version < s.Latest {
+		return UnavailableErr(fmt.Sprintf("sqlite schema version %d is behind the latest version %d; apply the migrations with allsrv migrate up", s.Version, s.Latest))
+	}
+	return nil
+}
+
+// SQLiteSchemaStatus provides the status of the schema of the sqlite db.
+func SQLiteSchemaStatus(ctx context.Context, db *sql.DB) (SQLiteSchema, error) {
+	latest, err := LatestSQLiteSchemaVersion()
+	if err != nil {
+		return SQLiteSchema{}, err
+	}
+
+	var exists bool
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&exists)
+	if err != nil {
+		return SQLiteSchema{}, errors.Wrap(err, "failed to read sqlite schema version", errSQLiteFields(err))
+	}
+	if !exists {
+		return SQLiteSchema{Latest: latest}, nil
+	}
+
+	version, dirty, err := sqliteSchemaVersion(ctx, db)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return SQLiteSchema{}, errors.Wrap(err, "failed to read sqlite schema version", errSQLiteFields(err))
+	}
+
+	return SQLiteSchema{Version: version, Dirty: dirty, Latest: latest}, nil
+}
+
+// LatestSQLiteSchemaVersion provides the version of the latest migration
+// of migrations.SQLite.
+func LatestSQLiteSchemaVersion() (uint, error) {
+	src, err := iofs.New(migrations.SQLite, "sqlite")
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+		version = next
+	}
+}
+
+// sqliteSchemaVersion reads the schema version recorded by the migrations.
+func sqliteSchemaVersion(ctx context.Context, db *sql.DB) (uint, bool, error) {
+	var (
+		version uint
+		dirty   bool
+	)
+	err := db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	return version, dirty, err
+}
+
+// Readyz is the body of the readiness check.
+type Readyz struct {
+	Status string        `json:"status"`
+	Schema *SQLiteSchema `json:"schema,omitempty"`
+	Err    string        `json:"error,omitempty"`
+}
+
+// ReadyzHandler provides the readiness check of the server. When the sqlite
+// db is provided, the check reports the schema version of the db, and is not
+// ready when the db is unreachable or the schema is not OK. The db may be nil
+// for the in-memory db.
+func ReadyzHandler(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, status := Readyz{Status: "ready"}, http.StatusOK
+		if db != nil {
+			schema, err := SQLiteSchemaStatus(r.Context(), db)
+			if err == nil {
+				out.Schema = &schema
+				err = schema.OK()
+			}
+			if err != nil {
+				out.Status, out.Err, status = "unready", err.Error(), http.StatusServiceUnavailable
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(out)
+	})
+}
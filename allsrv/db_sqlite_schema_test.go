@@ -0,0 +1,115 @@
+package allsrv_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+)
+
+func TestSQLiteSchemaStatus(t *testing.T) {
+	latest, err := allsrv.LatestSQLiteSchemaVersion()
+	require.NoError(t, err)
+
+	t.Run("migrated db should be OK", func(t *testing.T) {
+		db := newSQLiteInmem(t)
+		t.Cleanup(func() { db.Close() })
+
+		schema, err := allsrv.SQLiteSchemaStatus(context.TODO(), db.DB)
+		require.NoError(t, err)
+
+		assert.Equal(t, allsrv.SQLiteSchema{Version: latest, Latest: latest}, schema)
+		assert.NoError(t, schema.OK())
+	})
+
+	t.Run("db without migrations should be behind", func(t *testing.T) {
+		db := newSQLiteUnmigrated(t)
+
+		schema, err := allsrv.SQLiteSchemaStatus(context.TODO(), db)
+		require.NoError(t, err)
+
+		assert.Equal(t, allsrv.SQLiteSchema{Latest: latest}, schema)
+		err = schema.OK()
+		assert.ErrorIs(t, err, allsrv.ErrKindUnavailable)
+		assert.Contains(t, err.Error(), "is behind the latest version")
+	})
+
+	t.Run("dirty db should not be OK", func(t *testing.T) {
+		db := newSQLiteInmem(t)
+		t.Cleanup(func() { db.Close() })
+		_, err := db.Exec("UPDATE schema_migrations SET dirty = true")
+		require.NoError(t, err)
+
+		schema, err := allsrv.SQLiteSchemaStatus(context.TODO(), db.DB)
+		require.NoError(t, err)
+
+		assert.True(t, schema.Dirty)
+		assert.ErrorIs(t, schema.OK(), allsrv.ErrKindUnavailable)
+	})
+}
+
+func TestReadyzHandler(t *testing.T) {
+	type readyzResp struct {
+		Status string               `json:"status"`
+		Schema *allsrv.SQLiteSchema `json:"schema"`
+		Err    string               `json:"error"`
+	}
+
+	readyz := func(t *testing.T, db *sql.DB) (int, readyzResp) {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		allsrv.ReadyzHandler(db).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+		var out readyzResp
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&out))
+		return rec.Code, out
+	}
+
+	t.Run("without a sqlite db should be ready", func(t *testing.T) {
+		code, out := readyz(t, nil)
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ready", out.Status)
+		assert.Nil(t, out.Schema)
+	})
+
+	t.Run("with migrated sqlite db should report the schema version", func(t *testing.T) {
+		db := newSQLiteInmem(t)
+		t.Cleanup(func() { db.Close() })
+
+		code, out := readyz(t, db.DB)
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ready", out.Status)
+		require.NotNil(t, out.Schema)
+		assert.NotZero(t, out.Schema.Version)
+	})
+
+	t.Run("with sqlite db behind should not be ready", func(t *testing.T) {
+		code, out := readyz(t, newSQLiteUnmigrated(t))
+
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "unready", out.Status)
+		require.NotNil(t, out.Schema)
+		assert.Zero(t, out.Schema.Version)
+		assert.NotEmpty(t, out.Err)
+	})
+}
+
+func newSQLiteUnmigrated(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxIdleConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
@@ -1,8 +1,10 @@
 package allsrv_test
 
 import (
+	"context"
 	"database/sql"
 	"testing"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	migsqlite "github.com/golang-migrate/migrate/v4/database/sqlite3"
@@ -13,11 +15,35 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
 	"github.com/jsteenb2/mess/allsrv/migrations"
 )
 
 func TestSQLite(t *testing.T) {
-	testDB(t, newSQLiteDB)
+	testDBSuites(t, newSQLiteDB)
+}
+
+func TestSQLiteReadFooFields(t *testing.T) {
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	db := newSQLiteDB(t)
+	allsrvtesting.CreateFoos(allsrv.Foo{
+		ID:        "1",
+		Name:      "name-1",
+		Note:      "note-1",
+		CreatedAt: start,
+		UpdatedAt: start.Add(time.Hour),
+	})(t, db)
+
+	got, err := db.ReadFoo(context.TODO(), "1", allsrv.WithReadFields("name", "updated_at"))
+	require.NoError(t, err)
+
+	want := allsrv.Foo{
+		ID:        "1",
+		Name:      "name-1",
+		UpdatedAt: start.Add(time.Hour),
+	}
+	assert.Equal(t, want, got)
 }
 
 func newSQLiteDB(t *testing.T) allsrv.DB {
@@ -10,6 +10,8 @@ const (
 	ErrKindNotFound = errors.Kind("not found")
 	ErrKindUnAuthed = errors.Kind("unauthorized")
 	ErrKindInternal = errors.Kind("internal")
+
+	ErrKindUnavailable = errors.Kind("unavailable")
 )
 
 const (
@@ -18,6 +20,8 @@ const (
 	errCodeNotFound = 3
 	errCodeUnAuthed = 4
 	errCodeInternal = 5
+
+	errCodeUnavailable = 6
 )
 
 func errCode(kind error) int {
@@ -32,11 +36,16 @@ func errCode(kind error) int {
 		return errCodeUnAuthed
 	case errors.Is(kind, ErrKindInternal):
 		return errCodeInternal
+	case errors.Is(kind, ErrKindUnavailable):
+		return errCodeUnavailable
 	default:
 		return errCode(ErrKindInternal)
 	}
 }
 
+// errKinds are the kinds of the errors of the foo svc.
+var errKinds = []errors.Kind{ErrKindExists, ErrKindInvalid, ErrKindNotFound, ErrKindUnAuthed, ErrKindInternal, ErrKindUnavailable}
+
 var (
 	errIDRequired = InvalidErr("id is required")
 )
@@ -62,3 +71,8 @@ func NotFoundErr(msg string, fields ...any) error {
 func unauthedErr(msg string, fields ...any) error {
 	return errors.New(msg, errors.KVs(fields...), ErrKindUnAuthed, errors.SkipCaller)
 }
+
+// UnavailableErr creates an unavailable error.
+func UnavailableErr(msg string, fields ...any) error {
+	return errors.New(msg, errors.KVs(fields...), ErrKindUnavailable, errors.SkipCaller)
+}
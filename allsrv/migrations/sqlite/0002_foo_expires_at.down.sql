@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS foos_expires_at;
+
+ALTER TABLE foos DROP COLUMN expires_at;
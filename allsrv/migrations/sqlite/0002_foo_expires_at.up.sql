@@ -0,0 +1,3 @@
+ALTER TABLE foos ADD COLUMN expires_at timestamp;
+
+CREATE INDEX foos_expires_at ON foos (expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS foos_delete_labels;
+
+DROP TABLE IF EXISTS foo_labels;
@@ -0,0 +1,15 @@
+CREATE TABLE foo_labels
+(
+    foo_id TEXT NOT NULL REFERENCES foos (id) ON DELETE CASCADE,
+    key    TEXT NOT NULL,
+    value  TEXT NOT NULL,
+    PRIMARY KEY (foo_id, key)
+);
+
+CREATE INDEX foo_labels_key_value ON foo_labels (key, value);
+
+-- deletes the labels with their foo, even when foreign keys are not enforced
+CREATE TRIGGER foos_delete_labels AFTER DELETE ON foos
+BEGIN
+    DELETE FROM foo_labels WHERE foo_id = OLD.id;
+END;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS foos_delete_attachments;
+
+DROP TABLE IF EXISTS foo_attachments;
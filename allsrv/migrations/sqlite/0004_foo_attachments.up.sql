@@ -0,0 +1,19 @@
+CREATE TABLE foo_attachments
+(
+    id           TEXT PRIMARY KEY,
+    foo_id       TEXT      NOT NULL REFERENCES foos (id) ON DELETE CASCADE,
+    name         TEXT      NOT NULL,
+    content_type TEXT      NOT NULL,
+    size         INTEGER   NOT NULL,
+    digest       TEXT      NOT NULL,
+    created_at   timestamp NOT NULL,
+    UNIQUE (foo_id, name)
+);
+
+CREATE INDEX foo_attachments_digest ON foo_attachments (digest);
+
+-- deletes the attachments with their foo, even when foreign keys are not enforced
+CREATE TRIGGER foos_delete_attachments AFTER DELETE ON foos
+BEGIN
+    DELETE FROM foo_attachments WHERE foo_id = OLD.id;
+END;
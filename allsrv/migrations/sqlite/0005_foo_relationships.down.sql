@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS foos_delete_relationships;
+
+DROP TABLE IF EXISTS foo_relationships;
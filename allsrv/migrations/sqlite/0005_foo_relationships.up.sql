@@ -0,0 +1,17 @@
+CREATE TABLE foo_relationships
+(
+    foo_id     TEXT NOT NULL REFERENCES foos (id) ON DELETE CASCADE,
+    name       TEXT NOT NULL,
+    related_id TEXT NOT NULL REFERENCES foos (id) ON DELETE CASCADE,
+    PRIMARY KEY (foo_id, name, related_id)
+);
+
+CREATE INDEX foo_relationships_related ON foo_relationships (related_id, name);
+
+-- deletes the relationships from and to a foo with the foo, even when foreign
+-- keys are not enforced. A live foo that is related to is not deleted by DelFoo,
+-- so only the relationships of reaped foos are cascaded.
+CREATE TRIGGER foos_delete_relationships AFTER DELETE ON foos
+BEGIN
+    DELETE FROM foo_relationships WHERE foo_id = OLD.id OR related_id = OLD.id;
+END;
@@ -0,0 +1 @@
+ALTER TABLE foos DROP COLUMN metadata;
@@ -0,0 +1 @@
+ALTER TABLE foos ADD COLUMN metadata text CHECK (metadata IS NULL OR json_valid(metadata));
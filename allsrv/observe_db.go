@@ -37,12 +37,12 @@ func (d *dbMW) CreateFoo(ctx context.Context, f Foo) error {
 	return rec(d.next.CreateFoo(ctx, f))
 }
 
-func (d *dbMW) ReadFoo(ctx context.Context, id string) (Foo, error) {
+func (d *dbMW) ReadFoo(ctx context.Context, id string, opts ...ReadOptFn) (Foo, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "db_"+d.name+"_foo_read")
 	defer span.Finish()
 
 	rec := d.record("read")
-	f, err := d.next.ReadFoo(ctx, id)
+	f, err := d.next.ReadFoo(ctx, id, opts...)
 	return f, rec(err)
 }
 
@@ -62,6 +62,15 @@ func (d *dbMW) DelFoo(ctx context.Context, id string) error {
 	return rec(d.next.DelFoo(ctx, id))
 }
 
+func (d *dbMW) ListFoos(ctx context.Context, sel LabelSelector, opts ...ListOptFn) ([]Foo, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "db_"+d.name+"_foo_list")
+	defer span.Finish()
+
+	rec := d.record("list")
+	foos, err := d.next.ListFoos(ctx, sel, opts...)
+	return foos, rec(err)
+}
+
 func (d *dbMW) record(op string) func(error) error {
 	start := time.Now()
 	name := []string{metricsPrefix, d.name, op}
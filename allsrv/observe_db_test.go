@@ -0,0 +1,21 @@
+package allsrv_test
+
+import (
+	"testing"
+
+	"github.com/jsteenb2/mess/allsrv"
+)
+
+func TestObserveDB(t *testing.T) {
+	t.Run("inmem", func(t *testing.T) {
+		testDBSuites(t, func(t *testing.T) allsrv.DB {
+			return allsrv.ObserveDB("inmem", newTestMetrics(t))(new(allsrv.InmemDB))
+		})
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		testDBSuites(t, func(t *testing.T) allsrv.DB {
+			return allsrv.ObserveDB("sqlite", newTestMetrics(t))(newSQLiteDB(t))
+		})
+	})
+}
@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/gofrs/uuid"
 	"github.com/hashicorp/go-metrics"
 )
 
@@ -50,9 +49,20 @@ import (
 */
 
 type serverOpts struct {
-	authFn func(http.Handler) http.Handler
-	idFn   func() string
-	nowFn  func() time.Time
+	accessLog func(http.Handler) http.Handler
+	authFn    func(http.Handler) http.Handler
+	codecs    []Codec
+	nowFn     func() time.Time
+
+	deprecations          map[string]DeprecationPolicy
+	deprecationUsageLimit int
+
+	backups       *SQLiteBackups
+	attachments   *Attachments
+	relationships *Relationships
+	chaos         *Chaos
+	traffic       *TrafficRecorder
+	shadow        *Shadow
 
 	met *metrics.Metrics
 	mux *http.ServeMux
@@ -66,22 +76,21 @@ func WithBasicAuth(user, pass string) func(*serverOpts) {
 	}
 }
 
-// WithIDFn sets the id generation fn for the server.
-func WithIDFn(fn func() string) func(*serverOpts) {
-	return func(s *serverOpts) {
-		s.idFn = fn
-	}
-}
-
+// Server is the legacy foo server. It is backed by the same SVC as the
+// ServerV2, so that legacy callers receive the same business rules and
+// observability, while retaining the legacy API responses.
 type Server struct {
-	db  DB             // 1)
+	svc SVC            // 1)
 	mux *http.ServeMux // 4)
 
 	authFn func(http.Handler) http.Handler // 3)
-	idFn   func() string                   // 11)
+
+	deprecations *deprecations
+	traffic      *TrafficRecorder
+	shadow       *Shadow
 }
 
-func NewServer(db DB, opts ...func(*serverOpts)) *Server {
+func NewServer(svc SVC, opts ...SvrOptFn) *Server {
 	opt := serverOpts{
 		authFn: func(next http.Handler) http.Handler { // 3)
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -89,21 +98,22 @@ func NewServer(db DB, opts ...func(*serverOpts)) *Server {
 				next.ServeHTTP(w, r)
 			})
 		},
-		idFn: func() string {
-			// defaults to using a uuid
-			return uuid.Must(uuid.NewV4()).String()
-		},
-		mux: http.NewServeMux(),
+		mux:   http.NewServeMux(),
+		nowFn: time.Now,
+
+		deprecationUsageLimit: DefaultDeprecationUsageLimit,
 	}
 	for _, o := range opts {
 		o(&opt)
 	}
 
 	s := Server{
-		db:     db,
-		mux:    opt.mux, // 4)
-		authFn: opt.authFn,
-		idFn:   opt.idFn,
+		svc:          svc,
+		mux:          opt.mux, // 4)
+		authFn:       opt.authFn,
+		deprecations: newDeprecations(opt.deprecations, opt.deprecationUsageLimit, opt.nowFn),
+		traffic:      opt.traffic,
+		shadow:       opt.shadow,
 	}
 
 	s.routes()
@@ -111,13 +121,37 @@ func NewServer(db DB, opts ...func(*serverOpts)) *Server {
 }
 
 func (s *Server) routes() {
-	mw := applyMW(s.authFn, deprecationHeaders) // 2)
+	mw := applyMW(s.record, withOriginUserAgent, s.authFn) // 2)
 
 	// 4) 7) 9) 10)
-	s.mux.Handle("POST /foo", mw(http.HandlerFunc(s.createFoo)))
-	s.mux.Handle("GET /foo", mw(http.HandlerFunc(s.readFoo)))
-	s.mux.Handle("PUT /foo", mw(http.HandlerFunc(s.updateFoo)))
-	s.mux.Handle("DELETE /foo", mw(http.HandlerFunc(s.delFoo)))
+	s.handleDeprecated("POST /foo", http.HandlerFunc(s.createFoo))
+	s.handleDeprecated("GET /foo", http.HandlerFunc(s.readFoo))
+	s.handleDeprecated("PUT /foo", http.HandlerFunc(s.updateFoo))
+	s.handleDeprecated("DELETE /foo", http.HandlerFunc(s.delFoo))
+
+	s.mux.Handle("GET /deprecations/usage", mw(http.HandlerFunc(s.deprecations.serveReport)))
+}
+
+func (s *Server) handleDeprecated(pattern string, h http.Handler) {
+	mw := applyMW(withRoute(pattern), s.record, withOriginUserAgent, s.authFn, s.deprecations.enforce(pattern), s.shadowed) // 2)
+	s.mux.Handle(pattern, mw(h))
+}
+
+// record records the traffic of the handler when the server has a traffic
+// recorder.
+func (s *Server) record(next http.Handler) http.Handler {
+	if s.traffic == nil {
+		return next
+	}
+	return s.traffic.Record(next)
+}
+
+// shadowed shadows the requests of the handler when the server has a shadow.
+func (s *Server) shadowed(next http.Handler) http.Handler {
+	if s.shadow == nil {
+		return next
+	}
+	return s.shadow.Compare(next)
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -139,12 +173,11 @@ func (s *Server) createFoo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newFoo := Foo{
-		ID:   s.idFn(), // 11)
+	newFoo, err := s.svc.CreateFoo(r.Context(), Foo{
 		Name: f.Name,
 		Note: f.Note,
-	}
-	if err := s.db.CreateFoo(r.Context(), newFoo); err != nil {
+	})
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError) // 9)
 		return
 	}
@@ -156,7 +189,7 @@ func (s *Server) createFoo(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) readFoo(w http.ResponseWriter, r *http.Request) {
-	f, err := s.db.ReadFoo(r.Context(), r.URL.Query().Get("id"))
+	f, err := s.svc.ReadFoo(r.Context(), r.URL.Query().Get("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound) // 9)
 		return
@@ -179,19 +212,19 @@ func (s *Server) updateFoo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updateFoo := Foo{
+	updateFoo := FooUpd{
 		ID:   f.ID,
-		Name: f.Name,
-		Note: f.Note,
+		Name: &f.Name,
+		Note: &f.Note,
 	}
-	if err := s.db.UpdateFoo(r.Context(), updateFoo); err != nil {
+	if _, err := s.svc.UpdateFoo(r.Context(), updateFoo); err != nil {
 		w.WriteHeader(http.StatusInternalServerError) // 9)
 		return
 	}
 }
 
 func (s *Server) delFoo(w http.ResponseWriter, r *http.Request) {
-	if err := s.db.DelFoo(r.Context(), r.URL.Query().Get("id")); err != nil {
+	if err := s.svc.DelFoo(r.Context(), r.URL.Query().Get("id")); err != nil {
 		w.WriteHeader(http.StatusNotFound) // 9)
 		return
 	}
@@ -210,10 +243,3 @@ func basicAuth(expectedUser, expectedPass string) func(http.Handler) http.Handle
 		})
 	}
 }
-
-func deprecationHeaders(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Deprecation", "Fri, 26 July 2024 23:59:59 GMT")
-		next.ServeHTTP(w, r)
-	})
-}
@@ -0,0 +1,228 @@
+package allsrv
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DeprecationPolicy declares the deprecation of a route. The Deprecation, Sunset,
+// and Link headers inform callers of the deprecation. Once the sunset has passed,
+// all calls to the route are rejected with a 410 Gone.
+type DeprecationPolicy struct {
+	// Deprecated is when the route was deprecated.
+	Deprecated time.Time
+	// Sunset is when the route will stop responding. Optional.
+	Sunset time.Time
+	// Link provides documentation about the deprecation. Optional.
+	Link string
+	// Successor provides the route replacing the deprecated route. Optional.
+	Successor string
+	// Brownouts are windows of time before the sunset in which a percentage
+	// of calls are rejected, to shake out callers ahead of the sunset.
+	Brownouts []Brownout
+}
+
+// Brownout is a window of time where a percentage of calls to a deprecated
+// route are rejected with a 410 Gone.
+type Brownout struct {
+	Start   time.Time
+	End     time.Time
+	Percent int
+}
+
+// DefaultDeprecationPolicy is the policy applied to legacy routes without
+// a policy of their own.
+var DefaultDeprecationPolicy = DeprecationPolicy{
+	Deprecated: time.Date(2024, time.July, 26, 23, 59, 59, 0, time.UTC),
+}
+
+// WithDeprecationPolicy sets the deprecation policy of a legacy route. The
+// route is the pattern the route is registered with (i.e. "GET /foo").
+func WithDeprecationPolicy(route string, policy DeprecationPolicy) func(*serverOpts) {
+	return func(s *serverOpts) {
+		if s.deprecations == nil {
+			s.deprecations = make(map[string]DeprecationPolicy)
+		}
+		s.deprecations[route] = policy
+	}
+}
+
+// DefaultDeprecationUsageLimit is the default number of callers the usage of
+// the deprecated routes is tracked for.
+const DefaultDeprecationUsageLimit = 1000
+
+// deprecationUsageOther is the origin and user agent of the callers past the
+// usage limit.
+const deprecationUsageOther = "other"
+
+// WithDeprecationUsageLimit sets the number of callers the usage of the
+// deprecated routes is tracked for. The usage of the callers past the limit
+// is folded into the "other" caller of the route, as the callers are named by
+// the Origin and User-Agent headers of the request.
+func WithDeprecationUsageLimit(limit int) func(*serverOpts) {
+	return func(s *serverOpts) {
+		s.deprecationUsageLimit = limit
+	}
+}
+
+// DeprecatedRouteUsage is the usage of a deprecated route by a single caller.
+type DeprecatedRouteUsage struct {
+	Route      string    `json:"route"`
+	Origin     string    `json:"origin"`
+	UserAgent  string    `json:"user_agent"`
+	Calls      int       `json:"calls"`
+	BrownedOut int       `json:"browned_out"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// DeprecationUsageReport is the usage of the deprecated routes. The usage
+// is ordered by route, with the most frequent callers of a route first. The
+// callers past the usage limit are reported as the "other" caller of a route.
+type DeprecationUsageReport struct {
+	Usage []DeprecatedRouteUsage `json:"usage"`
+}
+
+type deprecations struct {
+	policies map[string]DeprecationPolicy
+	nowFn    func() time.Time
+	randFn   func() float64
+
+	mu         sync.Mutex
+	usage      map[deprecationUsageKey]*DeprecatedRouteUsage
+	usageLimit int
+}
+
+type deprecationUsageKey struct {
+	route, origin, userAgent string
+}
+
+func newDeprecations(policies map[string]DeprecationPolicy, usageLimit int, nowFn func() time.Time) *deprecations {
+	return &deprecations{
+		policies:   policies,
+		nowFn:      nowFn,
+		randFn:     rand.Float64,
+		usage:      make(map[deprecationUsageKey]*DeprecatedRouteUsage),
+		usageLimit: usageLimit,
+	}
+}
+
+func (d *deprecations) policy(route string) DeprecationPolicy {
+	if p, ok := d.policies[route]; ok {
+		return p
+	}
+	return DefaultDeprecationPolicy
+}
+
+// enforce sets the deprecation headers, records the caller's usage, and rejects
+// calls that fall within a brownout or after the sunset of the route.
+func (d *deprecations) enforce(route string) func(http.Handler) http.Handler {
+	policy := d.policy(route)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			now := d.nowFn()
+
+			if !policy.Deprecated.IsZero() {
+				w.Header().Set("Deprecation", policy.Deprecated.UTC().Format(http.TimeFormat))
+			}
+			if !policy.Sunset.IsZero() {
+				w.Header().Set("Sunset", policy.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if policy.Link != "" {
+				w.Header().Add("Link", "<"+policy.Link+`>; rel="deprecation"`)
+			}
+			if policy.Successor != "" {
+				w.Header().Add("Link", "<"+policy.Successor+`>; rel="successor-version"`)
+			}
+
+			gone := !policy.Sunset.IsZero() && !now.Before(policy.Sunset)
+			if !gone {
+				gone = d.brownedOut(policy, now)
+			}
+			d.record(r, route, now, gone)
+
+			if gone {
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (d *deprecations) brownedOut(policy DeprecationPolicy, now time.Time) bool {
+	for _, b := range policy.Brownouts {
+		if now.Before(b.Start) || !now.Before(b.End) {
+			continue
+		}
+		return d.randFn()*100 < float64(b.Percent)
+	}
+	return false
+}
+
+func (d *deprecations) record(r *http.Request, route string, now time.Time, brownedOut bool) {
+	ctx := r.Context()
+	key := deprecationUsageKey{
+		route:     route,
+		origin:    getOrigin(ctx),
+		userAgent: getUserAgent(ctx),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	u, ok := d.usage[key]
+	if !ok && len(d.usage) >= d.usageLimit {
+		// the callers are named by the request, so the callers tracked are
+		// limited to keep a caller rotating its headers from growing the usage
+		key.origin, key.userAgent = deprecationUsageOther, deprecationUsageOther
+		u, ok = d.usage[key]
+	}
+	if !ok {
+		u = &DeprecatedRouteUsage{
+			Route:     key.route,
+			Origin:    key.origin,
+			UserAgent: key.userAgent,
+			FirstSeen: now,
+		}
+		d.usage[key] = u
+	}
+	u.Calls++
+	if brownedOut {
+		u.BrownedOut++
+	}
+	u.LastSeen = now
+}
+
+func (d *deprecations) report() DeprecationUsageReport {
+	d.mu.Lock()
+	out := make([]DeprecatedRouteUsage, 0, len(d.usage))
+	for _, u := range d.usage {
+		out = append(out, *u)
+	}
+	d.mu.Unlock()
+
+	slices.SortFunc(out, func(a, b DeprecatedRouteUsage) int {
+		return cmp.Or(
+			cmp.Compare(a.Route, b.Route),
+			cmp.Compare(b.Calls, a.Calls),
+			cmp.Compare(a.Origin, b.Origin),
+			cmp.Compare(a.UserAgent, b.UserAgent),
+		)
+	})
+
+	return DeprecationUsageReport{Usage: out}
+}
+
+func (d *deprecations) serveReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d.report()); err != nil {
+		log.Printf("unexpected error writing json value to response body: %v", err)
+	}
+}
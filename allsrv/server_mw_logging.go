@@ -0,0 +1,92 @@
+package allsrv
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// WithAccessLog sets the access logger for the server. Requests resulting
+// in an error status are always logged. Successful requests are logged at
+// the provided sample rate, where 0 logs none and 1 logs every request.
+func WithAccessLog(logger *slog.Logger, successSampleRate float64) SvrOptFn {
+	return func(o *serverOpts) {
+		o.accessLog = AccessLog(logger, successSampleRate)
+	}
+}
+
+// AccessLog provides structured logging of the http exchange.
+func AccessLog(logger *slog.Logger, successSampleRate float64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return &accessLogMW{
+			logger: logger,
+			next:   next,
+			sample: func() bool {
+				return rand.Float64() < successSampleRate
+			},
+		}
+	}
+}
+
+type accessLogMW struct {
+	logger *slog.Logger
+	next   http.Handler
+	sample func() bool
+}
+
+func (a *accessLogMW) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	reqBody := &readRec{ReadCloser: r.Body}
+	r.Body = reqBody
+
+	rec := &responseWriterRec{ResponseWriter: w}
+
+	a.next.ServeHTTP(rec, r)
+
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+	if rec.code < http.StatusBadRequest && !a.sample() {
+		return
+	}
+
+	ctx := r.Context()
+	logger := a.logger.With(
+		"method", r.Method,
+		"route", getRoute(ctx),
+		"url_path", r.URL.Path,
+		"status", rec.code,
+		"request_body_size", reqBody.size,
+		"response_body_size", rec.size,
+		"took_ms", time.Since(start).Milliseconds(),
+		"trace_id", getTraceID(ctx),
+		"origin", getOrigin(ctx),
+		"user_agent", getUserAgent(ctx),
+	)
+
+	switch {
+	case rec.code >= http.StatusInternalServerError:
+		logger.ErrorContext(ctx, "http request failed")
+	case rec.code >= http.StatusBadRequest:
+		logger.WarnContext(ctx, "http request rejected")
+	default:
+		logger.InfoContext(ctx, "http request completed")
+	}
+}
+
+func withRoute(pattern string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), ctxKeyRoute, pattern)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func getRoute(ctx context.Context) string {
+	route, _ := ctx.Value(ctxKeyRoute).(string)
+	return route
+}
@@ -7,7 +7,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-metrics"
 	"github.com/stretchr/testify/assert"
@@ -21,12 +23,10 @@ func TestServer(t *testing.T) {
 		t.Run("when provided a valid foo should pass", func(t *testing.T) {
 			met := newTestMetrics(t)
 			db := allsrv.ObserveDB("inmem", met)(new(allsrv.InmemDB))
-			var svr http.Handler = allsrv.NewServer(db,
-				allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"),
-				allsrv.WithIDFn(func() string {
-					return "id1"
-				}),
-			)
+			svc := allsrv.NewService(db, allsrv.WithSVCIDFn(func() string {
+				return "id1"
+			}))
+			var svr http.Handler = allsrv.NewServer(svc, allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
 			svr = allsrv.ObserveHandler("allsrv", met)(svr)
 
 			req := httptest.NewRequest("POST", "/foo", newJSONBody(t, allsrv.FooV0{
@@ -49,8 +49,25 @@ func TestServer(t *testing.T) {
 			})
 		})
 
+		t.Run("when provided a foo violating the service rules should fail", func(t *testing.T) {
+			db := new(allsrv.InmemDB)
+			svr := allsrv.NewServer(allsrv.NewService(db), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
+
+			req := httptest.NewRequest("POST", "/foo", newJSONBody(t, allsrv.FooV0{
+				Name: "",
+				Note: "some note",
+			}))
+			req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+			rec := httptest.NewRecorder()
+
+			svr.ServeHTTP(rec, req)
+
+			// legacy API maps all service errors on create to a 500
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		})
+
 		t.Run("when provided invalid basic auth should fail", func(t *testing.T) {
-			svr := allsrv.NewServer(new(allsrv.InmemDB), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
+			svr := allsrv.NewServer(allsrv.NewService(new(allsrv.InmemDB)), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
 
 			req := httptest.NewRequest("POST", "/foo", newJSONBody(t, allsrv.FooV0{
 				Name: "first-foo",
@@ -76,7 +93,7 @@ func TestServer(t *testing.T) {
 			})
 			require.NoError(t, err)
 
-			var svr http.Handler = allsrv.NewServer(db, allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
+			var svr http.Handler = allsrv.NewServer(allsrv.NewService(db), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
 			svr = allsrv.ObserveHandler("allsrv", met)(svr)
 
 			req := httptest.NewRequest("GET", "/foo?id=reader1", nil)
@@ -97,7 +114,7 @@ func TestServer(t *testing.T) {
 		})
 
 		t.Run("when provided invalid basic auth should fail", func(t *testing.T) {
-			svr := allsrv.NewServer(new(allsrv.InmemDB), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
+			svr := allsrv.NewServer(allsrv.NewService(new(allsrv.InmemDB)), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
 
 			req := httptest.NewRequest("GET", "/foo?id=reader1", nil)
 			req.SetBasicAuth("dodgers@rule.com", "wrongO")
@@ -120,7 +137,7 @@ func TestServer(t *testing.T) {
 			})
 			require.NoError(t, err)
 
-			var svr http.Handler = allsrv.NewServer(db, allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
+			var svr http.Handler = allsrv.NewServer(allsrv.NewService(db), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
 			svr = allsrv.ObserveHandler("allsrv", met)(svr)
 
 			req := httptest.NewRequest("PUT", "/foo", newJSONBody(t, allsrv.FooV0{
@@ -146,7 +163,7 @@ func TestServer(t *testing.T) {
 			})
 			require.NoError(t, err)
 
-			svr := allsrv.NewServer(db, allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
+			svr := allsrv.NewServer(allsrv.NewService(db), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
 
 			req := httptest.NewRequest("PUT", "/foo", newJSONBody(t, allsrv.FooV0{
 				ID:   "id1",
@@ -173,7 +190,7 @@ func TestServer(t *testing.T) {
 			})
 			require.NoError(t, err)
 
-			var svr http.Handler = allsrv.NewServer(db, allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
+			var svr http.Handler = allsrv.NewServer(allsrv.NewService(db), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
 			svr = allsrv.ObserveHandler("allsrv", met)(svr)
 
 			req := httptest.NewRequest("DELETE", "/foo?id=id1", nil)
@@ -186,7 +203,7 @@ func TestServer(t *testing.T) {
 		})
 
 		t.Run("when provided invalid basic auth should fail", func(t *testing.T) {
-			svr := allsrv.NewServer(new(allsrv.InmemDB), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
+			svr := allsrv.NewServer(allsrv.NewService(new(allsrv.InmemDB)), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
 
 			req := httptest.NewRequest("DELETE", "/foo?id=id1", nil)
 			req.SetBasicAuth("dodgers@rule.com", "wrongO")
@@ -197,6 +214,197 @@ func TestServer(t *testing.T) {
 			assert.Equal(t, http.StatusUnauthorized, rec.Code)
 		})
 	})
+
+	t.Run("deprecations", func(t *testing.T) {
+		t.Run("when a route has no policy should provide the default deprecation header", func(t *testing.T) {
+			svr := allsrv.NewServer(allsrv.NewService(new(allsrv.InmemDB)))
+
+			req := httptest.NewRequest("GET", "/foo?id=id1", nil)
+			rec := httptest.NewRecorder()
+
+			svr.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Equal(t, "Fri, 26 Jul 2024 23:59:59 GMT", rec.Header().Get("Deprecation"))
+			assert.Empty(t, rec.Header().Get("Sunset"))
+		})
+
+		t.Run("when a route has a policy should provide the deprecation headers", func(t *testing.T) {
+			svr := allsrv.NewServer(
+				allsrv.NewService(new(allsrv.InmemDB)),
+				allsrv.WithDeprecationPolicy("GET /foo", allsrv.DeprecationPolicy{
+					Deprecated: time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC),
+					Sunset:     time.Now().Add(24 * time.Hour),
+					Link:       "https://example.com/deprecations/v1",
+					Successor:  "/v1/foos/{id}",
+				}),
+			)
+
+			req := httptest.NewRequest("GET", "/foo?id=id1", nil)
+			rec := httptest.NewRecorder()
+
+			svr.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Equal(t, "Mon, 01 Jul 2024 00:00:00 GMT", rec.Header().Get("Deprecation"))
+			assert.NotEmpty(t, rec.Header().Get("Sunset"))
+			assert.Equal(t, []string{
+				`<https://example.com/deprecations/v1>; rel="deprecation"`,
+				`</v1/foos/{id}>; rel="successor-version"`,
+			}, rec.Header().Values("Link"))
+		})
+
+		t.Run("when the sunset has passed should fail with gone", func(t *testing.T) {
+			svr := allsrv.NewServer(
+				allsrv.NewService(new(allsrv.InmemDB)),
+				allsrv.WithDeprecationPolicy("GET /foo", allsrv.DeprecationPolicy{
+					Deprecated: time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC),
+					Sunset:     time.Now().Add(-time.Hour),
+				}),
+			)
+
+			req := httptest.NewRequest("GET", "/foo?id=id1", nil)
+			rec := httptest.NewRecorder()
+
+			svr.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusGone, rec.Code)
+		})
+
+		t.Run("when within a brownout should fail the browned out percentage of calls with gone", func(t *testing.T) {
+			now := time.Now()
+			svr := allsrv.NewServer(
+				allsrv.NewService(new(allsrv.InmemDB)),
+				allsrv.WithDeprecationPolicy("GET /foo", allsrv.DeprecationPolicy{
+					Deprecated: time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC),
+					Brownouts: []allsrv.Brownout{
+						{Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour), Percent: 0},
+						{Start: now.Add(-time.Hour), End: now.Add(time.Hour), Percent: 100},
+					},
+				}),
+				allsrv.WithDeprecationPolicy("DELETE /foo", allsrv.DeprecationPolicy{
+					Deprecated: time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC),
+					Brownouts: []allsrv.Brownout{
+						{Start: now.Add(-time.Hour), End: now.Add(time.Hour), Percent: 0},
+					},
+				}),
+			)
+
+			req := httptest.NewRequest("GET", "/foo?id=id1", nil)
+			rec := httptest.NewRecorder()
+			svr.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusGone, rec.Code)
+
+			req = httptest.NewRequest("DELETE", "/foo?id=id1", nil)
+			rec = httptest.NewRecorder()
+			svr.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		})
+
+		t.Run("should report the usage of deprecated routes by caller", func(t *testing.T) {
+			svr := allsrv.NewServer(
+				allsrv.NewService(new(allsrv.InmemDB)),
+				allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"),
+				allsrv.WithDeprecationPolicy("DELETE /foo", allsrv.DeprecationPolicy{
+					Sunset: time.Now().Add(-time.Hour),
+				}),
+			)
+
+			call := func(method, origin, userAgent string) {
+				t.Helper()
+
+				req := httptest.NewRequest(method, "/foo?id=id1", nil)
+				req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+				req.Header.Set("Origin", origin)
+				req.Header.Set("User-Agent", userAgent)
+				svr.ServeHTTP(httptest.NewRecorder(), req)
+			}
+			call("GET", "allsrvc", "allsrvc (github.com/jsteenb2/allsrvc)")
+			call("GET", "allsrvc", "allsrvc (github.com/jsteenb2/allsrvc)")
+			call("GET", "legacy", "curl/8.4.0")
+			call("DELETE", "legacy", "curl/8.4.0")
+
+			req := httptest.NewRequest("GET", "/deprecations/usage", nil)
+			req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+			rec := httptest.NewRecorder()
+
+			svr.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			expectJSONBody(t, rec.Body, func(t *testing.T, got allsrv.DeprecationUsageReport) {
+				require.Len(t, got.Usage, 3)
+
+				type caller struct {
+					route, origin, userAgent string
+					calls, brownedOut        int
+				}
+				var callers []caller
+				for _, u := range got.Usage {
+					assert.False(t, u.FirstSeen.IsZero())
+					assert.False(t, u.LastSeen.Before(u.FirstSeen))
+					callers = append(callers, caller{
+						route:      u.Route,
+						origin:     u.Origin,
+						userAgent:  u.UserAgent,
+						calls:      u.Calls,
+						brownedOut: u.BrownedOut,
+					})
+				}
+
+				want := []caller{
+					{route: "DELETE /foo", origin: "legacy", userAgent: "curl/8.4.0", calls: 1, brownedOut: 1},
+					{route: "GET /foo", origin: "allsrvc", userAgent: "allsrvc (github.com/jsteenb2/allsrvc)", calls: 2},
+					{route: "GET /foo", origin: "legacy", userAgent: "curl/8.4.0", calls: 1},
+				}
+				assert.Equal(t, want, callers)
+			})
+		})
+
+		t.Run("callers past the usage limit should be reported as other", func(t *testing.T) {
+			svr := allsrv.NewServer(
+				allsrv.NewService(new(allsrv.InmemDB)),
+				allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"),
+				allsrv.WithDeprecationUsageLimit(2),
+			)
+
+			for i := range 5 {
+				req := httptest.NewRequest("GET", "/foo?id=id1", nil)
+				req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+				req.Header.Set("User-Agent", "rotating/"+strconv.Itoa(i))
+				svr.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			req := httptest.NewRequest("GET", "/deprecations/usage", nil)
+			req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+			rec := httptest.NewRecorder()
+
+			svr.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			expectJSONBody(t, rec.Body, func(t *testing.T, got allsrv.DeprecationUsageReport) {
+				require.Len(t, got.Usage, 3)
+
+				other := got.Usage[0]
+				assert.Equal(t, "other", other.Origin)
+				assert.Equal(t, "other", other.UserAgent)
+				assert.Equal(t, 3, other.Calls)
+				assert.Equal(t, "rotating/0", got.Usage[1].UserAgent)
+				assert.Equal(t, "rotating/1", got.Usage[2].UserAgent)
+			})
+		})
+
+		t.Run("when provided invalid basic auth should not report usage", func(t *testing.T) {
+			svr := allsrv.NewServer(allsrv.NewService(new(allsrv.InmemDB)), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"))
+
+			req := httptest.NewRequest("GET", "/deprecations/usage", nil)
+			req.SetBasicAuth("dodgers@rule.com", "wrongO")
+			rec := httptest.NewRecorder()
+
+			svr.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		})
+	})
 }
 
 func newJSONBody(t *testing.T, v any) *bytes.Buffer {
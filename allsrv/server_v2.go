@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"slices"
 	"time"
 	
 	"github.com/gofrs/uuid"
@@ -28,9 +29,19 @@ func WithMux(mux *http.ServeMux) SvrOptFn {
 }
 
 type ServerV2 struct {
-	mux *http.ServeMux
-	svc SVC
-	mw  func(next http.Handler) http.Handler
+	mux    *http.ServeMux
+	svc    SVC
+	codecs codecRegistry
+	mw     func(next http.Handler) http.Handler
+	bodyMW func(next http.Handler) http.Handler
+
+	authed   bool
+	patterns []string
+
+	backups       *SQLiteBackups
+	attachments   *Attachments
+	relationships *Relationships
+	chaos         *Chaos
 }
 
 func NewServerV2(svc SVC, opts ...SvrOptFn) *ServerV2 {
@@ -42,21 +53,38 @@ func NewServerV2(svc SVC, opts ...SvrOptFn) *ServerV2 {
 	}
 	
 	s := ServerV2{
-		svc: svc,
-		mux: opt.mux,
+		svc:           svc,
+		mux:           opt.mux,
+		codecs:        newCodecRegistry(append(DefaultCodecs(), opt.codecs...)...),
+		authed:        opt.authFn != nil,
+		backups:       opt.backups,
+		attachments:   opt.attachments,
+		relationships: opt.relationships,
+		chaos:         opt.chaos,
+	}
+	
+	mw := []func(http.Handler) http.Handler{withOriginUserAgent, withTraceID, withStartTime}
+	if opt.traffic != nil { // put the recorder ahead of auth so rejected requests are recorded
+		mw = append(mw, opt.traffic.Record)
+	}
+	if opt.accessLog != nil { // put access log ahead of auth so rejected requests are logged
+		mw = append(mw, opt.accessLog)
 	}
+	mw = append(mw, negotiateCodec(s.codecs))
 	
-	var mw []func(http.Handler) http.Handler
+	var inner []func(http.Handler) http.Handler
 	if opt.authFn != nil {
-		mw = append(mw, opt.authFn)
+		inner = append(inner, opt.authFn)
 	}
-	mw = append(mw, withOriginUserAgent, withTraceID, withStartTime)
 	if opt.met != nil { // put metrics last since these are executed LIFO
-		mw = append(mw, ObserveHandler("v2", opt.met))
+		inner = append(inner, ObserveHandler("v2", opt.met))
 	}
-	mw = append(mw, recoverer)
+	inner = append(inner, recoverer)
 	
-	s.mw = applyMW(mw...)
+	s.mw = applyMW(slices.Concat(mw, inner)...)
+	// put the media type check of request bodies ahead of auth, so an unsupported
+	// media type is rejected with a 415 regardless of the credentials
+	s.bodyMW = applyMW(slices.Concat(mw, []func(http.Handler) http.Handler{contentType(s.codecs)}, inner)...)
 	
 	s.routes()
 
@@ -64,13 +92,47 @@ func NewServerV2(svc SVC, opts ...SvrOptFn) *ServerV2 {
 }
 
 func (s *ServerV2) routes() {
-	withContentTypeJSON := applyMW(contentTypeJSON, s.mw)
+	withContentType := s.bodyMW
 
 	// 9)
-	s.mux.Handle("POST /v1/foos", withContentTypeJSON(jsonIn(resourceTypeFoo, http.StatusCreated, s.createFooV1)))
-	s.mux.Handle("GET /v1/foos/{id}", s.mw(read(s.readFooV1)))
-	s.mux.Handle("PATCH /v1/foos/{id}", withContentTypeJSON(jsonIn(resourceTypeFoo, http.StatusOK, s.updateFooV1)))
-	s.mux.Handle("DELETE /v1/foos/{id}", s.mw(del(s.delFooV1)))
+	s.handle("POST /v1/foos", withContentType(withSparseFields(bodyIn(resourceTypeFoo, http.StatusCreated, s.createFooV1))))
+	s.handle("GET /v1/foos", s.mw(withSparseFields(s.withIncludes(read(s.listFoosV1, nil)))))
+	s.handle("GET /v1/foos/{id}", s.mw(withSparseFields(s.withIncludes(read(s.readFooV1, fooLastModified)))))
+	s.handle("PATCH /v1/foos/{id}", withContentType(withSparseFields(bodyIn(resourceTypeFoo, http.StatusOK, s.updateFooV1))))
+	s.handle("DELETE /v1/foos/{id}", s.mw(del(s.delFooV1)))
+
+	if s.attachments != nil {
+		s.handle("POST /v1/foos/{id}/attachments", s.mw(handler(http.StatusCreated, nil, s.createAttachmentV1)))
+		s.handle("GET /v1/foos/{id}/attachments", s.mw(read(s.listAttachmentsV1, nil)))
+		s.handle("GET /v1/foos/{id}/attachments/{attachment_id}", s.mw(http.HandlerFunc(s.downloadAttachmentV1)))
+		s.handle("DELETE /v1/foos/{id}/attachments/{attachment_id}", s.mw(del(s.delAttachmentV1)))
+	}
+
+	if s.relationships != nil {
+		s.handle("GET /v1/foos/{id}/relationships/{name}", s.mw(s.relationshipV1(http.StatusOK, false, s.readRelationshipV1)))
+		s.handle("PATCH /v1/foos/{id}/relationships/{name}", withContentType(s.relationshipV1(http.StatusOK, true, s.setRelationshipV1)))
+		s.handle("POST /v1/foos/{id}/relationships/{name}", withContentType(s.relationshipV1(http.StatusOK, true, s.addRelationshipV1)))
+		s.handle("DELETE /v1/foos/{id}/relationships/{name}", withContentType(s.relationshipV1(http.StatusOK, true, s.delRelationshipV1)))
+	}
+
+	s.handle("GET /v1/openapi.json", s.mw(http.HandlerFunc(s.openAPI)))
+
+	// the admin routes change the db and the behavior of the server for every
+	// client, so they are never registered without auth
+	if s.backups != nil && s.authed {
+		s.handle("POST /v1/admin/backups", s.mw(handler(http.StatusCreated, nil, s.createBackupV1)))
+		s.handle("GET /v1/admin/backups", s.mw(read(s.listBackupsV1, nil)))
+	}
+
+	if s.chaos != nil && s.authed {
+		s.handle("GET /v1/admin/chaos", s.mw(read(s.readChaosV1, nil)))
+		s.handle("PUT /v1/admin/chaos", withContentType(bodyIn(resourceTypeChaos, http.StatusOK, s.setChaosV1)))
+	}
+}
+
+func (s *ServerV2) handle(pattern string, h http.Handler) {
+	s.patterns = append(s.patterns, pattern)
+	s.mux.Handle(pattern, withRoute(pattern)(h))
 }
 
 func (s *ServerV2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -80,27 +142,34 @@ func (s *ServerV2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 const (
 	resourceTypeFoo = "foo"
+
+	// labelsParam is the query parameter of the label selector of foo lists.
+	labelsParam = "filter[labels]"
 )
 
-func (s *ServerV2) createFooV1(ctx context.Context, req allsrvc.ReqBody[allsrvc.FooCreateAttrs]) (*allsrvc.Data[allsrvc.ResourceFooAttrs], []allsrvc.RespErr) {
+func (s *ServerV2) createFooV1(ctx context.Context, req allsrvc.ReqBody[FooCreateAttrs]) (*allsrvc.Data[FooAttrs], []allsrvc.RespErr) {
+	expiresAt, err := parseTimestamp("expires_at", req.Data.Attrs.ExpiresAt)
+	if err != nil {
+		return nil, toAttrRespErrs(err)
+	}
+
 	newFoo, err := s.svc.CreateFoo(ctx, Foo{
-		Name: req.Data.Attrs.Name,
-		Note: req.Data.Attrs.Note,
+		Name:      req.Data.Attrs.Name,
+		Note:      req.Data.Attrs.Note,
+		ExpiresAt: expiresAt,
+		Labels:    req.Data.Attrs.Labels,
+		Metadata:  req.Data.Attrs.Metadata,
 	})
 	if err != nil {
-		respErr := toRespErr(err)
-		if errors.Is(err, ErrKindExists) {
-			respErr.Source = &allsrvc.RespErrSource{Pointer: "/data/attributes/name"}
-		}
-		return nil, []allsrvc.RespErr{respErr}
+		return nil, toAttrRespErrs(err)
 	}
 	
 	out := FooToData(newFoo)
 	return &out, nil
 }
 
-func (s *ServerV2) readFooV1(ctx context.Context, r *http.Request) (*allsrvc.Data[allsrvc.ResourceFooAttrs], []allsrvc.RespErr) {
-	f, err := s.svc.ReadFoo(ctx, r.PathValue("id"))
+func (s *ServerV2) readFooV1(ctx context.Context, r *http.Request) (*allsrvc.Data[FooAttrs], []allsrvc.RespErr) {
+	f, err := s.svc.ReadFoo(ctx, r.PathValue("id"), WithReadFields(getSparseFields(ctx)...))
 	if err != nil {
 		return nil, []allsrvc.RespErr{toRespErr(err)}
 	}
@@ -109,18 +178,25 @@ func (s *ServerV2) readFooV1(ctx context.Context, r *http.Request) (*allsrvc.Dat
 	return &out, nil
 }
 
-func (s *ServerV2) updateFooV1(ctx context.Context, req allsrvc.ReqBody[allsrvc.FooUpdAttrs]) (*allsrvc.Data[allsrvc.ResourceFooAttrs], []allsrvc.RespErr) {
-	existing, err := s.svc.UpdateFoo(ctx, FooUpd{
-		ID:   req.Data.ID,
-		Name: req.Data.Attrs.Name,
-		Note: req.Data.Attrs.Note,
-	})
-	if err != nil {
-		respErr := toRespErr(err)
-		if errors.Is(err, ErrKindExists) {
-			respErr.Source = &allsrvc.RespErrSource{Pointer: "/data/attributes/name"}
+func (s *ServerV2) updateFooV1(ctx context.Context, req allsrvc.ReqBody[FooUpdAttrs]) (*allsrvc.Data[FooAttrs], []allsrvc.RespErr) {
+	upd := FooUpd{
+		ID:       req.Data.ID,
+		Name:     req.Data.Attrs.Name,
+		Note:     req.Data.Attrs.Note,
+		Labels:   req.Data.Attrs.Labels,
+		Metadata: req.Data.Attrs.Metadata,
+	}
+	if ts := req.Data.Attrs.ExpiresAt; ts != nil {
+		expiresAt, err := parseTimestamp("expires_at", *ts)
+		if err != nil {
+			return nil, toAttrRespErrs(err)
 		}
-		return nil, []allsrvc.RespErr{respErr}
+		upd.ExpiresAt = &expiresAt
+	}
+
+	existing, err := s.svc.UpdateFoo(ctx, upd)
+	if err != nil {
+		return nil, toAttrRespErrs(err)
 	}
 	
 	out := FooToData(existing)
@@ -135,29 +211,65 @@ func (s *ServerV2) delFooV1(ctx context.Context, r *http.Request) []allsrvc.Resp
 	return nil
 }
 
-func FooToData(f Foo) allsrvc.Data[allsrvc.ResourceFooAttrs] {
-	return allsrvc.Data[allsrvc.ResourceFooAttrs]{
+func (s *ServerV2) listFoosV1(ctx context.Context, r *http.Request) (*allsrvc.Data[[]allsrvc.Data[FooAttrs]], []allsrvc.RespErr) {
+	sel, err := ParseLabelSelector(r.URL.Query().Get(labelsParam))
+	if err != nil {
+		respErr := toRespErr(err)
+		respErr.Source = &allsrvc.RespErrSource{Parameter: labelsParam}
+		return nil, []allsrvc.RespErr{respErr}
+	}
+	filter, respErr := parseMetadataFilter(r.URL.Query())
+	if respErr != nil {
+		return nil, []allsrvc.RespErr{*respErr}
+	}
+
+	foos, err := s.svc.ListFoos(ctx, sel, WithListMetadata(filter))
+	if err != nil {
+		return nil, []allsrvc.RespErr{toRespErr(err)}
+	}
+
+	out := allsrvc.Data[[]allsrvc.Data[FooAttrs]]{
+		Type:  resourceTypeFoo,
+		Attrs: make([]allsrvc.Data[FooAttrs], 0, len(foos)),
+	}
+	for _, f := range foos {
+		out.Attrs = append(out.Attrs, FooToData(f))
+	}
+	return &out, nil
+}
+
+func FooToData(f Foo) allsrvc.Data[FooAttrs] {
+	return allsrvc.Data[FooAttrs]{
 		Type: resourceTypeFoo,
 		ID:   f.ID,
-		Attrs: allsrvc.ResourceFooAttrs{
-			Name:      f.Name,
-			Note:      f.Note,
-			CreatedAt: toTimestamp(f.CreatedAt),
-			UpdatedAt: toTimestamp(f.UpdatedAt),
+		Attrs: FooAttrs{
+			ResourceFooAttrs: allsrvc.ResourceFooAttrs{
+				Name:      f.Name,
+				Note:      f.Note,
+				CreatedAt: toTimestamp(f.CreatedAt),
+				UpdatedAt: toTimestamp(f.UpdatedAt),
+			},
+			ExpiresAt: toOptTimestamp(f.ExpiresAt),
+			Labels:    f.Labels,
+			Metadata:  f.Metadata,
 		},
 	}
 }
 
+func fooLastModified(attrs FooAttrs) time.Time {
+	return toTime(attrs.UpdatedAt)
+}
+
 func toTimestamp(t time.Time) string {
 	return t.Format(time.RFC3339)
 }
 
-func jsonIn[ReqAttr, RespAttr allsrvc.Attrs](
+func bodyIn[ReqAttr, RespAttr allsrvc.Attrs](
 	resource string,
 	successCode int,
 	fn func(context.Context, allsrvc.ReqBody[ReqAttr]) (*allsrvc.Data[RespAttr], []allsrvc.RespErr),
 ) http.Handler {
-	return handler(successCode, func(ctx context.Context, r *http.Request) (*allsrvc.Data[RespAttr], []allsrvc.RespErr) {
+	return handler(successCode, nil, func(ctx context.Context, r *http.Request) (*allsrvc.Data[RespAttr], []allsrvc.RespErr) {
 		var reqBody allsrvc.ReqBody[ReqAttr]
 		if respErr := decodeReq(r, &reqBody); respErr != nil {
 			return nil, []allsrvc.RespErr{*respErr}
@@ -177,18 +289,30 @@ func jsonIn[ReqAttr, RespAttr allsrvc.Attrs](
 	})
 }
 
-func read[Attr any | []Attr](fn func(ctx context.Context, r *http.Request) (*allsrvc.Data[Attr], []allsrvc.RespErr)) http.Handler {
-	return handler(http.StatusOK, fn)
+// read provides a handler for reading a resource. The response provides an ETag
+// and Last-Modified, from the lastModified of the resource, for conditional
+// requests.
+func read[Attr any | []Attr](
+	fn func(ctx context.Context, r *http.Request) (*allsrvc.Data[Attr], []allsrvc.RespErr),
+	lastModified func(Attr) time.Time,
+) http.Handler {
+	return handler(http.StatusOK, lastModified, fn)
 }
 
 func del(fn func(ctx context.Context, r *http.Request) []allsrvc.RespErr) http.Handler {
-	return handler(http.StatusOK, func(ctx context.Context, r *http.Request) (*allsrvc.Data[any], []allsrvc.RespErr) {
+	return handler(http.StatusOK, nil, func(ctx context.Context, r *http.Request) (*allsrvc.Data[any], []allsrvc.RespErr) {
 		return nil, fn(ctx, r)
 	})
 }
 
-func handler[Attr allsrvc.Attrs](successCode int, fn func(ctx context.Context, req *http.Request) (*allsrvc.Data[Attr], []allsrvc.RespErr)) http.Handler {
+func handler[Attr allsrvc.Attrs](
+	successCode int,
+	lastModified func(Attr) time.Time,
+	fn func(ctx context.Context, req *http.Request) (*allsrvc.Data[Attr], []allsrvc.RespErr),
+) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := getSparseFields(r.Context())
+		
 		out, errs := fn(r.Context(), r)
 		
 		status := successCode
@@ -197,7 +321,58 @@ func handler[Attr allsrvc.Attrs](successCode int, fn func(ctx context.Context, r
 				status = e.Status
 			}
 		}
-		writeResp(w, status, allsrvc.RespBody[Attr]{
+		if inc := getIncludes(r.Context()); inc != nil && out != nil {
+			writeErr := func(err error) {
+				status, errs = http.StatusInternalServerError, append(errs, toRespErr(InternalErr(err.Error())))
+				writeResp(r.Context(), w, status, allsrvc.RespBody[any]{
+					Meta: getMeta(r.Context()),
+					Errs: errs,
+				})
+			}
+			
+			var data any = out
+			if len(fields) > 0 {
+				sparse, err := toSparseData(out, fields)
+				if err != nil {
+					writeErr(err)
+					return
+				}
+				data = sparse
+			}
+			doc, err := inc.compound(r.Context(), data)
+			if err != nil {
+				writeErr(err)
+				return
+			}
+			// the included foos are part of the representation for the ETag. The
+			// representation is changed by relating foos, which does not modify
+			// any foo, so there is no Last-Modified of a compound document.
+			if status == http.StatusOK && lastModified != nil && notModified(w, r, []any{doc.Data, doc.Included}, time.Time{}) {
+				return
+			}
+			doc.Meta, doc.Errs = getMeta(r.Context()), errs
+			writeResp(r.Context(), w, status, doc)
+			return
+		}
+		if out != nil && len(fields) > 0 {
+			sparse, err := toSparseData(out, fields)
+			if err != nil {
+				status, errs = http.StatusInternalServerError, append(errs, toRespErr(InternalErr(err.Error())))
+			}
+			if status == http.StatusOK && lastModified != nil && notModified(w, r, sparse, lastModified(out.Attrs)) {
+				return
+			}
+			writeResp(r.Context(), w, status, allsrvc.RespBody[map[string]any]{
+				Meta: getMeta(r.Context()),
+				Errs: errs,
+				Data: sparse,
+			})
+			return
+		}
+		if status == http.StatusOK && out != nil && lastModified != nil && notModified(w, r, out, lastModified(out.Attrs)) {
+			return
+		}
+		writeResp(r.Context(), w, status, allsrvc.RespBody[Attr]{
 			Meta: getMeta(r.Context()),
 			Errs: errs,
 			Data: out,
@@ -205,14 +380,15 @@ func handler[Attr allsrvc.Attrs](successCode int, fn func(ctx context.Context, r
 	})
 }
 
-func writeResp(w http.ResponseWriter, status int, body any) {
-	w.Header().Set("Content-Type", "application/json")
+func writeResp(ctx context.Context, w http.ResponseWriter, status int, body any) {
+	codec := getRespCodec(ctx)
+	w.Header().Set("Content-Type", codec.MediaType())
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(body) // 10.b)
+	codec.Encode(w, body) // 10.b)
 }
 
 func decodeReq[Attr allsrvc.Attrs](r *http.Request, v *allsrvc.ReqBody[Attr]) *allsrvc.RespErr {
-	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+	if err := getReqCodec(r.Context()).Decode(r.Body, v); err != nil {
 		respErr := allsrvc.RespErr{
 			Status: http.StatusBadRequest,
 			Msg:    "failed to decode request body: " + err.Error(),
@@ -240,6 +416,35 @@ func decodeReq[Attr allsrvc.Attrs](r *http.Request, v *allsrvc.ReqBody[Attr]) *a
 	return nil
 }
 
+// toAttrRespErrs converts the errors from a write of the foo attributes, providing
+// every joined error as its own response error with the offending attribute
+// identified by its source.
+func toAttrRespErrs(err error) []allsrvc.RespErr {
+	errs := disjoin(err)
+	out := make([]allsrvc.RespErr, 0, len(errs))
+	for _, err := range errs {
+		respErr := toRespErr(err)
+		switch attr, _ := errors.V(err, "attribute").(string); {
+		case attr != "":
+			respErr.Source = &allsrvc.RespErrSource{Pointer: "/data/attributes/" + attr}
+		case errors.Is(err, ErrKindExists):
+			respErr.Source = &allsrvc.RespErrSource{Pointer: "/data/attributes/name"}
+		}
+		out = append(out, respErr)
+	}
+	return out
+}
+
+// disjoin separates a joined error that may be wrapped.
+func disjoin(err error) []error {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if errs := errors.Disjoin(e); len(errs) > 0 {
+			return errs
+		}
+	}
+	return []error{err}
+}
+
 func toRespErr(err error) allsrvc.RespErr {
 	return allsrvc.RespErr{
 		Status: errStatus(err),
@@ -258,6 +463,8 @@ func errStatus(err error) int {
 		return http.StatusNotFound
 	case errors.Is(err, ErrKindUnAuthed):
 		return http.StatusUnauthorized
+	case errors.Is(err, ErrKindUnavailable):
+		return http.StatusServiceUnavailable
 	default:
 		return http.StatusInternalServerError
 	}
@@ -270,7 +477,7 @@ func WithBasicAuthV2(adminUser, adminPass string) func(*serverOpts) {
 		s.authFn = func(next http.Handler) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if user, pass, ok := r.BasicAuth(); !(ok && user == adminUser && pass == adminPass) {
-					writeResp(w, http.StatusUnauthorized, allsrvc.RespBody[any]{
+					writeResp(r.Context(), w, http.StatusUnauthorized, allsrvc.RespBody[any]{
 						Meta: getMeta(r.Context()),
 						Errs: []allsrvc.RespErr{{
 							Status: http.StatusUnauthorized,
@@ -289,23 +496,6 @@ func WithBasicAuthV2(adminUser, adminPass string) func(*serverOpts) {
 	}
 }
 
-func contentTypeJSON(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ct := r.Header.Get("Content-Type")
-		if ct != "application/json" {
-			writeResp(w, http.StatusUnsupportedMediaType, allsrvc.RespBody[any]{
-				Meta: getMeta(r.Context()),
-				Errs: []allsrvc.RespErr{{
-					Code: http.StatusUnsupportedMediaType,
-					Msg:  "received invalid media type",
-				}},
-			})
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 func getMeta(ctx context.Context) allsrvc.RespMeta {
 	return allsrvc.RespMeta{
 		TookMilli: int(took(ctx).Milliseconds()),
@@ -337,10 +527,15 @@ func recoverer(next http.Handler) http.Handler {
 type ctxKey string
 
 const (
-	ctxKeyOrigin    ctxKey = "origin"
-	ctxStartTime    ctxKey = "start"
-	ctxTraceID      ctxKey = "trace-id"
-	ctxKeyUserAgent ctxKey = "user_agent"
+	ctxKeyIncludes     ctxKey = "includes"
+	ctxKeyOrigin       ctxKey = "origin"
+	ctxKeyReqCodec     ctxKey = "req_codec"
+	ctxKeyRespCodec    ctxKey = "resp_codec"
+	ctxKeyRoute        ctxKey = "route"
+	ctxKeySparseFields ctxKey = "sparse_fields"
+	ctxStartTime       ctxKey = "start"
+	ctxTraceID         ctxKey = "trace-id"
+	ctxKeyUserAgent    ctxKey = "user_agent"
 )
 
 func withTraceID(next http.Handler) http.Handler {
@@ -0,0 +1,153 @@
+package allsrv
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/jsteenb2/errors"
+
+	"github.com/jsteenb2/allsrvc"
+)
+
+const resourceTypeAttachment = "attachment"
+
+// WithAttachments registers the routes of the ServerV2 for uploading,
+// downloading, listing, and deleting the attachments of foos.
+func WithAttachments(a *Attachments) SvrOptFn {
+	return func(o *serverOpts) {
+		o.attachments = a
+	}
+}
+
+// AttachmentAttrs are the attributes of an attachment resource.
+type AttachmentAttrs struct {
+	FooID       string `json:"foo_id"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Digest      string `json:"digest"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// createAttachmentV1 streams the request body into an attachment of the foo. The
+// name of the attachment is the filename of the Content-Disposition, and its
+// content type is the Content-Type of the request.
+func (s *ServerV2) createAttachmentV1(ctx context.Context, r *http.Request) (*allsrvc.Data[AttachmentAttrs], []allsrvc.RespErr) {
+	if maxSize := s.attachments.MaxSize(); r.ContentLength > maxSize {
+		err := InvalidErr("attachment must be at most "+strconv.FormatInt(maxSize, 10)+" bytes", "max_size", maxSize)
+		return nil, []allsrvc.RespErr{toAttachmentRespErr(err)}
+	}
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] == "" {
+		return nil, []allsrvc.RespErr{{
+			Status: http.StatusBadRequest,
+			Code:   errCode(ErrKindInvalid),
+			Msg:    "Content-Disposition must provide the filename of the attachment",
+			Source: &allsrvc.RespErrSource{
+				Header: "Content-Disposition",
+			},
+		}}
+	}
+
+	att, err := s.attachments.CreateAttachment(ctx, r.PathValue("id"), params["filename"], r.Header.Get("Content-Type"), r.Body)
+	if err != nil {
+		var errs []allsrvc.RespErr
+		for _, err := range disjoin(err) {
+			errs = append(errs, toAttachmentRespErr(err))
+		}
+		return nil, errs
+	}
+
+	out := AttachmentToData(att)
+	return &out, nil
+}
+
+func (s *ServerV2) listAttachmentsV1(ctx context.Context, r *http.Request) (*allsrvc.Data[[]allsrvc.Data[AttachmentAttrs]], []allsrvc.RespErr) {
+	atts, err := s.attachments.ListAttachments(ctx, r.PathValue("id"))
+	if err != nil {
+		return nil, []allsrvc.RespErr{toRespErr(err)}
+	}
+
+	out := allsrvc.Data[[]allsrvc.Data[AttachmentAttrs]]{
+		Type:  resourceTypeAttachment,
+		Attrs: make([]allsrvc.Data[AttachmentAttrs], 0, len(atts)),
+	}
+	for _, att := range atts {
+		out.Attrs = append(out.Attrs, AttachmentToData(att))
+	}
+	return &out, nil
+}
+
+// downloadAttachmentV1 streams the content of the attachment. The digest of the
+// content is its ETag, so the content is not modified when the ETag matches.
+func (s *ServerV2) downloadAttachmentV1(w http.ResponseWriter, r *http.Request) {
+	att, content, err := s.attachments.OpenAttachment(r.Context(), r.PathValue("id"), r.PathValue("attachment_id"))
+	if err != nil {
+		respErr := toRespErr(err)
+		writeResp(r.Context(), w, respErr.Status, allsrvc.RespBody[any]{
+			Meta: getMeta(r.Context()),
+			Errs: []allsrvc.RespErr{respErr},
+		})
+		return
+	}
+	defer content.Close()
+
+	etag := strconv.Quote(att.Digest)
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatch(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", att.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(att.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": att.Name}))
+	// the content type is provided by the uploader, so the browser must not
+	// sniff the content for a type to render it as
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, content)
+}
+
+func (s *ServerV2) delAttachmentV1(ctx context.Context, r *http.Request) []allsrvc.RespErr {
+	if err := s.attachments.DelAttachment(ctx, r.PathValue("id"), r.PathValue("attachment_id")); err != nil {
+		return []allsrvc.RespErr{toRespErr(err)}
+	}
+	return nil
+}
+
+// toAttachmentRespErr converts the error of an attachment upload, identifying
+// the header of the offending attribute. An attachment that is too large is
+// rejected with a 413.
+func toAttachmentRespErr(err error) allsrvc.RespErr {
+	respErr := toRespErr(err)
+	if errors.V(err, "max_size") != nil {
+		respErr.Status = http.StatusRequestEntityTooLarge
+	}
+	switch attr, _ := errors.V(err, "attribute").(string); attr {
+	case "name":
+		respErr.Source = &allsrvc.RespErrSource{Header: "Content-Disposition"}
+	case "content_type":
+		respErr.Source = &allsrvc.RespErrSource{Header: "Content-Type"}
+	}
+	return respErr
+}
+
+func AttachmentToData(att Attachment) allsrvc.Data[AttachmentAttrs] {
+	return allsrvc.Data[AttachmentAttrs]{
+		Type: resourceTypeAttachment,
+		ID:   att.ID,
+		Attrs: AttachmentAttrs{
+			FooID:       att.FooID,
+			Name:        att.Name,
+			ContentType: att.ContentType,
+			Size:        att.Size,
+			Digest:      att.Digest,
+			CreatedAt:   toTimestamp(att.CreatedAt),
+		},
+	}
+}
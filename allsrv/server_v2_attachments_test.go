@@ -0,0 +1,185 @@
+package allsrv_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/allsrvc"
+
+	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
+)
+
+func TestServerV2Attachments(t *testing.T) {
+	start := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
+
+	newSvr := func(t *testing.T) *allsrv.ServerV2 {
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(allsrv.Foo{ID: "1", Name: "first-foo", CreatedAt: start, UpdatedAt: start})(t, db)
+
+		blobs, err := allsrv.NewFSBlobStore(t.TempDir())
+		require.NoError(t, err)
+		attachments := allsrv.NewAttachments(db, blobs,
+			allsrv.WithAttachmentsIDFn(allsrvtesting.IDGen(1, 1)),
+			allsrv.WithAttachmentsNowFn(allsrvtesting.NowFn(start, time.Hour)),
+			allsrv.WithAttachmentsMaxSize(16),
+		)
+
+		svc := allsrv.SVCAttachmentsCascade(attachments)(allsrv.NewService(db))
+		return allsrv.NewServerV2(svc,
+			allsrv.WithBasicAuthV2("dodgers@stink.com", "PaSsWoRd"),
+			allsrv.WithAttachments(attachments),
+		)
+	}
+
+	upload := func(target, filename, content string) *http.Request {
+		return newReq("POST", target, strings.NewReader(content),
+			withContentType("text/plain"),
+			withHeader("Content-Disposition", `attachment; filename="`+filename+`"`),
+			withBasicAuth("dodgers@stink.com", "PaSsWoRd"),
+		)
+	}
+
+	// sha256 of "some content"
+	const digest = "sha256:290f493c44f5d63d06b374d0a5abd292fae38b92cab2fae5efefe1b0e9347f56"
+
+	t.Run("uploaded attachment should be listed and downloadable", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, upload("/v1/foos/1/attachments", "notes.txt", "some content"))
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		want := allsrvc.Data[allsrv.AttachmentAttrs]{
+			Type: "attachment",
+			ID:   "1",
+			Attrs: allsrv.AttachmentAttrs{
+				FooID:       "1",
+				Name:        "notes.txt",
+				ContentType: "text/plain",
+				Size:        12,
+				Digest:      digest,
+				CreatedAt:   start.Format(time.RFC3339),
+			},
+		}
+		expectData[allsrv.AttachmentAttrs](t, rec.Body, want)
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1/attachments", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		require.Equal(t, http.StatusOK, rec.Code)
+		expectData[[]allsrvc.Data[allsrv.AttachmentAttrs]](t, rec.Body, allsrvc.Data[[]allsrvc.Data[allsrv.AttachmentAttrs]]{
+			Type:  "attachment",
+			Attrs: []allsrvc.Data[allsrv.AttachmentAttrs]{want},
+		})
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1/attachments/1", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "some content", rec.Body.String())
+		assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+		assert.Equal(t, "12", rec.Header().Get("Content-Length"))
+		assert.Equal(t, `attachment; filename=notes.txt`, rec.Header().Get("Content-Disposition"))
+		assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+		assert.Equal(t, `"`+digest+`"`, rec.Header().Get("ETag"))
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1/attachments/1",
+			withHeader("If-None-Match", `"`+digest+`"`),
+			withBasicAuth("dodgers@stink.com", "PaSsWoRd"),
+		))
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Body.Bytes())
+	})
+
+	t.Run("deleted attachment should not be found", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, upload("/v1/foos/1/attachments", "notes.txt", "some content"))
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, del("/v1/foos/1/attachments/1", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1/attachments/1", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		expectErrs(t, rec.Body, allsrvc.RespErr{
+			Status: http.StatusNotFound,
+			Code:   3,
+			Msg:    "attachment not found for id: 1",
+		})
+	})
+
+	t.Run("deleted foo should delete its attachments", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, upload("/v1/foos/1/attachments", "notes.txt", "some content"))
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, del("/v1/foos/1", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1/attachments", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("attachment larger than the max size should fail", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, upload("/v1/foos/1/attachments", "big.txt", strings.Repeat("a", 17)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		expectErrs(t, rec.Body, allsrvc.RespErr{
+			Status: http.StatusRequestEntityTooLarge,
+			Code:   2,
+			Msg:    "attachment must be at most 16 bytes",
+		})
+	})
+
+	t.Run("upload without a filename should fail", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, newReq("POST", "/v1/foos/1/attachments", strings.NewReader("some content"),
+			withBasicAuth("dodgers@stink.com", "PaSsWoRd"),
+		))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		expectErrs(t, rec.Body, allsrvc.RespErr{
+			Status: http.StatusBadRequest,
+			Code:   2,
+			Msg:    "Content-Disposition must provide the filename of the attachment",
+			Source: &allsrvc.RespErrSource{
+				Header: "Content-Disposition",
+			},
+		})
+	})
+
+	t.Run("upload for non-existent foo should fail", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, upload("/v1/foos/9000/attachments", "notes.txt", "some content"))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("upload with unauthorized user should fail", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, newReq("POST", "/v1/foos/1/attachments", strings.NewReader("some content"),
+			withHeader("Content-Disposition", `attachment; filename="notes.txt"`),
+		))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
@@ -0,0 +1,56 @@
+package allsrv
+
+import (
+	"time"
+
+	"github.com/jsteenb2/allsrvc"
+)
+
+// FooCreateAttrs are the attributes for creating a foo. The attributes of the
+// allsrvc SDK are extended with the attributes the SDK does not yet provide.
+type FooCreateAttrs struct {
+	allsrvc.FooCreateAttrs
+	ExpiresAt string            `json:"expires_at,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Metadata  map[string]any    `json:"metadata,omitempty"`
+}
+
+// FooUpdAttrs are the attributes for updating a foo. An empty ExpiresAt
+// removes the expiry of the foo, and the Labels and Metadata replace all the
+// labels and metadata of the foo.
+type FooUpdAttrs struct {
+	allsrvc.FooUpdAttrs
+	ExpiresAt *string            `json:"expires_at,omitempty"`
+	Labels    *map[string]string `json:"labels,omitempty"`
+	Metadata  *map[string]any    `json:"metadata,omitempty"`
+}
+
+// FooAttrs are the attributes of the foo resource.
+type FooAttrs struct {
+	allsrvc.ResourceFooAttrs
+	ExpiresAt string            `json:"expires_at,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Metadata  map[string]any    `json:"metadata,omitempty"`
+}
+
+// parseTimestamp parses the RFC3339 timestamp of the attribute. An empty
+// timestamp is the zero time.
+func parseTimestamp(attr, v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, InvalidErr(attr+" must be an RFC3339 timestamp", "attribute", attr)
+	}
+	return t, nil
+}
+
+// toOptTimestamp formats the time as an RFC3339 timestamp, or empty for the
+// zero time.
+func toOptTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return toTimestamp(t)
+}
@@ -0,0 +1,66 @@
+package allsrv
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jsteenb2/allsrvc"
+)
+
+const resourceTypeBackup = "backup"
+
+// WithSQLiteBackups registers the admin routes of the ServerV2 for making and
+// listing the backups of the sqlite db. The routes are only registered when
+// the server requires auth (i.e. WithBasicAuthV2).
+func WithSQLiteBackups(b *SQLiteBackups) SvrOptFn {
+	return func(o *serverOpts) {
+		o.backups = b
+	}
+}
+
+// BackupAttrs are the attributes of a backup resource.
+type BackupAttrs struct {
+	Name          string `json:"name"`
+	SchemaVersion uint   `json:"schema_version"`
+	Size          int64  `json:"size"`
+	CreatedAt     string `json:"created_at"`
+}
+
+func (s *ServerV2) createBackupV1(ctx context.Context, r *http.Request) (*allsrvc.Data[BackupAttrs], []allsrvc.RespErr) {
+	backup, err := s.backups.Backup(ctx)
+	if err != nil {
+		return nil, []allsrvc.RespErr{toRespErr(err)}
+	}
+
+	out := allsrvc.Data[BackupAttrs]{
+		Type:  resourceTypeBackup,
+		ID:    backup.Name,
+		Attrs: toBackupAttrs(backup),
+	}
+	return &out, nil
+}
+
+func (s *ServerV2) listBackupsV1(ctx context.Context, r *http.Request) (*allsrvc.Data[[]BackupAttrs], []allsrvc.RespErr) {
+	backups, err := s.backups.List(ctx)
+	if err != nil {
+		return nil, []allsrvc.RespErr{toRespErr(err)}
+	}
+
+	out := allsrvc.Data[[]BackupAttrs]{
+		Type:  resourceTypeBackup,
+		Attrs: make([]BackupAttrs, 0, len(backups)),
+	}
+	for _, b := range backups {
+		out.Attrs = append(out.Attrs, toBackupAttrs(b))
+	}
+	return &out, nil
+}
+
+func toBackupAttrs(b SQLiteBackup) BackupAttrs {
+	return BackupAttrs{
+		Name:          b.Name,
+		SchemaVersion: b.SchemaVersion,
+		Size:          b.Size,
+		CreatedAt:     toTimestamp(b.CreatedAt),
+	}
+}
@@ -0,0 +1,113 @@
+package allsrv
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jsteenb2/errors"
+
+	"github.com/jsteenb2/allsrvc"
+)
+
+const resourceTypeChaos = "chaos"
+
+// WithChaos registers the admin routes of the ServerV2 for reading and
+// replacing the rules of the chaos. The routes are only registered when the
+// server requires auth (i.e. WithBasicAuthV2).
+func WithChaos(c *Chaos) SvrOptFn {
+	return func(o *serverOpts) {
+		o.chaos = c
+	}
+}
+
+// ChaosAttrs are the attributes of the chaos resource. The seed is optional
+// when replacing the rules, the faults are rolled from the provided seed.
+type ChaosAttrs struct {
+	Seed  *int64           `json:"seed,omitempty"`
+	Rules []ChaosRuleAttrs `json:"rules"`
+}
+
+// ChaosRuleAttrs are the attributes of a rule of the chaos. The latency is a
+// duration (i.e. 250ms).
+type ChaosRuleAttrs struct {
+	Layer       string  `json:"layer,omitempty"`
+	Op          string  `json:"op,omitempty"`
+	Fault       string  `json:"fault"`
+	Probability float64 `json:"probability"`
+	Latency     string  `json:"latency,omitempty"`
+	ErrKind     string  `json:"error_kind,omitempty"`
+}
+
+func (s *ServerV2) readChaosV1(ctx context.Context, r *http.Request) (*allsrvc.Data[ChaosAttrs], []allsrvc.RespErr) {
+	out := s.chaosData()
+	return &out, nil
+}
+
+func (s *ServerV2) setChaosV1(ctx context.Context, req allsrvc.ReqBody[ChaosAttrs]) (*allsrvc.Data[ChaosAttrs], []allsrvc.RespErr) {
+	rules, err := toChaosRules(req.Data.Attrs.Rules)
+	if err == nil {
+		err = s.chaos.SetRules(rules...)
+	}
+	if err != nil {
+		return nil, toAttrRespErrs(err)
+	}
+	if seed := req.Data.Attrs.Seed; seed != nil {
+		s.chaos.Reseed(*seed)
+	}
+
+	out := s.chaosData()
+	return &out, nil
+}
+
+func (s *ServerV2) chaosData() allsrvc.Data[ChaosAttrs] {
+	seed := s.chaos.Seed()
+	attrs := ChaosAttrs{
+		Seed:  &seed,
+		Rules: make([]ChaosRuleAttrs, 0),
+	}
+	for _, r := range s.chaos.Rules() {
+		ruleAttrs := ChaosRuleAttrs{
+			Layer:       r.Layer,
+			Op:          r.Op,
+			Fault:       string(r.Fault),
+			Probability: r.Probability,
+			ErrKind:     string(r.ErrKind),
+		}
+		if r.Latency > 0 {
+			ruleAttrs.Latency = r.Latency.String()
+		}
+		attrs.Rules = append(attrs.Rules, ruleAttrs)
+	}
+	return allsrvc.Data[ChaosAttrs]{
+		Type:  resourceTypeChaos,
+		Attrs: attrs,
+	}
+}
+
+func toChaosRules(attrs []ChaosRuleAttrs) ([]ChaosRule, error) {
+	var (
+		rules = make([]ChaosRule, 0, len(attrs))
+		errs  []error
+	)
+	for i, a := range attrs {
+		r := ChaosRule{
+			Layer:       a.Layer,
+			Op:          a.Op,
+			Fault:       ChaosFault(a.Fault),
+			Probability: a.Probability,
+			ErrKind:     errors.Kind(a.ErrKind),
+		}
+		if a.Latency != "" {
+			latency, err := time.ParseDuration(a.Latency)
+			if err != nil {
+				attr := "rules/" + strconv.Itoa(i) + "/latency"
+				errs = append(errs, InvalidErr(attr+" must be a duration (i.e. 250ms)", "attribute", attr))
+			}
+			r.Latency = latency
+		}
+		rules = append(rules, r)
+	}
+	return rules, joinViolations(errs...)
+}
@@ -0,0 +1,271 @@
+package allsrv
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/jsteenb2/allsrvc"
+)
+
+const (
+	MediaTypeJSON    = "application/json"
+	MediaTypeJSONAPI = "application/vnd.api+json"
+	MediaTypeMsgPack = "application/msgpack"
+	MediaTypeCBOR    = "application/cbor"
+)
+
+// Codec encodes and decodes the wire representation of a media type.
+type Codec interface {
+	MediaType() string
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+// WithCodecs registers additional codecs with the server. A codec registered
+// for a media type that is already registered replaces the existing codec.
+func WithCodecs(codecs ...Codec) SvrOptFn {
+	return func(o *serverOpts) {
+		o.codecs = append(o.codecs, codecs...)
+	}
+}
+
+// DefaultCodecs are the codecs supported by the server. The first codec
+// is used when the client does not state a preference.
+func DefaultCodecs() []Codec {
+	return []Codec{
+		JSONCodec(MediaTypeJSON),
+		JSONCodec(MediaTypeJSONAPI),
+		MsgPackCodec(),
+		CBORCodec(),
+	}
+}
+
+// JSONCodec provides a json codec for the given media type.
+func JSONCodec(mediaType string) Codec {
+	return jsonCodec{mediaType: mediaType}
+}
+
+type jsonCodec struct {
+	mediaType string
+}
+
+func (c jsonCodec) MediaType() string { return c.mediaType }
+
+func (c jsonCodec) Encode(w io.Writer, v any) error { return json.NewEncoder(w).Encode(v) }
+
+func (c jsonCodec) Decode(r io.Reader, v any) error { return json.NewDecoder(r).Decode(v) }
+
+// MsgPackCodec provides a MessagePack codec. The json struct tags are
+// honored so that the field names match those of the json codecs.
+func MsgPackCodec() Codec {
+	return msgpackCodec{}
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) MediaType() string { return MediaTypeMsgPack }
+
+func (msgpackCodec) Encode(w io.Writer, v any) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc.Encode(v)
+}
+
+func (msgpackCodec) Decode(r io.Reader, v any) error {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// CBORCodec provides a CBOR codec. The json struct tags are honored so that
+// the field names match those of the json codecs.
+func CBORCodec() Codec {
+	decMode, _ := cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]any(nil)),
+	}.DecMode() // only errs on invalid options
+	return cborCodec{decMode: decMode}
+}
+
+type cborCodec struct {
+	decMode cbor.DecMode
+}
+
+func (cborCodec) MediaType() string { return MediaTypeCBOR }
+
+func (cborCodec) Encode(w io.Writer, v any) error { return cbor.NewEncoder(w).Encode(v) }
+
+func (c cborCodec) Decode(r io.Reader, v any) error { return c.decMode.NewDecoder(r).Decode(v) }
+
+type codecRegistry struct {
+	codecs []Codec
+}
+
+func newCodecRegistry(codecs ...Codec) codecRegistry {
+	var reg codecRegistry
+	for _, c := range codecs {
+		i := slices.IndexFunc(reg.codecs, func(existing Codec) bool {
+			return existing.MediaType() == c.MediaType()
+		})
+		if i > -1 {
+			reg.codecs[i] = c
+			continue
+		}
+		reg.codecs = append(reg.codecs, c)
+	}
+	return reg
+}
+
+func (reg codecRegistry) defaultCodec() Codec {
+	return reg.codecs[0]
+}
+
+func (reg codecRegistry) lookup(mediaType string) (Codec, bool) {
+	i := slices.IndexFunc(reg.codecs, func(c Codec) bool {
+		return c.MediaType() == mediaType
+	})
+	if i == -1 {
+		return nil, false
+	}
+	return reg.codecs[i], true
+}
+
+// negotiate selects the codec of the highest quality media type accepted.
+// When no Accept is provided, the default codec is selected.
+func (reg codecRegistry) negotiate(accept string) (Codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return reg.defaultCodec(), true
+	}
+
+	type acceptable struct {
+		mediaType string
+		q         float64
+	}
+	var accepted []acceptable
+	for _, v := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(v)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if rawQ, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(rawQ, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		accepted = append(accepted, acceptable{mediaType: mediaType, q: q})
+	}
+	slices.SortStableFunc(accepted, func(a, b acceptable) int {
+		return cmp.Compare(b.q, a.q)
+	})
+
+	for _, a := range accepted {
+		if a.mediaType == "*/*" {
+			return reg.defaultCodec(), true
+		}
+		if prefix, ok := strings.CutSuffix(a.mediaType, "/*"); ok {
+			for _, c := range reg.codecs {
+				if strings.HasPrefix(c.MediaType(), prefix+"/") {
+					return c, true
+				}
+			}
+			continue
+		}
+		if c, ok := reg.lookup(a.mediaType); ok {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
+func (reg codecRegistry) mediaTypes() []string {
+	out := make([]string, 0, len(reg.codecs))
+	for _, c := range reg.codecs {
+		out = append(out, c.MediaType())
+	}
+	return out
+}
+
+// negotiateCodec selects the response codec from the Accept header of the request.
+func negotiateCodec(reg codecRegistry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			codec, ok := reg.negotiate(r.Header.Get("Accept"))
+			if !ok {
+				ctx := withRespCodec(r.Context(), reg.defaultCodec())
+				writeResp(ctx, w, http.StatusNotAcceptable, allsrvc.RespBody[any]{
+					Meta: getMeta(ctx),
+					Errs: []allsrvc.RespErr{{
+						Status: http.StatusNotAcceptable,
+						Code:   errCode(ErrKindInvalid),
+						Msg:    "none of the accepted media types are supported; supported media types are: " + strings.Join(reg.mediaTypes(), ", "),
+						Source: &allsrvc.RespErrSource{
+							Header: "Accept",
+						},
+					}},
+				})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withRespCodec(r.Context(), codec)))
+		})
+	}
+}
+
+// contentType validates the request body is of a supported media type, and
+// selects the codec for decoding the request body.
+func contentType(reg codecRegistry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			codec, ok := reg.lookup(mediaType)
+			if !ok {
+				writeResp(r.Context(), w, http.StatusUnsupportedMediaType, allsrvc.RespBody[any]{
+					Meta: getMeta(r.Context()),
+					Errs: []allsrvc.RespErr{{
+						Code: http.StatusUnsupportedMediaType,
+						Msg:  "received invalid media type",
+					}},
+				})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withReqCodec(r.Context(), codec)))
+		})
+	}
+}
+
+func withReqCodec(ctx context.Context, c Codec) context.Context {
+	return context.WithValue(ctx, ctxKeyReqCodec, c)
+}
+
+func getReqCodec(ctx context.Context) Codec {
+	if c, ok := ctx.Value(ctxKeyReqCodec).(Codec); ok {
+		return c
+	}
+	return JSONCodec(MediaTypeJSON)
+}
+
+func withRespCodec(ctx context.Context, c Codec) context.Context {
+	return context.WithValue(ctx, ctxKeyRespCodec, c)
+}
+
+func getRespCodec(ctx context.Context) Codec {
+	if c, ok := ctx.Value(ctxKeyRespCodec).(Codec); ok {
+		return c
+	}
+	return JSONCodec(MediaTypeJSON)
+}
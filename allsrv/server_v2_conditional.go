@@ -0,0 +1,61 @@
+package allsrv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notModified sets the ETag and Last-Modified validators of the representation,
+// and responds with a 304 Not Modified when the conditions of the request are
+// met by the validators. The If-None-Match takes precedence over the
+// If-Modified-Since, when both are provided.
+func notModified(w http.ResponseWriter, r *http.Request, data any, lastModified time.Time) bool {
+	etag, err := etagOf(getRespCodec(r.Context()).MediaType(), data)
+	if err != nil {
+		return false
+	}
+
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatch(inm, etag) {
+			return false
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || lastModified.IsZero() || lastModified.Truncate(time.Second).After(ims) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagOf provides a strong ETag of the data's representation in the media type.
+func etagOf(mediaType string, data any) (string, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(mediaType))
+	h.Write(b)
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`, nil
+}
+
+// etagMatch uses the weak comparison of the If-None-Match entity tags.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package allsrv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/jsteenb2/allsrvc"
+)
+
+// FooToSparseData converts the foo to its API representation, limited to the
+// provided attribute fields. When no fields are provided, all fields are included.
+func FooToSparseData(f Foo, fields ...string) allsrvc.Data[map[string]any] {
+	data := FooToData(f)
+	sparse, _ := toSparseData(&data, fields) // a foo will always marshal
+	return *sparse
+}
+
+// fieldsParam provides the JSON:API sparse fieldsets query parameter for the resource.
+func fieldsParam(resource string) string {
+	return "fields[" + resource + "]"
+}
+
+// withSparseFields parses the sparse fieldset of the foo resource, limiting
+// the foo attributes of the response to the fields requested.
+func withSparseFields(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields, respErr := sparseFields[FooAttrs](r, resourceTypeFoo)
+		if respErr != nil {
+			writeResp(r.Context(), w, respErr.Status, allsrvc.RespBody[any]{
+				Meta: getMeta(r.Context()),
+				Errs: []allsrvc.RespErr{*respErr},
+			})
+			return
+		}
+		if len(fields) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeySparseFields, fields))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getSparseFields provides the fields of the response. When empty, all
+// fields are provided.
+func getSparseFields(ctx context.Context) []string {
+	fields, _ := ctx.Value(ctxKeySparseFields).([]string)
+	return fields
+}
+
+// sparseFields parses the sparse fieldset of the resource from the request. The
+// requested fields are validated against the json fields of the attributes.
+func sparseFields[Attr any](r *http.Request, resource string) ([]string, *allsrvc.RespErr) {
+	param := fieldsParam(resource)
+	raw := r.URL.Query().Get(param)
+	if raw == "" {
+		return nil, nil
+	}
+
+	valid := attrFields(reflect.TypeFor[Attr]())
+
+	var fields []string
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if !slices.Contains(valid, field) {
+			return nil, &allsrvc.RespErr{
+				Status: http.StatusBadRequest,
+				Code:   errCode(ErrKindInvalid),
+				Msg:    "invalid " + param + " field provided: " + field + "; valid fields are: " + strings.Join(valid, ", "),
+			}
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+func attrFields(t reflect.Type) []string {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var out []string
+	for _, f := range jsonFields(t) {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// jsonFields provides the exported fields of the struct that are encoded to
+// json. The fields of an embedded struct are provided in place of the embedded
+// struct, as they are inlined by the encoding.
+func jsonFields(t reflect.Type) []reflect.StructField {
+	var out []reflect.StructField
+	for _, f := range reflect.VisibleFields(t) {
+		embedded := f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == ""
+		if !f.IsExported() || embedded {
+			continue
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func toSparseData[Attr any](data *allsrvc.Data[Attr], fields []string) (*allsrvc.Data[map[string]any], error) {
+	b, err := json.Marshal(data.Attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var attrs map[string]any
+	if err := json.Unmarshal(b, &attrs); err != nil {
+		return nil, err
+	}
+	if len(fields) > 0 {
+		for k := range attrs {
+			if !slices.Contains(fields, k) {
+				delete(attrs, k)
+			}
+		}
+	}
+
+	return &allsrvc.Data[map[string]any]{
+		Type:  data.Type,
+		ID:    data.ID,
+		Attrs: attrs,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package allsrv
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jsteenb2/allsrvc"
+)
+
+// metadataParamPrefix prefixes the query parameters of the metadata filter of
+// foo lists. The path of the metadata follows the prefix, and the parameter is
+// closed by a bracket (i.e. filter[metadata.region]=us).
+const metadataParamPrefix = "filter[metadata."
+
+// metadataParam provides the query parameter of the metadata requirement.
+func metadataParam(req MetadataReq) string {
+	return metadataParamPrefix + strings.Join(req.Path, ".") + "]"
+}
+
+// parseMetadataFilter parses the metadata filter of the query. Each metadata
+// parameter is a requirement of the filter, and may only be provided once.
+func parseMetadataFilter(q url.Values) (MetadataFilter, *allsrvc.RespErr) {
+	invalid := func(param, msg string) *allsrvc.RespErr {
+		return &allsrvc.RespErr{
+			Status: http.StatusBadRequest,
+			Code:   errCode(ErrKindInvalid),
+			Msg:    msg,
+			Source: &allsrvc.RespErrSource{Parameter: param},
+		}
+	}
+
+	var filter MetadataFilter
+	for _, param := range sortedKeys(q) {
+		rawPath, ok := strings.CutPrefix(param, metadataParamPrefix)
+		if !ok {
+			continue
+		}
+		rawPath, ok = strings.CutSuffix(rawPath, "]")
+		if !ok {
+			return nil, invalid(param, "invalid metadata filter provided: "+param+"; metadata filters must be of the form filter[metadata.<path>]")
+		}
+		path, err := ParseMetadataPath(rawPath)
+		if err != nil {
+			return nil, invalid(param, err.Error())
+		}
+		if len(q[param]) > 1 {
+			return nil, invalid(param, param+" must be provided once")
+		}
+		filter = append(filter, MetadataReq{Path: path, Value: q.Get(param)})
+	}
+	return filter, nil
+}
@@ -0,0 +1,480 @@
+package allsrv
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsteenb2/errors"
+
+	"github.com/jsteenb2/allsrvc"
+)
+
+// apiOp describes an operation of the ServerV2 API for the OpenAPI document.
+type apiOp struct {
+	id          string
+	summary     string
+	reqBody     reflect.Type // nil when the operation does not take a body
+	resp        reflect.Type
+	binaryBody  bool // takes raw content of any media type as the body
+	binaryResp  bool // provides raw content of any media type
+	successCode int
+	errKinds    []errors.Kind
+	fields      bool // supports sparse fieldsets
+	conditional bool // supports conditional requests
+	includes    bool // supports the include of related foos
+	params      []OpenAPIParam
+}
+
+// apiOps are the operations of the ServerV2 routes, keyed by the route pattern.
+// A route registered without an operation is left out of the OpenAPI document.
+var apiOps = map[string]apiOp{
+	"POST /v1/foos": {
+		id:          "createFoo",
+		summary:     "Create a foo.",
+		reqBody:     reflect.TypeFor[allsrvc.ReqBody[FooCreateAttrs]](),
+		resp:        reflect.TypeFor[allsrvc.RespBody[FooAttrs]](),
+		successCode: http.StatusCreated,
+		errKinds:    []errors.Kind{ErrKindInvalid, ErrKindExists},
+		fields:      true,
+	},
+	"GET /v1/foos": {
+		id:          "listFoos",
+		summary:     "List the foos, in the order they were created.",
+		resp:        reflect.TypeFor[allsrvc.RespBody[[]allsrvc.Data[FooAttrs]]](),
+		successCode: http.StatusOK,
+		errKinds:    []errors.Kind{ErrKindInvalid},
+		includes:    true,
+		params: []OpenAPIParam{{
+			Name:        labelsParam,
+			In:          "query",
+			Description: "Comma separated label selector requirements the foos must meet (i.e. env=prod,team!=infra,tier in (a,b),owner,!deprecated).",
+			Schema:      map[string]any{"type": "string"},
+		}, {
+			Name:        metadataParamPrefix + "{path}]",
+			In:          "query",
+			Description: "The value the metadata of the foos must have at the dot separated path (i.e. filter[metadata.owner.team]=infra). A string is compared by its value, and a number or boolean by its JSON text. May be provided for many paths.",
+			Schema:      map[string]any{"type": "string"},
+		}},
+	},
+	"GET /v1/foos/{id}": {
+		id:          "readFoo",
+		summary:     "Read a foo by its id.",
+		resp:        reflect.TypeFor[allsrvc.RespBody[FooAttrs]](),
+		successCode: http.StatusOK,
+		errKinds:    []errors.Kind{ErrKindInvalid, ErrKindNotFound},
+		fields:      true,
+		conditional: true,
+		includes:    true,
+	},
+	"PATCH /v1/foos/{id}": {
+		id:          "updateFoo",
+		summary:     "Update the provided attributes of a foo.",
+		reqBody:     reflect.TypeFor[allsrvc.ReqBody[FooUpdAttrs]](),
+		resp:        reflect.TypeFor[allsrvc.RespBody[FooAttrs]](),
+		successCode: http.StatusOK,
+		errKinds:    []errors.Kind{ErrKindInvalid, ErrKindNotFound, ErrKindExists},
+		fields:      true,
+	},
+	"DELETE /v1/foos/{id}": {
+		id:          "deleteFoo",
+		summary:     "Delete a foo by its id. A foo that another foo relates to is not deleted.",
+		resp:        reflect.TypeFor[allsrvc.RespBody[any]](),
+		successCode: http.StatusOK,
+		errKinds:    []errors.Kind{ErrKindInvalid, ErrKindNotFound, ErrKindExists},
+	},
+	"POST /v1/admin/backups": {
+		id:          "createBackup",
+		summary:     "Make an online backup of the sqlite db.",
+		resp:        reflect.TypeFor[allsrvc.RespBody[BackupAttrs]](),
+		successCode: http.StatusCreated,
+	},
+	"GET /v1/admin/backups": {
+		id:          "listBackups",
+		summary:     "List the retained backups of the sqlite db, newest first.",
+		resp:        reflect.TypeFor[allsrvc.RespBody[[]BackupAttrs]](),
+		successCode: http.StatusOK,
+	},
+	"GET /v1/admin/chaos": {
+		id:          "readChaos",
+		summary:     "Read the seed and the fault injection rules of the chaos.",
+		resp:        reflect.TypeFor[allsrvc.RespBody[ChaosAttrs]](),
+		successCode: http.StatusOK,
+	},
+	"PUT /v1/admin/chaos": {
+		id:          "setChaos",
+		summary:     "Replace the fault injection rules of the chaos, restarting its rolls from the seed when provided.",
+		reqBody:     reflect.TypeFor[allsrvc.ReqBody[ChaosAttrs]](),
+		resp:        reflect.TypeFor[allsrvc.RespBody[ChaosAttrs]](),
+		successCode: http.StatusOK,
+		errKinds:    []errors.Kind{ErrKindInvalid},
+	},
+	"POST /v1/foos/{id}/attachments": {
+		id:          "createAttachment",
+		summary:     "Upload an attachment of a foo. The request body is the content of the attachment.",
+		resp:        reflect.TypeFor[allsrvc.RespBody[AttachmentAttrs]](),
+		binaryBody:  true,
+		successCode: http.StatusCreated,
+		errKinds:    []errors.Kind{ErrKindInvalid, ErrKindNotFound, ErrKindExists},
+		params: []OpenAPIParam{{
+			Name:        "Content-Disposition",
+			In:          "header",
+			Description: "The filename of the attachment (i.e. attachment; filename=\"config.yaml\").",
+			Required:    true,
+			Schema:      map[string]any{"type": "string"},
+		}},
+	},
+	"GET /v1/foos/{id}/attachments": {
+		id:          "listAttachments",
+		summary:     "List the attachments of a foo, in the order they were created.",
+		resp:        reflect.TypeFor[allsrvc.RespBody[[]allsrvc.Data[AttachmentAttrs]]](),
+		successCode: http.StatusOK,
+		errKinds:    []errors.Kind{ErrKindInvalid, ErrKindNotFound},
+	},
+	"GET /v1/foos/{id}/attachments/{attachment_id}": {
+		id:          "downloadAttachment",
+		summary:     "Download the content of an attachment of a foo.",
+		binaryResp:  true,
+		successCode: http.StatusOK,
+		errKinds:    []errors.Kind{ErrKindInvalid, ErrKindNotFound},
+		params: []OpenAPIParam{{
+			Name:        "If-None-Match",
+			In:          "header",
+			Description: "The ETag of a previous download. A 304 is returned when the content matches.",
+			Schema:      map[string]any{"type": "string"},
+		}},
+	},
+	"DELETE /v1/foos/{id}/attachments/{attachment_id}": {
+		id:          "deleteAttachment",
+		summary:     "Delete an attachment of a foo.",
+		resp:        reflect.TypeFor[allsrvc.RespBody[any]](),
+		successCode: http.StatusOK,
+		errKinds:    []errors.Kind{ErrKindInvalid, ErrKindNotFound},
+	},
+	"GET /v1/foos/{id}/relationships/{name}": {
+		id:          "readRelationship",
+		summary:     "Read the resource linkage of a relationship of a foo.",
+		resp:        reflect.TypeFor[RelationshipBody](),
+		successCode: http.StatusOK,
+		errKinds:    []errors.Kind{ErrKindInvalid, ErrKindNotFound},
+	},
+	"PATCH /v1/foos/{id}/relationships/{name}": {
+		id:          "replaceRelationship",
+		summary:     "Replace the related foos of a relationship of a foo.",
+		reqBody:     reflect.TypeFor[RelationshipReqBody](),
+		resp:        reflect.TypeFor[RelationshipBody](),
+		successCode: http.StatusOK,
+		errKinds:    []errors.Kind{ErrKindInvalid, ErrKindNotFound},
+	},
+	"POST /v1/foos/{id}/relationships/{name}": {
+		id:          "addRelationship",
+		summary:     "Add related foos to a to-many relationship of a foo.",
+		reqBody:     reflect.TypeFor[RelationshipReqBody](),
+		resp:        reflect.TypeFor[RelationshipBody](),
+		successCode: http.StatusOK,
+		errKinds:    []errors.Kind{ErrKindInvalid, ErrKindNotFound},
+	},
+	"DELETE /v1/foos/{id}/relationships/{name}": {
+		id:          "removeRelationship",
+		summary:     "Remove related foos from a to-many relationship of a foo.",
+		reqBody:     reflect.TypeFor[RelationshipReqBody](),
+		resp:        reflect.TypeFor[RelationshipBody](),
+		successCode: http.StatusOK,
+		errKinds:    []errors.Kind{ErrKindInvalid, ErrKindNotFound},
+	},
+	"GET /v1/openapi.json": {
+		id:          "openAPI",
+		summary:     "The OpenAPI document of the API.",
+		resp:        reflect.TypeFor[map[string]any](),
+		successCode: http.StatusOK,
+	},
+}
+
+// OpenAPIDoc is an OpenAPI 3.1 document.
+type OpenAPIDoc struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       OpenAPIInfo                     `json:"info"`
+	Paths      map[string]map[string]OpenAPIOp `json:"paths"`
+	Components OpenAPIComponents               `json:"components"`
+	Security   []map[string][]string           `json:"security,omitempty"`
+}
+
+// OpenAPIInfo is the metadata of the API.
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIOp is a single operation of a path.
+type OpenAPIOp struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Parameters  []OpenAPIParam             `json:"parameters,omitempty"`
+	RequestBody *OpenAPIBody               `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+}
+
+// OpenAPIParam is a parameter of an operation.
+type OpenAPIParam struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      map[string]any `json:"schema"`
+}
+
+// OpenAPIBody is the request body of an operation.
+type OpenAPIBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIResponse is a response of an operation.
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIMediaType is the schema of a media type.
+type OpenAPIMediaType struct {
+	Schema map[string]any `json:"schema"`
+}
+
+// OpenAPIComponents are the reusable schemas and security schemes of the document.
+type OpenAPIComponents struct {
+	Schemas         map[string]map[string]any `json:"schemas"`
+	SecuritySchemes map[string]map[string]any `json:"securitySchemes,omitempty"`
+}
+
+// Routes provides the patterns of the registered routes.
+func (s *ServerV2) Routes() []string {
+	return slices.Clone(s.patterns)
+}
+
+// OpenAPI provides the OpenAPI document built from the registered routes.
+func (s *ServerV2) OpenAPI() OpenAPIDoc {
+	doc := OpenAPIDoc{
+		OpenAPI: "3.1.0",
+		Info: OpenAPIInfo{
+			Title:   "allsrv",
+			Version: "v1",
+		},
+		Paths: make(map[string]map[string]OpenAPIOp),
+		Components: OpenAPIComponents{
+			Schemas: make(map[string]map[string]any),
+		},
+	}
+	if s.authed {
+		doc.Components.SecuritySchemes = map[string]map[string]any{
+			"basicAuth": {"type": "http", "scheme": "basic"},
+		}
+		doc.Security = []map[string][]string{{"basicAuth": {}}}
+	}
+
+	gen := schemaGen{schemas: doc.Components.Schemas}
+	for _, pattern := range s.patterns {
+		op, ok := apiOps[pattern]
+		if !ok {
+			continue
+		}
+		method, path, _ := strings.Cut(pattern, " ")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]OpenAPIOp)
+		}
+		doc.Paths[path][strings.ToLower(method)] = s.openAPIOp(gen, path, op)
+	}
+
+	return doc
+}
+
+func (s *ServerV2) openAPIOp(gen schemaGen, path string, op apiOp) OpenAPIOp {
+	out := OpenAPIOp{
+		OperationID: op.id,
+		Summary:     op.summary,
+		Responses:   make(map[string]OpenAPIResponse),
+	}
+
+	for _, seg := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(seg, "{"); ok {
+			out.Parameters = append(out.Parameters, OpenAPIParam{
+				Name:     strings.TrimSuffix(name, "}"),
+				In:       "path",
+				Required: true,
+				Schema:   map[string]any{"type": "string"},
+			})
+		}
+	}
+	if op.fields {
+		valid := attrFields(reflect.TypeFor[FooAttrs]())
+		out.Parameters = append(out.Parameters, OpenAPIParam{
+			Name:        fieldsParam(resourceTypeFoo),
+			In:          "query",
+			Description: "Comma separated attributes of the foo to provide. Valid fields are: " + strings.Join(valid, ", "),
+			Schema:      map[string]any{"type": "string"},
+		})
+	}
+
+	if op.includes && s.relationships != nil {
+		var valid []string
+		for _, def := range s.relationships.Defs() {
+			valid = append(valid, def.Name)
+		}
+		out.Parameters = append(out.Parameters, OpenAPIParam{
+			Name:        includeParam,
+			In:          "query",
+			Description: "Comma separated relationships of the foos to include the related foos of, in a compound document. Valid relationships are: " + strings.Join(valid, ", "),
+			Schema:      map[string]any{"type": "string"},
+		})
+	}
+
+	out.Parameters = append(out.Parameters, op.params...)
+
+	if op.conditional {
+		out.Parameters = append(out.Parameters,
+			OpenAPIParam{
+				Name:        "If-None-Match",
+				In:          "header",
+				Description: "The ETag of a previous response. A 304 is returned when the representation matches.",
+				Schema:      map[string]any{"type": "string"},
+			},
+			OpenAPIParam{
+				Name:        "If-Modified-Since",
+				In:          "header",
+				Description: "The Last-Modified of a previous response. Ignored when If-None-Match is provided.",
+				Schema:      map[string]any{"type": "string"},
+			},
+		)
+		out.Responses[strconv.Itoa(http.StatusNotModified)] = OpenAPIResponse{Description: "The representation has not been modified."}
+	}
+
+	respErrs := gen.schema(reflect.TypeFor[allsrvc.RespBody[any]]())
+	errResp := func(desc string) OpenAPIResponse {
+		return OpenAPIResponse{Description: desc, Content: s.content(respErrs)}
+	}
+
+	if op.reqBody != nil {
+		out.RequestBody = &OpenAPIBody{
+			Required: true,
+			Content:  s.content(gen.schema(op.reqBody)),
+		}
+		out.Responses[strconv.Itoa(http.StatusUnsupportedMediaType)] = errResp("The request body media type is not supported.")
+		out.Responses[strconv.Itoa(http.StatusUnprocessableEntity)] = errResp("The request body is of the wrong resource type.")
+	}
+	if op.binaryBody {
+		out.RequestBody = &OpenAPIBody{Required: true, Content: binaryContent()}
+		out.Responses[strconv.Itoa(http.StatusRequestEntityTooLarge)] = errResp("The request body is too large.")
+	}
+
+	success := OpenAPIResponse{Description: http.StatusText(op.successCode)}
+	if op.binaryResp {
+		success.Content = binaryContent()
+	} else {
+		success.Content = s.content(gen.schema(op.resp))
+	}
+	out.Responses[strconv.Itoa(op.successCode)] = success
+	for _, kind := range op.errKinds {
+		status := strconv.Itoa(errStatus(kind))
+		if existing, ok := out.Responses[status]; ok {
+			existing.Description += " Or the foo is " + string(kind) + "."
+			out.Responses[status] = existing
+			continue
+		}
+		out.Responses[status] = errResp("The foo is " + string(kind) + ".")
+	}
+	if s.authed {
+		out.Responses[strconv.Itoa(errStatus(ErrKindUnAuthed))] = errResp("The request is unauthorized.")
+	}
+	out.Responses[strconv.Itoa(http.StatusNotAcceptable)] = errResp("None of the accepted media types are supported.")
+	out.Responses[strconv.Itoa(errStatus(ErrKindInternal))] = errResp("An unexpected error occurred.")
+
+	return out
+}
+
+func (s *ServerV2) content(schema map[string]any) map[string]OpenAPIMediaType {
+	out := make(map[string]OpenAPIMediaType)
+	for _, mediaType := range s.codecs.mediaTypes() {
+		out[mediaType] = OpenAPIMediaType{Schema: schema}
+	}
+	return out
+}
+
+// binaryContent is the content of raw bytes of any media type.
+func binaryContent() map[string]OpenAPIMediaType {
+	return map[string]OpenAPIMediaType{
+		"*/*": {Schema: map[string]any{"type": "string", "format": "binary"}},
+	}
+}
+
+func (s *ServerV2) openAPI(w http.ResponseWriter, r *http.Request) {
+	writeResp(r.Context(), w, http.StatusOK, s.OpenAPI())
+}
+
+// schemaGen generates the JSON schema of a type. Named types are added to
+// the component schemas and referenced, while generic types are inlined.
+type schemaGen struct {
+	schemas map[string]map[string]any
+}
+
+func (g schemaGen) schema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "" && !strings.Contains(t.Name(), "["):
+		if _, ok := g.schemas[t.Name()]; !ok {
+			g.schemas[t.Name()] = nil // guards against recursive types
+			g.schemas[t.Name()] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return g.object(t)
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
+func (g schemaGen) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	var required []string
+	for _, f := range jsonFields(t) {
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	out := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return out
+}
@@ -0,0 +1,328 @@
+package allsrv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jsteenb2/errors"
+
+	"github.com/jsteenb2/allsrvc"
+)
+
+// includeParam is the query parameter of the relationships of the foos that
+// are included in the compound document of a read.
+const includeParam = "include"
+
+// WithRelationships registers the routes of the ServerV2 for reading and
+// writing the relationships of foos, and supports the include query parameter
+// of foo reads.
+func WithRelationships(r *Relationships) SvrOptFn {
+	return func(o *serverOpts) {
+		o.relationships = r
+	}
+}
+
+// ResourceIdentifier identifies a resource of the resource linkage of a
+// relationship.
+type ResourceIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// RelationshipReqBody is the request body of a relationship write. Its data
+// is the resource linkage of the relationship.
+type RelationshipReqBody struct {
+	Data any `json:"data"`
+}
+
+// RelationshipBody is the document of a relationship. Its data is the resource
+// linkage of the relationship: a ResourceIdentifier, or null, for a to-one
+// relationship, and a list of ResourceIdentifiers for a to-many relationship.
+type RelationshipBody struct {
+	Meta allsrvc.RespMeta  `json:"meta"`
+	Errs []allsrvc.RespErr `json:"errors,omitempty"`
+	Data any               `json:"data"`
+}
+
+// CompoundBody is the compound document of a foo read with the include query
+// parameter. The data is the resource of the read, with the linkage of the
+// included relationships of every foo, and the related foos are included.
+type CompoundBody struct {
+	Meta     allsrvc.RespMeta               `json:"meta"`
+	Errs     []allsrvc.RespErr              `json:"errors,omitempty"`
+	Data     map[string]any                 `json:"data,omitempty"`
+	Included []allsrvc.Data[map[string]any] `json:"included"`
+}
+
+// relationshipV1 provides the handler of a relationship of the foo. The fn is
+// provided the related foo ids of the request body, when the request has one,
+// and returns the related foo ids of the relationship.
+func (s *ServerV2) relationshipV1(successCode int, withBody bool, fn func(ctx context.Context, fooID, name string, relatedIDs []string) ([]string, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeErrs := func(errs ...allsrvc.RespErr) {
+			status := errs[0].Status
+			for _, e := range errs[1:] {
+				status = max(status, e.Status)
+			}
+			writeResp(r.Context(), w, status, allsrvc.RespBody[any]{
+				Meta: getMeta(r.Context()),
+				Errs: errs,
+			})
+		}
+
+		def, err := s.relationships.Def(r.PathValue("name"))
+		if err != nil {
+			writeErrs(toRespErr(err))
+			return
+		}
+
+		var relatedIDs []string
+		if withBody {
+			var reqBody RelationshipReqBody
+			if err := getReqCodec(r.Context()).Decode(r.Body, &reqBody); err != nil {
+				writeErrs(allsrvc.RespErr{
+					Status: http.StatusBadRequest,
+					Code:   errCode(ErrKindInvalid),
+					Msg:    "failed to decode request body: " + err.Error(),
+					Source: &allsrvc.RespErrSource{Pointer: "/data"},
+				})
+				return
+			}
+			ids, respErr := linkageIDs(def, reqBody.Data)
+			if respErr != nil {
+				writeErrs(*respErr)
+				return
+			}
+			relatedIDs = ids
+		}
+
+		relatedIDs, err = fn(r.Context(), r.PathValue("id"), def.Name, relatedIDs)
+		if err != nil {
+			var errs []allsrvc.RespErr
+			for _, err := range disjoin(err) {
+				errs = append(errs, toRespErr(err))
+			}
+			writeErrs(errs...)
+			return
+		}
+
+		writeResp(r.Context(), w, successCode, RelationshipBody{
+			Meta: getMeta(r.Context()),
+			Data: toLinkage(def, relatedIDs),
+		})
+	})
+}
+
+// linkageIDs provides the ids of the foos of the resource linkage. A to-many
+// relationship takes a list of resource identifiers, and a to-one relationship
+// takes a resource identifier or null.
+func linkageIDs(def Relationship, data any) ([]string, *allsrvc.RespErr) {
+	invalid := func(status int, pointer, msg string) *allsrvc.RespErr {
+		return &allsrvc.RespErr{
+			Status: status,
+			Code:   errCode(ErrKindInvalid),
+			Msg:    msg,
+			Source: &allsrvc.RespErrSource{Pointer: pointer},
+		}
+	}
+
+	var (
+		idents   []any
+		pointers []string
+	)
+	switch v := data.(type) {
+	case nil:
+		if def.ToMany {
+			return nil, invalid(http.StatusBadRequest, "/data", "data must be a list of resource identifiers for the to-many relationship "+def.Name)
+		}
+	case []any:
+		if !def.ToMany {
+			return nil, invalid(http.StatusBadRequest, "/data", "data must be a resource identifier or null for the to-one relationship "+def.Name)
+		}
+		idents = v
+		for i := range v {
+			pointers = append(pointers, "/data/"+strconv.Itoa(i))
+		}
+	default:
+		if def.ToMany {
+			return nil, invalid(http.StatusBadRequest, "/data", "data must be a list of resource identifiers for the to-many relationship "+def.Name)
+		}
+		idents, pointers = []any{v}, []string{"/data"}
+	}
+
+	ids := make([]string, 0, len(idents))
+	for i, ident := range idents {
+		m, _ := ident.(map[string]any)
+		if typ, _ := m["type"].(string); typ != resourceTypeFoo {
+			return nil, invalid(http.StatusUnprocessableEntity, pointers[i]+"/type", "type must be "+resourceTypeFoo)
+		}
+		id, _ := m["id"].(string)
+		if id == "" {
+			return nil, invalid(http.StatusBadRequest, pointers[i]+"/id", "id is required")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// toLinkage provides the resource linkage of the related foos.
+func toLinkage(def Relationship, relatedIDs []string) any {
+	idents := make([]ResourceIdentifier, 0, len(relatedIDs))
+	for _, id := range relatedIDs {
+		idents = append(idents, ResourceIdentifier{Type: resourceTypeFoo, ID: id})
+	}
+	if def.ToMany {
+		return idents
+	}
+	if len(idents) == 0 {
+		return nil
+	}
+	return idents[0]
+}
+
+// withIncludes provides the relationships of the include query parameter to
+// the read, which then responds with a compound document. Only the
+// relationships of the foos of the read are included, the relationships of
+// the included foos are not followed.
+func (s *ServerV2) withIncludes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := r.URL.Query().Get(includeParam)
+		if raw == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		respErr := allsrvc.RespErr{
+			Status: http.StatusBadRequest,
+			Code:   errCode(ErrKindInvalid),
+			Source: &allsrvc.RespErrSource{Parameter: includeParam},
+		}
+		if s.relationships == nil {
+			respErr.Msg = includeParam + " is not supported"
+			writeResp(r.Context(), w, respErr.Status, allsrvc.RespBody[any]{
+				Meta: getMeta(r.Context()),
+				Errs: []allsrvc.RespErr{respErr},
+			})
+			return
+		}
+
+		inc := includes{rels: s.relationships, svc: s.svc}
+		for _, name := range strings.Split(raw, ",") {
+			def, err := s.relationships.Def(strings.TrimSpace(name))
+			if err != nil {
+				var valid []string
+				for _, def := range s.relationships.Defs() {
+					valid = append(valid, def.Name)
+				}
+				respErr.Msg = "invalid " + includeParam + " relationship provided: " + name + "; valid relationships are: " + strings.Join(valid, ", ")
+				writeResp(r.Context(), w, respErr.Status, allsrvc.RespBody[any]{
+					Meta: getMeta(r.Context()),
+					Errs: []allsrvc.RespErr{respErr},
+				})
+				return
+			}
+			inc.defs = append(inc.defs, def)
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyIncludes, &inc)))
+	})
+}
+
+func getIncludes(ctx context.Context) *includes {
+	inc, _ := ctx.Value(ctxKeyIncludes).(*includes)
+	return inc
+}
+
+// includes are the relationships included in the compound document of a read.
+type includes struct {
+	rels *Relationships
+	svc  SVC
+	defs []Relationship
+}
+
+// compound provides the compound document of the resource of a read. The
+// resource is either a foo, or a list of foos as its attributes.
+func (inc *includes) compound(ctx context.Context, data any) (CompoundBody, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return CompoundBody{}, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return CompoundBody{}, err
+	}
+
+	resources := []map[string]any{doc}
+	if items, ok := doc["attributes"].([]any); ok {
+		resources = resources[:0]
+		for _, item := range items {
+			if m, ok := item.(map[string]any); ok {
+				resources = append(resources, m)
+			}
+		}
+	}
+
+	var primaryIDs, includedIDs []string
+	for _, res := range resources {
+		if typ, _ := res["type"].(string); typ != resourceTypeFoo {
+			continue
+		}
+		id, _ := res["id"].(string)
+		primaryIDs = append(primaryIDs, id)
+
+		rels := make(map[string]any, len(inc.defs))
+		for _, def := range inc.defs {
+			relatedIDs, err := inc.rels.Related(ctx, id, def.Name)
+			if err != nil && !errors.Is(err, ErrKindNotFound) { // a foo deleted since the read has no relationships
+				return CompoundBody{}, err
+			}
+			rels[def.Name] = map[string]any{"data": toLinkage(def, relatedIDs)}
+			for _, relatedID := range relatedIDs {
+				if !slices.Contains(includedIDs, relatedID) {
+					includedIDs = append(includedIDs, relatedID)
+				}
+			}
+		}
+		res["relationships"] = rels
+	}
+
+	out := CompoundBody{
+		Data:     doc,
+		Included: make([]allsrvc.Data[map[string]any], 0, len(includedIDs)),
+	}
+	fields := getSparseFields(ctx)
+	for _, id := range includedIDs {
+		if slices.Contains(primaryIDs, id) {
+			continue // a foo is provided once, a primary foo is not included
+		}
+		f, err := inc.svc.ReadFoo(ctx, id, WithReadFields(fields...))
+		if errors.Is(err, ErrKindNotFound) {
+			continue
+		}
+		if err != nil {
+			return CompoundBody{}, err
+		}
+		out.Included = append(out.Included, FooToSparseData(f, fields...))
+	}
+	return out, nil
+}
+
+func (s *ServerV2) readRelationshipV1(ctx context.Context, fooID, name string, _ []string) ([]string, error) {
+	return s.relationships.Related(ctx, fooID, name)
+}
+
+func (s *ServerV2) setRelationshipV1(ctx context.Context, fooID, name string, relatedIDs []string) ([]string, error) {
+	return s.relationships.SetRelated(ctx, fooID, name, relatedIDs)
+}
+
+func (s *ServerV2) addRelationshipV1(ctx context.Context, fooID, name string, relatedIDs []string) ([]string, error) {
+	return s.relationships.AddRelated(ctx, fooID, name, relatedIDs)
+}
+
+func (s *ServerV2) delRelationshipV1(ctx context.Context, fooID, name string, relatedIDs []string) ([]string, error) {
+	return s.relationships.DelRelated(ctx, fooID, name, relatedIDs)
+}
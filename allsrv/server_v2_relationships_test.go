@@ -0,0 +1,323 @@
+package allsrv_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/allsrvc"
+
+	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
+)
+
+func TestServerV2Relationships(t *testing.T) {
+	start := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
+
+	newSvr := func(t *testing.T) *allsrv.ServerV2 {
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(
+			allsrv.Foo{ID: "1", Name: "first-foo", CreatedAt: start, UpdatedAt: start},
+			allsrv.Foo{ID: "2", Name: "second-foo", CreatedAt: start, UpdatedAt: start},
+			allsrv.Foo{ID: "3", Name: "third-foo", CreatedAt: start, UpdatedAt: start},
+		)(t, db)
+
+		return allsrv.NewServerV2(allsrv.NewService(db),
+			allsrv.WithBasicAuthV2("dodgers@stink.com", "PaSsWoRd"),
+			allsrv.WithRelationships(allsrv.NewRelationships(db)),
+		)
+	}
+
+	writeRel := func(method, target string, data any) *http.Request {
+		return newJSONReq(method, target,
+			newJSONBody(t, allsrv.RelationshipReqBody{Data: data}),
+			withBasicAuth("dodgers@stink.com", "PaSsWoRd"),
+		)
+	}
+
+	fooIdent := func(id string) allsrv.ResourceIdentifier {
+		return allsrv.ResourceIdentifier{Type: "foo", ID: id}
+	}
+
+	expectLinkage := func(t *testing.T, rec *httptest.ResponseRecorder, want any) {
+		t.Helper()
+
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		expectJSONBody(t, rec.Body, func(t *testing.T, got struct {
+			Errs []allsrvc.RespErr `json:"errors"`
+			Data any               `json:"data"`
+		}) {
+			assert.Empty(t, got.Errs)
+			assert.Equal(t, want, got.Data)
+		})
+	}
+
+	t.Run("to-one relationship should be replaced and cleared", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, writeRel("PATCH", "/v1/foos/2/relationships/parent", fooIdent("1")))
+		expectLinkage(t, rec, map[string]any{"type": "foo", "id": "1"})
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1/relationships/children", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		expectLinkage(t, rec, []any{map[string]any{"type": "foo", "id": "2"}})
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, writeRel("PATCH", "/v1/foos/2/relationships/parent", nil))
+		expectLinkage(t, rec, nil)
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1/relationships/children", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		expectLinkage(t, rec, []any{})
+	})
+
+	t.Run("to-many relationship should add and remove related foos", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, writeRel("POST", "/v1/foos/1/relationships/depends_on", []allsrv.ResourceIdentifier{fooIdent("2"), fooIdent("3")}))
+		expectLinkage(t, rec, []any{
+			map[string]any{"type": "foo", "id": "2"},
+			map[string]any{"type": "foo", "id": "3"},
+		})
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, writeRel("DELETE", "/v1/foos/1/relationships/depends_on", []allsrv.ResourceIdentifier{fooIdent("2")}))
+		expectLinkage(t, rec, []any{map[string]any{"type": "foo", "id": "3"}})
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1/relationships/depends_on", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		expectLinkage(t, rec, []any{map[string]any{"type": "foo", "id": "3"}})
+	})
+
+	t.Run("parent forming a cycle should fail", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, writeRel("PATCH", "/v1/foos/2/relationships/parent", fooIdent("1")))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, writeRel("PATCH", "/v1/foos/1/relationships/parent", fooIdent("2")))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		expectErrs(t, rec.Body, allsrvc.RespErr{
+			Status: http.StatusBadRequest,
+			Code:   2,
+			Msg:    "relationship parent of foo 1 to foo 2 forms a cycle",
+		})
+	})
+
+	t.Run("deleting a foo with children should fail", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, writeRel("PATCH", "/v1/foos/2/relationships/parent", fooIdent("1")))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, del("/v1/foos/1", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		expectErrs(t, rec.Body, allsrvc.RespErr{
+			Status: http.StatusConflict,
+			Code:   1,
+			Msg:    "foo 1 is related to by the parent of foo 2",
+		})
+	})
+
+	t.Run("invalid linkage should fail", func(t *testing.T) {
+		tests := []struct {
+			name string
+			req  *http.Request
+			want allsrvc.RespErr
+		}{
+			{
+				name: "to-many with a resource identifier",
+				req:  writeRel("POST", "/v1/foos/1/relationships/depends_on", fooIdent("2")),
+				want: allsrvc.RespErr{
+					Status: http.StatusBadRequest,
+					Code:   2,
+					Msg:    "data must be a list of resource identifiers for the to-many relationship depends_on",
+					Source: &allsrvc.RespErrSource{Pointer: "/data"},
+				},
+			},
+			{
+				name: "to-one with a list",
+				req:  writeRel("PATCH", "/v1/foos/1/relationships/parent", []allsrv.ResourceIdentifier{fooIdent("2")}),
+				want: allsrvc.RespErr{
+					Status: http.StatusBadRequest,
+					Code:   2,
+					Msg:    "data must be a resource identifier or null for the to-one relationship parent",
+					Source: &allsrvc.RespErrSource{Pointer: "/data"},
+				},
+			},
+			{
+				name: "wrong resource type",
+				req:  writeRel("POST", "/v1/foos/1/relationships/depends_on", []allsrv.ResourceIdentifier{{Type: "bar", ID: "2"}}),
+				want: allsrvc.RespErr{
+					Status: http.StatusUnprocessableEntity,
+					Code:   2,
+					Msg:    "type must be foo",
+					Source: &allsrvc.RespErrSource{Pointer: "/data/0/type"},
+				},
+			},
+			{
+				name: "non-existent related foo",
+				req:  writeRel("POST", "/v1/foos/1/relationships/depends_on", []allsrv.ResourceIdentifier{fooIdent("9000")}),
+				want: allsrvc.RespErr{
+					Status: http.StatusNotFound,
+					Code:   3,
+					Msg:    "foo not found for id: 9000",
+				},
+			},
+			{
+				name: "unknown relationship",
+				req:  writeRel("PATCH", "/v1/foos/1/relationships/siblings", nil),
+				want: allsrvc.RespErr{
+					Status: http.StatusNotFound,
+					Code:   3,
+					Msg:    "relationship not found for name: siblings",
+				},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rec := httptest.NewRecorder()
+				newSvr(t).ServeHTTP(rec, tt.req)
+
+				assert.Equal(t, tt.want.Status, rec.Code)
+				expectErrs(t, rec.Body, tt.want)
+			})
+		}
+	})
+
+	type compoundDoc struct {
+		Data struct {
+			ID            string                    `json:"id"`
+			Attrs         any                       `json:"attributes"`
+			Relationships map[string]map[string]any `json:"relationships"`
+		} `json:"data"`
+		Included []allsrvc.Data[allsrv.FooAttrs] `json:"included"`
+	}
+
+	t.Run("read with include should provide a compound document", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, writeRel("PATCH", "/v1/foos/2/relationships/parent", fooIdent("1")))
+		require.Equal(t, http.StatusOK, rec.Code)
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, writeRel("POST", "/v1/foos/2/relationships/depends_on", []allsrv.ResourceIdentifier{fooIdent("3"), fooIdent("1")}))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/2?include=parent,depends_on", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		expectJSONBody(t, rec.Body, func(t *testing.T, got compoundDoc) {
+			assert.Equal(t, "2", got.Data.ID)
+			assert.Equal(t, map[string]map[string]any{
+				"parent": {"data": map[string]any{"type": "foo", "id": "1"}},
+				"depends_on": {"data": []any{
+					map[string]any{"type": "foo", "id": "3"},
+					map[string]any{"type": "foo", "id": "1"},
+				}},
+			}, got.Data.Relationships)
+
+			var included []string
+			for _, f := range got.Included {
+				included = append(included, f.ID)
+			}
+			assert.Equal(t, []string{"1", "3"}, included)
+			assert.Equal(t, "first-foo", got.Included[0].Attrs.Name)
+		})
+	})
+
+	t.Run("read with include should not be conditional on the modification of the foo", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/2?include=parent", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Empty(t, rec.Header().Get("Last-Modified"))
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, writeRel("PATCH", "/v1/foos/2/relationships/parent", fooIdent("1")))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		// relating the foos does not modify foo 2, but does change the document
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/2?include=parent",
+			withBasicAuth("dodgers@stink.com", "PaSsWoRd"),
+			withHeader("If-Modified-Since", start.Add(time.Hour).Format(http.TimeFormat)),
+		))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		expectJSONBody(t, rec.Body, func(t *testing.T, got compoundDoc) {
+			require.Len(t, got.Included, 1)
+			assert.Equal(t, "1", got.Included[0].ID)
+		})
+	})
+
+	t.Run("list with include should provide the relationships of every foo", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, writeRel("PATCH", "/v1/foos/2/relationships/parent", fooIdent("1")))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos?include=children", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		expectJSONBody(t, rec.Body, func(t *testing.T, got compoundDoc) {
+			foos, ok := got.Data.Attrs.([]any)
+			require.True(t, ok)
+			require.Len(t, foos, 3)
+
+			first, ok := foos[0].(map[string]any)
+			require.True(t, ok)
+			assert.Equal(t, map[string]any{
+				"children": map[string]any{"data": []any{map[string]any{"type": "foo", "id": "2"}}},
+			}, first["relationships"])
+
+			// the child is a primary foo, so it is not included
+			assert.Empty(t, got.Included)
+		})
+	})
+
+	t.Run("read with invalid include should fail", func(t *testing.T) {
+		svr := newSvr(t)
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1?include=siblings", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		expectErrs(t, rec.Body, allsrvc.RespErr{
+			Status: http.StatusBadRequest,
+			Code:   2,
+			Msg:    "invalid include relationship provided: siblings; valid relationships are: parent, children, depends_on",
+			Source: &allsrvc.RespErrSource{Parameter: "include"},
+		})
+	})
+
+	t.Run("read with include without relationships should fail", func(t *testing.T) {
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(allsrv.Foo{ID: "1", Name: "first-foo", CreatedAt: start, UpdatedAt: start})(t, db)
+		svr := allsrv.NewServerV2(allsrv.NewService(db))
+
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1?include=parent"))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		expectErrs(t, rec.Body, allsrvc.RespErr{
+			Status: http.StatusBadRequest,
+			Code:   2,
+			Msg:    "include is not supported",
+			Source: &allsrvc.RespErrSource{Parameter: "include"},
+		})
+	})
+}
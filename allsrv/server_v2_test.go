@@ -1,10 +1,15 @@
 package allsrv_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 	
@@ -29,6 +34,224 @@ func TestServerV2HttpClient(t *testing.T) {
 	})
 }
 
+func TestServerV2HttpClientCodecs(t *testing.T) {
+	codecs := []allsrv.Codec{
+		allsrv.JSONCodec(allsrv.MediaTypeJSONAPI),
+		allsrv.MsgPackCodec(),
+		allsrv.CBORCodec(),
+	}
+	for _, codec := range codecs {
+		t.Run(codec.MediaType(), func(t *testing.T) {
+			allsrvtesting.TestSVC(t, func(t *testing.T, opts allsrvtesting.SVCTestOpts) allsrvtesting.SVCDeps {
+				svc := allsrvtesting.NewInmemSVC(t, opts)
+				srv := httptest.NewServer(allsrv.NewServerV2(svc))
+				t.Cleanup(srv.Close)
+				
+				return allsrvtesting.SVCDeps{
+					SVC: allsrv.NewClientHTTP(srv.URL, "allsrv_test", &http.Client{Timeout: time.Second}, allsrv.WithClientCodec(codec)),
+				}
+			})
+		})
+	}
+}
+
+func TestServerV2ContentNegotiation(t *testing.T) {
+	start := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
+	
+	newSvr := func(t *testing.T) *allsrv.ServerV2 {
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(allsrv.Foo{
+			ID:        "1",
+			Name:      "first-foo",
+			Note:      "some note",
+			CreatedAt: start,
+			UpdatedAt: start,
+		})(t, db)
+		
+		return allsrv.NewServerV2(allsrv.NewService(db,
+			allsrv.WithSVCIDFn(allsrvtesting.IDGen(2, 1)),
+			allsrv.WithSVCNowFn(allsrvtesting.NowFn(start, time.Hour)),
+		))
+	}
+	
+	wantFoo := allsrvc.Data[allsrvc.ResourceFooAttrs]{
+		Type: "foo",
+		ID:   "1",
+		Attrs: allsrvc.ResourceFooAttrs{
+			Name:      "first-foo",
+			Note:      "some note",
+			CreatedAt: start.Format(time.RFC3339),
+			UpdatedAt: start.Format(time.RFC3339),
+		},
+	}
+	
+	t.Run("with accepted media type should respond with matching codec", func(t *testing.T) {
+		for _, codec := range allsrv.DefaultCodecs() {
+			t.Run(codec.MediaType(), func(t *testing.T) {
+				rec := httptest.NewRecorder()
+				newSvr(t).ServeHTTP(rec, get("/v1/foos/1", withAccept(codec.MediaType())))
+				
+				require.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, codec.MediaType(), rec.Header().Get("Content-Type"))
+				
+				var got allsrvc.RespBody[allsrvc.ResourceFooAttrs]
+				require.NoError(t, codec.Decode(rec.Body, &got))
+				require.NotNil(t, got.Data)
+				assert.Equal(t, wantFoo, *got.Data)
+			})
+		}
+	})
+	
+	t.Run("with weighted accept should respond with highest quality supported codec", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newSvr(t).ServeHTTP(rec, get("/v1/foos/1", withAccept("text/html, application/cbor;q=0.5, application/msgpack;q=0.9")))
+		
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, allsrv.MediaTypeMsgPack, rec.Header().Get("Content-Type"))
+	})
+	
+	t.Run("with wildcard accept should respond with json", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newSvr(t).ServeHTTP(rec, get("/v1/foos/1", withAccept("*/*")))
+		
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, allsrv.MediaTypeJSON, rec.Header().Get("Content-Type"))
+		expectData[allsrvc.ResourceFooAttrs](t, rec.Body, wantFoo)
+	})
+	
+	t.Run("with unsupported accept should fail", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newSvr(t).ServeHTTP(rec, get("/v1/foos/1", withAccept("text/html")))
+		
+		assert.Equal(t, http.StatusNotAcceptable, rec.Code)
+		expectErrs(t, rec.Body, allsrvc.RespErr{
+			Status: http.StatusNotAcceptable,
+			Code:   2,
+			Msg:    "none of the accepted media types are supported; supported media types are: application/json, application/vnd.api+json, application/msgpack, application/cbor",
+			Source: &allsrvc.RespErrSource{
+				Header: "Accept",
+			},
+		})
+	})
+	
+	t.Run("with msgpack request body should pass", func(t *testing.T) {
+		codec := allsrv.MsgPackCodec()
+		
+		var body bytes.Buffer
+		require.NoError(t, codec.Encode(&body, allsrvc.ReqBody[allsrvc.FooCreateAttrs]{
+			Data: allsrvc.Data[allsrvc.FooCreateAttrs]{
+				Type: "foo",
+				Attrs: allsrvc.FooCreateAttrs{
+					Name: "second-foo",
+					Note: "packed",
+				},
+			},
+		}))
+		
+		rec := httptest.NewRecorder()
+		newSvr(t).ServeHTTP(rec, newReq("POST", "/v1/foos", &body, withContentType(allsrv.MediaTypeMsgPack)))
+		
+		require.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, allsrv.MediaTypeJSON, rec.Header().Get("Content-Type"))
+		expectData[allsrvc.ResourceFooAttrs](t, rec.Body, allsrvc.Data[allsrvc.ResourceFooAttrs]{
+			Type: "foo",
+			ID:   "2",
+			Attrs: allsrvc.ResourceFooAttrs{
+				Name:      "second-foo",
+				Note:      "packed",
+				CreatedAt: start.Format(time.RFC3339),
+				UpdatedAt: start.Format(time.RFC3339),
+			},
+		})
+	})
+}
+
+func TestServerV2HttpClientFooFields(t *testing.T) {
+	start := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
+	
+	db := new(allsrv.InmemDB)
+	allsrvtesting.CreateFoos(allsrv.Foo{
+		ID:        "1",
+		Name:      "first-foo",
+		Note:      "some note",
+		CreatedAt: start,
+		UpdatedAt: start,
+	})(t, db)
+	
+	srv := httptest.NewServer(allsrv.NewServerV2(allsrv.NewService(db)))
+	t.Cleanup(srv.Close)
+	
+	client := allsrv.NewClientHTTP(srv.URL, "allsrv_test", &http.Client{Timeout: time.Second})
+	
+	got, err := client.ReadFoo(context.TODO(), "1", allsrv.WithReadFields("name"))
+	require.NoError(t, err)
+	
+	assert.Equal(t, allsrv.Foo{ID: "1", Name: "first-foo"}, got)
+}
+
+func TestServerV2SparseFields(t *testing.T) {
+	start := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
+	
+	t.Run("update with sqlite db should keep the fields not requested", func(t *testing.T) {
+		db := newSQLiteDB(t)
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+		allsrvtesting.CreateFoos(allsrv.Foo{
+			ID:        "1",
+			Name:      "first-foo",
+			Note:      "keep-me",
+			CreatedAt: start,
+			UpdatedAt: start,
+			ExpiresAt: expiresAt,
+			Labels:    map[string]string{"env": "prod"},
+			Metadata:  map[string]any{"region": "us"},
+		})(t, db)
+		svr := allsrv.NewServerV2(allsrv.NewService(db, allsrvtesting.DefaultSVCOpts(start)...))
+		
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, newJSONReq("PATCH", "/v1/foos/1?fields[foo]=name",
+			newJSONBody(t, allsrvc.ReqBody[allsrvc.FooUpdAttrs]{
+				Data: allsrvc.Data[allsrvc.FooUpdAttrs]{
+					Type:  "foo",
+					ID:    "1",
+					Attrs: allsrvc.FooUpdAttrs{Name: allsrvtesting.Ptr("new-name")},
+				},
+			}),
+		))
+		
+		require.Equal(t, http.StatusOK, rec.Code)
+		expectData[map[string]any](t, rec.Body, allsrvc.Data[map[string]any]{
+			Type:  "foo",
+			ID:    "1",
+			Attrs: map[string]any{"name": "new-name"},
+		})
+		
+		got, err := db.ReadFoo(context.TODO(), "1")
+		require.NoError(t, err)
+		assert.Equal(t, "new-name", got.Name)
+		assert.Equal(t, "keep-me", got.Note)
+		assert.Equal(t, expiresAt, got.ExpiresAt.UTC())
+		assert.Equal(t, map[string]string{"env": "prod"}, got.Labels)
+		assert.Equal(t, map[string]any{"region": "us"}, got.Metadata)
+	})
+	
+	t.Run("routes other than the foo reads and writes should ignore the fields", func(t *testing.T) {
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(allsrv.Foo{ID: "1", Name: "first-foo", CreatedAt: start, UpdatedAt: start})(t, db)
+		svr := allsrv.NewServerV2(allsrv.NewService(db), allsrv.WithBasicAuthV2("dodgers@stink.com", "PaSsWoRd"), allsrv.WithChaos(allsrv.NewChaos(1)))
+		
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/admin/chaos?fields[foo]=WRONGO", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, newReq("DELETE", "/v1/foos/1?fields[foo]=WRONGO", nil, withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		
+		_, err := db.ReadFoo(context.TODO(), "1")
+		require.Error(t, err)
+	})
+}
+
 func TestServerV2(t *testing.T) {
 	type (
 		inputs struct {
@@ -47,7 +270,7 @@ func TestServerV2(t *testing.T) {
 		}
 	)
 	
-	start := time.Time{}.Add(time.Hour).UTC()
+	start := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
 	
 	testSvr := func(t *testing.T, tt testCase) {
 		db := new(allsrv.InmemDB)
@@ -143,6 +366,24 @@ func TestServerV2(t *testing.T) {
 					require.Error(t, err)
 				},
 			},
+			{
+				name:    "when provided an unsupported media type and missing auth should fail with unsupported media type",
+				svrOpts: []allsrv.SvrOptFn{allsrv.WithBasicAuthV2("dodgers@stink.com", "PaSsWoRd")},
+				inputs: inputs{
+					req: newReq("POST", "/v1/foos", strings.NewReader("first-foo"),
+						withContentType("text/plain"),
+						withBasicAuth("dodgers@stink.com", "WRONGO"),
+					),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, db allsrv.DB) {
+					// the media type is checked ahead of auth
+					assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+					expectErrs(t, rec.Body, allsrvc.RespErr{
+						Code: http.StatusUnsupportedMediaType,
+						Msg:  "received invalid media type",
+					})
+				},
+			},
 			{
 				name:    "when creating foo with name that collides with existing should fail",
 				prepare: allsrvtesting.CreateFoos(allsrv.Foo{ID: "9000", Name: "existing-foo"}),
@@ -172,6 +413,189 @@ func TestServerV2(t *testing.T) {
 					require.Error(t, err)
 				},
 			},
+			{
+				name: "when creating foo with sparse fieldset should pass with only requested fields",
+				inputs: inputs{
+					req: newJSONReq("POST", "/v1/foos?fields[foo]=name", newJSONBody(t, allsrvc.ReqBody[allsrvc.FooCreateAttrs]{
+						Data: allsrvc.Data[allsrvc.FooCreateAttrs]{
+							Type: "foo",
+							Attrs: allsrvc.FooCreateAttrs{
+								Name: "first-foo",
+								Note: "some note",
+							},
+						},
+					})),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, db allsrv.DB) {
+					assert.Equal(t, http.StatusCreated, rec.Code)
+					expectData[map[string]any](t, rec.Body, allsrvc.Data[map[string]any]{
+						Type:  "foo",
+						ID:    "1",
+						Attrs: map[string]any{"name": "first-foo"},
+					})
+					
+					dbHasFoo(t, db, allsrv.Foo{
+						ID:        "1",
+						Name:      "first-foo",
+						Note:      "some note",
+						CreatedAt: start,
+						UpdatedAt: start,
+					})
+				},
+			},
+			{
+				name: "when creating foo with unnormalized name should pass with trimmed and composed name",
+				inputs: inputs{
+					req: newJSONReq("POST", "/v1/foos", newJSONBody(t, allsrvc.ReqBody[allsrvc.FooCreateAttrs]{
+						Data: allsrvc.Data[allsrvc.FooCreateAttrs]{
+							Type: "foo",
+							Attrs: allsrvc.FooCreateAttrs{
+								Name: "  cafe\u0301\t",
+								Note: "some note",
+							},
+						},
+					})),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, db allsrv.DB) {
+					assert.Equal(t, http.StatusCreated, rec.Code)
+					
+					dbHasFoo(t, db, allsrv.Foo{
+						ID:        "1",
+						Name:      "caf\u00e9",
+						Note:      "some note",
+						CreatedAt: start,
+						UpdatedAt: start,
+					})
+				},
+			},
+			{
+				name: "when creating foo with invalid attributes should fail with every violation",
+				inputs: inputs{
+					req: newJSONReq("POST", "/v1/foos", newJSONBody(t, allsrvc.ReqBody[allsrvc.FooCreateAttrs]{
+						Data: allsrvc.Data[allsrvc.FooCreateAttrs]{
+							Type: "foo",
+							Attrs: allsrvc.FooCreateAttrs{
+								Name: "not\x00valid",
+								Note: strings.Repeat("a", 4097),
+							},
+						},
+					})),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, db allsrv.DB) {
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+					expectErrs(t, rec.Body,
+						allsrvc.RespErr{
+							Status: http.StatusBadRequest,
+							Code:   2,
+							Msg:    "name must only contain printable characters",
+							Source: &allsrvc.RespErrSource{
+								Pointer: "/data/attributes/name",
+							},
+						},
+						allsrvc.RespErr{
+							Status: http.StatusBadRequest,
+							Code:   2,
+							Msg:    "note must be at most 4096 bytes",
+							Source: &allsrvc.RespErrSource{
+								Pointer: "/data/attributes/note",
+							},
+						},
+					)
+					
+					_, err := db.ReadFoo(context.TODO(), "1")
+					require.Error(t, err)
+				},
+			},
+			{
+				name: "when creating foo with expires_at should pass",
+				inputs: inputs{
+					req: newJSONReq("POST", "/v1/foos", newJSONBody(t, allsrvc.ReqBody[allsrv.FooCreateAttrs]{
+						Data: allsrvc.Data[allsrv.FooCreateAttrs]{
+							Type: "foo",
+							Attrs: allsrv.FooCreateAttrs{
+								FooCreateAttrs: allsrvc.FooCreateAttrs{Name: "first-foo"},
+								ExpiresAt:      "3000-01-01T00:00:00Z",
+							},
+						},
+					})),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, db allsrv.DB) {
+					assert.Equal(t, http.StatusCreated, rec.Code)
+					expectData[allsrv.FooAttrs](t, rec.Body, allsrvc.Data[allsrv.FooAttrs]{
+						Type: "foo",
+						ID:   "1",
+						Attrs: allsrv.FooAttrs{
+							ResourceFooAttrs: allsrvc.ResourceFooAttrs{
+								Name:      "first-foo",
+								CreatedAt: start.Format(time.RFC3339),
+								UpdatedAt: start.Format(time.RFC3339),
+							},
+							ExpiresAt: "3000-01-01T00:00:00Z",
+						},
+					})
+					
+					dbFoo, err := db.ReadFoo(context.TODO(), "1")
+					require.NoError(t, err)
+					assert.Equal(t, time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC), dbFoo.ExpiresAt)
+				},
+			},
+			{
+				name: "when creating foo with malformed expires_at should fail",
+				inputs: inputs{
+					req: newJSONReq("POST", "/v1/foos", newJSONBody(t, allsrvc.ReqBody[allsrv.FooCreateAttrs]{
+						Data: allsrvc.Data[allsrv.FooCreateAttrs]{
+							Type: "foo",
+							Attrs: allsrv.FooCreateAttrs{
+								FooCreateAttrs: allsrvc.FooCreateAttrs{Name: "first-foo"},
+								ExpiresAt:      "tomorrow",
+							},
+						},
+					})),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, db allsrv.DB) {
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+					expectErrs(t, rec.Body, allsrvc.RespErr{
+						Status: http.StatusBadRequest,
+						Code:   2,
+						Msg:    "expires_at must be an RFC3339 timestamp",
+						Source: &allsrvc.RespErrSource{
+							Pointer: "/data/attributes/expires_at",
+						},
+					})
+					
+					_, err := db.ReadFoo(context.TODO(), "1")
+					require.Error(t, err)
+				},
+			},
+			{
+				name:    "when creating foo with metadata violating the metadata schema should fail",
+				svcOpts: []func(*allsrv.Service){allsrv.WithSVCFooRules(metadataSchemaRules(t, `{"properties": {"region": {"enum": ["us", "eu"]}}}`))},
+				inputs: inputs{
+					req: newJSONReq("POST", "/v1/foos", newJSONBody(t, allsrvc.ReqBody[allsrv.FooCreateAttrs]{
+						Data: allsrvc.Data[allsrv.FooCreateAttrs]{
+							Type: "foo",
+							Attrs: allsrv.FooCreateAttrs{
+								FooCreateAttrs: allsrvc.FooCreateAttrs{Name: "first-foo"},
+								Metadata:       map[string]any{"region": "ap"},
+							},
+						},
+					})),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, db allsrv.DB) {
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+					expectErrs(t, rec.Body, allsrvc.RespErr{
+						Status: http.StatusBadRequest,
+						Code:   2,
+						Msg:    `metadata/region must be one of: "us", "eu"`,
+						Source: &allsrvc.RespErrSource{
+							Pointer: "/data/attributes/metadata/region",
+						},
+					})
+					
+					_, err := db.ReadFoo(context.TODO(), "1")
+					require.Error(t, err)
+				},
+			},
 			{
 				name: "when creating foo with invalid resource type should fail",
 				inputs: inputs{
@@ -262,6 +686,129 @@ func TestServerV2(t *testing.T) {
 					})
 				},
 			},
+			{
+				name: "with sparse fieldset for existing foo should pass with only requested fields",
+				prepare: allsrvtesting.CreateFoos(allsrv.Foo{
+					ID:        "1",
+					Name:      "first-foo",
+					Note:      "some note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				}),
+				inputs: inputs{
+					req: get("/v1/foos/1?fields[foo]=name,updated_at"),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, _ allsrv.DB) {
+					assert.Equal(t, http.StatusOK, rec.Code)
+					expectData[map[string]any](t, rec.Body, allsrvc.Data[map[string]any]{
+						Type: "foo",
+						ID:   "1",
+						Attrs: map[string]any{
+							"name":       "first-foo",
+							"updated_at": start.Format(time.RFC3339),
+						},
+					})
+				},
+			},
+			{
+				name: "with sparse fieldset containing invalid field should fail",
+				prepare: allsrvtesting.CreateFoos(allsrv.Foo{
+					ID:   "1",
+					Name: "first-foo",
+				}),
+				inputs: inputs{
+					req: get("/v1/foos/1?fields[foo]=name,WRONGO"),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, _ allsrv.DB) {
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+					expectErrs(t, rec.Body, allsrvc.RespErr{
+						Status: http.StatusBadRequest,
+						Code:   2,
+						Msg:    "invalid fields[foo] field provided: WRONGO; valid fields are: name, note, created_at, updated_at, expires_at, labels, metadata",
+					})
+				},
+			},
+			{
+				name: "with existing foo should provide the validators of the foo",
+				prepare: allsrvtesting.CreateFoos(allsrv.Foo{
+					ID:        "1",
+					Name:      "first-foo",
+					Note:      "some note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				}),
+				inputs: inputs{
+					req: get("/v1/foos/1"),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, _ allsrv.DB) {
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.NotEmpty(t, rec.Header().Get("ETag"))
+					assert.Equal(t, start.Format(http.TimeFormat), rec.Header().Get("Last-Modified"))
+				},
+			},
+			{
+				name: "with if modified since of the last modified should pass with not modified",
+				prepare: allsrvtesting.CreateFoos(allsrv.Foo{
+					ID:        "1",
+					Name:      "first-foo",
+					Note:      "some note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				}),
+				inputs: inputs{
+					req: get("/v1/foos/1", withHeader("If-Modified-Since", start.Format(http.TimeFormat))),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, _ allsrv.DB) {
+					assert.Equal(t, http.StatusNotModified, rec.Code)
+					assert.Empty(t, rec.Body.Bytes())
+				},
+			},
+			{
+				name: "with if modified since before the last modified should pass with the foo",
+				prepare: allsrvtesting.CreateFoos(allsrv.Foo{
+					ID:        "1",
+					Name:      "first-foo",
+					Note:      "some note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				}),
+				inputs: inputs{
+					req: get("/v1/foos/1", withHeader("If-Modified-Since", start.Add(-time.Hour).Format(http.TimeFormat))),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, _ allsrv.DB) {
+					assert.Equal(t, http.StatusOK, rec.Code)
+					expectData[allsrvc.ResourceFooAttrs](t, rec.Body, allsrvc.Data[allsrvc.ResourceFooAttrs]{
+						Type: "foo",
+						ID:   "1",
+						Attrs: allsrvc.ResourceFooAttrs{
+							Name:      "first-foo",
+							Note:      "some note",
+							CreatedAt: start.Format(time.RFC3339),
+							UpdatedAt: start.Format(time.RFC3339),
+						},
+					})
+				},
+			},
+			{
+				name: "with if none match of a stale etag should pass with the foo",
+				prepare: allsrvtesting.CreateFoos(allsrv.Foo{
+					ID:        "1",
+					Name:      "first-foo",
+					Note:      "some note",
+					CreatedAt: start,
+					UpdatedAt: start,
+				}),
+				inputs: inputs{
+					req: get("/v1/foos/1",
+						withHeader("If-None-Match", `"stale"`),
+						withHeader("If-Modified-Since", start.Format(http.TimeFormat)),
+					),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, _ allsrv.DB) {
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.NotEmpty(t, rec.Header().Get("ETag"))
+				},
+			},
 			{
 				name: "with request for non-existent foo should fail",
 				inputs: inputs{
@@ -526,6 +1073,352 @@ func TestServerV2(t *testing.T) {
 			})
 		}
 	})
+	
+	t.Run("foo list", func(t *testing.T) {
+		prepare := allsrvtesting.CreateFoos(
+			allsrv.Foo{ID: "1", Name: "first-foo", CreatedAt: start, UpdatedAt: start, Labels: map[string]string{"env": "prod"}},
+			allsrv.Foo{ID: "2", Name: "second-foo", CreatedAt: start, UpdatedAt: start, Labels: map[string]string{"env": "dev"}, Metadata: map[string]any{"owner": map[string]any{"team": "web"}, "replicas": 2.0}},
+		)
+		
+		tests := []testCase{
+			{
+				name:    "with label selector should provide the matching foos",
+				prepare: prepare,
+				inputs: inputs{
+					req: get("/v1/foos?filter[labels]=" + url.QueryEscape("env in (prod,staging)")),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, _ allsrv.DB) {
+					assert.Equal(t, http.StatusOK, rec.Code)
+					expectData[[]allsrvc.Data[allsrv.FooAttrs]](t, rec.Body, allsrvc.Data[[]allsrvc.Data[allsrv.FooAttrs]]{
+						Type: "foo",
+						Attrs: []allsrvc.Data[allsrv.FooAttrs]{
+							{
+								Type: "foo",
+								ID:   "1",
+								Attrs: allsrv.FooAttrs{
+									ResourceFooAttrs: allsrvc.ResourceFooAttrs{
+										Name:      "first-foo",
+										CreatedAt: start.Format(time.RFC3339),
+										UpdatedAt: start.Format(time.RFC3339),
+									},
+									Labels: map[string]string{"env": "prod"},
+								},
+							},
+						},
+					})
+				},
+			},
+			{
+				name:    "without label selector should provide every foo",
+				prepare: prepare,
+				inputs: inputs{
+					req: get("/v1/foos"),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, _ allsrv.DB) {
+					assert.Equal(t, http.StatusOK, rec.Code)
+					expectJSONBody(t, rec.Body, func(t *testing.T, got allsrvc.RespBody[[]allsrvc.Data[allsrv.FooAttrs]]) {
+						require.NotNil(t, got.Data)
+						require.Len(t, got.Data.Attrs, 2)
+						assert.Equal(t, "1", got.Data.Attrs[0].ID)
+						assert.Equal(t, "2", got.Data.Attrs[1].ID)
+					})
+				},
+			},
+			{
+				name: "with invalid label selector should fail",
+				inputs: inputs{
+					req: get("/v1/foos?filter[labels]=" + url.QueryEscape("env in (prod")),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, _ allsrv.DB) {
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+					expectErrs(t, rec.Body, allsrvc.RespErr{
+						Status: http.StatusBadRequest,
+						Code:   2,
+						Msg:    `invalid label selector requirement "env in (prod": set requirements must be of the form: key in (v1,v2) or key notin (v1,v2)`,
+						Source: &allsrvc.RespErrSource{
+							Parameter: "filter[labels]",
+						},
+					})
+				},
+			},
+			{
+				name:    "with metadata filters should provide the matching foos",
+				prepare: prepare,
+				inputs: inputs{
+					req: get("/v1/foos?filter[metadata.owner.team]=web&filter[metadata.replicas]=2"),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, _ allsrv.DB) {
+					assert.Equal(t, http.StatusOK, rec.Code)
+					expectData[[]allsrvc.Data[allsrv.FooAttrs]](t, rec.Body, allsrvc.Data[[]allsrvc.Data[allsrv.FooAttrs]]{
+						Type: "foo",
+						Attrs: []allsrvc.Data[allsrv.FooAttrs]{
+							{
+								Type: "foo",
+								ID:   "2",
+								Attrs: allsrv.FooAttrs{
+									ResourceFooAttrs: allsrvc.ResourceFooAttrs{
+										Name:      "second-foo",
+										CreatedAt: start.Format(time.RFC3339),
+										UpdatedAt: start.Format(time.RFC3339),
+									},
+									Labels:   map[string]string{"env": "dev"},
+									Metadata: map[string]any{"owner": map[string]any{"team": "web"}, "replicas": 2.0},
+								},
+							},
+						},
+					})
+				},
+			},
+			{
+				name: "with invalid metadata filter path should fail",
+				inputs: inputs{
+					req: get("/v1/foos?" + url.QueryEscape("filter[metadata.owner..team]") + "=web"),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, _ allsrv.DB) {
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+					expectErrs(t, rec.Body, allsrvc.RespErr{
+						Status: http.StatusBadRequest,
+						Code:   2,
+						Msg:    "invalid metadata path provided: owner..team; path keys must only contain letters, digits, '-', or '_'",
+						Source: &allsrvc.RespErrSource{
+							Parameter: "filter[metadata.owner..team]",
+						},
+					})
+				},
+			},
+			{
+				name: "with metadata filter provided more than once should fail",
+				inputs: inputs{
+					req: get("/v1/foos?filter[metadata.region]=us&filter[metadata.region]=eu"),
+				},
+				want: func(t *testing.T, rec *httptest.ResponseRecorder, _ allsrv.DB) {
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+					expectErrs(t, rec.Body, allsrvc.RespErr{
+						Status: http.StatusBadRequest,
+						Code:   2,
+						Msg:    "filter[metadata.region] must be provided once",
+						Source: &allsrvc.RespErrSource{
+							Parameter: "filter[metadata.region]",
+						},
+					})
+				},
+			},
+		}
+		
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				testSvr(t, tt)
+			})
+		}
+	})
+}
+
+func TestServerV2ConditionalRead(t *testing.T) {
+	start := time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC)
+	db := new(allsrv.InmemDB)
+	allsrvtesting.CreateFoos(allsrv.Foo{
+		ID:        "1",
+		Name:      "first-foo",
+		Note:      "some note",
+		CreatedAt: start,
+		UpdatedAt: start,
+	})(t, db)
+
+	svr := allsrv.NewServerV2(allsrv.NewService(db))
+
+	rec := httptest.NewRecorder()
+	svr.ServeHTTP(rec, get("/v1/foos/1"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	t.Run("with matching etag should pass with not modified", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1", withHeader("If-None-Match", etag)))
+
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Equal(t, etag, rec.Header().Get("ETag"))
+		assert.Empty(t, rec.Body.Bytes())
+	})
+
+	t.Run("with etag of a different representation should pass with the foo", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1?fields[foo]=name", withHeader("If-None-Match", etag)))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+	})
+
+	t.Run("with etag of a modified foo should pass with the foo", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, newJSONReq("PATCH", "/v1/foos/1", newJSONBody(t, allsrvc.ReqBody[allsrvc.FooUpdAttrs]{
+			Data: allsrvc.Data[allsrvc.FooUpdAttrs]{
+				Type:  "foo",
+				ID:    "1",
+				Attrs: allsrvc.FooUpdAttrs{Note: allsrvtesting.Ptr("new note")},
+			},
+		})))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1", withHeader("If-None-Match", etag)))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+	})
+}
+
+func TestServerV2OpenAPI(t *testing.T) {
+	svr := allsrv.NewServerV2(
+		allsrv.NewService(new(allsrv.InmemDB)),
+		allsrv.WithBasicAuthV2("dodgers@stink.com", "PaSsWoRd"),
+		allsrv.WithSQLiteBackups(allsrv.NewSQLiteBackups(nil, t.TempDir(), 1)),
+		allsrv.WithAttachments(allsrv.NewAttachments(new(allsrv.InmemDB), nil)),
+		allsrv.WithRelationships(allsrv.NewRelationships(new(allsrv.InmemDB))),
+	)
+
+	rec := httptest.NewRecorder()
+	svr.ServeHTTP(rec, get("/v1/openapi.json", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var doc allsrv.OpenAPIDoc
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&doc))
+
+	assert.Equal(t, "3.1.0", doc.OpenAPI)
+	assert.Contains(t, doc.Components.Schemas, "RespErr")
+	assert.Contains(t, doc.Components.SecuritySchemes, "basicAuth")
+
+	t.Run("every registered route should be documented", func(t *testing.T) {
+		routes := svr.Routes()
+		require.NotEmpty(t, routes)
+
+		for _, route := range routes {
+			method, path, _ := strings.Cut(route, " ")
+			ops, ok := doc.Paths[path]
+			if !assert.Truef(t, ok, "route %q is missing from the OpenAPI document", route) {
+				continue
+			}
+			op, ok := ops[strings.ToLower(method)]
+			if !assert.Truef(t, ok, "route %q is missing from the OpenAPI document", route) {
+				continue
+			}
+			assert.NotEmpty(t, op.OperationID)
+			assert.Contains(t, op.Responses, "401")
+			assert.Contains(t, op.Responses, "500")
+		}
+	})
+
+	t.Run("should document the error statuses of each operation", func(t *testing.T) {
+		read := doc.Paths["/v1/foos/{id}"]["get"]
+		assert.Contains(t, read.Responses, "200")
+		assert.Contains(t, read.Responses, "404")
+
+		create := doc.Paths["/v1/foos"]["post"]
+		require.NotNil(t, create.RequestBody)
+		assert.Contains(t, create.RequestBody.Content, "application/json")
+		for _, status := range []string{"201", "400", "409", "415", "422"} {
+			assert.Contains(t, create.Responses, status)
+		}
+	})
+}
+
+func TestServerV2AccessLog(t *testing.T) {
+	type logLine struct {
+		Level     string `json:"level"`
+		Method    string `json:"method"`
+		Route     string `json:"route"`
+		URLPath   string `json:"url_path"`
+		Status    int    `json:"status"`
+		RespSize  int    `json:"response_body_size"`
+		TookMS    int64  `json:"took_ms"`
+		TraceID   string `json:"trace_id"`
+		Origin    string `json:"origin"`
+		UserAgent string `json:"user_agent"`
+	}
+	
+	newSvr := func(t *testing.T, sampleRate float64, logs io.Writer) *allsrv.ServerV2 {
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(allsrv.Foo{ID: "1", Name: "first-foo"})(t, db)
+		
+		logger := slog.New(slog.NewJSONHandler(logs, nil))
+		return allsrv.NewServerV2(allsrv.NewService(db),
+			allsrv.WithBasicAuthV2("dodgers@stink.com", "PaSsWoRd"),
+			allsrv.WithAccessLog(logger, sampleRate),
+		)
+	}
+	
+	t.Run("with rejected auth should log regardless of sample rate", func(t *testing.T) {
+		var logs bytes.Buffer
+		svr := newSvr(t, 0, &logs)
+		
+		req := get("/v1/foos/1", withBasicAuth("dodgers@stink.com", "WRONGO"), func(r *http.Request) {
+			r.Header.Set("Origin", "allsrv_test")
+			r.Header.Set("User-Agent", "test-agent")
+			r.Header.Set("X-Mess-Trace-Id", "trace-1")
+		})
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, req)
+		
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		expectJSONBody(t, &logs, func(t *testing.T, got logLine) {
+			want := logLine{
+				Level:     "WARN",
+				Method:    "GET",
+				Route:     "GET /v1/foos/{id}",
+				URLPath:   "/v1/foos/1",
+				Status:    http.StatusUnauthorized,
+				RespSize:  rec.Body.Len(),
+				TraceID:   "trace-1",
+				Origin:    "allsrv_test",
+				UserAgent: "test-agent",
+			}
+			assert.Equal(t, want, got)
+		})
+	})
+	
+	t.Run("with invalid content type should log", func(t *testing.T) {
+		var logs bytes.Buffer
+		svr := newSvr(t, 0, &logs)
+		
+		req := newReq("POST", "/v1/foos", nil, withContentType("text/plain"), withBasicAuth("dodgers@stink.com", "PaSsWoRd"))
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, req)
+		
+		require.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+		expectJSONBody(t, &logs, func(t *testing.T, got logLine) {
+			assert.Equal(t, "POST /v1/foos", got.Route)
+			assert.Equal(t, http.StatusUnsupportedMediaType, got.Status)
+			assert.NotZero(t, got.TraceID)
+		})
+	})
+	
+	t.Run("with successful request and zero sample rate should not log", func(t *testing.T) {
+		var logs bytes.Buffer
+		svr := newSvr(t, 0, &logs)
+		
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Zero(t, logs.Len())
+	})
+	
+	t.Run("with successful request and full sample rate should log", func(t *testing.T) {
+		var logs bytes.Buffer
+		svr := newSvr(t, 1, &logs)
+		
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, get("/v1/foos/1", withBasicAuth("dodgers@stink.com", "PaSsWoRd")))
+		
+		require.Equal(t, http.StatusOK, rec.Code)
+		expectJSONBody(t, &logs, func(t *testing.T, got logLine) {
+			assert.Equal(t, "INFO", got.Level)
+			assert.Equal(t, http.StatusOK, got.Status)
+			assert.Equal(t, rec.Body.Len(), got.RespSize)
+		})
+	})
 }
 
 func expectErrs(t *testing.T, r io.Reader, want ...allsrvc.RespErr) {
@@ -590,6 +1483,18 @@ func withContentType(ct string) func(*http.Request) {
 	}
 }
 
+func withAccept(accept string) func(*http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("Accept", accept)
+	}
+}
+
+func withHeader(key, val string) func(*http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set(key, val)
+	}
+}
+
 func withBasicAuth(user, pass string) func(*http.Request) {
 	return func(req *http.Request) {
 		req.SetBasicAuth(user, pass)
@@ -0,0 +1,467 @@
+package allsrv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hashicorp/go-metrics"
+	"github.com/jsteenb2/errors"
+
+	"github.com/jsteenb2/allsrvc"
+)
+
+// Shadow executes the requests of the legacy Server against the v2 API of a
+// shadow SVC as well, and compares the outputs of the two field by field. The
+// shadow SVC is backed by a mirrored store, so the shadowed requests never
+// write to the store of the legacy server. The divergences are counted in the
+// metrics, and logged with the outputs of both.
+//
+// The foos created through the shadow are given the ids of the legacy foos.
+// The foos that exist ahead of the shadow are expected to be mirrored with the
+// same ids, see MirrorFoos, and the writes to the store other than those of
+// the legacy server are expected to be applied to the mirror as well, see
+// MirrorDB.
+//
+// The requests are shadowed off the request path, so the latency of the
+// legacy requests does not include the shadow. The requests are queued for
+// Run, which must be running for the requests to be shadowed.
+type Shadow struct {
+	v2     http.Handler
+	logger *slog.Logger
+	met    *metrics.Metrics
+
+	svcOpts []func(*Service)
+	queue   chan shadowReq
+	pending sync.WaitGroup
+
+	// nextID is the id of the foo the shadow creates, the id of the foo the
+	// legacy server created
+	mu     sync.Mutex
+	nextID string
+}
+
+// DefaultShadowQueueSize is the number of requests queued to be shadowed when
+// no other size is provided.
+const DefaultShadowQueueSize = 1000
+
+// WithShadowQueueSize sets the number of requests queued to be shadowed. The
+// requests served while the queue is full are not shadowed.
+func WithShadowQueueSize(n int) func(*Shadow) {
+	return func(s *Shadow) {
+		s.queue = make(chan shadowReq, max(n, 1))
+	}
+}
+
+// WithShadowSVCOpts sets the options of the SVC of the shadow. The ids of the
+// foos are those of the legacy foos, regardless of the options.
+func WithShadowSVCOpts(opts ...func(*Service)) func(*Shadow) {
+	return func(s *Shadow) {
+		s.svcOpts = append(s.svcOpts, opts...)
+	}
+}
+
+// NewShadow creates a shadow of the legacy requests against the v2 API of a
+// SVC of the mirror. The mirror must be a store other than the store of the
+// legacy server. The metrics are optional.
+func NewShadow(mirror DB, logger *slog.Logger, met *metrics.Metrics, opts ...func(*Shadow)) *Shadow {
+	s := Shadow{
+		logger: logger,
+		met:    met,
+		queue:  make(chan shadowReq, DefaultShadowQueueSize),
+	}
+	for _, o := range opts {
+		o(&s)
+	}
+	s.v2 = NewServerV2(NewService(mirror, append(s.svcOpts, WithSVCIDFn(s.takeID))...))
+	return &s
+}
+
+// WithShadow shadows the legacy requests of the server with the shadow.
+func WithShadow(sh *Shadow) SvrOptFn {
+	return func(o *serverOpts) {
+		o.shadow = sh
+	}
+}
+
+// MirrorFoos creates the foos of the db in the mirror db, keeping their ids.
+// The number of foos mirrored is returned.
+func MirrorFoos(ctx context.Context, db, mirror DB) (int, error) {
+	foos, err := db.ListFoos(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list foos to mirror")
+	}
+	for i, f := range foos {
+		if err := mirror.CreateFoo(ctx, f); err != nil {
+			return i, errors.Wrap(err, "failed to mirror foo "+f.ID)
+		}
+	}
+	return len(foos), nil
+}
+
+// MirrorDB applies the writes of the db to the mirror as well, so the mirror
+// of the shadow keeps up with the writes made outside the legacy server. The
+// writes of the legacy server are applied to the mirror by the shadow, so the
+// legacy server must not write through the MirrorDB. The writes the mirror
+// fails are left to the shadow to report as divergences.
+func MirrorDB(mirror DB) func(DB) DB {
+	return func(next DB) DB {
+		return &mirrorDB{DB: next, mirror: mirror}
+	}
+}
+
+type mirrorDB struct {
+	DB
+	mirror DB
+}
+
+func (m *mirrorDB) CreateFoo(ctx context.Context, f Foo) error {
+	if err := m.DB.CreateFoo(ctx, f); err != nil {
+		return err
+	}
+	m.mirror.CreateFoo(ctx, f)
+	return nil
+}
+
+func (m *mirrorDB) UpdateFoo(ctx context.Context, f Foo) error {
+	if err := m.DB.UpdateFoo(ctx, f); err != nil {
+		return err
+	}
+	m.mirror.UpdateFoo(ctx, f)
+	return nil
+}
+
+func (m *mirrorDB) DelFoo(ctx context.Context, id string) error {
+	if err := m.DB.DelFoo(ctx, id); err != nil {
+		return err
+	}
+	m.mirror.DelFoo(ctx, id)
+	return nil
+}
+
+// MirrorExpirer deletes the expired foos of the mirror as well, once the
+// expired foos of the expirer are deleted.
+func MirrorExpirer(mirror FooExpirer) func(FooExpirer) FooExpirer {
+	return func(next FooExpirer) FooExpirer {
+		return &mirrorExpirer{next: next, mirror: mirror}
+	}
+}
+
+type mirrorExpirer struct {
+	next   FooExpirer
+	mirror FooExpirer
+}
+
+func (m *mirrorExpirer) DelExpiredFoos(ctx context.Context, now time.Time, limit int) (int, error) {
+	n, err := m.next.DelExpiredFoos(ctx, now, limit)
+	if err != nil {
+		return n, err
+	}
+	// the batches of the mirror may select other foos than those of the next
+	// expirer, so every expired foo of the mirror is deleted
+	m.mirror.DelExpiredFoos(ctx, now, math.MaxInt)
+	return n, nil
+}
+
+// Run shadows the queued requests, one at a time in the order they were
+// queued, until the ctx is done.
+func (s *Shadow) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-s.queue:
+			s.shadow(ctx, req)
+			s.pending.Done()
+		}
+	}
+}
+
+// Wait waits for the queued requests to be shadowed, such as once the server
+// is shut down. No requests may be served while waiting.
+func (s *Shadow) Wait() {
+	s.pending.Wait()
+}
+
+// Compare queues the requests of the legacy handler to be shadowed, once the
+// legacy handler has served them. The requests are queued in the order they
+// are served, so concurrent requests for the same foo may be applied in a
+// different order by the shadow and diverge. A request served while the queue
+// is full is dropped, and counted in the metrics.
+func (s *Shadow) Compare(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqBody []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			reqBody, _ = io.ReadAll(io.LimitReader(r.Body, DefaultTrafficMaxBody+1))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(reqBody), r.Body), r.Body}
+		}
+		rec := &trafficRespRec{ResponseWriter: w, body: trafficBuf{max: DefaultTrafficMaxBody}}
+
+		next.ServeHTTP(rec, r)
+
+		if len(reqBody) > DefaultTrafficMaxBody || rec.body.truncated {
+			return // the outputs of bodies this large are not compared
+		}
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		s.enqueue(shadowReq{
+			route:  getRoute(r.Context()),
+			method: r.Method,
+			url:    r.URL,
+			body:   reqBody,
+			legacy: legacyOutput(rec.code, rec.body.Bytes()),
+		})
+	})
+}
+
+// shadowReq is a served legacy request, with the output of the legacy handler.
+type shadowReq struct {
+	route  string
+	method string
+	url    *url.URL
+	body   []byte
+	legacy shadowOutput
+}
+
+func (s *Shadow) enqueue(req shadowReq) {
+	s.pending.Add(1)
+	select {
+	case s.queue <- req:
+	default:
+		s.pending.Done()
+		if s.met != nil {
+			s.met.IncrCounterWithLabels([]string{metricsPrefix, "shadow", "dropped"}, 1, []metrics.Label{{Name: "route", Value: req.route}})
+		}
+	}
+}
+
+// shadowOutput is the output of a request, the foo is nil when the output has
+// no foo.
+type shadowOutput struct {
+	Status int
+	Body   []byte
+	Foo    *FooV0
+}
+
+// legacyOutput decodes the foo of a legacy output. The fields of the legacy
+// foos are matched case insensitively, as the legacy API responds to creates
+// with the Foo as is.
+func legacyOutput(status int, body []byte) shadowOutput {
+	out := shadowOutput{Status: status, Body: body}
+	var f FooV0
+	if isSuccess(status) && len(bytes.TrimSpace(body)) > 0 && json.Unmarshal(body, &f) == nil {
+		out.Foo = &f
+	}
+	return out
+}
+
+// v2Output decodes the foo of a v2 output to the shape of the legacy foo.
+func v2Output(status int, body []byte) shadowOutput {
+	out := shadowOutput{Status: status, Body: body}
+	var resp allsrvc.RespBody[FooAttrs]
+	if isSuccess(status) && json.Unmarshal(body, &resp) == nil && resp.Data != nil {
+		out.Foo = &FooV0{ID: resp.Data.ID, Name: resp.Data.Attrs.Name, Note: resp.Data.Attrs.Note}
+	}
+	return out
+}
+
+func (s *Shadow) shadow(ctx context.Context, legacy shadowReq) {
+	req, ok := s.v2Req(ctx, legacy)
+	if !ok {
+		return
+	}
+	if legacy.method == http.MethodPost && legacy.legacy.Foo != nil {
+		s.setID(legacy.legacy.Foo.ID)
+	}
+	rec := new(shadowRec)
+	s.v2.ServeHTTP(rec, req)
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+	s.setID("")
+	v2Out, legacyOut := v2Output(rec.code, rec.body.Bytes()), legacy.legacy
+
+	fields := s.compare(legacyOut, v2Out)
+	s.record(legacy.route, fields)
+	if len(fields) == 0 {
+		return
+	}
+
+	s.logger.Warn("shadow divergence",
+		"route", legacy.route,
+		"fields", fields,
+		slog.Group("legacy",
+			"method", legacy.method,
+			"url", legacy.url.RequestURI(),
+			"status", legacyOut.Status,
+			"body", string(legacyOut.Body),
+		),
+		slog.Group("v2",
+			"method", req.Method,
+			"url", req.URL.RequestURI(),
+			"status", v2Out.Status,
+			"body", string(v2Out.Body),
+		),
+	)
+}
+
+// v2Req translates the legacy request to the request of the v2 API. A legacy
+// body that is not a foo is sent to the v2 API as is.
+func (s *Shadow) v2Req(ctx context.Context, legacy shadowReq) (*http.Request, bool) {
+	var (
+		body    = legacy.body
+		f       FooV0
+		decoded = json.NewDecoder(bytes.NewReader(body)).Decode(&f) == nil
+		method  string
+		path    string
+		v2Body  any
+	)
+	switch legacy.method {
+	case http.MethodPost:
+		method, path = http.MethodPost, "/v1/foos"
+		if decoded {
+			v2Body = allsrvc.ReqBody[FooCreateAttrs]{Data: allsrvc.Data[FooCreateAttrs]{
+				Type:  resourceTypeFoo,
+				Attrs: FooCreateAttrs{FooCreateAttrs: allsrvc.FooCreateAttrs{Name: f.Name, Note: f.Note}},
+			}}
+		}
+	case http.MethodGet:
+		method, path = http.MethodGet, "/v1/foos/"+url.PathEscape(legacy.url.Query().Get("id"))
+	case http.MethodPut:
+		method, path = http.MethodPatch, "/v1/foos/"+url.PathEscape(f.ID)
+		if decoded {
+			v2Body = allsrvc.ReqBody[FooUpdAttrs]{Data: allsrvc.Data[FooUpdAttrs]{
+				Type:  resourceTypeFoo,
+				ID:    f.ID,
+				Attrs: FooUpdAttrs{FooUpdAttrs: allsrvc.FooUpdAttrs{Name: &f.Name, Note: &f.Note}},
+			}}
+		}
+	case http.MethodDelete:
+		method, path = http.MethodDelete, "/v1/foos/"+url.PathEscape(legacy.url.Query().Get("id"))
+	default:
+		return nil, false
+	}
+
+	if v2Body != nil {
+		b, err := json.Marshal(v2Body)
+		if err != nil {
+			return nil, false
+		}
+		body = b
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, false
+	}
+	req.Header.Set("Accept", MediaTypeJSON)
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", MediaTypeJSON)
+	}
+	return req, true
+}
+
+// compare compares the outputs field by field, and provides the fields that
+// diverge. Only the fields of the legacy output are compared, the v2 API
+// responds with fields the legacy API does not.
+func (s *Shadow) compare(legacyOut, v2Out shadowOutput) []string {
+	var fields []string
+	if legacyOut.Status != v2Out.Status {
+		fields = append(fields, "status")
+	}
+
+	switch legacyFoo, v2Foo := legacyOut.Foo, v2Out.Foo; {
+	case legacyFoo == nil:
+	case v2Foo == nil:
+		fields = append(fields, "foo")
+	default:
+		if legacyFoo.ID != v2Foo.ID {
+			fields = append(fields, "id")
+		}
+		if legacyFoo.Name != v2Foo.Name {
+			fields = append(fields, "name")
+		}
+		if legacyFoo.Note != v2Foo.Note {
+			fields = append(fields, "note")
+		}
+	}
+	return fields
+}
+
+func (s *Shadow) record(route string, fields []string) {
+	if s.met == nil {
+		return
+	}
+
+	name := []string{metricsPrefix, "shadow"}
+	labels := []metrics.Label{{Name: "route", Value: route}}
+	s.met.IncrCounterWithLabels(append(name, "reqs"), 1, labels)
+	if len(fields) == 0 {
+		return
+	}
+	s.met.IncrCounterWithLabels(append(name, "divergences"), 1, labels)
+	for _, f := range fields {
+		s.met.IncrCounterWithLabels(append(name, "divergent_fields"), 1, append(labels, metrics.Label{Name: "field", Value: f}))
+	}
+}
+
+// takeID provides the id of the foo the shadow creates. A foo the legacy
+// server did not create is given an id of its own.
+func (s *Shadow) takeID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nextID != "" {
+		return s.nextID
+	}
+	return uuid.Must(uuid.NewV4()).String()
+}
+
+func (s *Shadow) setID(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID = id
+}
+
+func isSuccess(status int) bool {
+	return status >= 200 && status < 300
+}
+
+// shadowRec records the response of the shadow, the response is never written
+// to the caller.
+type shadowRec struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (r *shadowRec) Header() http.Header {
+	if r.header == nil {
+		r.header = make(http.Header)
+	}
+	return r.header
+}
+
+func (r *shadowRec) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *shadowRec) WriteHeader(statusCode int) {
+	if r.code == 0 {
+		r.code = statusCode
+	}
+}
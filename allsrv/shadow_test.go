@@ -0,0 +1,278 @@
+package allsrv_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
+)
+
+func TestShadow(t *testing.T) {
+	type shadowed struct {
+		svr    *allsrv.Server
+		v2     *allsrv.ServerV2
+		shadow *allsrv.Shadow
+		mirror *allsrv.InmemDB
+		sink   *metrics.InmemSink
+		logs   *bytes.Buffer
+	}
+
+	newShadowed := func(t *testing.T, existing ...allsrv.Foo) shadowed {
+		t.Helper()
+
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(existing...)(t, db)
+		mirror := new(allsrv.InmemDB)
+		n, err := allsrv.MirrorFoos(context.TODO(), db, mirror)
+		require.NoError(t, err)
+		require.Equal(t, len(existing), n)
+
+		sink := metrics.NewInmemSink(time.Minute, time.Minute)
+		cfg := metrics.DefaultConfig("")
+		cfg.EnableRuntimeMetrics = false
+		met, err := metrics.New(cfg, sink)
+		require.NoError(t, err)
+
+		logs := new(bytes.Buffer)
+		shadow := allsrv.NewShadow(mirror, slog.New(slog.NewJSONHandler(logs, nil)), met)
+		ctx, cancel := context.WithCancel(context.TODO())
+		t.Cleanup(cancel)
+		go shadow.Run(ctx)
+		svc := allsrv.NewService(db, allsrv.WithSVCIDFn(allsrvtesting.IDGen(1, 1)))
+		v2SVC := allsrv.NewService(allsrv.MirrorDB(mirror)(db), allsrv.WithSVCIDFn(allsrvtesting.IDGen(100, 1)))
+
+		return shadowed{
+			svr:    allsrv.NewServer(svc, allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"), allsrv.WithShadow(shadow)),
+			v2:     allsrv.NewServerV2(v2SVC, allsrv.WithBasicAuthV2("dodgers@stink.com", "PaSsWoRd")),
+			shadow: shadow,
+			mirror: mirror,
+			sink:   sink,
+			logs:   logs,
+		}
+	}
+
+	do := func(t *testing.T, svr http.Handler, method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, req)
+		return rec
+	}
+
+	counter := func(sink *metrics.InmemSink, name string, labels ...string) int {
+		key := strings.Join(append([]string{name}, labels...), ";")
+		c, ok := sink.Data()[0].Counters[key]
+		if !ok {
+			return 0
+		}
+		return c.Count
+	}
+
+	t.Run("legacy requests matching the v2 API should not diverge", func(t *testing.T) {
+		s := newShadowed(t, allsrv.Foo{ID: "existing", Name: "existing_foo", Note: "existing note"})
+
+		create := do(t, s.svr, "POST", "/foo", `{"name":"first_foo","note":"some note"}`)
+		require.Equal(t, http.StatusCreated, create.Code)
+
+		var created allsrv.FooV0
+		require.NoError(t, json.Unmarshal(create.Body.Bytes(), &created))
+		assert.Equal(t, "1", created.ID)
+
+		assert.Equal(t, http.StatusOK, do(t, s.svr, "GET", "/foo?id=1", "").Code)
+		assert.Equal(t, http.StatusOK, do(t, s.svr, "PUT", "/foo", `{"id":"1","name":"first_foo","note":"new note"}`).Code)
+		assert.Equal(t, http.StatusOK, do(t, s.svr, "GET", "/foo?id=1", "").Code)
+		assert.Equal(t, http.StatusOK, do(t, s.svr, "GET", "/foo?id=existing", "").Code)
+		assert.Equal(t, http.StatusOK, do(t, s.svr, "DELETE", "/foo?id=1", "").Code)
+		assert.Equal(t, http.StatusNotFound, do(t, s.svr, "GET", "/foo?id=1", "").Code)
+		s.shadow.Wait()
+
+		assert.Empty(t, s.logs.String())
+		assert.Equal(t, 1, counter(s.sink, "mess.shadow.reqs", "route=POST_/foo"))
+		assert.Equal(t, 4, counter(s.sink, "mess.shadow.reqs", "route=GET_/foo"))
+		assert.Equal(t, 1, counter(s.sink, "mess.shadow.reqs", "route=PUT_/foo"))
+		assert.Equal(t, 1, counter(s.sink, "mess.shadow.reqs", "route=DELETE_/foo"))
+		assert.Zero(t, counter(s.sink, "mess.shadow.divergences", "route=POST_/foo"))
+
+		foos, err := s.mirror.ListFoos(context.TODO(), nil)
+		require.NoError(t, err)
+		require.Len(t, foos, 1, "the shadow foo should be deleted from the mirror")
+		assert.Equal(t, "existing", foos[0].ID)
+	})
+
+	t.Run("legacy requests diverging from the v2 API should be counted and logged", func(t *testing.T) {
+		s := newShadowed(t)
+
+		create := do(t, s.svr, "POST", "/foo", `{"name":"first_foo",`)
+		require.Equal(t, http.StatusForbidden, create.Code)
+		s.shadow.Wait()
+
+		var logged struct {
+			Msg    string   `json:"msg"`
+			Route  string   `json:"route"`
+			Fields []string `json:"fields"`
+			Legacy struct {
+				Method string `json:"method"`
+				URL    string `json:"url"`
+				Status int    `json:"status"`
+				Body   string `json:"body"`
+			} `json:"legacy"`
+			V2 struct {
+				Method string `json:"method"`
+				URL    string `json:"url"`
+				Status int    `json:"status"`
+				Body   string `json:"body"`
+			} `json:"v2"`
+		}
+		require.NoError(t, json.Unmarshal(s.logs.Bytes(), &logged))
+		assert.Equal(t, "shadow divergence", logged.Msg)
+		assert.Equal(t, "POST /foo", logged.Route)
+		assert.Equal(t, []string{"status"}, logged.Fields)
+		assert.Equal(t, http.StatusForbidden, logged.Legacy.Status)
+		assert.Equal(t, "/v1/foos", logged.V2.URL)
+		assert.Equal(t, http.StatusBadRequest, logged.V2.Status)
+		assert.Contains(t, logged.V2.Body, `"errors"`)
+
+		assert.Equal(t, 1, counter(s.sink, "mess.shadow.divergences", "route=POST_/foo"))
+		assert.Equal(t, 1, counter(s.sink, "mess.shadow.divergent_fields", "route=POST_/foo", "field=status"))
+	})
+
+	t.Run("legacy foo missing from the mirror should diverge", func(t *testing.T) {
+		s := newShadowed(t)
+
+		require.Equal(t, http.StatusCreated, do(t, s.svr, "POST", "/foo", `{"name":"first_foo","note":"some note"}`).Code)
+		require.Equal(t, http.StatusOK, do(t, s.svr, "GET", "/foo?id=1", "").Code)
+		s.shadow.Wait()
+
+		// the create is shadowed as a foo of the legacy id
+		assert.Zero(t, counter(s.sink, "mess.shadow.divergences", "route=GET_/foo"))
+
+		require.NoError(t, s.mirror.DelFoo(context.TODO(), "1"))
+		require.Equal(t, http.StatusOK, do(t, s.svr, "GET", "/foo?id=1", "").Code)
+		s.shadow.Wait()
+		assert.Equal(t, 1, counter(s.sink, "mess.shadow.divergent_fields", "route=GET_/foo", "field=status"))
+		assert.Equal(t, 1, counter(s.sink, "mess.shadow.divergent_fields", "route=GET_/foo", "field=foo"))
+	})
+
+	t.Run("v2 writes should be mirrored for the legacy reads that follow", func(t *testing.T) {
+		s := newShadowed(t, allsrv.Foo{ID: "existing", Name: "existing_foo", Note: "existing note"})
+
+		v2Do := func(method, target, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, target, strings.NewReader(body))
+			req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+			if body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			rec := httptest.NewRecorder()
+			s.v2.ServeHTTP(rec, req)
+			return rec
+		}
+
+		require.Equal(t, http.StatusCreated, v2Do("POST", "/v1/foos", `{"data":{"type":"foo","attributes":{"name":"v2_foo","note":"v2 note"}}}`).Code)
+		require.Equal(t, http.StatusOK, v2Do("PATCH", "/v1/foos/existing", `{"data":{"type":"foo","id":"existing","attributes":{"note":"v2 note"}}}`).Code)
+
+		require.Equal(t, http.StatusOK, do(t, s.svr, "GET", "/foo?id=100", "").Code)
+		require.Equal(t, http.StatusOK, do(t, s.svr, "GET", "/foo?id=existing", "").Code)
+		s.shadow.Wait()
+
+		assert.Equal(t, 2, counter(s.sink, "mess.shadow.reqs", "route=GET_/foo"))
+		assert.Zero(t, counter(s.sink, "mess.shadow.divergences", "route=GET_/foo"))
+		assert.Empty(t, s.logs.String())
+
+		require.Equal(t, http.StatusOK, v2Do("DELETE", "/v1/foos/existing", "").Code)
+		require.Equal(t, http.StatusNotFound, do(t, s.svr, "GET", "/foo?id=existing", "").Code)
+		s.shadow.Wait()
+		assert.Zero(t, counter(s.sink, "mess.shadow.divergences", "route=GET_/foo"))
+	})
+
+	t.Run("legacy foo differing from the mirror should diverge on the fields", func(t *testing.T) {
+		s := newShadowed(t, allsrv.Foo{ID: "existing", Name: "existing_foo", Note: "existing note"})
+		require.NoError(t, s.mirror.UpdateFoo(context.TODO(), allsrv.Foo{ID: "existing", Name: "existing_foo", Note: "drifted note"}))
+
+		require.Equal(t, http.StatusOK, do(t, s.svr, "GET", "/foo?id=existing", "").Code)
+		s.shadow.Wait()
+
+		assert.Equal(t, 1, counter(s.sink, "mess.shadow.divergences", "route=GET_/foo"))
+		assert.Equal(t, 1, counter(s.sink, "mess.shadow.divergent_fields", "route=GET_/foo", "field=note"))
+		assert.Zero(t, counter(s.sink, "mess.shadow.divergent_fields", "route=GET_/foo", "field=name"))
+	})
+
+	t.Run("legacy requests should not wait on the shadow", func(t *testing.T) {
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(allsrv.Foo{ID: "existing", Name: "existing_foo"})(t, db)
+
+		unblock := make(chan struct{})
+		shadow := allsrv.NewShadow(&blockingDB{DB: db, unblock: unblock}, slog.New(slog.NewJSONHandler(io.Discard, nil)), nil)
+		ctx, cancel := context.WithCancel(context.TODO())
+		t.Cleanup(cancel)
+		go shadow.Run(ctx)
+		svr := allsrv.NewServer(allsrv.NewService(db), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"), allsrv.WithShadow(shadow))
+
+		served := make(chan int)
+		go func() {
+			served <- do(t, svr, "GET", "/foo?id=existing", "").Code
+		}()
+
+		select {
+		case code := <-served:
+			assert.Equal(t, http.StatusOK, code)
+		case <-time.After(time.Second):
+			t.Fatal("the legacy request waited on the shadow")
+		}
+		close(unblock)
+		shadow.Wait()
+	})
+
+	t.Run("legacy requests over the queue size should be dropped and counted", func(t *testing.T) {
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(allsrv.Foo{ID: "existing", Name: "existing_foo"})(t, db)
+
+		sink := metrics.NewInmemSink(time.Minute, time.Minute)
+		cfg := metrics.DefaultConfig("")
+		cfg.EnableRuntimeMetrics = false
+		met, err := metrics.New(cfg, sink)
+		require.NoError(t, err)
+
+		// the shadow is not run until the queue is full
+		logs := new(bytes.Buffer)
+		shadow := allsrv.NewShadow(db, slog.New(slog.NewJSONHandler(logs, nil)), met, allsrv.WithShadowQueueSize(2))
+		svr := allsrv.NewServer(allsrv.NewService(db), allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"), allsrv.WithShadow(shadow))
+		for range 5 {
+			require.Equal(t, http.StatusOK, do(t, svr, "GET", "/foo?id=existing", "").Code)
+		}
+		assert.Equal(t, 3, counter(sink, "mess.shadow.dropped", "route=GET_/foo"))
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		t.Cleanup(cancel)
+		go shadow.Run(ctx)
+		shadow.Wait()
+
+		assert.Equal(t, 2, counter(sink, "mess.shadow.reqs", "route=GET_/foo"))
+		assert.Empty(t, logs.String())
+	})
+}
+
+// blockingDB blocks the reads of the foos until unblocked.
+type blockingDB struct {
+	allsrv.DB
+	unblock <-chan struct{}
+}
+
+func (db *blockingDB) ReadFoo(ctx context.Context, id string, opts ...allsrv.ReadOptFn) (allsrv.Foo, error) {
+	<-db.unblock
+	return db.DB.ReadFoo(ctx, id, opts...)
+}
@@ -15,29 +15,97 @@ type Foo struct {
 	Note      string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	ExpiresAt time.Time // zero when the foo does not expire
+	Labels    map[string]string
+	Metadata  map[string]any // the free-form JSON object of the foo
 }
 
-// OK validates the fields are provided.
+// Expired reports whether the foo has expired by now. An expired foo is
+// hidden from reads, and is deleted by the FooReaper.
+func (f Foo) Expired(now time.Time) bool {
+	return !f.ExpiresAt.IsZero() && !now.Before(f.ExpiresAt)
+}
+
+// OK validates the foo has a name. The rules of the foo attributes are
+// validated by the Service, per the rules it is configured with.
 func (f Foo) OK() error {
 	if f.Name == "" {
-		return InvalidErr("name is required")
+		return InvalidErr("name is required", "attribute", "name")
 	}
 	return nil
 }
 
-// FooUpd is a record for updating an existing foo.
+// FooUpd is a record for updating an existing foo. A zero ExpiresAt
+// removes the expiry of the foo. The Labels replace all the labels of
+// the foo, and the Metadata replaces all the metadata of the foo.
 type FooUpd struct {
-	ID   string
-	Name *string
-	Note *string
+	ID        string
+	Name      *string
+	Note      *string
+	ExpiresAt *time.Time
+	Labels    *map[string]string
+	Metadata  *map[string]any
+}
+
+// ReadOpts are the options of a foo read.
+type ReadOpts struct {
+	// Fields limits the foo attributes read to the given fields, the attribute
+	// names of the foo resource (i.e. name, updated_at). When empty, all fields
+	// are read.
+	Fields []string
+}
+
+// ReadOptFn is a functional option for a foo read.
+type ReadOptFn func(*ReadOpts)
+
+// WithReadFields limits the foo attributes read to the given fields.
+func WithReadFields(fields ...string) ReadOptFn {
+	return func(o *ReadOpts) {
+		o.Fields = fields
+	}
+}
+
+func newReadOpts(opts []ReadOptFn) ReadOpts {
+	var o ReadOpts
+	for _, fn := range opts {
+		fn(&o)
+	}
+	return o
+}
+
+// ListOpts are the options of a foo list.
+type ListOpts struct {
+	// Metadata limits the foos listed to those whose metadata matches the
+	// filter. When empty, the foos are not filtered by their metadata.
+	Metadata MetadataFilter
+}
+
+// ListOptFn is a functional option for a foo list.
+type ListOptFn func(*ListOpts)
+
+// WithListMetadata limits the foos listed to those whose metadata matches
+// the filter.
+func WithListMetadata(filter MetadataFilter) ListOptFn {
+	return func(o *ListOpts) {
+		o.Metadata = filter
+	}
+}
+
+func newListOpts(opts []ListOptFn) ListOpts {
+	var o ListOpts
+	for _, fn := range opts {
+		fn(&o)
+	}
+	return o
 }
 
 // SVC defines the service behavior.
 type SVC interface {
 	CreateFoo(ctx context.Context, f Foo) (Foo, error)
-	ReadFoo(ctx context.Context, id string) (Foo, error)
+	ReadFoo(ctx context.Context, id string, opts ...ReadOptFn) (Foo, error)
 	UpdateFoo(ctx context.Context, f FooUpd) (Foo, error)
 	DelFoo(ctx context.Context, id string) error
+	ListFoos(ctx context.Context, sel LabelSelector, opts ...ListOptFn) ([]Foo, error)
 }
 
 // Service dependencies
@@ -45,15 +113,17 @@ type (
 	// DB represents the foo persistence layer.
 	DB interface {
 		CreateFoo(ctx context.Context, f Foo) error
-		ReadFoo(ctx context.Context, id string) (Foo, error)
+		ReadFoo(ctx context.Context, id string, opts ...ReadOptFn) (Foo, error)
 		UpdateFoo(ctx context.Context, f Foo) error
 		DelFoo(ctx context.Context, id string) error
+		ListFoos(ctx context.Context, sel LabelSelector, opts ...ListOptFn) ([]Foo, error)
 	}
 )
 
 // Service is the home for business logic of the foo domain.
 type Service struct {
-	db DB
+	db    DB
+	rules FooRules
 
 	idFn  func() string
 	nowFn func() time.Time
@@ -71,9 +141,17 @@ func WithSVCNowFn(fn func() time.Time) func(*Service) {
 	}
 }
 
+// WithSVCFooRules sets the validation rules enforced on foo attributes.
+func WithSVCFooRules(rules FooRules) func(*Service) {
+	return func(s *Service) {
+		s.rules = rules
+	}
+}
+
 func NewService(db DB, opts ...func(*Service)) *Service {
 	s := Service{
 		db:    db,
+		rules: DefaultFooRules(),
 		idFn:  func() string { return uuid.Must(uuid.NewV4()).String() },
 		nowFn: func() time.Time { return time.Now().UTC() },
 	}
@@ -86,12 +164,21 @@ func NewService(db DB, opts ...func(*Service)) *Service {
 }
 
 func (s *Service) CreateFoo(ctx context.Context, f Foo) (Foo, error) {
-	if err := f.OK(); err != nil {
+	f.Name, f.Note, f.Labels = normalizeName(f.Name), normalizeAttr(f.Note), normalizeLabels(f.Labels)
+	metadata, metadataErr := normalizeMetadata(f.Metadata)
+	if metadataErr != nil {
+		return Foo{}, errors.Wrap(metadataErr)
+	}
+	f.Metadata = metadata
+	now := s.nowFn()
+	if err := joinViolations(s.rules.Validate(f), validateExpiresAt(f.ExpiresAt, now)); err != nil {
 		return Foo{}, errors.Wrap(err)
 	}
 
-	now := s.nowFn()
 	f.ID, f.CreatedAt, f.UpdatedAt = s.idFn(), now, now
+	if !f.ExpiresAt.IsZero() {
+		f.ExpiresAt = f.ExpiresAt.UTC()
+	}
 
 	if err := s.db.CreateFoo(ctx, f); err != nil {
 		return Foo{}, errors.Wrap(err)
@@ -100,15 +187,40 @@ func (s *Service) CreateFoo(ctx context.Context, f Foo) (Foo, error) {
 	return f, nil
 }
 
-func (s *Service) ReadFoo(ctx context.Context, id string) (Foo, error) {
+func (s *Service) ReadFoo(ctx context.Context, id string, opts ...ReadOptFn) (Foo, error) {
 	if id == "" {
 		return Foo{}, errIDRequired
 	}
-	f, err := s.db.ReadFoo(ctx, id)
+	f, err := s.db.ReadFoo(ctx, id, opts...)
 	return f, errors.Wrap(err)
 }
 
 func (s *Service) UpdateFoo(ctx context.Context, f FooUpd) (Foo, error) {
+	if f.Name != nil {
+		f.Name = ptr(normalizeName(*f.Name))
+	}
+	if f.Note != nil {
+		f.Note = ptr(normalizeAttr(*f.Note))
+	}
+	if f.Labels != nil {
+		f.Labels = ptr(normalizeLabels(*f.Labels))
+	}
+	if f.Metadata != nil {
+		metadata, err := normalizeMetadata(*f.Metadata)
+		if err != nil {
+			return Foo{}, errors.Wrap(err)
+		}
+		f.Metadata = &metadata
+	}
+	now := s.nowFn()
+	var expiresErr error
+	if f.ExpiresAt != nil {
+		expiresErr = validateExpiresAt(*f.ExpiresAt, now)
+	}
+	if err := joinViolations(s.rules.validateUpd(f), expiresErr); err != nil {
+		return Foo{}, errors.Wrap(err)
+	}
+
 	existing, err := s.db.ReadFoo(ctx, f.ID)
 	if err != nil {
 		return Foo{}, errors.Wrap(err)
@@ -119,7 +231,19 @@ func (s *Service) UpdateFoo(ctx context.Context, f FooUpd) (Foo, error) {
 	if newNote := f.Note; newNote != nil {
 		existing.Note = *newNote
 	}
-	existing.UpdatedAt = s.nowFn()
+	if expiresAt := f.ExpiresAt; expiresAt != nil {
+		existing.ExpiresAt = *expiresAt
+		if !expiresAt.IsZero() {
+			existing.ExpiresAt = expiresAt.UTC()
+		}
+	}
+	if labels := f.Labels; labels != nil {
+		existing.Labels = *labels
+	}
+	if metadata := f.Metadata; metadata != nil {
+		existing.Metadata = *metadata
+	}
+	existing.UpdatedAt = now
 
 	err = s.db.UpdateFoo(ctx, existing)
 	if err != nil {
@@ -135,3 +259,15 @@ func (s *Service) DelFoo(ctx context.Context, id string) error {
 	}
 	return errors.Wrap(s.db.DelFoo(ctx, id))
 }
+
+// ListFoos lists the foos whose labels match the selector, in the order they
+// were created. The foos are also filtered by the metadata filter provided
+// via WithListMetadata.
+func (s *Service) ListFoos(ctx context.Context, sel LabelSelector, opts ...ListOptFn) ([]Foo, error) {
+	foos, err := s.db.ListFoos(ctx, sel, opts...)
+	return foos, errors.Wrap(err)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
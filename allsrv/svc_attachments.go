@@ -0,0 +1,355 @@
+package allsrv
+
+import (
+	"cmp"
+	"context"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gofrs/uuid"
+	"github.com/jsteenb2/errors"
+)
+
+// DefaultAttachmentMaxSize is the size limit of an attachment when no other
+// limit is provided, 10 MiB.
+const DefaultAttachmentMaxSize = 10 << 20
+
+// Attachment is a file attached to a foo. The content of the attachment is the
+// blob of its digest in the BlobStore.
+type Attachment struct {
+	ID          string
+	FooID       string
+	Name        string
+	ContentType string
+	Size        int64
+	Digest      string
+	CreatedAt   time.Time
+}
+
+// AttachmentDB persists the attachments of the foos. The attachments of a foo
+// are deleted with the foo. Both the InmemDB and the sqlite db are attachment
+// dbs.
+type AttachmentDB interface {
+	CreateAttachment(ctx context.Context, a Attachment) error
+	ReadAttachment(ctx context.Context, fooID, id string) (Attachment, error)
+	ListAttachments(ctx context.Context, fooID string) ([]Attachment, error)
+	DelAttachment(ctx context.Context, fooID, id string) error
+	DigestReferenced(ctx context.Context, digest string) (bool, error)
+	ExpiredDigests(ctx context.Context, now time.Time) ([]string, error)
+}
+
+// Attachments is the home for the business logic of the attachments of foos.
+// Identical content is stored once, and a blob is deleted once no attachment
+// references it.
+type Attachments struct {
+	db      AttachmentDB
+	blobs   BlobStore
+	maxSize int64
+
+	idFn  func() string
+	nowFn func() time.Time
+
+	// mu keeps the blobs from being pruned while an attachment of the blob is
+	// being created. Creates share the lock, deletes hold it exclusively.
+	mu sync.RWMutex
+}
+
+// WithAttachmentsMaxSize sets the size limit of an attachment in bytes.
+func WithAttachmentsMaxSize(n int64) func(*Attachments) {
+	return func(a *Attachments) {
+		a.maxSize = n
+	}
+}
+
+func WithAttachmentsIDFn(fn func() string) func(*Attachments) {
+	return func(a *Attachments) {
+		a.idFn = fn
+	}
+}
+
+func WithAttachmentsNowFn(fn func() time.Time) func(*Attachments) {
+	return func(a *Attachments) {
+		a.nowFn = fn
+	}
+}
+
+// NewAttachments creates the attachments of the db, with their content stored
+// in the blobs.
+func NewAttachments(db AttachmentDB, blobs BlobStore, opts ...func(*Attachments)) *Attachments {
+	a := Attachments{
+		db:      db,
+		blobs:   blobs,
+		maxSize: DefaultAttachmentMaxSize,
+		idFn:    func() string { return uuid.Must(uuid.NewV4()).String() },
+		nowFn:   func() time.Time { return time.Now().UTC() },
+	}
+
+	for _, o := range opts {
+		o(&a)
+	}
+
+	return &a
+}
+
+// MaxSize is the size limit of an attachment in bytes.
+func (a *Attachments) MaxSize() int64 {
+	return a.maxSize
+}
+
+// CreateAttachment attaches the content of r to the foo. The content is
+// streamed into the blob store, and is rejected once it exceeds the max size.
+// An empty content type defaults to application/octet-stream.
+func (a *Attachments) CreateAttachment(ctx context.Context, fooID, name, contentType string, r io.Reader) (Attachment, error) {
+	if fooID == "" {
+		return Attachment{}, errIDRequired
+	}
+	name, contentType = normalizeName(name), cmp.Or(strings.TrimSpace(contentType), "application/octet-stream")
+	if err := validateAttachment(name, contentType); err != nil {
+		return Attachment{}, errors.Wrap(err)
+	}
+
+	// fail fast for a foo that does not exist, before the content is streamed
+	if _, err := a.db.ListAttachments(ctx, fooID); err != nil {
+		return Attachment{}, errors.Wrap(err)
+	}
+
+	a.mu.RLock()
+	blob, err := a.blobs.PutBlob(ctx, &sizeLimitReader{r: r, remaining: a.maxSize, max: a.maxSize})
+	if err != nil {
+		a.mu.RUnlock()
+		return Attachment{}, errors.Wrap(err)
+	}
+
+	att := Attachment{
+		ID:          a.idFn(),
+		FooID:       fooID,
+		Name:        name,
+		ContentType: contentType,
+		Size:        blob.Size,
+		Digest:      blob.Digest,
+		CreatedAt:   a.nowFn(),
+	}
+	err = a.db.CreateAttachment(ctx, att)
+	a.mu.RUnlock()
+	if err != nil {
+		// a blob left behind by a failed prune is removed by the next PruneBlobs
+		a.mu.Lock()
+		a.pruneDigests(context.WithoutCancel(ctx), blob.Digest)
+		a.mu.Unlock()
+		return Attachment{}, errors.Wrap(err)
+	}
+
+	return att, nil
+}
+
+func (a *Attachments) ReadAttachment(ctx context.Context, fooID, id string) (Attachment, error) {
+	if fooID == "" || id == "" {
+		return Attachment{}, errIDRequired
+	}
+	att, err := a.db.ReadAttachment(ctx, fooID, id)
+	return att, errors.Wrap(err)
+}
+
+// OpenAttachment opens the content of the attachment for reading. The caller
+// must close the content.
+func (a *Attachments) OpenAttachment(ctx context.Context, fooID, id string) (Attachment, io.ReadCloser, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	att, err := a.ReadAttachment(ctx, fooID, id)
+	if err != nil {
+		return Attachment{}, nil, err
+	}
+	content, err := a.blobs.OpenBlob(ctx, att.Digest)
+	if err != nil {
+		return Attachment{}, nil, errors.Wrap(err)
+	}
+	return att, content, nil
+}
+
+// ListAttachments lists the attachments of the foo, in the order they were
+// created.
+func (a *Attachments) ListAttachments(ctx context.Context, fooID string) ([]Attachment, error) {
+	if fooID == "" {
+		return nil, errIDRequired
+	}
+	atts, err := a.db.ListAttachments(ctx, fooID)
+	return atts, errors.Wrap(err)
+}
+
+// DelAttachment deletes the attachment, along with its blob when no other
+// attachment shares the blob.
+func (a *Attachments) DelAttachment(ctx context.Context, fooID, id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	att, err := a.ReadAttachment(ctx, fooID, id)
+	if err != nil {
+		return err
+	}
+	if err := a.db.DelAttachment(ctx, fooID, id); err != nil {
+		return errors.Wrap(err)
+	}
+	_, err = a.pruneDigests(ctx, att.Digest)
+	return err
+}
+
+// PruneBlobs deletes the blobs that no attachment references, which are left
+// behind by foos deleted outside the SVC, or by failed deletes. The number of
+// blobs deleted is returned.
+func (a *Attachments) PruneBlobs(ctx context.Context) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	digests, err := a.blobs.BlobDigests(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+	return a.pruneDigests(ctx, digests...)
+}
+
+// pruneDigests deletes the blobs of the digests that no attachment references.
+// The caller must hold the lock.
+func (a *Attachments) pruneDigests(ctx context.Context, digests ...string) (int, error) {
+	var n int
+	for _, digest := range digests {
+		referenced, err := a.db.DigestReferenced(ctx, digest)
+		if err != nil {
+			return n, errors.Wrap(err)
+		}
+		if referenced {
+			continue
+		}
+		if err := a.blobs.DelBlob(ctx, digest); err != nil {
+			return n, errors.Wrap(err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// SVCAttachmentsCascade deletes the attachments of a foo when the foo is
+// deleted. The db deletes the attachments with the foo, while the blobs no
+// other attachment shares are deleted here.
+func SVCAttachmentsCascade(a *Attachments) func(SVC) SVC {
+	return func(next SVC) SVC {
+		return &svcAttachmentsCascade{SVC: next, a: a}
+	}
+}
+
+type svcAttachmentsCascade struct {
+	SVC
+	a *Attachments
+}
+
+// DelFoo deletes the foo, and prunes the blobs of its attachments. Only the
+// prune holds the lock, so the deletes of foos without attachments do not
+// contend with the attachments of other foos. The blob of an attachment
+// created while the foo is deleted is pruned by PruneBlobs.
+func (s *svcAttachmentsCascade) DelFoo(ctx context.Context, id string) error {
+	// the foo not existing is left to the delete to report
+	atts, _ := s.a.db.ListAttachments(ctx, id)
+	if err := s.SVC.DelFoo(ctx, id); err != nil {
+		return err
+	}
+	if len(atts) == 0 {
+		return nil
+	}
+
+	digests := make([]string, 0, len(atts))
+	for _, att := range atts {
+		digests = append(digests, att.Digest)
+	}
+
+	s.a.mu.Lock()
+	defer s.a.mu.Unlock()
+
+	_, err := s.a.pruneDigests(ctx, digests...)
+	return errors.Wrap(err, "failed to delete the attachments of the foo")
+}
+
+// ExpirerAttachmentsCascade prunes the blobs of the attachments of the expired
+// foos that are deleted. Only the blobs of the attachments of the expired foos
+// are pruned, the blobs left behind otherwise are pruned by PruneBlobs.
+func ExpirerAttachmentsCascade(a *Attachments) func(FooExpirer) FooExpirer {
+	return func(next FooExpirer) FooExpirer {
+		return &expirerAttachmentsCascade{next: next, a: a}
+	}
+}
+
+type expirerAttachmentsCascade struct {
+	next FooExpirer
+	a    *Attachments
+}
+
+func (e *expirerAttachmentsCascade) DelExpiredFoos(ctx context.Context, now time.Time, limit int) (int, error) {
+	e.a.mu.Lock()
+	defer e.a.mu.Unlock()
+
+	// the digests include those of the expired foos beyond the limit, which
+	// remain referenced until their foos are deleted by a later batch
+	digests, err := e.a.db.ExpiredDigests(ctx, now)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list the attachments of the expired foos")
+	}
+	n, err := e.next.DelExpiredFoos(ctx, now, limit)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	if _, err := e.a.pruneDigests(ctx, digests...); err != nil {
+		return n, errors.Wrap(err, "failed to delete the attachments of the expired foos")
+	}
+	return n, nil
+}
+
+var attachmentNameRules = []Rule{
+	Required(),
+	MaxLen(255),
+	Charset("printable characters other than '/' or '\\'", isAttachmentNameRune),
+	func(v string) string {
+		if v == "." || v == ".." {
+			return "must not be . or .."
+		}
+		return ""
+	},
+}
+
+func validateAttachment(name, contentType string) error {
+	var typeErr error
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		typeErr = InvalidErr("attachment content_type must be a media type", "attribute", "content_type")
+	}
+	return joinViolations(
+		validateAttrs(attrVal{attr: "name", desc: "attachment name", val: name, rules: attachmentNameRules}),
+		typeErr,
+	)
+}
+
+func isAttachmentNameRune(r rune) bool {
+	return unicode.IsPrint(r) && r != '/' && r != '\\'
+}
+
+// sizeLimitReader reads from r until more than max bytes are read, at which
+// point the read fails.
+type sizeLimitReader struct {
+	r         io.Reader
+	remaining int64
+	max       int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, InvalidErr("attachment must be at most "+strconv.FormatInt(l.max, 10)+" bytes", "max_size", l.max)
+	}
+	return n, err
+}
@@ -0,0 +1,237 @@
+package allsrv_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsteenb2/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
+)
+
+func TestAttachments(t *testing.T) {
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	type deps struct {
+		db          *allsrv.InmemDB
+		blobs       *allsrv.FSBlobStore
+		attachments *allsrv.Attachments
+	}
+
+	newDeps := func(t *testing.T, opts ...func(*allsrv.Attachments)) deps {
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(
+			allsrv.Foo{ID: "1", Name: "name-1", CreatedAt: start, UpdatedAt: start},
+			allsrv.Foo{ID: "2", Name: "name-2", CreatedAt: start, UpdatedAt: start},
+		)(t, db)
+
+		blobs, err := allsrv.NewFSBlobStore(t.TempDir())
+		require.NoError(t, err)
+
+		opts = append([]func(*allsrv.Attachments){
+			allsrv.WithAttachmentsIDFn(allsrvtesting.IDGen(1, 1)),
+			allsrv.WithAttachmentsNowFn(allsrvtesting.NowFn(start, time.Hour)),
+		}, opts...)
+		return deps{db: db, blobs: blobs, attachments: allsrv.NewAttachments(db, blobs, opts...)}
+	}
+
+	blobDigests := func(t *testing.T, blobs allsrv.BlobStore) []string {
+		t.Helper()
+
+		digests, err := blobs.BlobDigests(context.TODO())
+		require.NoError(t, err)
+		return digests
+	}
+
+	t.Run("created attachment should be downloadable", func(t *testing.T) {
+		d := newDeps(t)
+
+		att, err := d.attachments.CreateAttachment(context.TODO(), "1", "config.yaml", "", strings.NewReader("key: value"))
+		require.NoError(t, err)
+		assert.Equal(t, allsrv.Attachment{
+			ID:          "1",
+			FooID:       "1",
+			Name:        "config.yaml",
+			ContentType: "application/octet-stream",
+			Size:        10,
+			Digest:      att.Digest,
+			CreatedAt:   start,
+		}, att)
+
+		got, content, err := d.attachments.OpenAttachment(context.TODO(), "1", att.ID)
+		require.NoError(t, err)
+		defer content.Close()
+		assert.Equal(t, att, got)
+
+		b, err := io.ReadAll(content)
+		require.NoError(t, err)
+		assert.Equal(t, "key: value", string(b))
+	})
+
+	t.Run("attachment larger than the max size should fail", func(t *testing.T) {
+		d := newDeps(t, allsrv.WithAttachmentsMaxSize(4))
+
+		_, err := d.attachments.CreateAttachment(context.TODO(), "1", "big.txt", "text/plain", strings.NewReader("12345"))
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindInvalid))
+		assert.Contains(t, err.Error(), "attachment must be at most 4 bytes")
+		assert.Empty(t, blobDigests(t, d.blobs))
+
+		_, err = d.attachments.CreateAttachment(context.TODO(), "1", "fits.txt", "text/plain", strings.NewReader("1234"))
+		require.NoError(t, err)
+	})
+
+	t.Run("attachment with invalid name or content type should fail", func(t *testing.T) {
+		d := newDeps(t)
+
+		_, err := d.attachments.CreateAttachment(context.TODO(), "1", "../passwd", "not a media type", strings.NewReader("content"))
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindInvalid))
+		assert.Contains(t, err.Error(), "attachment name must only contain")
+		assert.Contains(t, err.Error(), "attachment content_type must be a media type")
+	})
+
+	t.Run("attachment of non-existent foo should fail before storing the content", func(t *testing.T) {
+		d := newDeps(t)
+
+		_, err := d.attachments.CreateAttachment(context.TODO(), "9000", "config.yaml", "", strings.NewReader("content"))
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+		assert.Empty(t, blobDigests(t, d.blobs))
+	})
+
+	t.Run("attachment with existing name should fail and keep the existing blob", func(t *testing.T) {
+		d := newDeps(t)
+
+		existing, err := d.attachments.CreateAttachment(context.TODO(), "1", "config.yaml", "", strings.NewReader("first"))
+		require.NoError(t, err)
+
+		_, err = d.attachments.CreateAttachment(context.TODO(), "1", "config.yaml", "", strings.NewReader("second"))
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindExists))
+		assert.Equal(t, []string{existing.Digest}, blobDigests(t, d.blobs))
+	})
+
+	t.Run("identical content should share a blob until every attachment is deleted", func(t *testing.T) {
+		d := newDeps(t)
+
+		first, err := d.attachments.CreateAttachment(context.TODO(), "1", "a.txt", "", strings.NewReader("same"))
+		require.NoError(t, err)
+		second, err := d.attachments.CreateAttachment(context.TODO(), "2", "b.txt", "", strings.NewReader("same"))
+		require.NoError(t, err)
+		require.Equal(t, first.Digest, second.Digest)
+
+		require.NoError(t, d.attachments.DelAttachment(context.TODO(), "1", first.ID))
+		assert.Equal(t, []string{first.Digest}, blobDigests(t, d.blobs))
+
+		require.NoError(t, d.attachments.DelAttachment(context.TODO(), "2", second.ID))
+		assert.Empty(t, blobDigests(t, d.blobs))
+	})
+
+	t.Run("deleted foo should delete its attachments", func(t *testing.T) {
+		d := newDeps(t)
+		svc := allsrv.SVCAttachmentsCascade(d.attachments)(allsrv.NewService(d.db))
+
+		_, err := d.attachments.CreateAttachment(context.TODO(), "1", "a.txt", "", strings.NewReader("first"))
+		require.NoError(t, err)
+		shared, err := d.attachments.CreateAttachment(context.TODO(), "1", "b.txt", "", strings.NewReader("shared"))
+		require.NoError(t, err)
+		_, err = d.attachments.CreateAttachment(context.TODO(), "2", "b.txt", "", strings.NewReader("shared"))
+		require.NoError(t, err)
+
+		require.NoError(t, svc.DelFoo(context.TODO(), "1"))
+
+		_, err = d.attachments.ListAttachments(context.TODO(), "1")
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+		assert.Equal(t, []string{shared.Digest}, blobDigests(t, d.blobs))
+	})
+
+	t.Run("deleted foo without attachments should not wait on the attachments of other foos", func(t *testing.T) {
+		d := newDeps(t)
+		svc := allsrv.SVCAttachmentsCascade(d.attachments)(allsrv.NewService(d.db))
+
+		// the content of the attachment is streamed until the writer is closed
+		pr, pw := io.Pipe()
+		created := make(chan error, 1)
+		go func() {
+			_, err := d.attachments.CreateAttachment(context.TODO(), "1", "a.txt", "", pr)
+			created <- err
+		}()
+		_, err := pw.Write([]byte("streaming"))
+		require.NoError(t, err)
+
+		deleted := make(chan error, 1)
+		go func() {
+			deleted <- svc.DelFoo(context.TODO(), "2")
+		}()
+		select {
+		case err := <-deleted:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("delete of the foo waited on the attachment of another foo")
+		}
+
+		require.NoError(t, pw.Close())
+		require.NoError(t, <-created)
+	})
+
+	t.Run("reaped foo should delete its attachments", func(t *testing.T) {
+		d := newDeps(t)
+		expirer := allsrv.ExpirerAttachmentsCascade(d.attachments)(d.db)
+
+		_, err := d.attachments.CreateAttachment(context.TODO(), "1", "a.txt", "", strings.NewReader("first"))
+		require.NoError(t, err)
+
+		n, err := expirer.DelExpiredFoos(context.TODO(), time.Now(), 10)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+		assert.Len(t, blobDigests(t, d.blobs), 1)
+
+		require.NoError(t, d.db.UpdateFoo(context.TODO(), allsrv.Foo{
+			ID:        "1",
+			Name:      "name-1",
+			CreatedAt: start,
+			UpdatedAt: start,
+			ExpiresAt: time.Now().Add(time.Minute),
+		}))
+
+		n, err = expirer.DelExpiredFoos(context.TODO(), time.Now().Add(time.Hour), 10)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Empty(t, blobDigests(t, d.blobs))
+	})
+
+	t.Run("reaped foo should only prune the blobs of its attachments", func(t *testing.T) {
+		d := newDeps(t)
+		expirer := allsrv.ExpirerAttachmentsCascade(d.attachments)(d.db)
+
+		att, err := d.attachments.CreateAttachment(context.TODO(), "1", "a.txt", "", strings.NewReader("first"))
+		require.NoError(t, err)
+		shared, err := d.attachments.CreateAttachment(context.TODO(), "1", "b.txt", "", strings.NewReader("shared"))
+		require.NoError(t, err)
+		_, err = d.attachments.CreateAttachment(context.TODO(), "2", "b.txt", "", strings.NewReader("shared"))
+		require.NoError(t, err)
+		orphan, err := d.blobs.PutBlob(context.TODO(), strings.NewReader("orphan"))
+		require.NoError(t, err)
+
+		require.NoError(t, d.db.UpdateFoo(context.TODO(), allsrv.Foo{
+			ID:        "1",
+			Name:      "name-1",
+			CreatedAt: start,
+			UpdatedAt: start,
+			ExpiresAt: time.Now().Add(time.Minute),
+		}))
+
+		n, err := expirer.DelExpiredFoos(context.TODO(), time.Now().Add(time.Hour), 10)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.ElementsMatch(t, []string{shared.Digest, orphan.Digest}, blobDigests(t, d.blobs))
+		assert.NotContains(t, blobDigests(t, d.blobs), att.Digest)
+	})
+}
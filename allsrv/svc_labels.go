@@ -0,0 +1,190 @@
+package allsrv
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// LabelOp is the operator of a label requirement.
+type LabelOp string
+
+const (
+	LabelOpEq        LabelOp = "="
+	LabelOpNotEq     LabelOp = "!="
+	LabelOpIn        LabelOp = "in"
+	LabelOpNotIn     LabelOp = "notin"
+	LabelOpExists    LabelOp = "exists"
+	LabelOpNotExists LabelOp = "!"
+)
+
+// LabelReq is a requirement of a label selector on the label of the key. The
+// = and != operators take a single value, the in and notin operators take
+// a set of values, and the exists and ! operators take no values.
+type LabelReq struct {
+	Key    string
+	Op     LabelOp
+	Values []string
+}
+
+// Matches reports whether the labels meet the requirement. A label that is
+// not set meets the != and notin requirements.
+func (r LabelReq) Matches(labels map[string]string) bool {
+	v, ok := labels[r.Key]
+	switch r.Op {
+	case LabelOpEq, LabelOpIn:
+		return ok && slices.Contains(r.Values, v)
+	case LabelOpNotEq, LabelOpNotIn:
+		return !ok || !slices.Contains(r.Values, v)
+	case LabelOpExists:
+		return ok
+	case LabelOpNotExists:
+		return !ok
+	default:
+		return false
+	}
+}
+
+func (r LabelReq) String() string {
+	switch r.Op {
+	case LabelOpIn, LabelOpNotIn:
+		return r.Key + " " + string(r.Op) + " (" + strings.Join(r.Values, ",") + ")"
+	case LabelOpExists:
+		return r.Key
+	case LabelOpNotExists:
+		return "!" + r.Key
+	default:
+		return r.Key + string(r.Op) + strings.Join(r.Values, "")
+	}
+}
+
+// LabelSelector selects foos by their labels. A foo is selected when its
+// labels meet every requirement of the selector. An empty selector selects
+// every foo.
+type LabelSelector []LabelReq
+
+// Matches reports whether the labels meet every requirement of the selector.
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s LabelSelector) String() string {
+	reqs := make([]string, 0, len(s))
+	for _, r := range s {
+		reqs = append(reqs, r.String())
+	}
+	return strings.Join(reqs, ",")
+}
+
+// ParseLabelSelector parses the comma separated requirements of a label
+// selector (i.e. env=prod,team!=infra,tier in (a,b),!deprecated).
+func ParseLabelSelector(raw string) (LabelSelector, error) {
+	var sel LabelSelector
+	for _, term := range splitSelector(raw) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		req, err := parseLabelReq(term)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+// splitSelector splits the requirements of the selector at the commas that
+// are not within the value set of an in or notin requirement.
+func splitSelector(raw string) []string {
+	var (
+		terms []string
+		depth int
+		start int
+	)
+	for i, r := range raw {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms, start = append(terms, raw[start:i]), i+1
+			}
+		}
+	}
+	return append(terms, raw[start:])
+}
+
+func parseLabelReq(term string) (LabelReq, error) {
+	if key, ok := strings.CutPrefix(term, "!"); ok && !strings.HasPrefix(key, "=") {
+		return newLabelReq(term, strings.TrimSpace(key), LabelOpNotExists)
+	}
+
+	if i := strings.IndexByte(term, '('); i > -1 {
+		fields := strings.Fields(term[:i])
+		values, ok := strings.CutSuffix(strings.TrimSpace(term[i+1:]), ")")
+		if len(fields) != 2 || !ok {
+			return LabelReq{}, invalidSelectorErr(term, "set requirements must be of the form: key in (v1,v2) or key notin (v1,v2)")
+		}
+
+		op := LabelOp(fields[1])
+		if op != LabelOpIn && op != LabelOpNotIn {
+			return LabelReq{}, invalidSelectorErr(term, "set operator must be in or notin")
+		}
+		var vals []string
+		for _, v := range strings.Split(values, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				vals = append(vals, v)
+			}
+		}
+		if len(vals) == 0 {
+			return LabelReq{}, invalidSelectorErr(term, "set requirements must have at least one value")
+		}
+		return newLabelReq(term, fields[0], op, vals...)
+	}
+
+	for _, op := range []string{"!=", "==", "="} {
+		if key, val, ok := strings.Cut(term, op); ok {
+			reqOp := LabelOpEq
+			if op == "!=" {
+				reqOp = LabelOpNotEq
+			}
+			return newLabelReq(term, strings.TrimSpace(key), reqOp, strings.TrimSpace(val))
+		}
+	}
+
+	return newLabelReq(term, term, LabelOpExists)
+}
+
+func newLabelReq(term, key string, op LabelOp, vals ...string) (LabelReq, error) {
+	if key == "" || strings.IndexFunc(key, func(r rune) bool { return !isLabelKeyRune(r) }) > -1 {
+		return LabelReq{}, invalidSelectorErr(term, "label key must only contain letters, digits, '-', '_', '.', or '/'")
+	}
+	for _, v := range vals {
+		if strings.IndexFunc(v, func(r rune) bool { return !isLabelValueRune(r) }) > -1 {
+			return LabelReq{}, invalidSelectorErr(term, "label value must only contain letters, digits, '-', '_', or '.'")
+		}
+	}
+	return LabelReq{Key: key, Op: op, Values: vals}, nil
+}
+
+func invalidSelectorErr(term, reason string) error {
+	return InvalidErr("invalid label selector requirement "+strconv.Quote(term)+": "+reason, "requirement", term)
+}
+
+// labelKeys provides the keys of the labels in sorted order.
+func labelKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,439 @@
+package allsrv
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jsteenb2/errors"
+)
+
+// MetadataReq is a requirement of a metadata filter on the value at the path
+// of the metadata. The path is the keys of the nested objects of the metadata.
+// The requirement is met when the value is a string equal to the Value, or a
+// number or boolean whose JSON text is equal to the Value.
+type MetadataReq struct {
+	Path  []string
+	Value string
+}
+
+// Matches reports whether the metadata meets the requirement.
+func (r MetadataReq) Matches(metadata map[string]any) bool {
+	v, ok := metadataValue(metadata, r.Path)
+	if !ok {
+		return false
+	}
+	text, ok := metadataText(v)
+	return ok && text == r.Value
+}
+
+func (r MetadataReq) String() string {
+	return "metadata." + strings.Join(r.Path, ".") + "=" + r.Value
+}
+
+// MetadataFilter selects foos by their metadata. A foo is selected when its
+// metadata meets every requirement of the filter. An empty filter selects
+// every foo.
+type MetadataFilter []MetadataReq
+
+// Matches reports whether the metadata meets every requirement of the filter.
+func (f MetadataFilter) Matches(metadata map[string]any) bool {
+	for _, r := range f {
+		if !r.Matches(metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f MetadataFilter) String() string {
+	reqs := make([]string, 0, len(f))
+	for _, r := range f {
+		reqs = append(reqs, r.String())
+	}
+	return strings.Join(reqs, ",")
+}
+
+// ParseMetadataPath parses the dot separated path of a metadata filter
+// (i.e. region or owner.team). Each key of the path may only contain
+// letters, digits, '-', or '_'.
+func ParseMetadataPath(path string) ([]string, error) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys {
+		if k == "" || strings.IndexFunc(k, func(r rune) bool { return !isMetadataKeyRune(r) }) > -1 {
+			return nil, InvalidErr("invalid metadata path provided: "+path+"; path keys must only contain letters, digits, '-', or '_'", "path", path)
+		}
+	}
+	return keys, nil
+}
+
+func isMetadataKeyRune(r rune) bool {
+	return r < utf8.RuneSelf && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+}
+
+// metadataValue provides the value at the path of the nested objects of the
+// metadata.
+func metadataValue(metadata map[string]any, path []string) (any, bool) {
+	var v any = metadata
+	for _, k := range path {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// metadataText provides the text of a string, number, or boolean metadata
+// value that a filter requirement is compared to. Numbers and booleans are
+// provided as JSON text.
+func metadataText(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64, bool:
+		b, err := json.Marshal(v)
+		return string(b), err == nil
+	default:
+		return "", false
+	}
+}
+
+// normalizeMetadata normalizes the metadata to the values of a decoded JSON
+// object, so the metadata reads the same from every store. Empty metadata
+// is nil.
+func normalizeMetadata(metadata map[string]any) (map[string]any, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	b, err := marshalMetadata(metadata)
+	if err != nil {
+		return nil, InvalidErr("metadata must be a JSON object", "attribute", "metadata")
+	}
+	var out map[string]any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, InvalidErr("metadata must be a JSON object", "attribute", "metadata")
+	}
+	return out, nil
+}
+
+// marshalMetadata marshals the metadata to JSON, without escaping HTML
+// characters, so the stored JSON matches the JSON that was provided.
+func marshalMetadata(metadata map[string]any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(metadata); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// cloneMetadata deep copies the metadata, so the metadata of a stored foo is
+// not shared with its readers.
+func cloneMetadata(metadata map[string]any) map[string]any {
+	if metadata == nil {
+		return nil
+	}
+	out, _ := cloneMetadataValue(metadata).(map[string]any)
+	return out
+}
+
+func cloneMetadataValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			out[k] = cloneMetadataValue(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = cloneMetadataValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// MetadataSchema is a JSON Schema that validates the metadata of foos. The
+// schema supports the validation keywords type, enum, const, properties,
+// required, additionalProperties, items, minItems, maxItems, minLength,
+// maxLength, pattern, minimum, maximum, exclusiveMinimum, and
+// exclusiveMaximum. The annotations of the schema are ignored.
+type MetadataSchema struct {
+	types        []string
+	enum         []any
+	properties   map[string]*MetadataSchema
+	required     []string
+	additional   *MetadataSchema
+	noAdditional bool
+	items        *MetadataSchema
+	minItems     *int
+	maxItems     *int
+	minLength    *int
+	maxLength    *int
+	pattern      *regexp.Regexp
+	minimum      *float64
+	maximum      *float64
+	exclusiveMin *float64
+	exclusiveMax *float64
+}
+
+var metadataSchemaTypes = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+var metadataSchemaAnnotations = []string{"$schema", "$id", "$comment", "title", "description", "default", "examples"}
+
+// ParseMetadataSchema parses the JSON Schema of the metadata. A schema with a
+// keyword that is not supported is invalid, as the metadata would otherwise
+// not be validated as the schema intends.
+func ParseMetadataSchema(b []byte) (*MetadataSchema, error) {
+	s, err := parseMetadataSchema("#", b)
+	return s, errors.Wrap(err)
+}
+
+func parseMetadataSchema(loc string, b []byte) (*MetadataSchema, error) {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(b, &keywords); err != nil {
+		return nil, InvalidErr("metadata schema "+loc+" must be a JSON object: "+err.Error(), "schema", loc)
+	}
+
+	invalid := func(keyword, msg string) error {
+		return InvalidErr("metadata schema "+loc+"/"+keyword+" "+msg, "schema", loc+"/"+keyword)
+	}
+
+	var s MetadataSchema
+	for _, keyword := range sortedKeys(keywords) {
+		raw := keywords[keyword]
+		var err error
+		switch keyword {
+		case "type":
+			var typ string
+			if json.Unmarshal(raw, &typ) == nil {
+				s.types = []string{typ}
+			} else if err = json.Unmarshal(raw, &s.types); err != nil {
+				return nil, invalid(keyword, "must be a type or a list of types")
+			}
+			for _, typ := range s.types {
+				if !slices.Contains(metadataSchemaTypes, typ) {
+					return nil, invalid(keyword, "must be one of: "+strings.Join(metadataSchemaTypes, ", "))
+				}
+			}
+		case "enum":
+			if err = json.Unmarshal(raw, &s.enum); err != nil || len(s.enum) == 0 {
+				return nil, invalid(keyword, "must be a non-empty list")
+			}
+		case "const":
+			var v any
+			if err = json.Unmarshal(raw, &v); err != nil {
+				return nil, invalid(keyword, "must be a JSON value")
+			}
+			s.enum = []any{v}
+		case "properties":
+			var props map[string]json.RawMessage
+			if err = json.Unmarshal(raw, &props); err != nil {
+				return nil, invalid(keyword, "must be an object of schemas")
+			}
+			s.properties = make(map[string]*MetadataSchema, len(props))
+			for _, k := range sortedKeys(props) {
+				if s.properties[k], err = parseMetadataSchema(loc+"/properties/"+k, props[k]); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			if err = json.Unmarshal(raw, &s.required); err != nil {
+				return nil, invalid(keyword, "must be a list of property names")
+			}
+		case "additionalProperties":
+			var allowed bool
+			if json.Unmarshal(raw, &allowed) == nil {
+				s.noAdditional = !allowed
+			} else if s.additional, err = parseMetadataSchema(loc+"/additionalProperties", raw); err != nil {
+				return nil, err
+			}
+		case "items":
+			if s.items, err = parseMetadataSchema(loc+"/items", raw); err != nil {
+				return nil, err
+			}
+		case "minItems", "maxItems", "minLength", "maxLength":
+			var n int
+			if err = json.Unmarshal(raw, &n); err != nil || n < 0 {
+				return nil, invalid(keyword, "must be a non-negative integer")
+			}
+			switch keyword {
+			case "minItems":
+				s.minItems = &n
+			case "maxItems":
+				s.maxItems = &n
+			case "minLength":
+				s.minLength = &n
+			default:
+				s.maxLength = &n
+			}
+		case "pattern":
+			var pattern string
+			if err = json.Unmarshal(raw, &pattern); err != nil {
+				return nil, invalid(keyword, "must be a regular expression")
+			}
+			if s.pattern, err = regexp.Compile(pattern); err != nil {
+				return nil, invalid(keyword, "must be a regular expression: "+err.Error())
+			}
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+			var n float64
+			if err = json.Unmarshal(raw, &n); err != nil {
+				return nil, invalid(keyword, "must be a number")
+			}
+			switch keyword {
+			case "minimum":
+				s.minimum = &n
+			case "maximum":
+				s.maximum = &n
+			case "exclusiveMinimum":
+				s.exclusiveMin = &n
+			default:
+				s.exclusiveMax = &n
+			}
+		default:
+			if !slices.Contains(metadataSchemaAnnotations, keyword) {
+				return nil, invalid(keyword, "is not a supported keyword")
+			}
+		}
+	}
+	return &s, nil
+}
+
+// Validate validates the metadata against the schema. All violations are
+// returned as a joined error, where each violation is an invalid error that
+// identifies the offending value with the "attribute" field, as the path of
+// the value within the metadata (i.e. metadata/region).
+func (s *MetadataSchema) Validate(metadata map[string]any) error {
+	if metadata == nil {
+		metadata = map[string]any{} // no metadata is validated as an empty object
+	}
+	return joinViolations(s.validate("metadata", metadata)...)
+}
+
+func (s *MetadataSchema) validate(attr string, v any) []error {
+	violation := func(msg string) error {
+		return InvalidErr(attr+" "+msg, "attribute", attr)
+	}
+
+	if len(s.types) > 0 && !slices.ContainsFunc(s.types, func(typ string) bool { return isMetadataType(typ, v) }) {
+		if len(s.types) == 1 {
+			return []error{violation("must be of type " + s.types[0])}
+		}
+		return []error{violation("must be one of the types: " + strings.Join(s.types, ", "))}
+	}
+	if len(s.enum) > 0 && !slices.ContainsFunc(s.enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		vals := make([]string, 0, len(s.enum))
+		for _, e := range s.enum {
+			b, _ := json.Marshal(e)
+			vals = append(vals, string(b))
+		}
+		return []error{violation("must be one of: " + strings.Join(vals, ", "))}
+	}
+
+	var errs []error
+	switch v := v.(type) {
+	case map[string]any:
+		for _, k := range s.required {
+			if _, ok := v[k]; !ok {
+				errs = append(errs, InvalidErr(attr+"/"+k+" is required", "attribute", attr+"/"+k))
+			}
+		}
+		for _, k := range sortedKeys(v) {
+			prop, ok := s.properties[k]
+			switch {
+			case ok:
+			case s.noAdditional:
+				errs = append(errs, InvalidErr(attr+"/"+k+" is not allowed", "attribute", attr+"/"+k))
+				continue
+			case s.additional != nil:
+				prop = s.additional
+			default:
+				continue
+			}
+			errs = append(errs, prop.validate(attr+"/"+k, v[k])...)
+		}
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			errs = append(errs, violation("must have at least "+strconv.Itoa(*s.minItems)+" items"))
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			errs = append(errs, violation("must have at most "+strconv.Itoa(*s.maxItems)+" items"))
+		}
+		if s.items != nil {
+			for i, e := range v {
+				errs = append(errs, s.items.validate(attr+"/"+strconv.Itoa(i), e)...)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			errs = append(errs, violation("must be at least "+strconv.Itoa(*s.minLength)+" characters"))
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			errs = append(errs, violation("must be at most "+strconv.Itoa(*s.maxLength)+" characters"))
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			errs = append(errs, violation("must match the pattern "+s.pattern.String()))
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			errs = append(errs, violation("must be at least "+formatNum(*s.minimum)))
+		}
+		if s.maximum != nil && v > *s.maximum {
+			errs = append(errs, violation("must be at most "+formatNum(*s.maximum)))
+		}
+		if s.exclusiveMin != nil && v <= *s.exclusiveMin {
+			errs = append(errs, violation("must be greater than "+formatNum(*s.exclusiveMin)))
+		}
+		if s.exclusiveMax != nil && v >= *s.exclusiveMax {
+			errs = append(errs, violation("must be less than "+formatNum(*s.exclusiveMax)))
+		}
+	}
+	return errs
+}
+
+func isMetadataType(typ string, v any) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		return typ == "object"
+	case []any:
+		return typ == "array"
+	case string:
+		return typ == "string"
+	case float64:
+		return typ == "number" || typ == "integer" && v == math.Trunc(v)
+	case bool:
+		return typ == "boolean"
+	case nil:
+		return typ == "null"
+	default:
+		return false
+	}
+}
+
+func formatNum(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,225 @@
+package allsrv_test
+
+import (
+	"testing"
+
+	"github.com/jsteenb2/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+)
+
+func TestMetadataSchema(t *testing.T) {
+	t.Run("metadata meeting the schema should pass", func(t *testing.T) {
+		schema := mustMetadataSchema(t, `{
+			"$schema": "https://json-schema.org/draft/2020-12/schema",
+			"title": "foo metadata",
+			"type": "object",
+			"properties": {
+				"region": {"type": "string", "enum": ["us", "eu"]},
+				"replicas": {"type": "integer", "minimum": 1, "exclusiveMaximum": 10},
+				"owner": {
+					"type": "object",
+					"properties": {"team": {"type": "string", "pattern": "^[a-z]+$"}},
+					"required": ["team"],
+					"additionalProperties": false
+				},
+				"tags": {"type": "array", "items": {"type": "string", "maxLength": 8}, "maxItems": 3},
+				"canary": {"type": ["boolean", "null"]}
+			},
+			"required": ["region"]
+		}`)
+
+		err := schema.Validate(map[string]any{
+			"region":   "us",
+			"replicas": 3.0,
+			"owner":    map[string]any{"team": "infra"},
+			"tags":     []any{"a", "b"},
+			"canary":   nil,
+			"extra":    "allowed",
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("metadata violating the schema should report every violation", func(t *testing.T) {
+		schema := mustMetadataSchema(t, `{
+			"type": "object",
+			"properties": {
+				"region": {"const": "us"},
+				"replicas": {"type": "integer", "minimum": 1, "exclusiveMaximum": 10},
+				"owner": {
+					"type": "object",
+					"properties": {"team": {"type": "string", "pattern": "^[a-z]+$"}},
+					"required": ["team", "oncall"],
+					"additionalProperties": false
+				},
+				"tags": {"type": "array", "items": {"type": "string", "maxLength": 3}, "minItems": 1, "maxItems": 2},
+				"canary": {"type": ["boolean", "null"]}
+			},
+			"additionalProperties": {"type": "number"}
+		}`)
+
+		err := schema.Validate(map[string]any{
+			"region":   "eu",
+			"replicas": 10.0,
+			"owner":    map[string]any{"team": "Infra", "slack": "#infra"},
+			"tags":     []any{"a", "toolong", "c"},
+			"canary":   "yes",
+			"extra":    "not a number",
+		})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindInvalid))
+
+		wantViolations := []string{
+			`metadata/region must be one of: "us"`,
+			"metadata/replicas must be less than 10",
+			"metadata/owner/oncall is required",
+			"metadata/owner/slack is not allowed",
+			"metadata/owner/team must match the pattern ^[a-z]+$",
+			"metadata/tags must have at most 2 items",
+			"metadata/tags/1 must be at most 3 characters",
+			"metadata/canary must be one of the types: boolean, null",
+			"metadata/extra must be of type number",
+		}
+		for _, want := range wantViolations {
+			assert.Contains(t, err.Error(), want)
+		}
+
+		var attrs []string
+		for _, violation := range errors.Disjoin(err) {
+			attr, _ := errors.V(violation, "attribute").(string)
+			attrs = append(attrs, attr)
+		}
+		assert.Contains(t, attrs, "metadata/owner/slack")
+		assert.Contains(t, attrs, "metadata/tags/1")
+	})
+
+	t.Run("no metadata should be validated as an empty object", func(t *testing.T) {
+		schema := mustMetadataSchema(t, `{"required": ["region"]}`)
+
+		err := schema.Validate(nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "metadata/region is required")
+	})
+
+	t.Run("invalid schema should fail", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			schema  string
+			wantErr string
+		}{
+			{
+				name:    "not an object",
+				schema:  `["object"]`,
+				wantErr: "metadata schema # must be a JSON object",
+			},
+			{
+				name:    "unsupported keyword",
+				schema:  `{"properties": {"region": {"$ref": "#/$defs/region"}}}`,
+				wantErr: "metadata schema #/properties/region/$ref is not a supported keyword",
+			},
+			{
+				name:    "unknown type",
+				schema:  `{"type": "map"}`,
+				wantErr: "metadata schema #/type must be one of: object, array, string, number, integer, boolean, null",
+			},
+			{
+				name:    "invalid pattern",
+				schema:  `{"pattern": "("}`,
+				wantErr: "metadata schema #/pattern must be a regular expression",
+			},
+			{
+				name:    "negative length",
+				schema:  `{"maxLength": -1}`,
+				wantErr: "metadata schema #/maxLength must be a non-negative integer",
+			},
+			{
+				name:    "empty enum",
+				schema:  `{"enum": []}`,
+				wantErr: "metadata schema #/enum must be a non-empty list",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := allsrv.ParseMetadataSchema([]byte(tt.schema))
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, allsrv.ErrKindInvalid))
+				assert.Contains(t, err.Error(), tt.wantErr)
+			})
+		}
+	})
+}
+
+func TestMetadataFilter(t *testing.T) {
+	metadata := map[string]any{
+		"region":   "us",
+		"replicas": 3.0,
+		"ratio":    0.5,
+		"public":   true,
+		"owner":    map[string]any{"team": "infra"},
+		"tags":     []any{"a"},
+		"deleted":  nil,
+	}
+
+	tests := []struct {
+		name   string
+		filter allsrv.MetadataFilter
+		want   bool
+	}{
+		{name: "empty filter", want: true},
+		{name: "string", filter: allsrv.MetadataFilter{{Path: []string{"region"}, Value: "us"}}, want: true},
+		{name: "integer number", filter: allsrv.MetadataFilter{{Path: []string{"replicas"}, Value: "3"}}, want: true},
+		{name: "fractional number", filter: allsrv.MetadataFilter{{Path: []string{"ratio"}, Value: "0.5"}}, want: true},
+		{name: "boolean", filter: allsrv.MetadataFilter{{Path: []string{"public"}, Value: "true"}}, want: true},
+		{name: "nested path", filter: allsrv.MetadataFilter{{Path: []string{"owner", "team"}, Value: "infra"}}, want: true},
+		{
+			name: "every requirement",
+			filter: allsrv.MetadataFilter{
+				{Path: []string{"region"}, Value: "us"},
+				{Path: []string{"owner", "team"}, Value: "web"},
+			},
+		},
+		{name: "different value", filter: allsrv.MetadataFilter{{Path: []string{"region"}, Value: "eu"}}},
+		{name: "number of different text", filter: allsrv.MetadataFilter{{Path: []string{"replicas"}, Value: "3.0"}}},
+		{name: "null", filter: allsrv.MetadataFilter{{Path: []string{"deleted"}, Value: "null"}}},
+		{name: "array", filter: allsrv.MetadataFilter{{Path: []string{"tags"}, Value: "a"}}},
+		{name: "missing path", filter: allsrv.MetadataFilter{{Path: []string{"owner", "oncall"}, Value: "infra"}}},
+		{name: "path through non-object", filter: allsrv.MetadataFilter{{Path: []string{"region", "zone"}, Value: "us"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Matches(metadata))
+		})
+	}
+
+	t.Run("invalid path should fail", func(t *testing.T) {
+		for _, path := range []string{"", "owner..team", "owner.team.", "region[0]", "räksmörgås"} {
+			_, err := allsrv.ParseMetadataPath(path)
+			require.Error(t, err, path)
+			assert.True(t, errors.Is(err, allsrv.ErrKindInvalid))
+		}
+
+		path, err := allsrv.ParseMetadataPath("owner.team-name_2")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"owner", "team-name_2"}, path)
+	})
+}
+
+func mustMetadataSchema(t *testing.T, schema string) *allsrv.MetadataSchema {
+	t.Helper()
+
+	s, err := allsrv.ParseMetadataSchema([]byte(schema))
+	require.NoError(t, err)
+	return s
+}
+
+func metadataSchemaRules(t *testing.T, schema string) allsrv.FooRules {
+	t.Helper()
+
+	rules := allsrv.DefaultFooRules()
+	rules.Metadata = mustMetadataSchema(t, schema)
+	return rules
+}
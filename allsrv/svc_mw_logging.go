@@ -37,10 +37,10 @@ func (s *svcMWLogger) CreateFoo(ctx context.Context, f Foo) (Foo, error) {
 	return f, err
 }
 
-func (s *svcMWLogger) ReadFoo(ctx context.Context, id string) (Foo, error) {
+func (s *svcMWLogger) ReadFoo(ctx context.Context, id string, opts ...ReadOptFn) (Foo, error) {
 	logFn := s.logFn(ctx, "input_id", id)
 	
-	f, err := s.next.ReadFoo(ctx, id)
+	f, err := s.next.ReadFoo(ctx, id, opts...)
 	logger := logFn(err)
 	if err != nil {
 		logger.Error("failed to read foo")
@@ -85,6 +85,22 @@ func (s *svcMWLogger) DelFoo(ctx context.Context, id string) error {
 	return err
 }
 
+func (s *svcMWLogger) ListFoos(ctx context.Context, sel LabelSelector, opts ...ListOptFn) ([]Foo, error) {
+	fields := []any{"input_selector", sel.String()}
+	if filter := newListOpts(opts).Metadata; len(filter) > 0 {
+		fields = append(fields, "input_metadata_filter", filter.String())
+	}
+	logFn := s.logFn(ctx, fields...)
+	
+	foos, err := s.next.ListFoos(ctx, sel, opts...)
+	logger := logFn(err)
+	if err != nil {
+		logger.Error("failed to list foos")
+	}
+	
+	return foos, err
+}
+
 func (s *svcMWLogger) logFn(ctx context.Context, fields ...any) func(error) *slog.Logger {
 	start := time.Now()
 	return func(err error) *slog.Logger {
@@ -32,12 +32,12 @@ func (s *svcObserver) CreateFoo(ctx context.Context, f Foo) (Foo, error) {
 	return f, rec(err)
 }
 
-func (s *svcObserver) ReadFoo(ctx context.Context, id string) (Foo, error) {
+func (s *svcObserver) ReadFoo(ctx context.Context, id string, opts ...ReadOptFn) (Foo, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "svc_foo_read")
 	defer span.Finish()
 
 	rec := s.record("read")
-	f, err := s.next.ReadFoo(ctx, id)
+	f, err := s.next.ReadFoo(ctx, id, opts...)
 	return f, rec(err)
 }
 
@@ -58,6 +58,15 @@ func (s *svcObserver) DelFoo(ctx context.Context, id string) error {
 	return rec(s.next.DelFoo(ctx, id))
 }
 
+func (s *svcObserver) ListFoos(ctx context.Context, sel LabelSelector, opts ...ListOptFn) ([]Foo, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "svc_foo_list")
+	defer span.Finish()
+
+	rec := s.record("list")
+	foos, err := s.next.ListFoos(ctx, sel, opts...)
+	return foos, rec(err)
+}
+
 func (s *svcObserver) record(op string) func(error) error {
 	start := time.Now()
 	name := []string{metricsPrefix, op}
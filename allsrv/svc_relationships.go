@@ -0,0 +1,266 @@
+package allsrv
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jsteenb2/errors"
+)
+
+// Relationship defines a typed link from a foo to other foos.
+type Relationship struct {
+	Name string
+	// ToMany relationships relate any number of foos, while a to-one
+	// relationship relates at most one foo.
+	ToMany bool
+	// Hierarchical relationships must not form a cycle, as a foo may not
+	// be its own ancestor.
+	Hierarchical bool
+	// InverseOf names the relationship of which this relationship is the read
+	// only inverse. The inverse relates the foos that relate to the foo.
+	InverseOf string
+}
+
+// DefaultRelationships provides the parent/child hierarchy of foos, and the
+// foos a foo depends on.
+func DefaultRelationships() []Relationship {
+	return []Relationship{
+		{Name: "parent", Hierarchical: true},
+		{Name: "children", ToMany: true, InverseOf: "parent"},
+		{Name: "depends_on", ToMany: true},
+	}
+}
+
+// RelationshipDB persists the relationships between foos. Only live foos are
+// related, and a foo that is related to by another live foo is not deleted.
+// Both the InmemDB and the sqlite db are relationship dbs.
+type RelationshipDB interface {
+	// ReadRelated provides the ids of the foos the foo relates to by the
+	// relationship.
+	ReadRelated(ctx context.Context, fooID, name string) ([]string, error)
+	// ReadRelatedTo provides the ids of the foos that relate to the foo by
+	// the relationship.
+	ReadRelatedTo(ctx context.Context, fooID, name string) ([]string, error)
+	// SetRelated replaces the foos the foo relates to by the relationship.
+	SetRelated(ctx context.Context, fooID, name string, relatedIDs []string) error
+}
+
+// Relationships is the home for the business logic of the relationships
+// between foos.
+type Relationships struct {
+	db   RelationshipDB
+	defs []Relationship
+
+	// mu serializes the writes, so the cycle detection of a write sees the
+	// links of every write before it.
+	mu sync.Mutex
+}
+
+// WithRelationshipDefs sets the relationships of the foos, replacing the
+// DefaultRelationships.
+func WithRelationshipDefs(defs ...Relationship) func(*Relationships) {
+	return func(r *Relationships) {
+		r.defs = defs
+	}
+}
+
+// NewRelationships creates the relationships of the db.
+func NewRelationships(db RelationshipDB, opts ...func(*Relationships)) *Relationships {
+	r := Relationships{
+		db:   db,
+		defs: DefaultRelationships(),
+	}
+
+	for _, o := range opts {
+		o(&r)
+	}
+
+	return &r
+}
+
+// Defs provides the definitions of the relationships.
+func (r *Relationships) Defs() []Relationship {
+	return slices.Clone(r.defs)
+}
+
+// Def provides the definition of the named relationship.
+func (r *Relationships) Def(name string) (Relationship, error) {
+	for _, def := range r.defs {
+		if def.Name == name {
+			return def, nil
+		}
+	}
+	return Relationship{}, NotFoundErr("relationship not found for name: "+name, "relationship", name)
+}
+
+// Related provides the ids of the foos related to the foo by the relationship.
+func (r *Relationships) Related(ctx context.Context, fooID, name string) ([]string, error) {
+	if fooID == "" {
+		return nil, errIDRequired
+	}
+	def, err := r.Def(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.related(ctx, fooID, def)
+}
+
+// SetRelated replaces the foos related to the foo by the relationship. An
+// empty relatedIDs removes every related foo. The ids of the related foos
+// are returned.
+func (r *Relationships) SetRelated(ctx context.Context, fooID, name string, relatedIDs []string) ([]string, error) {
+	return r.write(ctx, fooID, name, func(def Relationship, _ []string) ([]string, error) {
+		if !def.ToMany && len(relatedIDs) > 1 {
+			return nil, InvalidErr("relationship "+def.Name+" is to-one and relates at most one foo", "relationship", def.Name)
+		}
+		return relatedIDs, nil
+	})
+}
+
+// AddRelated adds the foos to the to-many relationship of the foo. The ids of
+// the related foos are returned.
+func (r *Relationships) AddRelated(ctx context.Context, fooID, name string, relatedIDs []string) ([]string, error) {
+	return r.write(ctx, fooID, name, func(def Relationship, existing []string) ([]string, error) {
+		if !def.ToMany {
+			return nil, errToOne(def)
+		}
+		return append(existing, relatedIDs...), nil
+	})
+}
+
+// DelRelated removes the foos from the to-many relationship of the foo. The
+// ids of the related foos are returned.
+func (r *Relationships) DelRelated(ctx context.Context, fooID, name string, relatedIDs []string) ([]string, error) {
+	return r.write(ctx, fooID, name, func(def Relationship, existing []string) ([]string, error) {
+		if !def.ToMany {
+			return nil, errToOne(def)
+		}
+		return slices.DeleteFunc(existing, func(id string) bool {
+			return slices.Contains(relatedIDs, id)
+		}), nil
+	})
+}
+
+// write replaces the related foos with those provided by the fn, from the
+// existing related foos.
+func (r *Relationships) write(ctx context.Context, fooID, name string, fn func(def Relationship, existing []string) ([]string, error)) ([]string, error) {
+	if fooID == "" {
+		return nil, errIDRequired
+	}
+	def, err := r.Def(name)
+	if err != nil {
+		return nil, err
+	}
+	if def.InverseOf != "" {
+		return nil, InvalidErr("relationship "+def.Name+" is read only, as the inverse of "+def.InverseOf, "relationship", def.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, err := r.db.ReadRelated(ctx, fooID, def.Name)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	relatedIDs, err := fn(def, existing)
+	if err != nil {
+		return nil, err
+	}
+	relatedIDs, err = r.validateRelated(ctx, fooID, def, relatedIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.db.SetRelated(ctx, fooID, def.Name, relatedIDs); err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return relatedIDs, nil
+}
+
+// validateRelated validates the related foos of the foo, dropping duplicate
+// ids. A hierarchical relationship must not form a cycle.
+func (r *Relationships) validateRelated(ctx context.Context, fooID string, def Relationship, relatedIDs []string) ([]string, error) {
+	out := make([]string, 0, len(relatedIDs))
+	for _, id := range relatedIDs {
+		id = strings.TrimSpace(id)
+		switch {
+		case id == "":
+			return nil, InvalidErr("related foo id is required", "relationship", def.Name)
+		case id == fooID:
+			return nil, InvalidErr("foo "+fooID+" must not relate to itself by "+def.Name, "relationship", def.Name)
+		case slices.Contains(out, id):
+			continue
+		}
+		out = append(out, id)
+	}
+
+	if def.Hierarchical {
+		for _, id := range out {
+			cycle, err := r.reaches(ctx, def, id, fooID)
+			if err != nil {
+				return nil, err
+			}
+			if cycle {
+				return nil, InvalidErr("relationship "+def.Name+" of foo "+fooID+" to foo "+id+" forms a cycle", "relationship", def.Name, "related_id", id)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// reaches reports whether the target is reached by following the relationship
+// from the foo.
+func (r *Relationships) reaches(ctx context.Context, def Relationship, fooID, target string) (bool, error) {
+	visited := map[string]bool{fooID: true}
+	next := []string{fooID}
+	for len(next) > 0 {
+		id := next[len(next)-1]
+		next = next[:len(next)-1]
+
+		relatedIDs, err := r.db.ReadRelated(ctx, id, def.Name)
+		if errors.Is(err, ErrKindNotFound) {
+			continue // a foo that does not exist is reported by the write
+		}
+		if err != nil {
+			return false, errors.Wrap(err)
+		}
+		for _, relatedID := range relatedIDs {
+			if relatedID == target {
+				return true, nil
+			}
+			if !visited[relatedID] {
+				visited[relatedID] = true
+				next = append(next, relatedID)
+			}
+		}
+	}
+	return false, nil
+}
+
+func (r *Relationships) related(ctx context.Context, fooID string, def Relationship) ([]string, error) {
+	var (
+		ids []string
+		err error
+	)
+	if def.InverseOf != "" {
+		ids, err = r.db.ReadRelatedTo(ctx, fooID, def.InverseOf)
+	} else {
+		ids, err = r.db.ReadRelated(ctx, fooID, def.Name)
+	}
+	return ids, errors.Wrap(err)
+}
+
+func errToOne(def Relationship) error {
+	return InvalidErr("relationship "+def.Name+" is to-one, its related foo is replaced", "relationship", def.Name)
+}
+
+// errRelatedTo is the error of deleting a foo another live foo relates to.
+func errRelatedTo(fooID, relatedByID, name string) error {
+	return ExistsErr(
+		"foo "+fooID+" is related to by the "+name+" of foo "+relatedByID,
+		"id", fooID, "related_by_id", relatedByID, "relationship", name,
+	)
+}
@@ -0,0 +1,162 @@
+package allsrv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jsteenb2/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
+)
+
+func TestRelationships(t *testing.T) {
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	newRelationships := func(t *testing.T) (*allsrv.InmemDB, *allsrv.Relationships) {
+		db := new(allsrv.InmemDB)
+		allsrvtesting.CreateFoos(
+			allsrv.Foo{ID: "1", Name: "name-1", CreatedAt: start, UpdatedAt: start},
+			allsrv.Foo{ID: "2", Name: "name-2", CreatedAt: start, UpdatedAt: start},
+			allsrv.Foo{ID: "3", Name: "name-3", CreatedAt: start, UpdatedAt: start},
+		)(t, db)
+		return db, allsrv.NewRelationships(db)
+	}
+
+	t.Run("parent should provide the children of the parent", func(t *testing.T) {
+		_, rels := newRelationships(t)
+
+		for _, id := range []string{"2", "3"} {
+			related, err := rels.SetRelated(context.TODO(), id, "parent", []string{"1"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"1"}, related)
+		}
+
+		children, err := rels.Related(context.TODO(), "1", "children")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2", "3"}, children)
+	})
+
+	t.Run("to-many relationship should add and remove related foos", func(t *testing.T) {
+		_, rels := newRelationships(t)
+
+		related, err := rels.AddRelated(context.TODO(), "1", "depends_on", []string{"2"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2"}, related)
+
+		related, err = rels.AddRelated(context.TODO(), "1", "depends_on", []string{"3", "2"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2", "3"}, related)
+
+		related, err = rels.DelRelated(context.TODO(), "1", "depends_on", []string{"2"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"3"}, related)
+
+		related, err = rels.Related(context.TODO(), "1", "depends_on")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"3"}, related)
+	})
+
+	t.Run("hierarchical relationship forming a cycle should fail", func(t *testing.T) {
+		_, rels := newRelationships(t)
+		_, err := rels.SetRelated(context.TODO(), "2", "parent", []string{"1"})
+		require.NoError(t, err)
+		_, err = rels.SetRelated(context.TODO(), "3", "parent", []string{"2"})
+		require.NoError(t, err)
+
+		_, err = rels.SetRelated(context.TODO(), "1", "parent", []string{"3"})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindInvalid))
+		assert.Contains(t, err.Error(), "relationship parent of foo 1 to foo 3 forms a cycle")
+
+		_, err = rels.SetRelated(context.TODO(), "1", "parent", []string{"1"})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindInvalid))
+		assert.Contains(t, err.Error(), "foo 1 must not relate to itself by parent")
+
+		parent, err := rels.Related(context.TODO(), "1", "parent")
+		require.NoError(t, err)
+		assert.Empty(t, parent)
+
+		// the depends_on relationship is not hierarchical
+		_, err = rels.SetRelated(context.TODO(), "1", "depends_on", []string{"2"})
+		require.NoError(t, err)
+		_, err = rels.SetRelated(context.TODO(), "2", "depends_on", []string{"1"})
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid writes should fail", func(t *testing.T) {
+		_, rels := newRelationships(t)
+
+		tests := []struct {
+			name    string
+			write   func() ([]string, error)
+			wantErr string
+		}{
+			{
+				name: "to-one with many foos",
+				write: func() ([]string, error) {
+					return rels.SetRelated(context.TODO(), "1", "parent", []string{"2", "3"})
+				},
+				wantErr: "relationship parent is to-one and relates at most one foo",
+			},
+			{
+				name: "add to to-one",
+				write: func() ([]string, error) {
+					return rels.AddRelated(context.TODO(), "1", "parent", []string{"2"})
+				},
+				wantErr: "relationship parent is to-one, its related foo is replaced",
+			},
+			{
+				name: "inverse relationship",
+				write: func() ([]string, error) {
+					return rels.SetRelated(context.TODO(), "1", "children", []string{"2"})
+				},
+				wantErr: "relationship children is read only, as the inverse of parent",
+			},
+			{
+				name: "empty related id",
+				write: func() ([]string, error) {
+					return rels.AddRelated(context.TODO(), "1", "depends_on", []string{""})
+				},
+				wantErr: "related foo id is required",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := tt.write()
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, allsrv.ErrKindInvalid))
+				assert.Contains(t, err.Error(), tt.wantErr)
+			})
+		}
+	})
+
+	t.Run("unknown relationship should not be found", func(t *testing.T) {
+		_, rels := newRelationships(t)
+
+		_, err := rels.Related(context.TODO(), "1", "siblings")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindNotFound))
+		assert.Contains(t, err.Error(), "relationship not found for name: siblings")
+	})
+
+	t.Run("deleting a parent with children should fail", func(t *testing.T) {
+		db, rels := newRelationships(t)
+		svc := allsrv.NewService(db)
+		_, err := rels.SetRelated(context.TODO(), "2", "parent", []string{"1"})
+		require.NoError(t, err)
+
+		err = svc.DelFoo(context.TODO(), "1")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, allsrv.ErrKindExists))
+
+		_, err = rels.SetRelated(context.TODO(), "2", "parent", nil)
+		require.NoError(t, err)
+		require.NoError(t, svc.DelFoo(context.TODO(), "1"))
+	})
+}
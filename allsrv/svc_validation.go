@@ -0,0 +1,256 @@
+package allsrv
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jsteenb2/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Rule validates a single foo attribute value. When the value violates the
+// rule, a description of the expectation is returned (i.e. "is required"),
+// otherwise an empty string is returned.
+type Rule func(v string) string
+
+// FooRules declares the validation rules for each of the foo attributes. The
+// label rules apply to the key and value of every label, of which there may be
+// at most MaxLabels. A MaxLabels of zero does not limit the labels. The
+// metadata is validated against the Metadata schema, when provided, and its
+// JSON may be at most MaxMetadataBytes. A MaxMetadataBytes of zero does not
+// limit the metadata.
+type FooRules struct {
+	Name []Rule
+	Note []Rule
+
+	LabelKey   []Rule
+	LabelValue []Rule
+	MaxLabels  int
+
+	Metadata         *MetadataSchema
+	MaxMetadataBytes int
+}
+
+// DefaultFooRules are the rules enforced by the Service when no others are
+// provided.
+func DefaultFooRules() FooRules {
+	return FooRules{
+		Name: []Rule{
+			Required(),
+			MaxLen(128),
+			Charset("printable characters", unicode.IsGraphic),
+		},
+		Note: []Rule{
+			MaxBytes(4096),
+		},
+		LabelKey: []Rule{
+			Required(),
+			MaxLen(63),
+			Charset("letters, digits, '-', '_', '.', or '/'", isLabelKeyRune),
+		},
+		LabelValue: []Rule{
+			MaxLen(63),
+			Charset("letters, digits, '-', '_', or '.'", isLabelValueRune),
+		},
+		MaxLabels:        64,
+		MaxMetadataBytes: 16 << 10,
+	}
+}
+
+// Validate validates every attribute of the foo against the rules. All violations
+// are returned as a joined error, where each violation is an invalid error
+// that identifies the offending attribute with the "attribute" field.
+func (r FooRules) Validate(f Foo) error {
+	attrs := []attrVal{
+		{attr: "name", val: f.Name, rules: r.Name},
+		{attr: "note", val: f.Note, rules: r.Note},
+	}
+	return joinViolations(
+		validateAttrs(append(attrs, r.labelAttrs(f.Labels)...)...),
+		r.validateLabelCount(f.Labels),
+		r.validateMetadata(f.Metadata),
+	)
+}
+
+func (r FooRules) validateUpd(f FooUpd) error {
+	var (
+		attrs       []attrVal
+		countErr    error
+		metadataErr error
+	)
+	if f.Name != nil {
+		attrs = append(attrs, attrVal{attr: "name", val: *f.Name, rules: r.Name})
+	}
+	if f.Note != nil {
+		attrs = append(attrs, attrVal{attr: "note", val: *f.Note, rules: r.Note})
+	}
+	if f.Labels != nil {
+		attrs = append(attrs, r.labelAttrs(*f.Labels)...)
+		countErr = r.validateLabelCount(*f.Labels)
+	}
+	if f.Metadata != nil {
+		metadataErr = r.validateMetadata(*f.Metadata)
+	}
+	return joinViolations(validateAttrs(attrs...), countErr, metadataErr)
+}
+
+// labelAttrs provides the key and value of every label for validation. The
+// attribute of each label is the path of the label within the labels, so a
+// violation points at the offending label.
+func (r FooRules) labelAttrs(labels map[string]string) []attrVal {
+	var attrs []attrVal
+	for _, k := range labelKeys(labels) {
+		attr := "labels/" + k
+		attrs = append(attrs,
+			attrVal{attr: attr, desc: "label key " + strconv.Quote(k), val: k, rules: r.LabelKey},
+			attrVal{attr: attr, desc: "label " + strconv.Quote(k) + " value", val: labels[k], rules: r.LabelValue},
+		)
+	}
+	return attrs
+}
+
+func (r FooRules) validateLabelCount(labels map[string]string) error {
+	if r.MaxLabels > 0 && len(labels) > r.MaxLabels {
+		return InvalidErr("labels must have at most "+strconv.Itoa(r.MaxLabels)+" labels", "attribute", "labels")
+	}
+	return nil
+}
+
+// validateMetadata validates the size of the metadata, and the metadata
+// against the schema. The metadata is expected to be normalized.
+func (r FooRules) validateMetadata(metadata map[string]any) error {
+	if r.MaxMetadataBytes > 0 {
+		b, err := marshalMetadata(metadata)
+		if err != nil {
+			return InvalidErr("metadata must be a JSON object", "attribute", "metadata")
+		}
+		if len(b) > r.MaxMetadataBytes {
+			return InvalidErr("metadata must be at most "+strconv.Itoa(r.MaxMetadataBytes)+" bytes", "attribute", "metadata")
+		}
+	}
+	if r.Metadata == nil {
+		return nil
+	}
+	return r.Metadata.Validate(metadata)
+}
+
+type attrVal struct {
+	attr  string
+	desc  string // describes the attribute in violations, defaults to the attr
+	val   string
+	rules []Rule
+}
+
+func validateAttrs(attrs ...attrVal) error {
+	var errs []error
+	for _, a := range attrs {
+		for _, rule := range a.rules {
+			if violation := rule(a.val); violation != "" {
+				errs = append(errs, InvalidErr(cmp.Or(a.desc, a.attr)+" "+violation, "attribute", a.attr))
+			}
+		}
+	}
+	return joinViolations(errs...)
+}
+
+// joinViolations joins the violations of separate validations, so every
+// violation is reported as its own error of the joined error.
+func joinViolations(violations ...error) error {
+	var errs []error
+	for _, err := range violations {
+		if err != nil {
+			errs = append(errs, disjoin(err)...)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return errors.Join(errs)
+	}
+}
+
+// validateExpiresAt validates the expiry of a foo is in the future. A zero
+// expiry is valid, as the foo does not expire.
+func validateExpiresAt(expiresAt, now time.Time) error {
+	if expiresAt.IsZero() || expiresAt.After(now) {
+		return nil
+	}
+	return InvalidErr("expires_at must be in the future", "attribute", "expires_at")
+}
+
+// Required validates the value is not empty.
+func Required() Rule {
+	return func(v string) string {
+		if v == "" {
+			return "is required"
+		}
+		return ""
+	}
+}
+
+// MaxLen validates the value has no more than n characters.
+func MaxLen(n int) Rule {
+	return func(v string) string {
+		if utf8.RuneCountInString(v) > n {
+			return "must be at most " + strconv.Itoa(n) + " characters"
+		}
+		return ""
+	}
+}
+
+// MaxBytes validates the value is no larger than n bytes.
+func MaxBytes(n int) Rule {
+	return func(v string) string {
+		if len(v) > n {
+			return "must be at most " + strconv.Itoa(n) + " bytes"
+		}
+		return ""
+	}
+}
+
+// Charset validates every character of the value is allowed. The desc
+// describes the allowed characters to the user.
+func Charset(desc string, allowed func(r rune) bool) Rule {
+	return func(v string) string {
+		if strings.IndexFunc(v, func(r rune) bool { return !allowed(r) }) > -1 {
+			return "must only contain " + desc
+		}
+		return ""
+	}
+}
+
+func isLabelKeyRune(r rune) bool {
+	return isLabelValueRune(r) || r == '/'
+}
+
+func isLabelValueRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.'
+}
+
+// normalizeAttr puts the attribute value in its canonical form, so that
+// visually identical values are stored identically.
+func normalizeAttr(v string) string {
+	return norm.NFC.String(v)
+}
+
+func normalizeName(name string) string {
+	return normalizeAttr(strings.TrimSpace(name))
+}
+
+func normalizeLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[normalizeAttr(k)] = normalizeAttr(v)
+	}
+	return out
+}
@@ -0,0 +1,281 @@
+package allsrv
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TrafficExchange is a recorded request/response pair of a server, the line
+// of a traffic recording. A JSON body is recorded as is, any other body is
+// recorded as binary. The credentials of a request are not recorded, only
+// whether the request was authorized.
+type TrafficExchange struct {
+	Time   time.Time  `json:"time"`
+	Route  string     `json:"route,omitempty"`
+	Method string     `json:"method"`
+	URL    string     `json:"url"`
+	Auth   bool       `json:"auth,omitempty"` // the request was authorized by its credentials
+	Req    TrafficMsg `json:"request"`
+	Status int        `json:"status"`
+	Resp   TrafficMsg `json:"response"`
+}
+
+// TrafficMsg is the recorded headers and body of a request or response. A
+// body larger than the max body size of the recorder is left out and marked
+// as truncated.
+type TrafficMsg struct {
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       json.RawMessage   `json:"body,omitempty"`
+	BinaryBody []byte            `json:"binary_body,omitempty"`
+	Truncated  bool              `json:"truncated,omitempty"`
+}
+
+func (m TrafficMsg) body() []byte {
+	if len(m.Body) > 0 {
+		return m.Body
+	}
+	return m.BinaryBody
+}
+
+const (
+	// DefaultTrafficMaxBody is the default size limit of a recorded body in bytes.
+	DefaultTrafficMaxBody = 64 << 10
+
+	trafficRedacted = "REDACTED"
+)
+
+var (
+	// trafficHeaders are the headers recorded, any other header, such as the
+	// Authorization or Cookie, is left out of the recording.
+	trafficHeaders = []string{
+		"Accept", "Content-Type", "Content-Disposition",
+		"If-Match", "If-None-Match", "If-Modified-Since", "ETag", "Last-Modified",
+		"Location", "Deprecation", "Sunset",
+	}
+
+	// defaultTrafficRedactKeys are the keys of the JSON bodies whose values are
+	// redacted by default.
+	defaultTrafficRedactKeys = []string{"password", "secret", "token", "api_key", "authorization"}
+)
+
+// TrafficRecorder records the traffic of a server as JSON Lines, one
+// TrafficExchange per line. Only the allowed headers are recorded, and the
+// values of the redacted keys of the JSON bodies are replaced.
+type TrafficRecorder struct {
+	maxBody    int
+	redactKeys map[string]bool
+	nowFn      func() time.Time
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewTrafficRecorder creates a recorder that writes the traffic to w.
+func NewTrafficRecorder(w io.Writer, opts ...func(*TrafficRecorder)) *TrafficRecorder {
+	r := TrafficRecorder{
+		maxBody:    DefaultTrafficMaxBody,
+		redactKeys: make(map[string]bool),
+		nowFn:      time.Now,
+		enc:        json.NewEncoder(w),
+	}
+	for _, k := range defaultTrafficRedactKeys {
+		r.redactKeys[k] = true
+	}
+	for _, o := range opts {
+		o(&r)
+	}
+	r.enc.SetEscapeHTML(false)
+	return &r
+}
+
+// WithTrafficMaxBody sets the size limit of a recorded body in bytes.
+func WithTrafficMaxBody(maxBody int) func(*TrafficRecorder) {
+	return func(r *TrafficRecorder) {
+		r.maxBody = maxBody
+	}
+}
+
+// WithTrafficRedactKeys adds to the keys of the JSON bodies whose values are
+// redacted. The keys are matched case insensitively.
+func WithTrafficRedactKeys(keys ...string) func(*TrafficRecorder) {
+	return func(r *TrafficRecorder) {
+		for _, k := range keys {
+			r.redactKeys[strings.ToLower(k)] = true
+		}
+	}
+}
+
+// WithTrafficNowFn sets the time fn of the recorded exchanges.
+func WithTrafficNowFn(fn func() time.Time) func(*TrafficRecorder) {
+	return func(r *TrafficRecorder) {
+		r.nowFn = fn
+	}
+}
+
+// WithTrafficRecorder records the traffic of the server with the recorder.
+func WithTrafficRecorder(rec *TrafficRecorder) SvrOptFn {
+	return func(o *serverOpts) {
+		o.traffic = rec
+	}
+}
+
+// Err provides the first error writing the recording. The traffic is no
+// longer recorded once the recording fails.
+func (t *TrafficRecorder) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Record records the exchanges of the handler.
+func (t *TrafficRecorder) Record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := t.nowFn()
+
+		// the request body is read ahead of the handler, so the body of a
+		// request rejected before its body is read is recorded as well
+		reqBody := &trafficBuf{max: t.maxBody}
+		if r.Body != nil && r.Body != http.NoBody {
+			b, _ := io.ReadAll(io.LimitReader(r.Body, int64(t.maxBody)+1))
+			reqBody.Write(b)
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+		}
+		rec := &trafficRespRec{ResponseWriter: w, body: trafficBuf{max: t.maxBody}}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		// credentials that are rejected are not authorized, the exchange is
+		// replayed without credentials so it is rejected the same
+		authorized := r.Header.Get("Authorization") != "" && rec.code != http.StatusUnauthorized
+		t.write(TrafficExchange{
+			Time:   started,
+			Route:  getRoute(r.Context()),
+			Method: r.Method,
+			URL:    r.URL.RequestURI(),
+			Auth:   authorized,
+			Req:    t.msg(r.Header, reqBody),
+			Status: rec.code,
+			Resp:   t.msg(rec.Header(), &rec.body),
+		})
+	})
+}
+
+func (t *TrafficRecorder) msg(h http.Header, body *trafficBuf) TrafficMsg {
+	var msg TrafficMsg
+	for _, k := range trafficHeaders {
+		if v := h.Get(k); v != "" {
+			if msg.Headers == nil {
+				msg.Headers = make(map[string]string)
+			}
+			msg.Headers[k] = v
+		}
+	}
+
+	switch b := body.Bytes(); {
+	case body.truncated:
+		msg.Truncated = true
+	case len(b) == 0:
+	case json.Valid(b):
+		msg.Body = t.redact(b)
+	default:
+		msg.BinaryBody = bytes.Clone(b)
+	}
+	return msg
+}
+
+// redact replaces the values of the redacted keys of the JSON body. The body
+// is compacted once redacted.
+func (t *TrafficRecorder) redact(b []byte) json.RawMessage {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return bytes.Clone(b)
+	}
+	out, err := json.Marshal(t.redactValue(v))
+	if err != nil {
+		return bytes.Clone(b)
+	}
+	return out
+}
+
+func (t *TrafficRecorder) redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if t.redactKeys[strings.ToLower(k)] {
+				v[k] = trafficRedacted
+				continue
+			}
+			v[k] = t.redactValue(val)
+		}
+	case []any:
+		for i, val := range v {
+			v[i] = t.redactValue(val)
+		}
+	}
+	return v
+}
+
+func (t *TrafficRecorder) write(ex TrafficExchange) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	t.err = t.enc.Encode(ex)
+}
+
+// trafficBuf buffers a body up to its max size, marking the body as truncated
+// once it exceeds the max size.
+type trafficBuf struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *trafficBuf) Write(p []byte) (int, error) {
+	if b.truncated {
+		return len(p), nil
+	}
+	if b.Len()+len(p) > b.max {
+		b.truncated = true
+		b.Reset()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+type trafficRespRec struct {
+	http.ResponseWriter
+	code int
+	body trafficBuf
+}
+
+func (r *trafficRespRec) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *trafficRespRec) WriteHeader(statusCode int) {
+	if r.code == 0 {
+		r.code = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
@@ -0,0 +1,404 @@
+package allsrv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsteenb2/errors"
+)
+
+// defaultTrafficIgnoreKeys are the keys of the volatile fields of the bodies
+// that are ignored by the replay.
+var defaultTrafficIgnoreKeys = []string{"trace_id", "took_ms"}
+
+// TrafficReplayReport is the result of the replay of a traffic recording.
+type TrafficReplayReport struct {
+	Exchanges int                 `json:"exchanges"`
+	Matched   int                 `json:"matched"`
+	Skipped   int                 `json:"skipped"`
+	Diverged  []TrafficDivergence `json:"diverged,omitempty"`
+}
+
+// TrafficDivergence is a replayed exchange whose response diverged from the
+// recorded response. The line is the line of the exchange in the recording.
+type TrafficDivergence struct {
+	Line   int      `json:"line"`
+	Method string   `json:"method"`
+	URL    string   `json:"url"`
+	Diffs  []string `json:"diffs"`
+}
+
+// WriteText writes the report as text, with the diffs of each diverged
+// exchange.
+func (r TrafficReplayReport) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "replayed %d exchanges: %d matched, %d diverged, %d skipped\n", r.Exchanges, r.Matched, len(r.Diverged), r.Skipped)
+	for _, d := range r.Diverged {
+		fmt.Fprintf(w, "\nline %d: %s %s\n", d.Line, d.Method, d.URL)
+		for _, diff := range d.Diffs {
+			fmt.Fprintf(w, "  %s\n", diff)
+		}
+	}
+	return err
+}
+
+// TrafficReplayer replays a traffic recording against a server, and reports
+// the semantic diffs of the responses from the recorded responses.
+//
+// The ids, ETags and Last-Modified times provided by the server differ from
+// those of the recording. The replayer learns the replayed value of each from
+// the responses, and substitutes them into the requests that follow, so the
+// recorded traffic may be replayed against an empty server. The values of the
+// ignored keys, and any timestamps, of the bodies are not compared.
+type TrafficReplayer struct {
+	addr       string
+	hc         *http.Client
+	user, pass string
+	ignoreKeys map[string]bool
+
+	// subs are the replayed values of the recorded ids, ETags and times.
+	subs map[string]string
+}
+
+// NewTrafficReplayer creates a replayer of the traffic against the server at addr.
+func NewTrafficReplayer(addr string, c *http.Client, opts ...func(*TrafficReplayer)) *TrafficReplayer {
+	r := TrafficReplayer{
+		addr:       strings.TrimSuffix(addr, "/"),
+		hc:         c,
+		ignoreKeys: make(map[string]bool),
+		subs:       make(map[string]string),
+	}
+	if r.hc == nil {
+		r.hc = &http.Client{Timeout: 5 * time.Second}
+	}
+	for _, k := range defaultTrafficIgnoreKeys {
+		r.ignoreKeys[k] = true
+	}
+	for _, o := range opts {
+		o(&r)
+	}
+	return &r
+}
+
+// WithReplayBasicAuth sets the basic auth of the replayed requests that were
+// authorized when recorded. The credentials of the recorded requests are not
+// recorded, the requests that were not authorized, including those rejected
+// as unauthorized, are replayed without credentials.
+func WithReplayBasicAuth(user, pass string) func(*TrafficReplayer) {
+	return func(r *TrafficReplayer) {
+		r.user, r.pass = user, pass
+	}
+}
+
+// WithReplayIgnoreKeys adds to the keys of the body fields that are not compared.
+func WithReplayIgnoreKeys(keys ...string) func(*TrafficReplayer) {
+	return func(r *TrafficReplayer) {
+		for _, k := range keys {
+			r.ignoreKeys[k] = true
+		}
+	}
+}
+
+// Replay replays the exchanges of the recording, in order. An exchange with
+// a truncated body is skipped.
+func (t *TrafficReplayer) Replay(ctx context.Context, recording io.Reader) (TrafficReplayReport, error) {
+	var report TrafficReplayReport
+
+	scanner := bufio.NewScanner(recording)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var ex TrafficExchange
+		if err := json.Unmarshal(scanner.Bytes(), &ex); err != nil {
+			return report, InvalidErr("invalid traffic exchange at line "+strconv.Itoa(line)+": "+err.Error(), "line", line)
+		}
+		report.Exchanges++
+		if ex.Req.Truncated || ex.Resp.Truncated {
+			report.Skipped++
+			continue
+		}
+
+		diffs, err := t.replay(ctx, ex)
+		if err != nil {
+			return report, errors.Wrap(err, "failed to replay traffic exchange at line "+strconv.Itoa(line))
+		}
+		if len(diffs) == 0 {
+			report.Matched++
+			continue
+		}
+		report.Diverged = append(report.Diverged, TrafficDivergence{
+			Line:   line,
+			Method: ex.Method,
+			URL:    ex.URL,
+			Diffs:  diffs,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return report, errors.Wrap(err, "failed to read traffic recording")
+	}
+	return report, nil
+}
+
+func (t *TrafficReplayer) replay(ctx context.Context, ex TrafficExchange) ([]string, error) {
+	var body io.Reader
+	switch {
+	case len(ex.Req.Body) > 0:
+		b, err := t.substituteJSON(ex.Req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	case len(ex.Req.BinaryBody) > 0:
+		body = bytes.NewReader(ex.Req.BinaryBody)
+	}
+	u, err := t.substituteURL(ex.URL)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, ex.Method, t.addr+u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range ex.Req.Headers {
+		req.Header.Set(k, t.substitute(v))
+	}
+	if ex.Auth && ex.Status != http.StatusUnauthorized {
+		req.SetBasicAuth(t.user, t.pass)
+	}
+
+	resp, err := t.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range []string{"ETag", "Last-Modified"} {
+		if recorded, replayed := ex.Resp.Headers[k], resp.Header.Get(k); recorded != "" && replayed != "" {
+			t.subs[recorded] = replayed
+		}
+	}
+
+	var diffs []string
+	if ex.Status != resp.StatusCode {
+		diffs = append(diffs, fmt.Sprintf("status: %d != %d", ex.Status, resp.StatusCode))
+	}
+	// the content type sniffed by net/http is not recorded
+	if ct := ex.Resp.Headers["Content-Type"]; ct != "" {
+		recordedType, _, _ := mime.ParseMediaType(ct)
+		replayedType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if recordedType != replayedType {
+			diffs = append(diffs, fmt.Sprintf("content type: %q != %q", recordedType, replayedType))
+		}
+	}
+	return append(diffs, t.diffBody(ex.Resp, respBody)...), nil
+}
+
+func (t *TrafficReplayer) diffBody(recorded TrafficMsg, replayed []byte) []string {
+	if len(recorded.Body) == 0 || !json.Valid(replayed) {
+		if !bytes.Equal(recorded.body(), replayed) {
+			return []string{"body: content differs"}
+		}
+		return nil
+	}
+
+	rec, err := decodeTrafficJSON(recorded.Body)
+	if err != nil {
+		return []string{"body: recorded body is invalid JSON: " + err.Error()}
+	}
+	rep, err := decodeTrafficJSON(replayed)
+	if err != nil {
+		return []string{"body: replayed body is invalid JSON: " + err.Error()}
+	}
+
+	var diffs []string
+	t.diffValue("", "", rec, rep, &diffs)
+	return diffs
+}
+
+// diffValue compares the recorded and replayed values at the JSON pointer,
+// learning the replayed value of a recorded id along the way.
+func (t *TrafficReplayer) diffValue(pointer, key string, rec, rep any, diffs *[]string) {
+	switch rec := rec.(type) {
+	case map[string]any:
+		repObj, ok := rep.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := sortedKeys(rec)
+		for _, k := range sortedKeys(repObj) {
+			if _, ok := rec[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			recVal, inRec := rec[k]
+			repVal, inRep := repObj[k]
+			p := pointer + "/" + k
+			switch {
+			case t.ignoreKeys[k]:
+			case !inRep:
+				*diffs = append(*diffs, "body "+p+": missing from replay")
+			case !inRec:
+				*diffs = append(*diffs, "body "+p+": unexpected in replay: "+trafficJSON(repVal))
+			default:
+				t.diffValue(p, k, recVal, repVal, diffs)
+			}
+		}
+		return
+	case []any:
+		repArr, ok := rep.([]any)
+		if !ok {
+			break
+		}
+		if len(rec) != len(repArr) {
+			*diffs = append(*diffs, fmt.Sprintf("body %s: length %d != %d", pointer, len(rec), len(repArr)))
+		}
+		for i := range min(len(rec), len(repArr)) {
+			t.diffValue(pointer+"/"+strconv.Itoa(i), "", rec[i], repArr[i], diffs)
+		}
+		return
+	case string:
+		repStr, ok := rep.(string)
+		if !ok {
+			break
+		}
+		if t.sameString(key, rec, repStr) {
+			return
+		}
+	default:
+		if rec == rep {
+			return
+		}
+	}
+	*diffs = append(*diffs, "body "+pointer+": "+trafficJSON(rec)+" != "+trafficJSON(rep))
+}
+
+// sameString compares the strings by their semantics. Timestamps are the same
+// regardless of their time, and a redacted value is the same as any value. An
+// id is the same when it is the replayed value of the recorded id, a recorded
+// id that is yet to be replayed is learned.
+func (t *TrafficReplayer) sameString(key, rec, rep string) bool {
+	if rec == rep || rec == trafficRedacted || isTrafficTimestamp(rec) && isTrafficTimestamp(rep) {
+		return true
+	}
+	if replayed, ok := t.subs[rec]; ok {
+		return replayed == rep
+	}
+	if strings.EqualFold(key, "id") {
+		t.subs[rec] = rep
+		return true
+	}
+	return t.substituteText(rec) == rep
+}
+
+// substitute provides the replayed value of a recorded value. Only whole
+// values are substituted, so a short id is not replaced within other values.
+func (t *TrafficReplayer) substitute(s string) string {
+	if rep, ok := t.subs[s]; ok {
+		return rep
+	}
+	return s
+}
+
+// substituteText substitutes the recorded values that appear as words of the
+// text, such as the id within an error message.
+func (t *TrafficReplayer) substituteText(s string) string {
+	for rec, rep := range t.subs {
+		if !strings.Contains(s, rec) {
+			continue
+		}
+		re := regexp.MustCompile(`(^|[^\w-])` + regexp.QuoteMeta(rec) + `($|[^\w-])`)
+		s = re.ReplaceAllString(s, "${1}"+strings.ReplaceAll(rep, "$", "$$")+"${2}")
+	}
+	return s
+}
+
+// substituteURL substitutes the segments of the path, and the values of the
+// query, of the URL.
+func (t *TrafficReplayer) substituteURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	segments := strings.Split(u.Path, "/")
+	for i, seg := range segments {
+		segments[i] = t.substitute(seg)
+	}
+	u.Path, u.RawPath = strings.Join(segments, "/"), ""
+
+	q := u.Query()
+	for _, vals := range q {
+		for i, v := range vals {
+			vals[i] = t.substitute(v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.RequestURI(), nil
+}
+
+// substituteJSON substitutes the string values of the JSON body.
+func (t *TrafficReplayer) substituteJSON(b []byte) ([]byte, error) {
+	v, err := decodeTrafficJSON(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var sub func(v any) any
+	sub = func(v any) any {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, val := range v {
+				v[k] = sub(val)
+			}
+		case []any:
+			for i, val := range v {
+				v[i] = sub(val)
+			}
+		case string:
+			return t.substitute(v)
+		}
+		return v
+	}
+	return json.Marshal(sub(v))
+}
+
+func isTrafficTimestamp(s string) bool {
+	_, err := time.Parse(time.RFC3339, s)
+	return err == nil
+}
+
+func decodeTrafficJSON(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v any
+	err := dec.Decode(&v)
+	return v, err
+}
+
+func trafficJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,279 @@
+package allsrv_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsteenb2/mess/allsrv"
+	"github.com/jsteenb2/mess/allsrv/allsrvtesting"
+)
+
+func TestTrafficRecorder(t *testing.T) {
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	decodeExchanges := func(t *testing.T, recording *bytes.Buffer) []allsrv.TrafficExchange {
+		t.Helper()
+
+		var out []allsrv.TrafficExchange
+		for _, line := range strings.Split(strings.TrimSpace(recording.String()), "\n") {
+			var ex allsrv.TrafficExchange
+			require.NoError(t, json.Unmarshal([]byte(line), &ex))
+			out = append(out, ex)
+		}
+		return out
+	}
+
+	t.Run("v2 server traffic should be recorded sanitized", func(t *testing.T) {
+		var recording bytes.Buffer
+		svc := allsrv.NewService(new(allsrv.InmemDB),
+			allsrv.WithSVCIDFn(allsrvtesting.IDGen(1, 1)),
+			allsrv.WithSVCNowFn(allsrvtesting.NowFn(start, time.Hour)),
+		)
+		svr := allsrv.NewServerV2(svc,
+			allsrv.WithBasicAuthV2("dodgers@stink.com", "PaSsWoRd"),
+			allsrv.WithTrafficRecorder(allsrv.NewTrafficRecorder(&recording,
+				allsrv.WithTrafficNowFn(allsrvtesting.NowFn(start, time.Minute)),
+				allsrv.WithTrafficRedactKeys("api_secret"),
+			)),
+		)
+
+		req := httptest.NewRequest("POST", "/v1/foos", strings.NewReader(`{"data":{"type":"foo","attributes":{"name":"first_foo","note":"some note","metadata":{"Token":"t0k3n","api_secret":"s3cr3t","region":"us"}}}}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Cookie", "session=abc")
+		req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/foos/1", nil))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		req = httptest.NewRequest("GET", "/v1/foos/1", nil)
+		req.SetBasicAuth("dodgers@stink.com", "WRONG")
+		rec = httptest.NewRecorder()
+		svr.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		exchanges := decodeExchanges(t, &recording)
+		require.Len(t, exchanges, 3)
+
+		created := exchanges[0]
+		assert.Equal(t, start, created.Time)
+		assert.Equal(t, "POST /v1/foos", created.Route)
+		assert.Equal(t, "POST", created.Method)
+		assert.Equal(t, "/v1/foos", created.URL)
+		assert.True(t, created.Auth)
+		assert.Equal(t, map[string]string{"Content-Type": "application/json"}, created.Req.Headers)
+		assert.JSONEq(t, `{"data":{"type":"foo","attributes":{"name":"first_foo","note":"some note","metadata":{"Token":"REDACTED","api_secret":"REDACTED","region":"us"}}}}`, string(created.Req.Body))
+		assert.Equal(t, http.StatusCreated, created.Status)
+		assert.Equal(t, "application/json", created.Resp.Headers["Content-Type"])
+
+		var resp struct {
+			Data struct {
+				ID    string         `json:"id"`
+				Attrs map[string]any `json:"attributes"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(created.Resp.Body, &resp))
+		assert.Equal(t, "1", resp.Data.ID)
+		assert.Equal(t, map[string]any{"Token": "REDACTED", "api_secret": "REDACTED", "region": "us"}, resp.Data.Attrs["metadata"])
+
+		rejected := exchanges[1]
+		assert.Equal(t, start.Add(time.Minute), rejected.Time)
+		assert.Equal(t, "GET /v1/foos/{id}", rejected.Route)
+		assert.False(t, rejected.Auth)
+		assert.Equal(t, http.StatusUnauthorized, rejected.Status)
+		assert.Contains(t, string(rejected.Resp.Body), "unauthorized")
+
+		badCreds := exchanges[2]
+		assert.False(t, badCreds.Auth, "rejected credentials should not be recorded as authorized")
+		assert.Equal(t, http.StatusUnauthorized, badCreds.Status)
+	})
+
+	t.Run("legacy server traffic should be recorded", func(t *testing.T) {
+		var recording bytes.Buffer
+		svc := allsrv.NewService(new(allsrv.InmemDB), allsrv.WithSVCIDFn(allsrvtesting.IDGen(1, 1)))
+		svr := allsrv.NewServer(svc,
+			allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"),
+			allsrv.WithTrafficRecorder(allsrv.NewTrafficRecorder(&recording)),
+		)
+
+		req := httptest.NewRequest("POST", "/foo", strings.NewReader(`{"name":"first_foo","note":"some note"}`))
+		req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		exchanges := decodeExchanges(t, &recording)
+		require.Len(t, exchanges, 1)
+		assert.Equal(t, "POST /foo", exchanges[0].Route)
+		assert.True(t, exchanges[0].Auth)
+		assert.JSONEq(t, `{"name":"first_foo","note":"some note"}`, string(exchanges[0].Req.Body))
+		assert.Equal(t, http.StatusCreated, exchanges[0].Status)
+		assert.Contains(t, string(exchanges[0].Resp.Body), `"ID":"1"`)
+		assert.NotEmpty(t, exchanges[0].Resp.Headers["Deprecation"])
+	})
+
+	t.Run("body over the max body should be truncated", func(t *testing.T) {
+		var recording bytes.Buffer
+		svr := allsrv.NewServerV2(allsrv.NewService(new(allsrv.InmemDB)),
+			allsrv.WithTrafficRecorder(allsrv.NewTrafficRecorder(&recording, allsrv.WithTrafficMaxBody(32))),
+		)
+
+		req := httptest.NewRequest("POST", "/v1/foos", strings.NewReader(`{"data":{"type":"foo","attributes":{"name":"first_foo"}}}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		svr.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusCreated, rec.Code, "the handler should read the whole body")
+
+		exchanges := decodeExchanges(t, &recording)
+		require.Len(t, exchanges, 1)
+		assert.True(t, exchanges[0].Req.Truncated)
+		assert.Empty(t, exchanges[0].Req.Body)
+		assert.True(t, exchanges[0].Resp.Truncated)
+	})
+}
+
+func TestTrafficReplayer(t *testing.T) {
+	start := time.Time{}.Add(time.Hour).UTC()
+
+	newServer := func(t *testing.T, idStart int, opts ...allsrv.SvrOptFn) *httptest.Server {
+		t.Helper()
+
+		svc := allsrv.NewService(new(allsrv.InmemDB),
+			allsrv.WithSVCIDFn(allsrvtesting.IDGen(idStart, 1)),
+			allsrv.WithSVCNowFn(allsrvtesting.NowFn(start.Add(time.Duration(idStart)*time.Hour), time.Hour)),
+		)
+		mux := http.NewServeMux()
+		opts = append([]allsrv.SvrOptFn{allsrv.WithBasicAuth("dodgers@stink.com", "PaSsWoRd"), allsrv.WithMux(mux)}, opts...)
+		allsrv.NewServer(svc, opts...)
+		allsrv.NewServerV2(svc, append(opts, allsrv.WithBasicAuthV2("dodgers@stink.com", "PaSsWoRd"))...)
+
+		svr := httptest.NewServer(mux)
+		t.Cleanup(svr.Close)
+		return svr
+	}
+
+	// record records the traffic of a client of the server.
+	record := func(t *testing.T) *bytes.Buffer {
+		t.Helper()
+
+		var recording bytes.Buffer
+		svr := newServer(t, 1, allsrv.WithTrafficRecorder(allsrv.NewTrafficRecorder(&recording)))
+
+		do := func(method, path, body string, headers ...string) *http.Response {
+			req, err := http.NewRequest(method, svr.URL+path, strings.NewReader(body))
+			require.NoError(t, err)
+			req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+			if body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			for i := 0; i < len(headers); i += 2 {
+				req.Header.Set(headers[i], headers[i+1])
+			}
+			resp, err := svr.Client().Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			return resp
+		}
+
+		do("POST", "/v1/foos", `{"data":{"type":"foo","attributes":{"name":"first_foo","note":"some note"}}}`)
+		read := do("GET", "/v1/foos/1", "")
+		notModified := do("GET", "/v1/foos/1", "", "If-None-Match", read.Header.Get("ETag"))
+		require.Equal(t, http.StatusNotModified, notModified.StatusCode)
+		do("PATCH", "/v1/foos/1", `{"data":{"type":"foo","id":"1","attributes":{"note":"new note"}}}`)
+		do("POST", "/foo", `{"name":"legacy_foo","note":"legacy note"}`)
+		do("GET", "/foo?id=2", "")
+		do("GET", "/v1/foos", "")
+		do("DELETE", "/v1/foos/1", "")
+		do("GET", "/v1/foos/1", "")
+		unauthed := do("GET", "/v1/foos", "", "Authorization", "Basic d3Jvbmc6Y3JlZHM=") // wrong:creds
+		require.Equal(t, http.StatusUnauthorized, unauthed.StatusCode)
+
+		return &recording
+	}
+
+	t.Run("traffic replayed against an equivalent server should match", func(t *testing.T) {
+		recording := record(t)
+		target := newServer(t, 100)
+
+		report, err := allsrv.NewTrafficReplayer(target.URL, target.Client(), allsrv.WithReplayBasicAuth("dodgers@stink.com", "PaSsWoRd")).
+			Replay(context.TODO(), recording)
+		require.NoError(t, err)
+
+		assert.Equal(t, allsrv.TrafficReplayReport{Exchanges: 10, Matched: 10}, report)
+	})
+
+	t.Run("diverged responses should be reported", func(t *testing.T) {
+		recording := record(t)
+		target := newServer(t, 100)
+
+		// the foo the recording creates already exists in the target
+		req, err := http.NewRequest("POST", target.URL+"/v1/foos", strings.NewReader(`{"data":{"type":"foo","attributes":{"name":"first_foo","note":"other note"}}}`))
+		require.NoError(t, err)
+		req.SetBasicAuth("dodgers@stink.com", "PaSsWoRd")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := target.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		report, err := allsrv.NewTrafficReplayer(target.URL, target.Client(), allsrv.WithReplayBasicAuth("dodgers@stink.com", "PaSsWoRd")).
+			Replay(context.TODO(), recording)
+		require.NoError(t, err)
+
+		require.NotEmpty(t, report.Diverged)
+		assert.Equal(t, allsrv.TrafficDivergence{
+			Line:   1,
+			Method: "POST",
+			URL:    "/v1/foos",
+			Diffs: []string{
+				"status: 201 != 409",
+				"body /data: missing from replay",
+				"body /errors: unexpected in replay: " + `[{"code":1,"message":"foo first_foo exists","source":{"pointer":"/data/attributes/name"},"status":409}]`,
+			},
+		}, report.Diverged[0])
+
+		var text bytes.Buffer
+		require.NoError(t, report.WriteText(&text))
+		assert.Contains(t, text.String(), "line 1: POST /v1/foos\n  status: 201 != 409\n")
+	})
+
+	t.Run("ignored keys should not be compared", func(t *testing.T) {
+		recording := record(t)
+		// only the recorded responses are edited
+		edited := strings.ReplaceAll(recording.String(), `"note":"new note","updated_at"`, `"note":"edited note","updated_at"`)
+		target := newServer(t, 100)
+
+		report, err := allsrv.NewTrafficReplayer(target.URL, target.Client(), allsrv.WithReplayBasicAuth("dodgers@stink.com", "PaSsWoRd")).
+			Replay(context.TODO(), strings.NewReader(edited))
+		require.NoError(t, err)
+		require.NotEmpty(t, report.Diverged)
+		assert.Contains(t, report.Diverged[0].Diffs, `body /data/attributes/note: "edited note" != "new note"`)
+
+		target = newServer(t, 100)
+		report, err = allsrv.NewTrafficReplayer(target.URL, target.Client(),
+			allsrv.WithReplayBasicAuth("dodgers@stink.com", "PaSsWoRd"),
+			allsrv.WithReplayIgnoreKeys("note"),
+		).Replay(context.TODO(), strings.NewReader(edited))
+		require.NoError(t, err)
+		assert.Empty(t, report.Diverged)
+	})
+
+	t.Run("invalid recording should fail", func(t *testing.T) {
+		target := newServer(t, 100)
+
+		_, err := allsrv.NewTrafficReplayer(target.URL, target.Client()).Replay(context.TODO(), strings.NewReader("{\"method\":\"GET\",\"url\":\"/v1/foos\"}\nnot json\n"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid traffic exchange at line 2")
+	})
+}